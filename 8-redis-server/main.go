@@ -2,6 +2,11 @@ package main
 
 import (
 	"coding-challenges/8-redis-server/server"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func PanicIf(err error) {
@@ -10,7 +15,48 @@ func PanicIf(err error) {
 	}
 }
 
+// checkAOF validates an append only file like redis-check-aof: check-aof [--fix] <file>
+func checkAOF(args []string) int {
+	fix := len(args) == 2 && args[0] == "--fix"
+	if fix {
+		args = args[1:]
+	}
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: check-aof [--fix] <file.aof>")
+		return 1
+	}
+	valid, err := server.CheckAppendOnly(args[0], fix, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if !valid {
+		return 1
+	}
+	return 0
+}
+
 func main() {
-	s := server.NewRedisServer()
+	if len(os.Args) > 1 && os.Args[1] == "check-aof" {
+		os.Exit(checkAOF(os.Args[2:]))
+	}
+	config, err := server.ParseArgs(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "*** FATAL CONFIG FILE ERROR ***")
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	s := server.NewRedisServer(config)
+	// like Redis, SIGINT and SIGTERM stop the server gracefully
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		signal.Stop(signals)
+		ctx, cancel := context.WithTimeout(context.Background(), server.ShutdownTimeout)
+		defer cancel()
+		// the errors are logged by the server
+		_ = s.Stop(ctx)
+	}()
 	PanicIf(s.Start())
 }
@@ -0,0 +1,561 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// aclCategory is a set of categories of commands, which ACL rules allow or deny as a whole
+type aclCategory int
+
+const (
+	catKeyspace aclCategory = 1 << iota
+	catRead
+	catWrite
+	catString
+	catList
+	catHash
+	catSet
+	catSortedSet
+	catPubSub
+	catAdmin
+	catBlocking
+	catDangerous
+	catConnection
+	catTransaction
+	catStream
+	catBitmap
+	catHyperLogLog
+	catGeo
+)
+
+// aclCategories are the categories of commands, in the order ACL CAT lists them
+var aclCategories = []struct {
+	name     string
+	category aclCategory
+}{
+	{"keyspace", catKeyspace},
+	{"read", catRead},
+	{"write", catWrite},
+	{"set", catSet},
+	{"sortedset", catSortedSet},
+	{"stream", catStream},
+	{"list", catList},
+	{"hash", catHash},
+	{"string", catString},
+	{"bitmap", catBitmap},
+	{"hyperloglog", catHyperLogLog},
+	{"geo", catGeo},
+	{"pubsub", catPubSub},
+	{"admin", catAdmin},
+	{"blocking", catBlocking},
+	{"dangerous", catDangerous},
+	{"connection", catConnection},
+	{"transaction", catTransaction},
+}
+
+const defaultUser = "default"
+
+var (
+	ErrNoAuth              = errors.New("NOAUTH Authentication required.")
+	ErrWrongPass           = errors.New("WRONGPASS invalid username-password pair or user is disabled.")
+	ErrNoKeyPermission     = errors.New("NOPERM No permissions to access a key")
+	ErrNoChannelPermission = errors.New("NOPERM No permissions to access a channel")
+	ErrNoACLFile           = errors.New("ERR This Redis instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE (assuming you have a Redis configuration file set) in order to store users in the Redis configuration.")
+)
+
+// NoPermissionError is the error of a command the user of the client may not run
+func NoPermissionError(user *aclUser, cmd *Cmd) error {
+	return fmt.Errorf("NOPERM User %s has no permissions to run the '%s' command", user.name, cmd.Name())
+}
+
+// keyPattern is a glob-style pattern of the keys a user may read and/or write
+type keyPattern struct {
+	pattern     string
+	read, write bool
+}
+
+func (kp keyPattern) String() string {
+	switch {
+	case kp.read && kp.write:
+		return "~" + kp.pattern
+	case kp.read:
+		return "%R~" + kp.pattern
+	}
+	return "%W~" + kp.pattern
+}
+
+// aclUser is a user of the ACL: its passwords and the commands, keys and Pub/Sub
+// channels it may access. A user is created disabled, without permissions.
+type aclUser struct {
+	name      string
+	enabled   bool
+	nopass    bool     // any password authenticates the user
+	passwords []string // SHA-256 of the passwords, hex encoded
+	removed   bool     // deleted by ACL DELUSER, its clients are disconnected
+
+	commands     map[string]bool // the commands the user may run
+	commandRules []string        // the rules which built @commands, to describe them
+	keys         []keyPattern
+	channels     []string // glob-style patterns of the channels, "*" for all the channels
+}
+
+func newACLUser(name string) *aclUser {
+	return &aclUser{name: name, commands: make(map[string]bool), commandRules: []string{"-@all"}}
+}
+
+// newDefaultUser creates the user of the clients which didn't authenticate, which may
+// run all the commands. It requires @password, if any.
+func newDefaultUser(password string) *aclUser {
+	user := newACLUser(defaultUser)
+	for _, rule := range []string{"on", "nopass", "~*", "&*", "+@all"} {
+		_ = user.setRule(rule)
+	}
+	user.setPassword(password)
+	return user
+}
+
+// setPassword replaces the passwords of the user, nopass if @password is empty
+func (user *aclUser) setPassword(password string) {
+	if password == "" {
+		_ = user.setRule("nopass")
+	} else {
+		_ = user.setRule("resetpass")
+		_ = user.setRule(">" + password)
+	}
+}
+
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func isPasswordHash(s string) bool {
+	if len(s) != 2*sha256.Size {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isHex(s[i]) || (s[i] >= 'A' && s[i] <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// authenticate reports whether the password authenticates the user
+func (user *aclUser) authenticate(password string) bool {
+	if !user.enabled {
+		return false
+	}
+	if user.nopass {
+		return true
+	}
+	hash := hashPassword(password)
+	for _, stored := range user.passwords {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(stored)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// setRule applies a rule of ACL SETUSER to the user
+func (user *aclUser) setRule(rule string) error {
+	lower := strings.ToLower(rule)
+	switch {
+	case rule == "":
+		return errors.New("Syntax error")
+	case lower == "on":
+		user.enabled = true
+	case lower == "off":
+		user.enabled = false
+	case lower == "nopass":
+		user.nopass, user.passwords = true, nil
+	case lower == "resetpass":
+		user.nopass, user.passwords = false, nil
+	case rule[0] == '>':
+		user.addPassword(hashPassword(rule[1:]))
+	case rule[0] == '#':
+		if !isPasswordHash(rule[1:]) {
+			return errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+		user.addPassword(rule[1:])
+	case rule[0] == '<' || rule[0] == '!':
+		hash := rule[1:]
+		if rule[0] == '<' {
+			hash = hashPassword(hash)
+		}
+		index := -1
+		for i, stored := range user.passwords {
+			if stored == hash {
+				index = i
+			}
+		}
+		if index < 0 {
+			return errors.New("The password you are trying to remove from the user does not exist")
+		}
+		user.passwords = append(user.passwords[:index], user.passwords[index+1:]...)
+	case lower == "allkeys":
+		user.keys = []keyPattern{{"*", true, true}}
+	case lower == "resetkeys":
+		user.keys = nil
+	case rule[0] == '~':
+		user.addKeyPattern(keyPattern{rule[1:], true, true})
+	case rule[0] == '%':
+		perms, pattern, found := strings.Cut(lower[1:], "~")
+		kp := keyPattern{pattern: rule[len(perms)+2:]}
+		for _, perm := range perms {
+			kp.read = kp.read || perm == 'r'
+			kp.write = kp.write || perm == 'w'
+			if perm != 'r' && perm != 'w' {
+				found = false
+			}
+		}
+		if !found || pattern == "" || perms == "" {
+			return errors.New("Syntax error")
+		}
+		user.addKeyPattern(kp)
+	case lower == "allchannels":
+		user.channels = []string{"*"}
+	case lower == "resetchannels":
+		user.channels = nil
+	case rule[0] == '&':
+		if len(user.channels) != 1 || user.channels[0] != "*" {
+			user.channels = append(user.channels, rule[1:])
+		}
+	case lower == "allcommands":
+		return user.setCommandRule("+@all")
+	case lower == "nocommands":
+		return user.setCommandRule("-@all")
+	case rule[0] == '+' || rule[0] == '-':
+		return user.setCommandRule(lower)
+	case lower == "reset":
+		for _, reset := range []string{"resetpass", "resetkeys", "resetchannels", "off", "-@all"} {
+			_ = user.setRule(reset)
+		}
+	default:
+		return errors.New("Syntax error")
+	}
+	return nil
+}
+
+func (user *aclUser) addPassword(hash string) {
+	for _, stored := range user.passwords {
+		if stored == hash {
+			return
+		}
+	}
+	user.nopass = false
+	user.passwords = append(user.passwords, hash)
+}
+
+func (user *aclUser) addKeyPattern(kp keyPattern) {
+	if len(user.keys) == 1 && user.keys[0] == (keyPattern{"*", true, true}) {
+		return
+	}
+	user.keys = append(user.keys, kp)
+}
+
+// setCommandRule allows (+) or denies (-) a command or a category (@category)
+func (user *aclUser) setCommandRule(rule string) error {
+	allow, name := rule[0] == '+', rule[1:]
+	if category, found := strings.CutPrefix(name, "@"); found {
+		var mask aclCategory
+		if category != "all" {
+			if mask, found = lookupACLCategory(category); !found {
+				return errors.New("Unknown command or category name in ACL")
+			}
+		}
+		for command, spec := range commandTable {
+			if category == "all" || spec.categories&mask != 0 {
+				user.commands[command] = allow
+			}
+		}
+		if category == "all" {
+			user.commandRules = nil
+		}
+	} else {
+		if _, found := commandTable[name]; !found {
+			return errors.New("Unknown command or category name in ACL")
+		}
+		user.commands[name] = allow
+		// only the last rule of a command matters
+		rules := user.commandRules[:0]
+		for _, previous := range user.commandRules {
+			if previous[1:] != name {
+				rules = append(rules, previous)
+			}
+		}
+		user.commandRules = rules
+	}
+	user.commandRules = append(user.commandRules, rule)
+	return nil
+}
+
+func lookupACLCategory(name string) (aclCategory, bool) {
+	for _, category := range aclCategories {
+		if category.name == strings.ToLower(name) {
+			return category.category, true
+		}
+	}
+	return 0, false
+}
+
+// canAccessKey reports whether the user may read, or write, the key
+func (user *aclUser) canAccessKey(key string, write bool) bool {
+	for _, kp := range user.keys {
+		if (write && kp.write || !write && kp.read) && globMatch(kp.pattern, key, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// canAccessChannel reports whether the user may publish or subscribe to the channel.
+// A pattern of PSUBSCRIBE must be one of the patterns of the user.
+func (user *aclUser) canAccessChannel(channel string, pattern bool) bool {
+	for _, allowed := range user.channels {
+		if allowed == "*" || (pattern && allowed == channel) || (!pattern && globMatch(allowed, channel, false)) {
+			return true
+		}
+	}
+	return false
+}
+
+// rules describes the user as the rules which create it, e.g. in the ACL file
+func (user *aclUser) rules() []string {
+	rules := []string{"off"}
+	if user.enabled {
+		rules[0] = "on"
+	}
+	if user.nopass {
+		rules = append(rules, "nopass")
+	}
+	for _, hash := range user.passwords {
+		rules = append(rules, "#"+hash)
+	}
+	for _, kp := range user.keys {
+		rules = append(rules, kp.String())
+	}
+	if len(user.channels) != 1 || user.channels[0] != "*" {
+		rules = append(rules, "resetchannels")
+	}
+	for _, channel := range user.channels {
+		rules = append(rules, "&"+channel)
+	}
+	return append(rules, user.commandRules...)
+}
+
+func (user *aclUser) String() string {
+	rules := user.rules()
+	for i, rule := range rules {
+		rules[i] = quoteArg(rule)
+	}
+	return "user " + user.name + " " + strings.Join(rules, " ")
+}
+
+/* ---------------- executor ---------------- */
+
+// aclState holds the users of the ACL, by name
+type aclState struct {
+	users map[string]*aclUser
+}
+
+func newACLState(config *Config) aclState {
+	return aclState{users: map[string]*aclUser{defaultUser: newDefaultUser(config.RequirePass)}}
+}
+
+// applyRequirePass follows the requirepass option changed by CONFIG SET, which sets
+// the password of the default user
+func (re *RedisExecutorImpl) applyRequirePass() {
+	re.acl.users[defaultUser].setPassword(re.config.RequirePass)
+}
+
+// clientUser returns the user the client authenticated as, nil if it didn't. The
+// clients are authenticated as the default user while it requires no password.
+func (re *RedisExecutorImpl) clientUser(client *Client) *aclUser {
+	user := client.user
+	if user == nil {
+		if user = re.acl.users[defaultUser]; user.enabled && user.nopass {
+			client.user = user
+			return user
+		}
+		return nil
+	}
+	if current := re.acl.users[user.name]; current != user {
+		// the users were reloaded, the client keeps its user unless it was deleted
+		if user.removed || current == nil {
+			client.user = nil
+			client.Disconnect()
+			return nil
+		}
+		client.user = current
+	}
+	return client.user
+}
+
+// checkAccess checks that the client may run the command: it must be authenticated,
+// and its user must be allowed to run the command on its keys and channels. The
+// commands of the server itself (clients without connection) are always allowed.
+func (re *RedisExecutorImpl) checkAccess(client *Client, spec *commandSpec, cmd *Cmd) error {
+	if client == nil || client.writer == nil || spec.is(flagNoAuth) {
+		return nil
+	}
+	user := re.clientUser(client)
+	if user == nil {
+		return ErrNoAuth
+	}
+	if !user.commands[spec.name] {
+		return NoPermissionError(user, cmd)
+	}
+	for _, key := range spec.keysOf(cmd.Args()) {
+		if !user.canAccessKey(key, spec.is(flagWrite)) {
+			return ErrNoKeyPermission
+		}
+	}
+	switch spec.name {
+	case "publish":
+		if !user.canAccessChannel(cmd.Arg(0), false) {
+			return ErrNoChannelPermission
+		}
+	case "subscribe", "psubscribe":
+		for _, channel := range cmd.Args() {
+			if !user.canAccessChannel(channel, spec.name == "psubscribe") {
+				return ErrNoChannelPermission
+			}
+		}
+	}
+	return nil
+}
+
+// setUser creates or modifies a user with the rules of ACL SETUSER. The rules are
+// applied to a copy of the user, which is changed only if all of them are valid.
+func (acl *aclState) setUser(name string, rules []string) error {
+	user := newACLUser(name)
+	existing := acl.users[name]
+	if existing != nil {
+		*user = *existing
+		user.passwords = append([]string(nil), existing.passwords...)
+		user.keys = append([]keyPattern(nil), existing.keys...)
+		user.channels = append([]string(nil), existing.channels...)
+		user.commandRules = append([]string(nil), existing.commandRules...)
+		user.commands = make(map[string]bool, len(existing.commands))
+		for command, allowed := range existing.commands {
+			user.commands[command] = allowed
+		}
+	}
+	for _, rule := range rules {
+		if err := user.setRule(rule); err != nil {
+			return fmt.Errorf("Error in ACL SETUSER modifier '%s': %v", rule, err)
+		}
+	}
+	if existing != nil {
+		*existing = *user
+		return nil
+	}
+	acl.users[name] = user
+	return nil
+}
+
+// sortedUsers returns the users sorted by name
+func (acl *aclState) sortedUsers() []*aclUser {
+	users := make([]*aclUser, 0, len(acl.users))
+	for _, user := range acl.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].name < users[j].name })
+	return users
+}
+
+/* ---------------- ACL file ---------------- */
+
+// readACLFile reads the users of an ACL file, one "user <name> <rules...>" per line.
+// The default user is created if the file doesn't define it.
+func readACLFile(path string, config *Config) (map[string]*aclUser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	acl := aclState{users: make(map[string]*aclUser)}
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		args, err := splitArgs(line)
+		if err == nil && (len(args) < 2 || args[0] != "user") {
+			err = errors.New("line should start with user keyword")
+		}
+		if err == nil && acl.users[args[1]] != nil {
+			err = fmt.Errorf("duplicate user '%s' found", args[1])
+		}
+		if err == nil {
+			err = acl.setUser(args[1], args[2:])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if acl.users[defaultUser] == nil {
+		acl.users[defaultUser] = newDefaultUser(config.RequirePass)
+	}
+	return acl.users, nil
+}
+
+// LoadACL loads the users from the ACL file of the configuration, if any. The users
+// are replaced only if the whole file is valid. A missing file defines no users.
+func (re *RedisExecutorImpl) LoadACL() error {
+	if re.config.ACLFile == "" {
+		return nil
+	}
+	users, err := readACLFile(re.config.ACLFile, re.config)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	re.exclusive(func() { re.acl.users = users })
+	return nil
+}
+
+// saveACL writes the users to the ACL file, replacing it atomically
+func (re *RedisExecutorImpl) saveACL() error {
+	var b strings.Builder
+	for _, user := range re.acl.sortedUsers() {
+		b.WriteString(user.String() + "\n")
+	}
+	path := re.config.ACLFile
+	file, err := os.CreateTemp(filepath.Dir(path), "temp-acl-*.acl")
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(b.String())
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+	}
+	return err
+}
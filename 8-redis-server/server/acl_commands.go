@@ -0,0 +1,160 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"sort"
+	"strings"
+)
+
+func init() {
+	registerCommands(
+		&commandSpec{name: "auth", arity: -2, handler: authCommand, flags: flagNoAuth | flagSkipSlowlog, categories: catConnection},
+		&commandSpec{name: "acl", arity: -2, handler: aclCommand, flags: flagSkipSlowlog, categories: catAdmin | catDangerous},
+	)
+}
+
+// AUTH [username] password, the default user without username
+func authCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	client := cmd.Client()
+	if client == nil {
+		return ErrorResponse(ErrNoClient)
+	}
+	args := cmd.Args()
+	if len(args) > 2 {
+		return ErrorResponse(ErrSyntax)
+	}
+	name, password := defaultUser, args[len(args)-1]
+	if len(args) == 2 {
+		name = args[0]
+	} else if user := re.acl.users[defaultUser]; user.nopass {
+		return ErrorResponse(errors.New("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?"))
+	}
+	user := re.acl.users[name]
+	if user == nil || !user.authenticate(password) {
+		re.Warn("authentication failed", zap.String("user", name), zap.String("addr", client.addr))
+		return ErrorResponse(ErrWrongPass)
+	}
+	client.user = user
+	return OKResponse()
+}
+
+// ACL SETUSER username [rule ...] | GETUSER username | DELUSER username [username ...] |
+// LIST | USERS | WHOAMI | CAT [category] | LOAD | SAVE
+func aclCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	switch subcommand := strings.ToLower(args[0]); {
+	case subcommand == "setuser" && len(args) >= 2:
+		if err := re.acl.setUser(args[1], args[2:]); err != nil {
+			return ErrorResponse(fmt.Errorf("ERR %v", err))
+		}
+		return OKResponse()
+	case subcommand == "getuser" && len(args) == 2:
+		user := re.acl.users[args[1]]
+		if user == nil {
+			return NilResponse()
+		}
+		return aclUserResponse(user)
+	case subcommand == "deluser" && len(args) >= 2:
+		var deleted int64
+		for _, name := range args[1:] {
+			if name == defaultUser {
+				return ErrorResponse(errors.New("ERR The 'default' user cannot be removed"))
+			}
+		}
+		for _, name := range args[1:] {
+			if user := re.acl.users[name]; user != nil {
+				user.removed = true
+				delete(re.acl.users, name)
+				deleted++
+			}
+		}
+		return IntegerResponse(deleted)
+	case subcommand == "list" && len(args) == 1:
+		var lines []string
+		for _, user := range re.acl.sortedUsers() {
+			lines = append(lines, user.String())
+		}
+		return BulkArrayResponse(lines)
+	case subcommand == "users" && len(args) == 1:
+		var names []string
+		for _, user := range re.acl.sortedUsers() {
+			names = append(names, user.name)
+		}
+		return BulkArrayResponse(names)
+	case subcommand == "whoami" && len(args) == 1:
+		if client := cmd.Client(); client != nil && client.user != nil {
+			return BulkResponse(client.user.name)
+		}
+		return BulkResponse(defaultUser)
+	case subcommand == "cat" && len(args) <= 2:
+		return aclCat(args[1:])
+	case (subcommand == "load" || subcommand == "save") && len(args) == 1:
+		if re.config.ACLFile == "" {
+			return ErrorResponse(ErrNoACLFile)
+		}
+		var err error
+		if subcommand == "save" {
+			err = re.saveACL()
+		} else {
+			var users map[string]*aclUser
+			if users, err = readACLFile(re.config.ACLFile, re.config); err == nil {
+				re.acl.users = users
+			}
+		}
+		if err != nil {
+			return ErrorResponse(fmt.Errorf("ERR %v", err))
+		}
+		return OKResponse()
+	}
+	return ErrorResponse(UnknownSubcommandError(cmd))
+}
+
+// aclUserResponse describes a user for ACL GETUSER
+func aclUserResponse(user *aclUser) *RedisResponse {
+	flags := []string{"off"}
+	if user.enabled {
+		flags[0] = "on"
+	}
+	if user.nopass {
+		flags = append(flags, "nopass")
+	}
+	var keys, channels []string
+	for _, kp := range user.keys {
+		keys = append(keys, kp.String())
+	}
+	for _, channel := range user.channels {
+		channels = append(channels, "&"+channel)
+	}
+	return ArrayResponse(
+		BulkResponse("flags"), BulkArrayResponse(flags),
+		BulkResponse("passwords"), BulkArrayResponse(user.passwords),
+		BulkResponse("commands"), BulkResponse(strings.Join(user.commandRules, " ")),
+		BulkResponse("keys"), BulkResponse(strings.Join(keys, " ")),
+		BulkResponse("channels"), BulkResponse(strings.Join(channels, " ")),
+	)
+}
+
+// aclCat lists the categories, or the commands of a category
+func aclCat(args []string) *RedisResponse {
+	if len(args) == 0 {
+		names := make([]string, len(aclCategories))
+		for i, category := range aclCategories {
+			names[i] = category.name
+		}
+		return BulkArrayResponse(names)
+	}
+	category, found := lookupACLCategory(args[0])
+	if !found {
+		return ErrorResponse(fmt.Errorf("ERR Unknown category '%s'", args[0]))
+	}
+	var commands []string
+	for name, spec := range commandTable {
+		if spec.categories&category != 0 {
+			commands = append(commands, name)
+		}
+	}
+	sort.Strings(commands)
+	return BulkArrayResponse(commands)
+}
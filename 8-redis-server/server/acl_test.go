@@ -0,0 +1,152 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuth(t *testing.T) {
+	re := newTestExecutor()
+	c := NewClient(&bufferConn{})
+	runClientSteps(t, re, c, []testStep{
+		{cmd("AUTH", "secret"), "-ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?\r\n"},
+		{cmd("CONFIG", "SET", "requirepass", "secret"), "+OK\r\n"},
+		// an authenticated client stays authenticated
+		{cmd("GET", "k"), "$-1\r\n"},
+	})
+
+	c = NewClient(&bufferConn{})
+	runClientSteps(t, re, c, []testStep{
+		{cmd("GET", "k"), "-NOAUTH Authentication required.\r\n"},
+		{cmd("PING"), "-NOAUTH Authentication required.\r\n"},
+		{cmd("AUTH", "wrong"), "-WRONGPASS invalid username-password pair or user is disabled.\r\n"},
+		{cmd("AUTH", "default", "secret"), "+OK\r\n"},
+		{cmd("ACL", "WHOAMI"), "$7\r\ndefault\r\n"},
+		{cmd("SET", "k", "v"), "+OK\r\n"},
+	})
+	// the server itself is not restricted
+	runSteps(t, re, []testStep{{cmd("GET", "k"), "$1\r\nv\r\n"}})
+}
+
+func TestACLPermissions(t *testing.T) {
+	re := newTestExecutor()
+	runSteps(t, re, []testStep{
+		{cmd("ACL", "SETUSER", "alice", "on", ">p1", "~cache:*", "%R~shared:*", "&news.*", "+@read", "+set", "-hget", "+publish", "+subscribe", "+psubscribe", "+multi", "+exec"), "+OK\r\n"},
+		{cmd("ACL", "SETUSER", "bob", ">p2"), "+OK\r\n"},
+	})
+	c := NewClient(&bufferConn{})
+	runClientSteps(t, re, c, []testStep{
+		{cmd("AUTH", "alice", "p2"), "-WRONGPASS invalid username-password pair or user is disabled.\r\n"},
+		{cmd("AUTH", "bob", "p2"), "-WRONGPASS invalid username-password pair or user is disabled.\r\n"},
+		{cmd("AUTH", "alice", "p1"), "+OK\r\n"},
+		{cmd("ACL", "WHOAMI"), "-NOPERM User alice has no permissions to run the 'acl' command\r\n"},
+		{cmd("SET", "cache:a", "1"), "+OK\r\n"},
+		{cmd("GET", "cache:a"), "$1\r\n1\r\n"},
+		{cmd("GET", "shared:a"), "$-1\r\n"},
+		{cmd("SET", "shared:a", "1"), "-NOPERM No permissions to access a key\r\n"},
+		{cmd("GET", "other"), "-NOPERM No permissions to access a key\r\n"},
+		{cmd("MGET", "cache:a", "other"), "-NOPERM No permissions to access a key\r\n"},
+		{cmd("HGET", "cache:h", "f"), "-NOPERM User alice has no permissions to run the 'hget' command\r\n"},
+		{cmd("DEL", "cache:a"), "-NOPERM User alice has no permissions to run the 'del' command\r\n"},
+		{cmd("PUBLISH", "news.tech", "hi"), ":0\r\n"},
+		{cmd("PUBLISH", "sport", "hi"), "-NOPERM No permissions to access a channel\r\n"},
+		{cmd("PSUBSCRIBE", "news.t*"), "-NOPERM No permissions to access a channel\r\n"},
+		// a rejected command aborts the transaction
+		{cmd("MULTI"), "+OK\r\n"},
+		{cmd("SET", "other", "1"), "-NOPERM No permissions to access a key\r\n"},
+		{cmd("EXEC"), "-EXECABORT Transaction discarded because of previous errors.\r\n"},
+	})
+
+	// the rules are applied all or none, and take effect immediately
+	runSteps(t, re, []testStep{
+		{cmd("ACL", "SETUSER", "alice", "+del", "+foo"), "-ERR Error in ACL SETUSER modifier '+foo': Unknown command or category name in ACL\r\n"},
+		{cmd("ACL", "SETUSER", "alice", "-@read", "allkeys"), "+OK\r\n"},
+	})
+	runClientSteps(t, re, c, []testStep{
+		{cmd("GET", "cache:a"), "-NOPERM User alice has no permissions to run the 'get' command\r\n"},
+		{cmd("SET", "other", "1"), "+OK\r\n"},
+	})
+
+	// the clients of a deleted user are disconnected
+	runSteps(t, re, []testStep{
+		{cmd("ACL", "DELUSER", "alice", "carol"), ":1\r\n"},
+		{cmd("ACL", "DELUSER", "default"), "-ERR The 'default' user cannot be removed\r\n"},
+	})
+	runClientSteps(t, re, c, []testStep{{cmd("SET", "other", "1"), "-NOAUTH Authentication required.\r\n"}})
+}
+
+func TestACLUsers(t *testing.T) {
+	re := newTestExecutor()
+	hash := hashPassword("p1")
+	runSteps(t, re, []testStep{
+		{cmd("ACL", "LIST"), "*1\r\n$34\r\nuser default on nopass ~* &* +@all\r\n"},
+		{cmd("ACL", "SETUSER", "alice"), "+OK\r\n"},
+		{cmd("ACL", "GETUSER", "alice"), "*10\r\n$5\r\nflags\r\n*1\r\n$3\r\noff\r\n$9\r\npasswords\r\n*0\r\n$8\r\ncommands\r\n$5\r\n-@all\r\n$4\r\nkeys\r\n$0\r\n\r\n$8\r\nchannels\r\n$0\r\n\r\n"},
+		{cmd("ACL", "SETUSER", "alice", "on", ">p1", ">p2", "<p2", "~a:*", "%W~b:*", "+@string", "-get", "+get", "&c"), "+OK\r\n"},
+		{cmd("ACL", "SETUSER", "alice", "<p3"), "-ERR Error in ACL SETUSER modifier '<p3': The password you are trying to remove from the user does not exist\r\n"},
+		{cmd("ACL", "SETUSER", "alice", "#abc"), "-ERR Error in ACL SETUSER modifier '#abc': The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters\r\n"},
+		{cmd("ACL", "SETUSER", "alice", "%X~k"), "-ERR Error in ACL SETUSER modifier '%X~k': Syntax error\r\n"},
+		{cmd("ACL", "GETUSER", "bob"), "$-1\r\n"},
+		{cmd("ACL", "USERS"), "*2\r\n$5\r\nalice\r\n$7\r\ndefault\r\n"},
+		{cmd("ACL", "CAT", "nothing"), "-ERR Unknown category 'nothing'\r\n"},
+		{cmd("ACL", "CAT", "transaction"), "*5\r\n$7\r\ndiscard\r\n$4\r\nexec\r\n$5\r\nmulti\r\n$7\r\nunwatch\r\n$5\r\nwatch\r\n"},
+		{cmd("ACL", "WHOAMI"), "$7\r\ndefault\r\n"},
+		{cmd("ACL", "SAVE"), "-" + ErrNoACLFile.Error() + "\r\n"},
+	})
+	want := "user alice on #" + hash + " ~a:* %W~b:* resetchannels &c -@all +@string +get"
+	if got := execute(re, "ACL", "LIST"); !strings.Contains(got, want) {
+		t.Errorf("ACL LIST: got %q, want %q", got, want)
+	}
+	if got := execute(re, "ACL", "CAT"); !strings.HasPrefix(got, "*18\r\n$8\r\nkeyspace\r\n") {
+		t.Errorf("ACL CAT: got %q", got)
+	}
+}
+
+func TestACLFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.acl")
+	re := newTestExecutor()
+	re.config.ACLFile = path
+	if err := re.LoadACL(); err != nil {
+		t.Fatal(err)
+	}
+	runSteps(t, re, []testStep{
+		{cmd("ACL", "SETUSER", "alice", "on", ">p1", "~*", "+get"), "+OK\r\n"},
+		{cmd("ACL", "SETUSER", "default", "resetpass", ">secret"), "+OK\r\n"},
+		{cmd("ACL", "SAVE"), "+OK\r\n"},
+	})
+	saved := execute(re, "ACL", "LIST")
+
+	loaded := newTestExecutor()
+	loaded.config.ACLFile = path
+	if err := loaded.LoadACL(); err != nil {
+		t.Fatal(err)
+	}
+	if got := execute(loaded, "ACL", "LIST"); got != saved {
+		t.Errorf("got %q, want %q", got, saved)
+	}
+	c := NewClient(&bufferConn{})
+	runClientSteps(t, loaded, c, []testStep{
+		{cmd("AUTH", "alice", "p1"), "+OK\r\n"},
+		{cmd("GET", "k"), "$-1\r\n"},
+	})
+
+	// an invalid file is not loaded
+	if err := os.WriteFile(path, []byte("user alice on +get\nuser bob foo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := execute(loaded, "ACL", "LOAD"); got != "-ERR "+path+":2: Error in ACL SETUSER modifier 'foo': Syntax error\r\n" {
+		t.Errorf("ACL LOAD: got %q", got)
+	}
+	if err := os.WriteFile(path, []byte("user alice on nopass ~* +set\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the clients keep their user once reloaded
+	runSteps(t, loaded, []testStep{{cmd("ACL", "LOAD"), "+OK\r\n"}})
+	runClientSteps(t, loaded, c, []testStep{
+		{cmd("GET", "k"), "-NOPERM User alice has no permissions to run the 'get' command\r\n"},
+		{cmd("SET", "k", "v"), "+OK\r\n"},
+	})
+	runSteps(t, loaded, []testStep{{cmd("ACL", "LIST"), "*2\r\n$48\r\nuser alice on nopass ~* resetchannels -@all +set\r\n$34\r\nuser default on nopass ~* &* +@all\r\n"}})
+}
@@ -0,0 +1,608 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// appendfsync policies of the append only file
+const (
+	FsyncAlways   = "always"   // synced after every write command, before its reply
+	FsyncEverySec = "everysec" // synced once per second in the background
+	FsyncNo       = "no"       // synced whenever the operating system flushes the file
+)
+
+// aofRewriteItemsPerCmd is the number of elements of a collection written per command
+// when the append only file is rewritten
+const aofRewriteItemsPerCmd = 64
+
+var (
+	ErrAOFFormat         = errors.New("bad format of the append only file")
+	ErrRewriteInProgress = errors.New("ERR Background append only file rewriting already in progress")
+)
+
+func init() {
+	registerCommands(
+		&commandSpec{name: "bgrewriteaof", arity: 1, handler: bgrewriteaofCommand, categories: catAdmin | catDangerous},
+	)
+}
+
+// aofState is the state of the append only file (AOF), it is guarded by the executor lock.
+// The commands propagated by a command are buffered and written once it completes,
+// before its reply is sent.
+type aofState struct {
+	file      *os.File // nil unless appendonly is enabled
+	buf       []byte   // commands propagated by the current command
+	unsynced  bool     // written since the last fsync, for the everysec policy
+	lastFsync time.Time
+	loading   bool // the log is being replayed, nothing is propagated
+	// database selected by the last SELECT of the log, -1 if unknown. A SELECT is
+	// appended before a command propagated in another database.
+	selectedDB int
+
+	// set while the log is rewritten in the background
+	rewrite *aofRewriteState
+	// BGREWRITEAOF waits for the background snapshot to complete
+	rewriteScheduled bool
+}
+
+type aofRewriteState struct {
+	buf     []byte // commands propagated since the rewrite started, appended to the new log
+	path    string // the log being rewritten
+	name    string // the new log, once written
+	err     error
+	written chan struct{} // closed once the new log is written, see rewriteDone
+	done    chan struct{} // closed once the new log replaced the current one
+}
+
+/* ---------------- format ---------------- */
+
+// appendAOFCommand appends a command to @buf in RESP format, an array of bulk strings
+func appendAOFCommand(buf []byte, argv []string) []byte {
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(argv)), 10)
+	buf = append(buf, "\r\n"...)
+	for _, arg := range argv {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, "\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	return buf
+}
+
+// readAOFLine reads a line ending with CRLF, prefixed with @prefix, and parses the
+// length following the prefix
+func readAOFLine(r *bufio.Reader, prefix byte) (int, int, error) {
+	line, err := r.ReadString('\n')
+	if err == io.EOF && len(line) > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return 0, len(line), err
+	}
+	if len(line) < 3 || line[0] != prefix || line[len(line)-2] != '\r' {
+		return 0, len(line), ErrAOFFormat
+	}
+	n, err := strconv.Atoi(line[1 : len(line)-2])
+	if err != nil || n < 0 {
+		return 0, len(line), ErrAOFFormat
+	}
+	return n, len(line), nil
+}
+
+// readAOFCommand reads a command from the append only file and reports its size.
+// It returns io.EOF at the end of the log and io.ErrUnexpectedEOF if the log ends
+// in the middle of the command.
+func readAOFCommand(r *bufio.Reader) ([]string, int, error) {
+	count, size, err := readAOFLine(r, '*')
+	if err != nil {
+		return nil, size, err
+	}
+	// the lengths are bounded like those of the requests, a corrupted one mustn't
+	// allocate the memory it tells
+	if count == 0 || count > multibulkMaxLen {
+		return nil, size, ErrAOFFormat
+	}
+	argv := make([]string, count)
+	for i := range argv {
+		length, n, err := readAOFLine(r, '$')
+		size += n
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, size, err
+		}
+		if length > bulkMaxLen {
+			return nil, size, ErrAOFFormat
+		}
+		arg := make([]byte, length+2)
+		n, err = io.ReadFull(r, arg)
+		size += n
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, size, err
+		}
+		if arg[length] != '\r' || arg[length+1] != '\n' {
+			return nil, size, ErrAOFFormat
+		}
+		argv[i] = string(arg[:length])
+	}
+	return argv, size, nil
+}
+
+// scanAppendOnly reads the commands of the append only file, calling @fn for each.
+// It returns the offset up to which the log is valid: the end of the last complete
+// command outside of a transaction. A log ending in the middle of a command or of a
+// transaction reports io.ErrUnexpectedEOF, a corrupted one ErrAOFFormat.
+func scanAppendOnly(r io.Reader, fn func(argv []string) error) (int64, error) {
+	reader := bufio.NewReader(r)
+	var offset, valid int64
+	inMulti := false
+	for {
+		argv, size, err := readAOFCommand(reader)
+		if err == io.EOF {
+			if inMulti {
+				return valid, io.ErrUnexpectedEOF
+			}
+			return valid, nil
+		}
+		if errors.Is(err, ErrAOFFormat) {
+			return valid, fmt.Errorf("%w at offset %d", ErrAOFFormat, offset)
+		}
+		if err != nil {
+			return valid, err
+		}
+		offset += int64(size)
+
+		switch strings.ToLower(argv[0]) {
+		case "multi":
+			inMulti = true
+		case "exec":
+			inMulti = false
+		}
+		if !inMulti {
+			valid = offset
+		}
+		if fn != nil {
+			if err := fn(argv); err != nil {
+				return valid, err
+			}
+		}
+	}
+}
+
+// writeAppendOnly writes the commands rebuilding the items of each database, those of
+// a database following a SELECT
+func writeAppendOnly(w io.Writer, dbs [][]CacheItem) error {
+	bw := bufio.NewWriter(w)
+	var buf []byte
+	// batch writes the elements of a collection, @width strings per element
+	batch := func(name, key string, width int, elements []string) {
+		for start := 0; start < len(elements); start += width * aofRewriteItemsPerCmd {
+			end := min(start+width*aofRewriteItemsPerCmd, len(elements))
+			buf = appendAOFCommand(buf, append([]string{name, key}, elements[start:end]...))
+		}
+	}
+	for db, items := range dbs {
+		if len(items) == 0 {
+			continue
+		}
+		if _, err := bw.Write(appendAOFCommand(nil, []string{"select", strconv.Itoa(db)})); err != nil {
+			return err
+		}
+		for i := range items {
+			item := &items[i]
+			buf = buf[:0]
+			switch item.Type {
+			case StringType:
+				buf = appendAOFCommand(buf, []string{"set", item.Key, string(item.StringValue())})
+			case ListType:
+				list := item.ListValue()
+				elements := make([]string, list.Len())
+				for i := range elements {
+					elements[i] = list.Index(i)
+				}
+				batch("rpush", item.Key, 1, elements)
+			case SetType:
+				elements := make([]string, 0, item.SetValue().Len())
+				item.SetValue().ForEach(func(member string, _ struct{}) bool {
+					elements = append(elements, member)
+					return true
+				})
+				batch("sadd", item.Key, 1, elements)
+			case HashType:
+				elements := make([]string, 0, 2*item.HashValue().Len())
+				item.HashValue().ForEach(func(field, value string) bool {
+					elements = append(elements, field, value)
+					return true
+				})
+				batch("hset", item.Key, 2, elements)
+			case ZSetType:
+				zset := item.ZSetValue()
+				elements := make([]string, 0, 2*zset.Len())
+				for node := zset.zsl.header.level[0].forward; node != nil; node = node.level[0].forward {
+					elements = append(elements, formatScore(node.score), node.member)
+				}
+				batch("zadd", item.Key, 2, elements)
+			case StreamType:
+				buf = appendStreamCommands(buf, item.Key, item.StreamValue())
+			}
+			if item.ExpireAt > 0 {
+				buf = appendAOFCommand(buf, []string{"pexpireat", item.Key, strconv.FormatInt(item.ExpireAt, 10)})
+			}
+			if _, err := bw.Write(buf); err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// appendStreamCommands appends the commands rebuilding a stream: XADD for each entry, or
+// an XADD trimmed right away for an empty stream, XSETID for its IDs and counters,
+// XGROUP CREATE for each group, and XCLAIM for the pending entries of its consumers
+func appendStreamCommands(buf []byte, key string, stream *Stream) []byte {
+	for _, node := range stream.nodes {
+		for _, entry := range node.entries {
+			buf = appendAOFCommand(buf, append([]string{"xadd", key, entry.id.String()}, entry.fields...))
+		}
+	}
+	if stream.Len() == 0 {
+		id := stream.lastID
+		if id == (streamID{}) {
+			id.seq = 1
+		}
+		buf = appendAOFCommand(buf, []string{"xadd", key, "MAXLEN", "0", id.String(), "x", "y"})
+	}
+	buf = appendAOFCommand(buf, []string{"xsetid", key, stream.lastID.String(),
+		"ENTRIESADDED", strconv.FormatInt(stream.entriesAdded, 10), "MAXDELETEDID", stream.maxDeletedID.String()})
+	for _, cg := range stream.sortedGroups() {
+		buf = appendAOFCommand(buf, []string{"xgroup", "create", key, cg.name, cg.lastID.String()})
+		for _, consumer := range cg.sortedConsumers() {
+			buf = appendAOFCommand(buf, []string{"xgroup", "createconsumer", key, cg.name, consumer.name})
+		}
+		for _, nack := range cg.pel {
+			buf = appendAOFCommand(buf, []string{"xclaim", key, cg.name, nack.consumer.name, "0", nack.id.String(),
+				"TIME", strconv.FormatInt(nack.deliveryTime, 10), "RETRYCOUNT", strconv.FormatInt(nack.deliveryCount, 10),
+				"JUSTID", "FORCE"})
+		}
+	}
+	return buf
+}
+
+// rewriteAppendOnlyFile writes the commands rebuilding the items to a temporary
+// file next to @path, which is synced, and returns its name
+func rewriteAppendOnlyFile(path string, dbs [][]CacheItem) (name string, err error) {
+	file, err := os.CreateTemp(filepath.Dir(path), "temp-rewriteaof-*.aof")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			_ = os.Remove(file.Name())
+		}
+	}()
+	if err = writeAppendOnly(file, dbs); err != nil {
+		return "", err
+	}
+	return file.Name(), file.Sync()
+}
+
+// openAppendOnlyFile opens the append only file for appending, creating it if needed
+func openAppendOnlyFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+}
+
+/* ---------------- executor ---------------- */
+
+// feedAppendOnly buffers a command propagated in the database at index @db, it is
+// written once the current command completes. While the log is rewritten it is also
+// kept for the new log.
+func (re *RedisExecutorImpl) feedAppendOnly(db int, argv []string) {
+	if re.aof.file == nil && re.aof.rewrite == nil {
+		return
+	}
+	start := len(re.aof.buf)
+	if db != re.aof.selectedDB {
+		re.aof.buf = appendAOFCommand(re.aof.buf, []string{"select", strconv.Itoa(db)})
+		re.aof.selectedDB = db
+	}
+	re.aof.buf = appendAOFCommand(re.aof.buf, argv)
+	if re.aof.rewrite != nil {
+		re.aof.rewrite.buf = append(re.aof.rewrite.buf, re.aof.buf[start:]...)
+	}
+}
+
+// flushAppendOnly writes the commands propagated by the current command to the
+// append only file, syncing it with the always policy
+func (re *RedisExecutorImpl) flushAppendOnly() {
+	if len(re.aof.buf) == 0 {
+		return
+	}
+	buf := re.aof.buf
+	re.aof.buf = re.aof.buf[:0]
+	if re.aof.file == nil {
+		return
+	}
+	start := time.Now()
+	defer func() { re.latencyAddSample("aof-write", time.Since(start)) }()
+	if _, err := re.aof.file.Write(buf); err != nil {
+		re.Error("error while writing the append only file", zap.Error(err))
+		return
+	}
+	switch re.config.AppendFsync {
+	case FsyncAlways:
+		if err := re.aof.file.Sync(); err != nil {
+			re.Error("error while syncing the append only file", zap.Error(err))
+		}
+		re.aof.lastFsync = time.Now()
+	case FsyncEverySec:
+		re.aof.unsynced = true
+	}
+}
+
+// appendOnlyCron syncs the append only file every second with the everysec policy,
+// and starts a scheduled rewrite once no background save runs
+func (re *RedisExecutorImpl) appendOnlyCron(now time.Time) {
+	if re.aof.file != nil && re.aof.unsynced && re.config.AppendFsync == FsyncEverySec &&
+		now.Sub(re.aof.lastFsync) >= time.Second {
+		// synced in the background, the file being written meanwhile is fine
+		file := re.aof.file
+		go func() {
+			if err := file.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+				re.Error("error while syncing the append only file", zap.Error(err))
+			}
+		}()
+		re.aof.unsynced = false
+		re.aof.lastFsync = now
+	}
+	if re.aof.rewriteScheduled && re.rdb.bgsave == nil {
+		_ = re.rewriteAppendOnly()
+	}
+}
+
+// rewriteAppendOnly compacts the append only file in the background: the log is
+// rebuilt from the items of the datastore, shared copy-on-write as for BGSAVE. The
+// commands propagated meanwhile are appended to the new log, which then replaces
+// the current one. The rewrite is scheduled if a background snapshot is running.
+func (re *RedisExecutorImpl) rewriteAppendOnly() error {
+	if re.aof.rewrite != nil {
+		return ErrRewriteInProgress
+	}
+	if re.rdb.bgsave != nil {
+		re.aof.rewriteScheduled = true
+		return nil
+	}
+	re.aof.rewriteScheduled = false
+	dbs := re.snapshotItems(true)
+	state := &aofRewriteState{
+		path:    re.config.AppendOnlyPath(),
+		written: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	re.aof.rewrite = state
+	// the commands appended to the new log don't follow the SELECT of the current one
+	re.aof.selectedDB = -1
+
+	go func() {
+		state.name, state.err = rewriteAppendOnlyFile(state.path, dbs)
+		close(state.written)
+		re.exclusive(func() { re.rewriteDone(state) })
+	}()
+	return nil
+}
+
+// rewriteDone completes the rewrite once the new log is written, unless the shutdown
+// completed it already
+func (re *RedisExecutorImpl) rewriteDone(state *aofRewriteState) {
+	if re.aof.rewrite != state {
+		return
+	}
+	err := state.err
+	if err == nil {
+		err = re.installRewrite(state.path, state.name)
+	}
+	if err != nil {
+		re.Error("error while rewriting the append only file", zap.Error(err))
+		if state.name != "" {
+			_ = os.Remove(state.name)
+		}
+	} else {
+		re.Info("append only file rewritten", zap.String("path", state.path))
+	}
+	re.aof.rewrite = nil
+	re.shared = nil
+	close(state.done)
+}
+
+// installRewrite appends the commands propagated during the rewrite to the new log,
+// which replaces the current one
+func (re *RedisExecutorImpl) installRewrite(path, name string) error {
+	file, err := openAppendOnlyFile(name)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(re.aof.rewrite.buf); err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err = os.Rename(name, path); err != nil {
+		_ = file.Close()
+		return err
+	}
+	// the file is still open, appending to the new log
+	if re.aof.file != nil {
+		_ = re.aof.file.Close()
+		re.aof.file = file
+		re.aof.lastFsync = time.Now()
+		re.aof.unsynced = false
+		return nil
+	}
+	return file.Close()
+}
+
+// applyAppendOnly follows the appendonly option changed by CONFIG SET. Once enabled,
+// the log is rewritten from the datastore, the commands being appended meanwhile.
+func (re *RedisExecutorImpl) applyAppendOnly() {
+	if re.config.AppendOnly == (re.aof.file != nil) {
+		return
+	}
+	if !re.config.AppendOnly {
+		if err := re.aof.file.Close(); err != nil {
+			re.Error("error while closing the append only file", zap.Error(err))
+		}
+		re.aof.file = nil
+		re.aof.unsynced = false
+		return
+	}
+	file, err := openAppendOnlyFile(re.config.AppendOnlyPath())
+	if err != nil {
+		re.Error("error while opening the append only file", zap.Error(err))
+		re.config.AppendOnly = false
+		return
+	}
+	re.aof.file = file
+	re.aof.lastFsync = time.Now()
+	if err := re.rewriteAppendOnly(); err != nil && err != ErrRewriteInProgress {
+		re.Error("error while rewriting the append only file", zap.Error(err))
+	}
+}
+
+// LoadData loads the datastore at startup: from the append only file when it is
+// enabled, which is then opened for appending, otherwise from the snapshot
+func (re *RedisExecutorImpl) LoadData() error {
+	if !re.config.AppendOnly {
+		return re.LoadSnapshot()
+	}
+	if err := re.LoadAppendOnly(); err != nil {
+		return err
+	}
+	file, err := openAppendOnlyFile(re.config.AppendOnlyPath())
+	if err != nil {
+		return err
+	}
+	re.exclusive(func() {
+		re.aof.file = file
+		re.aof.lastFsync = time.Now()
+		// the log may end in any database
+		re.aof.selectedDB = -1
+	})
+	return nil
+}
+
+// LoadAppendOnly replays the append only file. A log truncated at its end, e.g. by a
+// crash in the middle of a write, is truncated to its last complete command (or
+// transaction) and loaded. A corrupted log is not loaded, see CheckAppendOnly.
+func (re *RedisExecutorImpl) LoadAppendOnly() error {
+	path := re.config.AppendOnlyPath()
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	re.exclusive(func() { err = re.replayAppendOnly(path, file) })
+	return err
+}
+
+// replayAppendOnly runs the commands of the append only file
+func (re *RedisExecutorImpl) replayAppendOnly(path string, file *os.File) error {
+	re.aof.loading = true
+	defer func() { re.aof.loading = false }()
+
+	// the commands of the log run as those of a client without connection,
+	// its transactions are queued and executed like any other
+	client := NewClient(nil)
+	commands := 0
+	valid, err := scanAppendOnly(file, func(argv []string) error {
+		if err := re.executeLocal(client, argv); err != nil {
+			return fmt.Errorf("%w: %v", ErrAOFFormat, err)
+		}
+		commands++
+		return nil
+	})
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		re.Warn("the append only file is truncated, loading up to its last complete command",
+			zap.String("path", path), zap.Int64("offset", valid))
+		err = os.Truncate(path, valid)
+	}
+	if err != nil {
+		return err
+	}
+	re.rdb.dirty = 0
+	re.Info("append only file loaded", zap.String("path", path), zap.Int("commands", commands))
+	return nil
+}
+
+// CheckAppendOnly validates the append only file at @path, like redis-check-aof, and
+// reports its findings to @out. With @fix, an invalid log is truncated to its last
+// valid command: a truncated tail or a corrupted part is discarded, with what follows.
+func CheckAppendOnly(path string, fix bool, out io.Writer) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	valid, err := scanAppendOnly(file, nil)
+	switch {
+	case err == nil:
+		_, _ = fmt.Fprintf(out, "AOF analyzed: size=%d, ok_up_to=%d, diff=0\nAOF is valid\n", info.Size(), valid)
+		return true, nil
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		_, _ = fmt.Fprintf(out, "Unexpected end of file at offset %d\n", valid)
+	case errors.Is(err, ErrAOFFormat):
+		_, _ = fmt.Fprintf(out, "%v\n", err)
+	default:
+		return false, err
+	}
+	_, _ = fmt.Fprintf(out, "AOF analyzed: size=%d, ok_up_to=%d, diff=%d\n", info.Size(), valid, info.Size()-valid)
+	if !fix {
+		_, _ = fmt.Fprintln(out, "AOF is not valid. Use the --fix option to try fixing it.")
+		return false, nil
+	}
+	if err := os.Truncate(path, valid); err != nil {
+		return false, err
+	}
+	_, _ = fmt.Fprintf(out, "Successfully truncated AOF to %d bytes\n", valid)
+	return true, nil
+}
+
+/* ---------------- commands ---------------- */
+
+// BGREWRITEAOF
+func bgrewriteaofCommand(re *RedisExecutorImpl, _ *Cmd) *RedisResponse {
+	if err := re.rewriteAppendOnly(); err != nil {
+		return ErrorResponse(err)
+	}
+	if re.aof.rewriteScheduled {
+		return SimpleStringResponse("Background append only file rewriting scheduled")
+	}
+	return SimpleStringResponse("Background append only file rewriting started")
+}
@@ -0,0 +1,318 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newAppendOnlyExecutor returns an executor logging to an append only file in @dir
+func newAppendOnlyExecutor(t *testing.T, dir string) *RedisExecutorImpl {
+	re := newTestExecutor()
+	re.config.Dir = dir
+	re.config.AppendOnly = true
+	re.config.AppendFsync = FsyncAlways
+	if err := re.LoadData(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = re.aof.file.Close() })
+	return re
+}
+
+// readAppendOnly returns the commands of the append only file, the unix times in
+// milliseconds being replaced with <ms>
+func readAppendOnly(t *testing.T, path string) []string {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	ms := regexp.MustCompile(`\b\d{13}\b`)
+	var commands []string
+	_, err = scanAppendOnly(file, func(argv []string) error {
+		commands = append(commands, ms.ReplaceAllString(strings.Join(argv, " "), "<ms>"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return commands
+}
+
+func TestPropagation(t *testing.T) {
+	re := newAppendOnlyExecutor(t, t.TempDir())
+	c := NewClient(&bufferConn{})
+	runClientSteps(t, re, c, []testStep{
+		{cmd("SET", "str", "v", "EX", "100"), "+OK\r\n"},
+		{cmd("GET", "str"), "$1\r\nv\r\n"},
+		{cmd("SET", "str", "w", "NX"), "$-1\r\n"},
+		{cmd("EXPIRE", "str", "50"), ":1\r\n"},
+		{cmd("INCRBYFLOAT", "float", "0.1"), "$3\r\n0.1\r\n"},
+		{cmd("SADD", "set", "a"), ":1\r\n"},
+		{cmd("SPOP", "set"), "$1\r\na\r\n"},
+		{cmd("LPUSH", "list", "a"), ":1\r\n"},
+		{cmd("BLPOP", "list", "0"), "*2\r\n$4\r\nlist\r\n$1\r\na\r\n"},
+		{cmd("MULTI"), "+OK\r\n"},
+		{cmd("INCR", "n"), "+QUEUED\r\n"},
+		{cmd("GET", "n"), "+QUEUED\r\n"},
+		{cmd("EXEC"), "*2\r\n:1\r\n$1\r\n1\r\n"},
+		{cmd("MULTI"), "+OK\r\n"},
+		{cmd("GET", "n"), "+QUEUED\r\n"},
+		{cmd("EXEC"), "*1\r\n$1\r\n1\r\n"},
+		{cmd("DEL", "missing"), ":0\r\n"},
+	})
+
+	got := readAppendOnly(t, re.config.AppendOnlyPath())
+	want := []string{
+		"select 0",
+		"set str v pxat <ms>",
+		"pexpireat str <ms>",
+		"set float 0.1 keepttl",
+		"sadd set a",
+		"srem set a",
+		"lpush list a",
+		"lpop list",
+		"multi",
+		"incr n",
+		"exec",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestPropagationServedClient(t *testing.T) {
+	re := newAppendOnlyExecutor(t, t.TempDir())
+	done := make(chan string)
+	go func() { done <- execute(re, "BLMOVE", "src", "dst", "LEFT", "RIGHT", "0") }()
+	for blocked := 0; blocked == 0; time.Sleep(time.Millisecond) {
+		re.mu.Lock()
+		blocked = len(re.blocked[dbKey{0, "src"}])
+		re.mu.Unlock()
+	}
+	execute(re, "RPUSH", "src", "a")
+	if got := <-done; got != "$1\r\na\r\n" {
+		t.Fatalf("BLMOVE: got %q", got)
+	}
+
+	got := readAppendOnly(t, re.config.AppendOnlyPath())
+	want := []string{"select 0", "rpush src a", "lmove src dst LEFT RIGHT"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLoadAppendOnly(t *testing.T) {
+	dir := t.TempDir()
+	re := newAppendOnlyExecutor(t, dir)
+	populate(re)
+	execute(re, "INCRBYFLOAT", "float", "1.5")
+	want := dump(re)
+
+	loaded := newAppendOnlyExecutor(t, dir)
+	got := dump(loaded)
+	for name := range want {
+		if got[name] != want[name] {
+			t.Errorf("%s: got %q, want %q", name, got[name], want[name])
+		}
+	}
+	if loaded.rdb.dirty != 0 {
+		t.Errorf("dirty: got %d, want 0", loaded.rdb.dirty)
+	}
+}
+
+func TestLoadTruncatedAppendOnly(t *testing.T) {
+	tests := []struct {
+		name string
+		tail string
+	}{
+		{"command", "*3\r\n$3\r\nset\r\n$1\r\nk"},
+		{"line", "*3\r\n$3"},
+		{"transaction", "*1\r\n$5\r\nmulti\r\n*3\r\n$3\r\nset\r\n$1\r\nk\r\n$1\r\nv\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			re := newAppendOnlyExecutor(t, dir)
+			execute(re, "SET", "a", "1")
+			path := re.config.AppendOnlyPath()
+			valid, _ := os.Stat(path)
+			if _, err := re.aof.file.WriteString(tt.tail); err != nil {
+				t.Fatal(err)
+			}
+
+			loaded := newAppendOnlyExecutor(t, dir)
+			if got := execute(loaded, "GET", "a"); got != "$1\r\n1\r\n" {
+				t.Errorf("GET a: got %q", got)
+			}
+			if got := execute(loaded, "EXISTS", "k"); got != ":0\r\n" {
+				t.Errorf("EXISTS k: got %q", got)
+			}
+			if info, _ := os.Stat(path); info.Size() != valid.Size() {
+				t.Errorf("size: got %d, want %d", info.Size(), valid.Size())
+			}
+		})
+	}
+}
+
+func TestLoadCorruptedAppendOnly(t *testing.T) {
+	re := newTestExecutor()
+	re.config.Dir = t.TempDir()
+	content := "*2\r\n$3\r\nget\r\n$1\r\nk\r\n*1\r\n$3\r\nxyz\r\n"
+	if err := os.WriteFile(re.config.AppendOnlyPath(), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := re.LoadAppendOnly(); err == nil {
+		t.Error("an unknown command was loaded")
+	}
+
+	// a corrupted length is reported rather than allocated
+	content = "*2\r\n$3\r\nget\r\n$999999999999999\r\nk\r\n"
+	if err := os.WriteFile(re.config.AppendOnlyPath(), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := re.LoadAppendOnly(); !errors.Is(err, ErrAOFFormat) {
+		t.Errorf("got %v, want %v", err, ErrAOFFormat)
+	}
+}
+
+func TestCheckAppendOnly(t *testing.T) {
+	valid := "*3\r\n$3\r\nset\r\n$1\r\nk\r\n$1\r\nv\r\n"
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"valid", valid, "AOF is valid"},
+		{"truncated", valid + "*2\r\n$3\r\nget\r\n$1", "Unexpected end of file at offset 27"},
+		{"corrupted", valid + "*2\r\n$3\r\nget\r\n%1\r\nk\r\n" + valid, "bad format of the append only file at offset 27"},
+		// lengths which can't be allocated
+		{"argument count", valid + "*999999999999999999\r\n$3\r\nget\r\n", "bad format of the append only file at offset 27"},
+		{"argument length", valid + "*2\r\n$3\r\nget\r\n$999999999999999\r\nk\r\n", "bad format of the append only file at offset 27"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := t.TempDir() + "/appendonly.aof"
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			ok, err := CheckAppendOnly(path, false, &out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != (tt.name == "valid") || !strings.Contains(out.String(), tt.want) {
+				t.Errorf("got %v %q, want %q", ok, out.String(), tt.want)
+			}
+
+			out.Reset()
+			if ok, err = CheckAppendOnly(path, true, &out); !ok || err != nil {
+				t.Fatalf("fix: got %v %v, output %q", ok, err, out.String())
+			}
+			if content, _ := os.ReadFile(path); string(content) != valid {
+				t.Errorf("fixed: got %q, want %q", content, valid)
+			}
+		})
+	}
+}
+
+// waitRewrite waits for the rewrite of the append only file to complete
+func waitRewrite(re *RedisExecutorImpl) {
+	re.mu.Lock()
+	state := re.aof.rewrite
+	re.mu.Unlock()
+	if state != nil {
+		<-state.done
+	}
+}
+
+func TestBgrewriteaof(t *testing.T) {
+	dir := t.TempDir()
+	re := newAppendOnlyExecutor(t, dir)
+	populate(re)
+	for i := 0; i < 100; i++ {
+		execute(re, "INCR", "counter")
+	}
+	for i := 0; i < 200; i++ {
+		execute(re, "RPUSH", "long", "x")
+	}
+
+	runSteps(t, re, []testStep{
+		{cmd("BGREWRITEAOF"), "+Background append only file rewriting started\r\n"},
+		{cmd("BGREWRITEAOF"), "-ERR Background append only file rewriting already in progress\r\n"},
+		{cmd("BGSAVE"), "-ERR An AOF log rewriting in progress: can't BGSAVE right now\r\n"},
+	})
+	// the writes made during the rewrite are kept
+	execute(re, "RPUSH", "list", "during")
+	execute(re, "INCR", "counter")
+	waitRewrite(re)
+	execute(re, "RPUSH", "list", "after")
+	want := dump(re)
+
+	commands := readAppendOnly(t, re.config.AppendOnlyPath())
+	counts := make(map[string]int)
+	for _, command := range commands {
+		counts[strings.Fields(command)[0]]++
+	}
+	if counts["incr"] > 1 || counts["rpush"] != 1+(200+aofRewriteItemsPerCmd-1)/aofRewriteItemsPerCmd+2 {
+		t.Errorf("the log wasn't compacted: %q", commands)
+	}
+
+	loaded := newAppendOnlyExecutor(t, dir)
+	got := dump(loaded)
+	for name := range want {
+		if got[name] != want[name] {
+			t.Errorf("%s: got %q, want %q", name, got[name], want[name])
+		}
+	}
+	if got := execute(loaded, "GET", "counter"); got != "$3\r\n101\r\n" {
+		t.Errorf("counter: got %q", got)
+	}
+}
+
+func TestBgrewriteaofScheduled(t *testing.T) {
+	re := newTestExecutor()
+	re.config.Dir = t.TempDir()
+	populate(re)
+	re.mu.Lock()
+	_ = re.bgsave()
+	re.mu.Unlock()
+	if got := execute(re, "BGREWRITEAOF"); got != "+Background append only file rewriting scheduled\r\n" {
+		t.Fatalf("BGREWRITEAOF: got %q", got)
+	}
+	waitBgsave(re)
+	re.mu.Lock()
+	re.appendOnlyCron(re.aof.lastFsync)
+	re.mu.Unlock()
+	waitRewrite(re)
+
+	content, err := os.ReadFile(re.config.AppendOnlyPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := scanAppendOnly(bytes.NewReader(content), nil); err != nil || len(content) == 0 {
+		t.Errorf("rewritten log: %q %v", content, err)
+	}
+}
+
+func TestReadAOFCommand(t *testing.T) {
+	var buf []byte
+	buf = appendAOFCommand(buf, []string{"set", "k", "a\r\nb"})
+	buf = appendAOFCommand(buf, []string{"del", ""})
+	r := bufio.NewReader(bytes.NewReader(buf))
+	for _, want := range []string{"set k a\r\nb", "del "} {
+		argv, _, err := readAOFCommand(r)
+		if err != nil || strings.Join(argv, " ") != want {
+			t.Errorf("got %q %v, want %q", argv, err, want)
+		}
+	}
+	if _, _, err := readAOFCommand(r); err != io.EOF {
+		t.Errorf("end: got %v, want EOF", err)
+	}
+}
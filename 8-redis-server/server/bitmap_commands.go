@@ -0,0 +1,523 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrBitOffset    = errors.New("ERR bit offset is not an integer or out of range")
+	ErrBitValue     = errors.New("ERR bit is not an integer or out of range")
+	ErrBitposBit    = errors.New("ERR The bit argument must be 1 or 0.")
+	ErrBitopNot     = errors.New("ERR BITOP NOT must be called with a single source key.")
+	ErrBitfieldType = errors.New("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+	ErrOverflowType = errors.New("ERR Invalid OVERFLOW type specified")
+	ErrBitfieldRO   = errors.New("ERR BITFIELD_RO only supports the GET subcommand")
+)
+
+func init() {
+	registerCommands(
+		&commandSpec{name: "setbit", arity: 4, handler: setbitCommand, flags: flagWrite | flagDenyOOM, keys: firstKey, categories: catBitmap},
+		&commandSpec{name: "getbit", arity: 3, handler: getbitCommand, keys: firstKey, categories: catBitmap},
+		&commandSpec{name: "bitcount", arity: -2, handler: bitcountCommand, keys: firstKey, categories: catBitmap},
+		&commandSpec{name: "bitpos", arity: -3, handler: bitposCommand, keys: firstKey, categories: catBitmap},
+		&commandSpec{name: "bitop", arity: -4, handler: bitopCommand, flags: flagWrite | flagDenyOOM, keys: keySpec{1, -1, 1}, categories: catBitmap},
+		&commandSpec{name: "bitfield", arity: -2, handler: bitfieldCommand, flags: flagWrite | flagDenyOOM, keys: firstKey, categories: catBitmap},
+		&commandSpec{name: "bitfield_ro", arity: -2, handler: bitfieldCommand, keys: firstKey, categories: catBitmap},
+	)
+}
+
+/* ---------------- helpers ---------------- */
+
+// parseBitOffset parses the offset of a bit, prefixed by # it is a number of integers
+// of @width bits
+func parseBitOffset(arg string, width int64) (int64, error) {
+	multiply := strings.HasPrefix(arg, "#")
+	offset, ok := parseInt(strings.TrimPrefix(arg, "#"))
+	if multiply {
+		if !ok || offset > math.MaxInt64/width {
+			return 0, ErrBitOffset
+		}
+		offset *= width
+	}
+	if !ok || offset < 0 || offset>>3 >= maxStringLength {
+		return 0, ErrBitOffset
+	}
+	return offset, nil
+}
+
+// growBitmap returns the string at key zero-padded to hold @n bits, creating it if needed
+func growBitmap(re *RedisExecutorImpl, key string, n int64) (*CacheItem, error) {
+	item, found, err := lookupString(re, key)
+	if err != nil {
+		return nil, err
+	}
+	size := (n + 7) / 8
+	if !found {
+		item = &CacheItem{Key: key, Value: make([]byte, size), Type: StringType}
+		_ = re.Set(key, item)
+		return item, nil
+	}
+	item.Value = growString(item.StringValue(), size)
+	return item, nil
+}
+
+// getBit returns the bit at @offset, the bits past the end of the string are 0. The
+// bit 0 is the most significant bit of the first byte.
+func getBit(value []byte, offset int64) byte {
+	if offset>>3 >= int64(len(value)) {
+		return 0
+	}
+	return value[offset>>3] >> (7 - offset&7) & 1
+}
+
+func setBit(value []byte, offset int64, bit byte) {
+	mask := byte(1) << (7 - offset&7)
+	if bit == 1 {
+		value[offset>>3] |= mask
+	} else {
+		value[offset>>3] &^= mask
+	}
+}
+
+// bitRange converts the range of BITCOUNT and BITPOS, in bytes or in bits if @bit is
+// set, to a range of bits of a string of @length bytes. Negative indexes count from the
+// end of the string. It returns false if the range is empty.
+func bitRange(start, end int64, bit bool, length int64) (int64, int64, bool) {
+	total := length
+	if bit {
+		total = length * 8
+	}
+	if start < 0 {
+		start += total
+	}
+	if end < 0 {
+		end += total
+	}
+	start, end = max(start, 0), min(max(end, 0), total-1)
+	if start > end {
+		return 0, 0, false
+	}
+	if !bit {
+		start, end = start*8, end*8+7
+	}
+	return start, end, true
+}
+
+// parseBitUnit parses the unit of the range of BITCOUNT and BITPOS, it returns true for BIT
+func parseBitUnit(arg string) (bool, error) {
+	switch strings.ToLower(arg) {
+	case "byte":
+		return false, nil
+	case "bit":
+		return true, nil
+	}
+	return false, ErrSyntax
+}
+
+/* ---------------- commands ---------------- */
+
+// SETBIT key offset value
+func setbitCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	offset, err := parseBitOffset(cmd.Arg(1), 1)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if cmd.Arg(2) != "0" && cmd.Arg(2) != "1" {
+		return ErrorResponse(ErrBitValue)
+	}
+
+	key := cmd.Arg(0)
+	item, err := growBitmap(re, key, offset+1)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	value := item.StringValue()
+	old := getBit(value, offset)
+	setBit(value, offset, cmd.Arg(2)[0]-'0')
+	re.signalModifiedKey(key)
+	re.notifyKeyspaceEvent(notifyString, "setbit", key)
+	return IntegerResponse(int64(old))
+}
+
+// GETBIT key offset
+func getbitCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	offset, err := parseBitOffset(cmd.Arg(1), 1)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	item, found, err := lookupString(re, cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return IntegerResponse(0)
+	}
+	return IntegerResponse(int64(getBit(item.StringValue(), offset)))
+}
+
+// BITCOUNT key [start end [BYTE | BIT]]
+func bitcountCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	start, end := int64(0), int64(-1)
+	var bit bool
+	switch len(args) {
+	case 1:
+	case 3, 4:
+		var ok1, ok2 bool
+		start, ok1 = parseInt(args[1])
+		end, ok2 = parseInt(args[2])
+		if !ok1 || !ok2 {
+			return ErrorResponse(ErrNotInteger)
+		}
+		if len(args) == 4 {
+			var err error
+			if bit, err = parseBitUnit(args[3]); err != nil {
+				return ErrorResponse(err)
+			}
+		}
+	default:
+		return ErrorResponse(ErrSyntax)
+	}
+
+	item, found, err := lookupString(re, args[0])
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return IntegerResponse(0)
+	}
+	value := item.StringValue()
+	first, last, ok := bitRange(start, end, bit, int64(len(value)))
+	if !ok {
+		return IntegerResponse(0)
+	}
+	var count int
+	for i := first >> 3; i <= last>>3; i++ {
+		b := value[i]
+		if i == first>>3 {
+			b &= 0xff >> (first & 7)
+		}
+		if i == last>>3 {
+			b &= 0xff << (7 - last&7)
+		}
+		count += bits.OnesCount8(b)
+	}
+	return IntegerResponse(int64(count))
+}
+
+// BITPOS key bit [start [end [BYTE | BIT]]]
+func bitposCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	if args[1] != "0" && args[1] != "1" {
+		return ErrorResponse(ErrBitposBit)
+	}
+	bit := args[1][0] - '0'
+	start, end := int64(0), int64(-1)
+	var unitBit bool
+	if len(args) > 5 {
+		return ErrorResponse(ErrSyntax)
+	}
+	if len(args) > 2 {
+		var ok bool
+		if start, ok = parseInt(args[2]); !ok {
+			return ErrorResponse(ErrNotInteger)
+		}
+	}
+	endGiven := len(args) > 3
+	if endGiven {
+		var ok bool
+		if end, ok = parseInt(args[3]); !ok {
+			return ErrorResponse(ErrNotInteger)
+		}
+	}
+	if len(args) == 5 {
+		var err error
+		if unitBit, err = parseBitUnit(args[4]); err != nil {
+			return ErrorResponse(err)
+		}
+	}
+
+	item, found, err := lookupString(re, args[0])
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		// a missing key is an empty string, padded with 0 bits
+		if bit == 0 {
+			return IntegerResponse(0)
+		}
+		return IntegerResponse(-1)
+	}
+	value := item.StringValue()
+	first, last, ok := bitRange(start, end, unitBit, int64(len(value)))
+	if !ok {
+		return IntegerResponse(-1)
+	}
+	// the bytes without the bit are skipped at once
+	skip := byte(0)
+	if bit == 0 {
+		skip = 0xff
+	}
+	for i := first; i <= last; {
+		if i&7 == 0 && i+7 <= last && value[i>>3] == skip {
+			i += 8
+			continue
+		}
+		if getBit(value, i) == bit {
+			return IntegerResponse(i)
+		}
+		i++
+	}
+	// without an end, the string is considered padded with 0 bits on the right
+	if bit == 0 && !endGiven {
+		return IntegerResponse(last + 1)
+	}
+	return IntegerResponse(-1)
+}
+
+// BITOP AND | OR | XOR | NOT destkey key [key ...]
+func bitopCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	op := strings.ToLower(args[0])
+	switch op {
+	case "and", "or", "xor":
+	case "not":
+		if len(args) != 3 {
+			return ErrorResponse(ErrBitopNot)
+		}
+	default:
+		return ErrorResponse(ErrSyntax)
+	}
+
+	// the missing keys are empty strings
+	sources := make([][]byte, 0, len(args)-2)
+	var length int
+	for _, key := range args[2:] {
+		item, found, err := lookupString(re, key)
+		if err != nil {
+			return ErrorResponse(err)
+		}
+		var value []byte
+		if found {
+			value = item.StringValue()
+		}
+		sources = append(sources, value)
+		length = max(length, len(value))
+	}
+
+	// the shorter strings are padded with 0 bytes
+	result := make([]byte, length)
+	copy(result, sources[0])
+	for i := range result {
+		for _, source := range sources[1:] {
+			var b byte
+			if i < len(source) {
+				b = source[i]
+			}
+			switch op {
+			case "and":
+				result[i] &= b
+			case "or":
+				result[i] |= b
+			case "xor":
+				result[i] ^= b
+			}
+		}
+		if op == "not" {
+			result[i] = ^result[i]
+		}
+	}
+
+	dest := args[1]
+	if length == 0 {
+		if found, _ := re.Contains(dest); found {
+			_ = re.Remove(dest)
+			re.signalModifiedKey(dest)
+			re.notifyKeyspaceEvent(notifyGeneric, "del", dest)
+		}
+		return IntegerResponse(0)
+	}
+	setString(re, dest, result, 0)
+	re.notifyKeyspaceEvent(notifyString, "set", dest)
+	return IntegerResponse(int64(length))
+}
+
+// bitfieldOp is a subcommand of BITFIELD
+type bitfieldOp struct {
+	name     string // get, set or incrby
+	signed   bool
+	width    int64 // the number of bits of the integer
+	offset   int64 // the offset of its first bit
+	value    int64 // the value of SET, the increment of INCRBY
+	overflow string
+}
+
+// parseBitfieldType parses an integer type, i<bits> (up to 64) or u<bits> (up to 63)
+func parseBitfieldType(arg string, op *bitfieldOp) error {
+	if len(arg) < 2 {
+		return ErrBitfieldType
+	}
+	op.signed = arg[0] == 'i' || arg[0] == 'I'
+	width, err := strconv.ParseInt(arg[1:], 10, 64)
+	if err != nil || (!op.signed && arg[0] != 'u' && arg[0] != 'U') || width < 1 || width > 64 || (!op.signed && width == 64) {
+		return ErrBitfieldType
+	}
+	op.width = width
+	return nil
+}
+
+// get returns the integer of the op stored in @value
+func (op *bitfieldOp) get(value []byte) int64 {
+	var n uint64
+	for i := int64(0); i < op.width; i++ {
+		n = n<<1 | uint64(getBit(value, op.offset+i))
+	}
+	if op.signed && op.width < 64 && n>>(op.width-1) == 1 {
+		// sign extension
+		n |= math.MaxUint64 << op.width
+	}
+	return int64(n)
+}
+
+// set stores the integer of the op in @value, which holds its bits
+func (op *bitfieldOp) set(value []byte, n int64) {
+	for i := int64(0); i < op.width; i++ {
+		setBit(value, op.offset+i, byte(uint64(n)>>(op.width-1-i)&1))
+	}
+}
+
+// add returns @n + @incr for the type of the op, wrapping around or saturating on
+// overflow depending on its OVERFLOW mode, or false on overflow in the FAIL mode
+func (op *bitfieldOp) add(n, incr int64) (int64, bool) {
+	var low, high bool
+	var minValue, maxValue int64
+	if op.signed {
+		minValue, maxValue = -1<<(op.width-1), 1<<(op.width-1)-1
+		high = incr > 0 && n > maxValue-incr
+		low = incr < 0 && n < minValue-incr
+	} else {
+		maxValue = 1<<op.width - 1
+		// incr may be MinInt64, whose magnitude is still 1<<63 as an uint64
+		high = incr > 0 && uint64(incr) > uint64(maxValue-n)
+		low = incr < 0 && uint64(-incr) > uint64(n)
+	}
+	if !low && !high {
+		return n + incr, true
+	}
+	switch op.overflow {
+	case "sat":
+		if high {
+			return maxValue, true
+		}
+		return minValue, true
+	case "fail":
+		return 0, false
+	}
+	// wrap around, keeping the low bits of the sum
+	sum := uint64(n) + uint64(incr)
+	if op.width == 64 {
+		return int64(sum), true
+	}
+	sum &= 1<<op.width - 1
+	if op.signed && sum>>(op.width-1) == 1 {
+		sum |= math.MaxUint64 << op.width
+	}
+	return int64(sum), true
+}
+
+// BITFIELD key [GET type offset | [OVERFLOW WRAP | SAT | FAIL] SET type offset value |
+// [OVERFLOW WRAP | SAT | FAIL] INCRBY type offset increment ...], also BITFIELD_RO key
+// [GET type offset ...]
+func bitfieldCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	readOnly := cmd.Name() == "bitfield_ro"
+	var ops []*bitfieldOp
+	overflow := "wrap"
+	var bitsNeeded int64 // the bits the string must hold for SET and INCRBY
+	for i := 1; i < len(args); i++ {
+		name := strings.ToLower(args[i])
+		if readOnly && name != "get" {
+			return ErrorResponse(ErrBitfieldRO)
+		}
+		if name == "overflow" && i+1 < len(args) {
+			overflow = strings.ToLower(args[i+1])
+			if overflow != "wrap" && overflow != "sat" && overflow != "fail" {
+				return ErrorResponse(ErrOverflowType)
+			}
+			i++
+			continue
+		}
+		arity := map[string]int{"get": 2, "set": 3, "incrby": 3}[name]
+		if arity == 0 || i+arity >= len(args) {
+			return ErrorResponse(ErrSyntax)
+		}
+		op := &bitfieldOp{name: name, overflow: overflow}
+		if err := parseBitfieldType(args[i+1], op); err != nil {
+			return ErrorResponse(err)
+		}
+		var err error
+		if op.offset, err = parseBitOffset(args[i+2], op.width); err != nil {
+			return ErrorResponse(err)
+		}
+		if name != "get" {
+			var ok bool
+			if op.value, ok = parseInt(args[i+3]); !ok {
+				return ErrorResponse(ErrNotInteger)
+			}
+			bitsNeeded = max(bitsNeeded, op.offset+op.width)
+		}
+		ops = append(ops, op)
+		i += arity
+	}
+
+	key := args[0]
+	var value []byte
+	if bitsNeeded > 0 {
+		item, err := growBitmap(re, key, bitsNeeded)
+		if err != nil {
+			return ErrorResponse(err)
+		}
+		value = item.StringValue()
+		re.signalModifiedKey(key)
+		re.notifyKeyspaceEvent(notifyString, "setbit", key)
+	} else {
+		item, found, err := lookupString(re, key)
+		if err != nil {
+			return ErrorResponse(err)
+		}
+		if found {
+			value = item.StringValue()
+		}
+	}
+
+	replies := make([]*RedisResponse, 0, len(ops))
+	for _, op := range ops {
+		old := op.get(value)
+		var n int64
+		var ok bool
+		switch op.name {
+		case "get":
+			replies = append(replies, IntegerResponse(old))
+			continue
+		case "set":
+			n, ok = op.add(0, op.value)
+		case "incrby":
+			n, ok = op.add(old, op.value)
+		}
+		if !ok {
+			replies = append(replies, NilResponse())
+			continue
+		}
+		op.set(value, n)
+		if op.name == "set" {
+			replies = append(replies, IntegerResponse(old))
+		} else {
+			replies = append(replies, IntegerResponse(n))
+		}
+	}
+	return ArrayResponse(replies...)
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bufio"
+	"testing"
+)
+
+func TestBitCommands(t *testing.T) {
+	runSteps(t, newTestExecutor(), []testStep{
+		{cmd("SETBIT", "b", "7", "1"), ":0\r\n"},
+		{cmd("SETBIT", "b", "7", "1"), ":1\r\n"},
+		{cmd("GET", "b"), "$1\r\n\x01\r\n"},
+		{cmd("SETBIT", "b", "15", "1"), ":0\r\n"},
+		{cmd("STRLEN", "b"), ":2\r\n"},
+		{cmd("GETBIT", "b", "15"), ":1\r\n"},
+		{cmd("GETBIT", "b", "100"), ":0\r\n"},
+		{cmd("GETBIT", "missing", "0"), ":0\r\n"},
+		{cmd("SETBIT", "b", "-1", "1"), "-ERR bit offset is not an integer or out of range\r\n"},
+		{cmd("SETBIT", "b", "4294967296", "1"), "-ERR bit offset is not an integer or out of range\r\n"},
+		{cmd("SETBIT", "b", "0", "2"), "-ERR bit is not an integer or out of range\r\n"},
+		// a copy doesn't share the bits
+		{cmd("COPY", "b", "c"), ":1\r\n"},
+		{cmd("SETBIT", "c", "0", "1"), ":0\r\n"},
+		{cmd("GETBIT", "b", "0"), ":0\r\n"},
+		{cmd("RPUSH", "list", "a"), ":1\r\n"},
+		{cmd("SETBIT", "list", "0", "1"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+
+		{cmd("SET", "s", "foobar"), "+OK\r\n"},
+		{cmd("BITCOUNT", "s"), ":26\r\n"},
+		{cmd("BITCOUNT", "s", "0", "0"), ":4\r\n"},
+		{cmd("BITCOUNT", "s", "1", "1", "BYTE"), ":6\r\n"},
+		{cmd("BITCOUNT", "s", "-2", "-1"), ":7\r\n"},
+		{cmd("BITCOUNT", "s", "5", "30", "BIT"), ":17\r\n"},
+		{cmd("BITCOUNT", "s", "3", "1"), ":0\r\n"},
+		{cmd("BITCOUNT", "s", "0"), "-ERR syntax error\r\n"},
+		{cmd("BITCOUNT", "s", "0", "1", "WORD"), "-ERR syntax error\r\n"},
+		{cmd("BITCOUNT", "missing"), ":0\r\n"},
+
+		{cmd("SET", "p", "\x00\xff\xf0"), "+OK\r\n"},
+		{cmd("BITPOS", "p", "1"), ":8\r\n"},
+		{cmd("BITPOS", "p", "0", "1"), ":20\r\n"},
+		{cmd("BITPOS", "p", "1", "2", "-1", "BYTE"), ":16\r\n"},
+		{cmd("BITPOS", "p", "1", "7", "15", "BIT"), ":8\r\n"},
+		{cmd("BITPOS", "p", "1", "0", "6", "BIT"), ":-1\r\n"},
+		{cmd("BITPOS", "p", "2"), "-ERR The bit argument must be 1 or 0.\r\n"},
+		{cmd("SET", "ones", "\xff\xff"), "+OK\r\n"},
+		// the clear bits are searched past the end of the string unless an end is given
+		{cmd("BITPOS", "ones", "0"), ":16\r\n"},
+		{cmd("BITPOS", "ones", "0", "0", "-1"), ":-1\r\n"},
+		{cmd("BITPOS", "missing", "0"), ":0\r\n"},
+		{cmd("BITPOS", "missing", "1"), ":-1\r\n"},
+	})
+}
+
+func TestBitop(t *testing.T) {
+	re := newTestExecutor()
+	execute(re, "SET", "k1", "foobar")
+	execute(re, "SET", "k2", "abcdef")
+	execute(re, "SET", "short", "\x0f")
+	execute(re, "RPUSH", "list", "a")
+	runSteps(t, re, []testStep{
+		{cmd("BITOP", "AND", "dest", "k1", "k2"), ":6\r\n"},
+		{cmd("GET", "dest"), "$6\r\n`bc`ab\r\n"},
+		{cmd("BITOP", "OR", "dest", "k1", "k2"), ":6\r\n"},
+		{cmd("GET", "dest"), "$6\r\ngoofev\r\n"},
+		{cmd("BITOP", "XOR", "dest", "k1", "short"), ":6\r\n"},
+		{cmd("GET", "dest"), "$6\r\nioobar\r\n"},
+		{cmd("BITOP", "AND", "dest", "k1", "short"), ":6\r\n"},
+		{cmd("GET", "dest"), "$6\r\n\x06\x00\x00\x00\x00\x00\r\n"},
+		{cmd("BITOP", "NOT", "dest", "short"), ":1\r\n"},
+		{cmd("GET", "dest"), "$1\r\n\xf0\r\n"},
+		{cmd("BITOP", "NOT", "dest", "k1", "k2"), "-ERR BITOP NOT must be called with a single source key.\r\n"},
+		{cmd("BITOP", "AND", "dest", "k1", "list"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{cmd("BITOP", "NAND", "dest", "k1"), "-ERR syntax error\r\n"},
+		// an empty result deletes the destination
+		{cmd("BITOP", "OR", "dest", "missing", "other"), ":0\r\n"},
+		{cmd("EXISTS", "dest"), ":0\r\n"},
+	})
+}
+
+func TestBitfield(t *testing.T) {
+	runSteps(t, newTestExecutor(), []testStep{
+		{cmd("BITFIELD", "k", "INCRBY", "i5", "100", "1", "GET", "u4", "0"), "*2\r\n:1\r\n:0\r\n"},
+		{cmd("BITFIELD", "o", "INCRBY", "u2", "100", "1", "OVERFLOW", "SAT", "INCRBY", "u2", "102", "1"), "*2\r\n:1\r\n:1\r\n"},
+		{cmd("BITFIELD", "o", "INCRBY", "u2", "100", "1", "OVERFLOW", "SAT", "INCRBY", "u2", "102", "1"), "*2\r\n:2\r\n:2\r\n"},
+		{cmd("BITFIELD", "o", "INCRBY", "u2", "100", "1", "OVERFLOW", "SAT", "INCRBY", "u2", "102", "1"), "*2\r\n:3\r\n:3\r\n"},
+		{cmd("BITFIELD", "o", "INCRBY", "u2", "100", "1", "OVERFLOW", "SAT", "INCRBY", "u2", "102", "1"), "*2\r\n:0\r\n:3\r\n"},
+		{cmd("BITFIELD", "o", "OVERFLOW", "FAIL", "INCRBY", "u2", "102", "1"), "*1\r\n$-1\r\n"},
+
+		{cmd("BITFIELD", "b", "SET", "i8", "#0", "-100", "GET", "u8", "0", "GET", "i8", "0"), "*3\r\n:0\r\n:156\r\n:-100\r\n"},
+		{cmd("BITFIELD", "b", "SET", "u8", "#1", "300", "GET", "u8", "8"), "*2\r\n:0\r\n:44\r\n"},
+		{cmd("BITFIELD", "b", "OVERFLOW", "SAT", "SET", "i8", "0", "200", "INCRBY", "i8", "8", "-300"), "*2\r\n:-100\r\n:-128\r\n"},
+		{cmd("BITFIELD", "b", "OVERFLOW", "FAIL", "SET", "u8", "0", "256", "GET", "i8", "0"), "*2\r\n$-1\r\n:127\r\n"},
+		{cmd("BITFIELD", "b", "SET", "i64", "16", "9223372036854775807", "INCRBY", "i64", "16", "1"), "*2\r\n:0\r\n:-9223372036854775808\r\n"},
+		{cmd("BITFIELD", "b", "INCRBY", "u63", "16", "-1"), "*1\r\n:4611686018427387903\r\n"},
+		{cmd("STRLEN", "b"), ":10\r\n"},
+		{cmd("BITFIELD_RO", "b", "GET", "i8", "0"), "*1\r\n:127\r\n"},
+		{cmd("BITFIELD_RO", "b", "SET", "i8", "0", "1"), "-ERR BITFIELD_RO only supports the GET subcommand\r\n"},
+		{cmd("BITFIELD", "missing", "GET", "u8", "0"), "*1\r\n:0\r\n"},
+		{cmd("EXISTS", "missing"), ":0\r\n"},
+
+		{cmd("BITFIELD", "b", "GET", "u64", "0"), "-ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.\r\n"},
+		{cmd("BITFIELD", "b", "GET", "x8", "0"), "-ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.\r\n"},
+		{cmd("BITFIELD", "b", "GET", "i8", "-1"), "-ERR bit offset is not an integer or out of range\r\n"},
+		{cmd("BITFIELD", "b", "SET", "i8", "0", "x"), "-ERR value is not an integer or out of range\r\n"},
+		{cmd("BITFIELD", "b", "OVERFLOW", "BOUNCE"), "-ERR Invalid OVERFLOW type specified\r\n"},
+		{cmd("BITFIELD", "b", "GET", "i8"), "-ERR syntax error\r\n"},
+	})
+}
+
+func TestBinaryValuesOverConnection(t *testing.T) {
+	re := newTestExecutor()
+	conn, _ := startCountingServer(t, re)
+	r := bufio.NewReader(conn)
+
+	value := "a\x00\r\n\xff*1\r\n"
+	roundTrip(t, conn, r, cmd("SET", "k", value), "+OK\r\n")
+	roundTrip(t, conn, r, cmd("APPEND", "k", "\r\n"), ":11\r\n")
+	roundTrip(t, conn, r, cmd("SETRANGE", "k", "1", "\r\n$0\r\n"), ":11\r\n")
+	roundTrip(t, conn, r, cmd("GET", "k"), "$11\r\na\r\n$0\r\n\r\n\r\n\r\n")
+
+	// the bits of CRLF, 0x0d 0x0a, set one by one then copied through the client
+	for _, offset := range []string{"4", "5", "7", "12", "14"} {
+		roundTrip(t, conn, r, cmd("SETBIT", "bits", offset, "1"), ":0\r\n")
+	}
+	roundTrip(t, conn, r, cmd("GET", "bits"), "$2\r\n\r\n\r\n")
+	roundTrip(t, conn, r, cmd("SET", "copy", "\r\n"), "+OK\r\n")
+	roundTrip(t, conn, r, cmd("BITCOUNT", "copy"), ":5\r\n")
+	roundTrip(t, conn, r, cmd("BITOP", "XOR", "diff", "bits", "copy"), ":2\r\n")
+	roundTrip(t, conn, r, cmd("BITCOUNT", "diff"), ":0\r\n")
+	roundTrip(t, conn, r, cmd("DBSIZE"), ":4\r\n")
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"math"
+	"time"
+)
+
+// blockedClient is a client waiting for one of its keys to be pushed to,
+// e.g. by BLPOP, BRPOP or BLMOVE. Clients blocked on a key are served in FIFO order.
+type blockedClient struct {
+	client  *Client // nil for the server itself
+	db      int     // index of the database of the keys
+	keys    []string
+	timeout time.Duration // 0 blocks forever
+	// serve tries to serve the client from the value at key, it is called
+	// while the executor lock is held. It returns false if there was nothing to serve.
+	// It rewrites the command propagated as the non-blocking command it ran.
+	serve func(key string) (*RedisResponse, bool)
+	reply chan *RedisResponse
+}
+
+// BlockedResponse is returned by the handler of a blocking command which couldn't be
+// served immediately. Execute waits for the client to be served or to time out.
+func BlockedResponse(bc *blockedClient) *RedisResponse {
+	return &RedisResponse{blocked: bc}
+}
+
+// parseTimeout parses the timeout of a blocking command given in seconds
+func parseTimeout(arg string) (time.Duration, error) {
+	seconds, ok := parseFloat(arg)
+	if !ok || math.IsInf(seconds, 0) || seconds*float64(time.Second) > math.MaxInt64 {
+		return 0, ErrTimeoutNotFloat
+	}
+	if seconds < 0 {
+		return 0, ErrTimeoutNegative
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// block registers the client on all of its keys, in the selected database
+func (re *RedisExecutorImpl) block(bc *blockedClient) {
+	if re.blocked == nil {
+		re.blocked = make(map[dbKey][]*blockedClient)
+	}
+	bc.db = re.db
+	bc.reply = make(chan *RedisResponse, 1)
+	for _, key := range bc.keys {
+		re.blocked[dbKey{bc.db, key}] = append(re.blocked[dbKey{bc.db, key}], bc)
+	}
+}
+
+// unblock removes the client from the waiting queue of all of its keys
+func (re *RedisExecutorImpl) unblock(bc *blockedClient) {
+	if bc.client != nil {
+		bc.client.blocked = nil
+	}
+	for _, name := range bc.keys {
+		key := dbKey{bc.db, name}
+		queue := re.blocked[key]
+		for i, waiting := range queue {
+			if waiting == bc {
+				queue = append(queue[:i], queue[i+1:]...)
+				break
+			}
+		}
+		if len(queue) == 0 {
+			delete(re.blocked, key)
+		} else {
+			re.blocked[key] = queue
+		}
+	}
+}
+
+// signalKeyAsReady marks a key of the selected database which was pushed to, clients
+// blocked on it are served once the current command completes
+func (re *RedisExecutorImpl) signalKeyAsReady(key string) {
+	if _, found := re.blocked[dbKey{re.db, key}]; found {
+		re.readyKeys = append(re.readyKeys, dbKey{re.db, key})
+	}
+}
+
+// handleReadyKeys serves the clients blocked on the keys that were pushed to, in the
+// database of the key. Serving a client may push to another key (BLMOVE), so this
+// runs until no key is ready.
+func (re *RedisExecutorImpl) handleReadyKeys() {
+	for len(re.readyKeys) > 0 {
+		key := re.readyKeys[0]
+		re.readyKeys = re.readyKeys[1:]
+		re.selectDB(key.db)
+		for len(re.blocked[key]) > 0 {
+			bc := re.blocked[key][0]
+			response, served := bc.serve(key.key)
+			if !served {
+				break
+			}
+			re.flushPropagation()
+			re.unblock(bc)
+			bc.reply <- response
+		}
+	}
+}
+
+// waitBlocked waits until the blocked client is served, its timeout elapses or its
+// connection is closed
+func (re *RedisExecutorImpl) waitBlocked(bc *blockedClient) *RedisResponse {
+	var timeout <-chan time.Time
+	if bc.timeout > 0 {
+		timer := time.NewTimer(bc.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var lost <-chan struct{}
+	if bc.client != nil {
+		lost = bc.client.lost
+	}
+
+	select {
+	case response := <-bc.reply:
+		return response
+	case <-timeout:
+	case <-lost:
+	}
+
+	re.exclusive(func() { re.unblock(bc) })
+	// the client might have been served meanwhile
+	select {
+	case response := <-bc.reply:
+		return response
+	default:
+		return NullArrayResponse()
+	}
+}
@@ -0,0 +1,306 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var ErrMaxClients = errors.New("ERR max number of clients reached")
+
+// Client is the state of a connection to the server. The commands of a client are
+// executed one at a time, its replies and the messages pushed to it are written
+// in order through its replyWriter.
+type Client struct {
+	writer  *replyWriter
+	addr    string // address of the peer, empty without connection
+	laddr   string // local address of the connection
+	created time.Time
+
+	// registry state, guarded by the executor lock
+	id              int64     // assigned once registered, 0 for the clients without connection
+	name            string    // set by CLIENT SETNAME
+	db              int       // index of the selected database (SELECT)
+	lastInteraction time.Time // time of the last command, for the idle timeout
+	lastCmd         string    // name of the last command
+	noEvict         bool      // CLIENT NO-EVICT, the server doesn't evict clients anyway
+	blocked         *blockedClient
+	paused          bool // the command waits for the end of CLIENT PAUSE
+	// the connection is closed once the reply to the current command is written, e.g.
+	// after CLIENT KILL of the client itself. Read by the connection handler.
+	closeAfterReply bool
+
+	// watchClose is set by the connection handler: it watches the connection while the
+	// client is blocked and calls connectionLost if the peer closes it. The returned
+	// function stops watching.
+	watchClose func() (stop func())
+	lost       chan struct{} // closed by connectionLost, it cancels the wait of the client
+	lostOnce   sync.Once
+
+	// Pub/Sub subscriptions, guarded by the executor lock
+	channels map[string]struct{}
+	patterns map[string]struct{}
+
+	// transaction state, guarded by the executor lock
+	multi    *multiState     // nil unless MULTI was called
+	watched  map[dbKey]int64 // watched keys and their expiry time when watched
+	dirtyCAS bool            // a watched key was modified, EXEC fails
+
+	// replication state, guarded by the executor lock
+	master  bool         // the connection of this replica to its master, which may write
+	replica *replicaInfo // set once the client is a replica of this server (PSYNC)
+
+	user *aclUser // the user the client authenticated as, guarded by the executor lock
+}
+
+// NewClient creates the client of a connection. Without connection, e.g. when the
+// append only file is replayed, the output of the client is discarded.
+func NewClient(conn io.WriteCloser) *Client {
+	now := time.Now()
+	c := &Client{
+		created:         now,
+		lastInteraction: now,
+		channels:        make(map[string]struct{}),
+		patterns:        make(map[string]struct{}),
+		watched:         make(map[dbKey]int64),
+		lost:            make(chan struct{}),
+	}
+	if conn != nil {
+		c.writer = newReplyWriter(conn)
+	}
+	if nc, ok := conn.(net.Conn); ok {
+		c.addr, c.laddr = nc.RemoteAddr().String(), nc.LocalAddr().String()
+	}
+	return c
+}
+
+// Write queues the reply to a command of the client, sent once flushed
+func (c *Client) Write(response *RedisResponse) {
+	if c.writer != nil {
+		c.writer.Write(response)
+	}
+}
+
+// Flush sends the replies queued by Write, e.g. once the pipelined commands of the
+// client ran or before it waits
+func (c *Client) Flush() {
+	if c.writer != nil {
+		c.writer.Flush()
+	}
+}
+
+// WaitOutput waits while more than pipelineMaxOutput bytes of replies are pending,
+// e.g. before the next command of the client is read
+func (c *Client) WaitOutput() {
+	if c.writer != nil {
+		c.writer.WaitDrained(pipelineMaxOutput)
+	}
+}
+
+// Push sends a message to the client from another client
+func (c *Client) Push(response *RedisResponse) {
+	if c.writer != nil {
+		c.writer.Push(response)
+	}
+}
+
+// WriteRaw sends output already encoded in RESP, the client is disconnected if its
+// pending output exceeds @limit
+func (c *Client) WriteRaw(p []byte, limit int) {
+	if c.writer != nil {
+		c.writer.WriteRaw(p, limit)
+	}
+}
+
+// Disconnect closes the connection of the client, discarding its pending output
+func (c *Client) Disconnect() {
+	if c.writer != nil {
+		c.writer.Disconnect()
+	}
+}
+
+// connectionLost reports that the peer closed the connection, a blocked client stops
+// waiting
+func (c *Client) connectionLost() {
+	c.lostOnce.Do(func() { close(c.lost) })
+}
+
+// Close flushes the pending output of the client
+func (c *Client) Close() error {
+	if c.writer == nil {
+		return nil
+	}
+	return c.writer.Close()
+}
+
+// subscriptions is the number of channels and patterns the client is subscribed to,
+// the client is in subscriber mode while it is positive
+func (c *Client) subscriptions() int {
+	return len(c.channels) + len(c.patterns)
+}
+
+// clientType is the type of a client for CLIENT LIST and CLIENT KILL: master, replica,
+// pubsub or normal
+func (c *Client) clientType() string {
+	switch {
+	case c.master:
+		return "master"
+	case c.replica != nil:
+		return "replica"
+	case c.subscriptions() > 0:
+		return "pubsub"
+	}
+	return "normal"
+}
+
+/* ---------------- registry ---------------- */
+
+// clientsState is the registry of the connected clients, it is guarded by the executor lock
+type clientsState struct {
+	byID             map[int64]*Client
+	nextID           int64
+	pause            pauseState
+	lastTimeoutCheck time.Time
+}
+
+// pauseState is set by CLIENT PAUSE
+type pauseState struct {
+	until  time.Time
+	all    bool          // all the commands are paused, only the write commands otherwise
+	resume chan struct{} // closed once the clients may be unpaused
+}
+
+// RegisterClient adds the client of a new connection to the registry, unless maxclients
+// clients are connected already
+func (re *RedisExecutorImpl) RegisterClient(client *Client) (err error) {
+	re.exclusive(func() {
+		if len(re.clients.byID) >= re.config.MaxClients {
+			re.stats.rejectedConnections++
+			err = ErrMaxClients
+			return
+		}
+		if re.clients.byID == nil {
+			re.clients.byID = make(map[int64]*Client)
+		}
+		re.clients.nextID++
+		client.id = re.clients.nextID
+		re.clients.byID[client.id] = client
+		re.stats.totalConnections++
+	})
+	return err
+}
+
+// connectedClients is the number of connected clients, excluding the replicas
+func (re *RedisExecutorImpl) connectedClients() int {
+	return len(re.clients.byID) - len(re.repl.replicas)
+}
+
+// killClient disconnects a client, a blocked client is unblocked first
+func (re *RedisExecutorImpl) killClient(c *Client) {
+	if bc := c.blocked; bc != nil {
+		re.unblock(bc)
+		bc.reply <- NullArrayResponse()
+	}
+	c.Disconnect()
+}
+
+// clientsPaused reports whether the clients are paused by CLIENT PAUSE. The active
+// expiry and the eviction are paused as well, so that the datastore doesn't change.
+func (re *RedisExecutorImpl) clientsPaused() bool {
+	return time.Now().Before(re.clients.pause.until)
+}
+
+// pausedFor reports whether the command of the client must wait for the pause to end.
+// The replicas and the master are never paused, nor is CLIENT so that UNPAUSE may run.
+// A write pause delays the write commands, and EXEC of a transaction queuing some.
+func (re *RedisExecutorImpl) pausedFor(client *Client, spec *commandSpec) bool {
+	if client == nil || client.writer == nil || client.master || client.replica != nil ||
+		spec.name == "client" || !re.clientsPaused() {
+		return false
+	}
+	if re.clients.pause.all {
+		return true
+	}
+	if client.multi != nil && spec.name == "exec" {
+		for _, queued := range client.multi.commands {
+			if queued.spec.is(flagWrite) {
+				return true
+			}
+		}
+		return false
+	}
+	return client.multi == nil && spec.is(flagWrite)
+}
+
+// waitUnpaused delays the command of a client while it is paused, the executor lock
+// being held and released while waiting
+func (re *RedisExecutorImpl) waitUnpaused(client *Client, spec *commandSpec) {
+	for re.pausedFor(client, spec) {
+		client.Flush()
+		resume := re.pauseResumed()
+		client.paused = true
+		re.mu.Unlock()
+		<-resume
+		re.mu.Lock()
+		client.paused = false
+	}
+}
+
+// pauseResumed returns the channel closed once the paused clients may go on
+func (re *RedisExecutorImpl) pauseResumed() <-chan struct{} {
+	if re.clients.pause.resume == nil {
+		re.clients.pause.resume = make(chan struct{})
+	}
+	return re.clients.pause.resume
+}
+
+// pauseClients pauses the clients for @timeout. An ongoing pause is only extended,
+// and a pause of all the commands isn't turned into a write pause.
+func (re *RedisExecutorImpl) pauseClients(timeout time.Duration, all bool) {
+	until := time.Now().Add(timeout)
+	if re.clientsPaused() {
+		all = all || re.clients.pause.all
+		if until.Before(re.clients.pause.until) {
+			until = re.clients.pause.until
+		}
+	}
+	re.clients.pause.until, re.clients.pause.all = until, all
+	time.AfterFunc(timeout, func() {
+		re.exclusive(func() { re.unpauseClients(false) })
+	})
+}
+
+// unpauseClients wakes up the paused clients, ending the pause if @end is set
+func (re *RedisExecutorImpl) unpauseClients(end bool) {
+	if end {
+		re.clients.pause.until = time.Time{}
+	}
+	if re.clients.pause.resume != nil {
+		close(re.clients.pause.resume)
+		re.clients.pause.resume = nil
+	}
+}
+
+// closeIdleClients disconnects the clients which didn't send a command for timeout
+// seconds, checked once per second. The replicas, the master, the blocked clients and
+// the subscribers are never idle.
+func (re *RedisExecutorImpl) closeIdleClients(now time.Time) {
+	if re.config.Timeout == 0 || now.Sub(re.clients.lastTimeoutCheck) < time.Second {
+		return
+	}
+	re.clients.lastTimeoutCheck = now
+	timeout := time.Duration(re.config.Timeout) * time.Second
+	for _, c := range re.clients.byID {
+		if c.master || c.replica != nil || c.blocked != nil || c.subscriptions() > 0 {
+			continue
+		}
+		if now.Sub(c.lastInteraction) > timeout {
+			re.Debug("closing idle client", zap.String("addr", c.addr), zap.Int64("id", c.id))
+			c.Disconnect()
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrClientName    = errors.New("ERR Client names cannot contain spaces, newlines or special characters.")
+	ErrNoSuchClient  = errors.New("ERR No such client")
+	ErrClientID      = errors.New("ERR client-id should be greater than 0")
+	ErrPauseTimeout  = errors.New("ERR timeout is not an integer or out of range")
+	ErrPauseNegative = errors.New("ERR timeout is negative")
+)
+
+func init() {
+	registerCommands(
+		&commandSpec{name: "client", arity: -2, handler: clientCommand, categories: catAdmin | catDangerous},
+	)
+}
+
+// clientTypes are the types of CLIENT LIST TYPE and CLIENT KILL TYPE, slave being the
+// former name of replica
+var clientTypes = map[string]string{"normal": "normal", "master": "master", "replica": "replica", "slave": "replica", "pubsub": "pubsub"}
+
+// clientInfo describes a client like a line of CLIENT LIST
+func (re *RedisExecutorImpl) clientInfo(c *Client, now time.Time) string {
+	var flags strings.Builder
+	for _, flag := range []struct {
+		set  bool
+		name byte
+	}{
+		{c.replica != nil, 'S'}, {c.master, 'M'}, {c.subscriptions() > 0, 'P'}, {c.multi != nil, 'x'},
+		{c.blocked != nil || c.paused, 'b'}, {c.dirtyCAS, 'd'}, {c.closeAfterReply, 'c'}, {c.noEvict, 'e'},
+	} {
+		if flag.set {
+			flags.WriteByte(flag.name)
+		}
+	}
+	if flags.Len() == 0 {
+		flags.WriteByte('N')
+	}
+	multi := -1
+	if c.multi != nil {
+		multi = len(c.multi.commands)
+	}
+	user := defaultUser
+	if c.user != nil {
+		user = c.user.name
+	}
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=%d sub=%d psub=%d multi=%d watch=%d user=%s cmd=%s",
+		c.id, c.addr, c.laddr, c.name, int64(now.Sub(c.created).Seconds()), int64(now.Sub(c.lastInteraction).Seconds()),
+		flags.String(), c.db, len(c.channels), len(c.patterns), multi, len(c.watched), user, c.lastCmd)
+}
+
+// sortedClients returns the registered clients sorted by ID
+func (re *RedisExecutorImpl) sortedClients() []*Client {
+	clients := make([]*Client, 0, len(re.clients.byID))
+	for _, c := range re.clients.byID {
+		clients = append(clients, c)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].id < clients[j].id })
+	return clients
+}
+
+// validClientName reports whether the name only has printable characters and no space
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// CLIENT ID | INFO | LIST | SETNAME | GETNAME | KILL | PAUSE | UNPAUSE | NO-EVICT
+func clientCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	subcommand := strings.ToLower(args[0])
+	client := cmd.Client()
+	switch {
+	case subcommand == "list":
+		return clientListCommand(re, args[1:])
+	case subcommand == "kill":
+		return clientKillCommand(re, client, args[1:])
+	case subcommand == "pause" && (len(args) == 2 || len(args) == 3):
+		timeout, ok := parseInt(args[1])
+		if !ok {
+			return ErrorResponse(ErrPauseTimeout)
+		}
+		if timeout < 0 {
+			return ErrorResponse(ErrPauseNegative)
+		}
+		all := true
+		if len(args) == 3 {
+			switch strings.ToLower(args[2]) {
+			case "write":
+				all = false
+			case "all":
+			default:
+				return ErrorResponse(ErrSyntax)
+			}
+		}
+		re.pauseClients(time.Duration(timeout)*time.Millisecond, all)
+		return OKResponse()
+	case subcommand == "unpause" && len(args) == 1:
+		re.unpauseClients(true)
+		return OKResponse()
+	}
+
+	// the other subcommands are about the client itself
+	if subcommand != "id" && subcommand != "info" && subcommand != "setname" && subcommand != "getname" && subcommand != "no-evict" {
+		return ErrorResponse(UnknownSubcommandError(cmd))
+	}
+	if client == nil {
+		return ErrorResponse(ErrNoClient)
+	}
+	switch {
+	case subcommand == "id" && len(args) == 1:
+		return IntegerResponse(client.id)
+	case subcommand == "info" && len(args) == 1:
+		return BulkResponse(re.clientInfo(client, time.Now()) + "\n")
+	case subcommand == "setname" && len(args) == 2:
+		if !validClientName(args[1]) {
+			return ErrorResponse(ErrClientName)
+		}
+		client.name = args[1]
+		return OKResponse()
+	case subcommand == "getname" && len(args) == 1:
+		if client.name == "" {
+			return NilResponse()
+		}
+		return BulkResponse(client.name)
+	case subcommand == "no-evict" && len(args) == 2:
+		switch strings.ToLower(args[1]) {
+		case "on":
+			client.noEvict = true
+		case "off":
+			client.noEvict = false
+		default:
+			return ErrorResponse(ErrSyntax)
+		}
+		return OKResponse()
+	}
+	return ErrorResponse(WrongArgsError("client|" + subcommand))
+}
+
+// CLIENT LIST [TYPE type] [ID id [id ...]]
+func clientListCommand(re *RedisExecutorImpl, args []string) *RedisResponse {
+	var clientType string
+	var ids map[int64]bool
+	for i := 0; i < len(args); i++ {
+		switch option := strings.ToLower(args[i]); {
+		case option == "type" && i+1 < len(args):
+			var found bool
+			if clientType, found = clientTypes[strings.ToLower(args[i+1])]; !found {
+				return ErrorResponse(fmt.Errorf("ERR Unknown client type '%s'", args[i+1]))
+			}
+			i++
+		case option == "id" && i+1 < len(args):
+			ids = make(map[int64]bool)
+			for _, arg := range args[i+1:] {
+				id, ok := parseInt(arg)
+				if !ok || id <= 0 {
+					return ErrorResponse(ErrClientID)
+				}
+				ids[id] = true
+			}
+			i = len(args)
+		default:
+			return ErrorResponse(ErrSyntax)
+		}
+	}
+	now := time.Now()
+	var b strings.Builder
+	for _, c := range re.sortedClients() {
+		if (clientType == "" || c.clientType() == clientType) && (ids == nil || ids[c.id]) {
+			b.WriteString(re.clientInfo(c, now) + "\n")
+		}
+	}
+	return BulkResponse(b.String())
+}
+
+// CLIENT KILL addr, or CLIENT KILL [ID id] [ADDR addr] [LADDR laddr] [USER username]
+// [TYPE type] [SKIPME yes|no] which kills the clients matching all the filters, not
+// the calling client unless SKIPME is no. The calling client is disconnected once the
+// reply is written.
+func clientKillCommand(re *RedisExecutorImpl, client *Client, args []string) *RedisResponse {
+	if len(args) == 1 {
+		for _, c := range re.clients.byID {
+			if c.addr == args[0] {
+				re.kill(client, c)
+				return OKResponse()
+			}
+		}
+		return ErrorResponse(ErrNoSuchClient)
+	}
+	if len(args) == 0 || len(args)%2 != 0 {
+		return ErrorResponse(ErrSyntax)
+	}
+	var filters []func(c *Client) bool
+	skipMe := true
+	for i := 0; i < len(args); i += 2 {
+		value := args[i+1]
+		switch strings.ToLower(args[i]) {
+		case "id":
+			id, ok := parseInt(value)
+			if !ok || id <= 0 {
+				return ErrorResponse(ErrClientID)
+			}
+			filters = append(filters, func(c *Client) bool { return c.id == id })
+		case "addr":
+			filters = append(filters, func(c *Client) bool { return c.addr == value })
+		case "laddr":
+			filters = append(filters, func(c *Client) bool { return c.laddr == value })
+		case "user":
+			if _, found := re.acl.users[value]; !found {
+				return ErrorResponse(fmt.Errorf("ERR No such user '%s'", value))
+			}
+			filters = append(filters, func(c *Client) bool { return c.user != nil && c.user.name == value })
+		case "type":
+			clientType, found := clientTypes[strings.ToLower(value)]
+			if !found {
+				return ErrorResponse(fmt.Errorf("ERR Unknown client type '%s'", value))
+			}
+			filters = append(filters, func(c *Client) bool { return c.clientType() == clientType })
+		case "skipme":
+			switch strings.ToLower(value) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				return ErrorResponse(ErrSyntax)
+			}
+		default:
+			return ErrorResponse(ErrSyntax)
+		}
+	}
+	var killed int64
+	for _, c := range re.sortedClients() {
+		if c == client && skipMe {
+			continue
+		}
+		matches := true
+		for _, filter := range filters {
+			matches = matches && filter(c)
+		}
+		if matches {
+			re.kill(client, c)
+			killed++
+		}
+	}
+	return IntegerResponse(killed)
+}
+
+// kill disconnects client @c on behalf of @client, which is disconnected only once the
+// reply to CLIENT KILL is written if it killed itself
+func (re *RedisExecutorImpl) kill(client, c *Client) {
+	if c == client {
+		c.closeAfterReply = true
+		return
+	}
+	re.killClient(c)
+}
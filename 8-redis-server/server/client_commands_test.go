@@ -0,0 +1,279 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestClient registers a client writing to a buffer
+func newTestClient(t *testing.T, re *RedisExecutorImpl, addr string) (*Client, *bufferConn) {
+	t.Helper()
+	conn := &bufferConn{}
+	c := NewClient(conn)
+	c.addr = addr
+	if err := re.RegisterClient(c); err != nil {
+		t.Fatal(err)
+	}
+	return c, conn
+}
+
+func TestClient(t *testing.T) {
+	re := newTestExecutor()
+	a, _ := newTestClient(t, re, "10.0.0.1:1000")
+	b, _ := newTestClient(t, re, "10.0.0.2:2000")
+	runClientSteps(t, re, a, []testStep{
+		{cmd("CLIENT", "ID"), ":1\r\n"},
+		{cmd("CLIENT", "GETNAME"), "$-1\r\n"},
+		{cmd("CLIENT", "SETNAME", "bad name"), "-" + ErrClientName.Error() + "\r\n"},
+		{cmd("CLIENT", "SETNAME", "conn-a"), "+OK\r\n"},
+		{cmd("CLIENT", "GETNAME"), "$6\r\nconn-a\r\n"},
+		{cmd("CLIENT", "SETNAME"), "-ERR wrong number of arguments for 'client|setname' command\r\n"},
+		{cmd("CLIENT", "NO-EVICT", "on"), "+OK\r\n"},
+		{cmd("CLIENT", "FOO"), "-ERR unknown subcommand 'FOO'. Try CLIENT HELP.\r\n"},
+		{cmd("SELECT", "3"), "+OK\r\n"},
+		{cmd("MULTI"), "+OK\r\n"},
+		{cmd("CLIENT", "ID"), "+QUEUED\r\n"},
+	})
+	executeAs(re, b, "SUBSCRIBE", "news")
+
+	info := re.clientInfo(a, time.Now())
+	for _, field := range []string{
+		"id=1 addr=10.0.0.1:1000 ", " name=conn-a ", " flags=xe ", " db=3 ", " multi=1 ", " user=default ", " cmd=client",
+	} {
+		if !strings.Contains(info, field) {
+			t.Errorf("CLIENT INFO: %q not in %q", field, info)
+		}
+	}
+
+	list := execute(re, "CLIENT", "LIST")
+	if strings.Count(list, "id=") != 2 || !strings.Contains(list, "id=2 addr=10.0.0.2:2000 ") ||
+		!strings.Contains(list, " flags=P ") || !strings.Contains(list, " sub=1 ") {
+		t.Errorf("CLIENT LIST: got %q", list)
+	}
+	if list := execute(re, "CLIENT", "LIST", "TYPE", "pubsub"); strings.Count(list, "id=") != 1 || !strings.Contains(list, "id=2 ") {
+		t.Errorf("CLIENT LIST TYPE pubsub: got %q", list)
+	}
+	if list := execute(re, "CLIENT", "LIST", "ID", "1", "5"); strings.Count(list, "id=") != 1 || !strings.Contains(list, "id=1 ") {
+		t.Errorf("CLIENT LIST ID: got %q", list)
+	}
+	runSteps(t, re, []testStep{
+		{cmd("CLIENT", "LIST", "TYPE", "foo"), "-ERR Unknown client type 'foo'\r\n"},
+		{cmd("CLIENT", "LIST", "ID", "0"), "-" + ErrClientID.Error() + "\r\n"},
+		{cmd("CLIENT", "ID"), "-" + ErrNoClient.Error() + "\r\n"},
+	})
+}
+
+func TestClientKill(t *testing.T) {
+	re := newTestExecutor()
+	re.acl.users["bob"] = newACLUser("bob")
+	a, connA := newTestClient(t, re, "10.0.0.1:1000")
+	b, connB := newTestClient(t, re, "10.0.0.2:2000")
+	c, connC := newTestClient(t, re, "10.0.0.3:3000")
+	c.user = re.acl.users["bob"]
+
+	runClientSteps(t, re, a, []testStep{
+		{cmd("CLIENT", "KILL", "10.0.0.9:9000"), "-" + ErrNoSuchClient.Error() + "\r\n"},
+		{cmd("CLIENT", "KILL", "10.0.0.2:2000"), "+OK\r\n"},
+		{cmd("CLIENT", "KILL", "USER", "nobody"), "-ERR No such user 'nobody'\r\n"},
+		{cmd("CLIENT", "KILL", "TYPE", "foo"), "-ERR Unknown client type 'foo'\r\n"},
+		{cmd("CLIENT", "KILL", "ID", "0"), "-" + ErrClientID.Error() + "\r\n"},
+		{cmd("CLIENT", "KILL", "ID", "2", "ADDR"), "-" + ErrSyntax.Error() + "\r\n"},
+		{cmd("CLIENT", "KILL", "USER", "bob", "ADDR", "10.0.0.1:1000"), ":0\r\n"},
+		{cmd("CLIENT", "KILL", "USER", "bob"), ":1\r\n"},
+	})
+	if connA.isClosed() || !connB.isClosed() || !connC.isClosed() {
+		t.Errorf("closed: got %v %v %v", connA.isClosed(), connB.isClosed(), connC.isClosed())
+	}
+	re.FreeClient(b)
+	re.FreeClient(c)
+
+	// the calling client is skipped by default, and disconnected once replied otherwise
+	runClientSteps(t, re, a, []testStep{
+		{cmd("CLIENT", "KILL", "ID", "1"), ":0\r\n"},
+		{cmd("CLIENT", "KILL", "ID", "1", "SKIPME", "no"), ":1\r\n"},
+	})
+	if !a.closeAfterReply || connA.isClosed() {
+		t.Errorf("got closeAfterReply %v, closed %v", a.closeAfterReply, connA.isClosed())
+	}
+}
+
+func TestClientKillBlocked(t *testing.T) {
+	re := newTestExecutor()
+	c, conn := newTestClient(t, re, "10.0.0.1:1000")
+	reply := make(chan string)
+	go func() {
+		reply <- re.Execute(CreateCommandFromTokens(bulkTokens([]string{"BLPOP", "q", "0"})).SetClient(c)).Serialize()
+	}()
+	for {
+		re.mu.Lock()
+		blocked := c.blocked != nil
+		re.mu.Unlock()
+		if blocked {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if list := execute(re, "CLIENT", "LIST"); !strings.Contains(list, " flags=b ") || !strings.Contains(list, " cmd=blpop") {
+		t.Errorf("CLIENT LIST: got %q", list)
+	}
+	runSteps(t, re, []testStep{{cmd("CLIENT", "KILL", "ID", "1"), ":1\r\n"}})
+	if got := <-reply; got != "*-1\r\n" {
+		t.Errorf("BLPOP: got %q", got)
+	}
+	if !conn.isClosed() || c.blocked != nil || len(re.blocked) != 0 {
+		t.Errorf("got closed %v, blocked %v", conn.isClosed(), re.blocked)
+	}
+}
+
+func TestClientListBlockedFlag(t *testing.T) {
+	for _, mode := range []struct {
+		name string
+		new  func() *RedisExecutorImpl
+	}{{"lock", newTestExecutor}, {"event loop", newEventLoopExecutor}} {
+		for _, test := range []struct {
+			argv  []string
+			pause bool
+		}{
+			{[]string{"BLPOP", "q", "0"}, false},
+			{[]string{"XREAD", "BLOCK", "0", "STREAMS", "s", "$"}, false},
+			{[]string{"XREADGROUP", "GROUP", "g", "c", "BLOCK", "0", "STREAMS", "s", ">"}, false},
+			{[]string{"SET", "k", "v"}, true},
+		} {
+			re := mode.new()
+			execute(re, "XGROUP", "CREATE", "s", "g", "$", "MKSTREAM")
+			if test.pause {
+				execute(re, "CLIENT", "PAUSE", "10000", "WRITE")
+			}
+			conn, _ := startCountingServer(t, re)
+			if _, err := conn.Write(appendAOFCommand(nil, test.argv)); err != nil {
+				t.Fatal(err)
+			}
+			// the client is listed with the b flag while it waits, over its connection
+			deadline := time.Now().Add(5 * time.Second)
+			list := execute(re, "CLIENT", "LIST")
+			for !strings.Contains(list, " flags=b ") && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+				list = execute(re, "CLIENT", "LIST")
+			}
+			if !strings.Contains(list, " flags=b ") ||
+				!test.pause && !strings.Contains(list, " cmd="+strings.ToLower(test.argv[0])) {
+				t.Errorf("%s, %s: got %q", mode.name, test.argv[0], list)
+			}
+			if test.pause {
+				execute(re, "CLIENT", "UNPAUSE")
+			} else {
+				execute(re, "XADD", "s", "*", "f", "v")
+				execute(re, "LPUSH", "q", "a")
+			}
+			// the flag is cleared once the client is served
+			reply := make([]byte, 1)
+			if _, err := conn.Read(reply); err != nil {
+				t.Fatal(err)
+			}
+			if list := execute(re, "CLIENT", "LIST"); strings.Contains(list, " flags=b ") {
+				t.Errorf("%s, %s served: got %q", mode.name, test.argv[0], list)
+			}
+		}
+	}
+}
+
+func TestMaxClients(t *testing.T) {
+	re := newTestExecutor()
+	re.config.MaxClients = 1
+	port := startTestServer(t, re)
+
+	first, err := net.Dial("tcp", "127.0.0.1:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	// wait for the first client to be registered
+	for {
+		re.mu.Lock()
+		connected := re.connectedClients()
+		re.mu.Unlock()
+		if connected == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	second, err := net.Dial("tcp", "127.0.0.1:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	line, err := bufio.NewReader(second).ReadString('\n')
+	if err != nil || line != "-"+ErrMaxClients.Error()+"\r\n" {
+		t.Errorf("got %q, %v", line, err)
+	}
+	if info := execute(re, "INFO", "stats"); !strings.Contains(info, "rejected_connections:1\r\n") {
+		t.Errorf("INFO: got %q", info)
+	}
+}
+
+func TestClientPause(t *testing.T) {
+	re := newTestExecutor()
+	c, _ := newTestClient(t, re, "10.0.0.1:1000")
+	run := func(args ...string) string {
+		return re.Execute(CreateCommandFromTokens(bulkTokens(args)).SetClient(c)).Serialize()
+	}
+	runSteps(t, re, []testStep{
+		{cmd("CLIENT", "PAUSE", "x"), "-" + ErrPauseTimeout.Error() + "\r\n"},
+		{cmd("CLIENT", "PAUSE", "-1"), "-" + ErrPauseNegative.Error() + "\r\n"},
+		{cmd("CLIENT", "PAUSE", "100", "FOO"), "-" + ErrSyntax.Error() + "\r\n"},
+		{cmd("CLIENT", "PAUSE", "10000", "WRITE"), "+OK\r\n"},
+	})
+
+	// reads go on during a write pause, writes wait for CLIENT UNPAUSE
+	if got := run("GET", "k"); got != "$-1\r\n" {
+		t.Errorf("GET: got %q", got)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	var set string
+	go func() {
+		defer wg.Done()
+		set = run("SET", "k", "v")
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if got := execute(re, "EXISTS", "k"); got != ":0\r\n" {
+		t.Errorf("EXISTS during the pause: got %q", got)
+	}
+	runSteps(t, re, []testStep{{cmd("CLIENT", "UNPAUSE"), "+OK\r\n"}})
+	wg.Wait()
+	if set != "+OK\r\n" {
+		t.Errorf("SET: got %q", set)
+	}
+
+	// the pause ends by itself after the timeout
+	runSteps(t, re, []testStep{{cmd("CLIENT", "PAUSE", "20"), "+OK\r\n"}})
+	start := time.Now()
+	if got := run("GET", "k"); got != "$1\r\nv\r\n" {
+		t.Errorf("GET: got %q", got)
+	}
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+		t.Errorf("GET wasn't paused: %v", elapsed)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	re := newTestExecutor()
+	re.config.Timeout = 10
+	idle, idleConn := newTestClient(t, re, "10.0.0.1:1000")
+	_, activeConn := newTestClient(t, re, "10.0.0.2:2000")
+	subscriber, subscriberConn := newTestClient(t, re, "10.0.0.3:3000")
+	executeAs(re, subscriber, "SUBSCRIBE", "news")
+
+	now := time.Now()
+	idle.lastInteraction = now.Add(-11 * time.Second)
+	subscriber.lastInteraction = now.Add(-11 * time.Second)
+	re.closeIdleClients(now)
+	if !idleConn.isClosed() || activeConn.isClosed() || subscriberConn.isClosed() {
+		t.Errorf("closed: got %v %v %v", idleConn.isClosed(), activeConn.isClosed(), subscriberConn.isClosed())
+	}
+}
@@ -1,12 +1,17 @@
 package server
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // Cmd represents a command that can be executed by the RedisExecutor
-// TODO : Have an enum for the supported Cmd types
 type Cmd struct {
 	name string // get, set
 	args map[string]interface{}
+	argv []string // positional arguments following the command name
+
+	client *Client // connection which sent the command, nil if executed internally
 }
 
 func NewCmd(name string) *Cmd {
@@ -17,7 +22,7 @@ func NewCmd(name string) *Cmd {
 }
 
 func (c *Cmd) String() string {
-	return fmt.Sprintf("%s %v", c.name, c.args)
+	return fmt.Sprintf("%s %v", c.name, c.argv)
 }
 
 func (c *Cmd) GetArg(name string) interface{} {
@@ -38,25 +43,44 @@ func (c *Cmd) SetName(name string) *Cmd {
 	return c
 }
 
+// Args returns the positional arguments of the command, excluding its name
+func (c *Cmd) Args() []string {
+	return c.argv
+}
+
+// Arg returns the i-th positional argument of the command
+func (c *Cmd) Arg(i int) string {
+	return c.argv[i]
+}
+
+func (c *Cmd) SetArgs(argv ...string) *Cmd {
+	c.argv = argv
+	return c
+}
+
+// Client returns the connection which sent the command
+func (c *Cmd) Client() *Client {
+	return c.client
+}
+
+func (c *Cmd) SetClient(client *Client) *Cmd {
+	c.client = client
+	return c
+}
+
 func (c *Cmd) IsExit() bool    { return c.name == "quit" || c.name == "exit" }
 func (c *Cmd) IsInvalid() bool { return c.name == "invalid" }
 
-// CreateCommandFromTokens creates a command from the tokens
+// CreateCommandFromTokens creates a command from the tokens.
+// The tokens alternate between the RESP type of an element and its content,
+// e.g. [$3 set $3 key $5 value]. Command names are case-insensitive.
 func CreateCommandFromTokens(tokens []string) *Cmd {
-	c := NewCmd(tokens[1])
-	switch tokens[1] {
-	case "get":
-		c.AddArg("key", tokens[3])
-	case "set":
-		if len(tokens) < 6 {
-			return c.SetName("invalid")
-		}
-		c.AddArg("key", tokens[3])
-		c.AddArg("type", tokens[4][0])
-		c.AddArg("value", tokens[5])
-	case "ping":
-	case "echo":
-		c.AddArg("value", tokens[3])
+	var argv []string
+	for i := 1; i < len(tokens); i += 2 {
+		argv = append(argv, tokens[i])
 	}
-	return c
+	if len(argv) == 0 {
+		return NewCmd("invalid")
+	}
+	return NewCmd(strings.ToLower(argv[0])).SetArgs(argv[1:]...)
 }
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// commandHandler executes a command on the Redis datastore and generates the response
+type commandHandler func(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse
+
+// commandFlag describes how a command interacts with the state of the server
+type commandFlag int
+
+const (
+	// flagPubSub commands may be run by a client in subscriber mode
+	flagPubSub commandFlag = 1 << iota
+	// flagNoQueue commands run immediately in a transaction instead of being queued
+	flagNoQueue
+	// flagNoMulti commands are not allowed in a transaction
+	flagNoMulti
+	// flagWrite commands may modify the datastore, they are rejected by a read-only replica
+	flagWrite
+	// flagNoAuth commands may be run by a client which didn't authenticate
+	flagNoAuth
+	// flagDenyOOM commands may use more memory, they are rejected once maxmemory is
+	// exceeded and no key can be evicted
+	flagDenyOOM
+	// flagSkipSlowlog commands are not added to the slow log, e.g. those given passwords
+	flagSkipSlowlog
+)
+
+// keySpec gives the positions of the keys among the arguments of a command: every
+// @step arguments from @first to @last, negative positions counting from the end.
+// A command without keys has a zero step.
+type keySpec struct {
+	first, last, step int
+}
+
+var (
+	firstKey = keySpec{0, 0, 1}  // the first argument, e.g. GET key
+	allKeys  = keySpec{0, -1, 1} // all the arguments, e.g. DEL key [key ...]
+)
+
+// commandSpec describes a command supported by the RedisExecutor
+type commandSpec struct {
+	name       string
+	arity      int // number of tokens including the name, -N means at least N
+	handler    commandHandler
+	flags      commandFlag
+	keys       keySpec
+	getKeys    func(args []string) []string // the keys of commands which locate them, e.g. ZUNIONSTORE
+	categories aclCategory                  // ACL categories, @read and @write are added from the flags
+}
+
+func (cs *commandSpec) is(flag commandFlag) bool {
+	return cs.flags&flag != 0
+}
+
+// keysOf returns the keys among the arguments of the command
+func (cs *commandSpec) keysOf(args []string) []string {
+	if cs.getKeys != nil {
+		return cs.getKeys(args)
+	}
+	if cs.keys.step == 0 {
+		return nil
+	}
+	last := cs.keys.last
+	if last < 0 {
+		last += len(args)
+	}
+	var keys []string
+	for i := cs.keys.first; i <= last && i < len(args); i += cs.keys.step {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
+
+// acceptsArgs reports whether @n arguments (excluding the name) satisfy the arity
+func (cs *commandSpec) acceptsArgs(n int) bool {
+	if cs.arity < 0 {
+		return n+1 >= -cs.arity
+	}
+	return n+1 == cs.arity
+}
+
+// commandTable holds the supported commands, keyed by their lowercase name.
+// Each <type>_commands.go file registers its commands from init().
+var commandTable = map[string]*commandSpec{}
+
+func registerCommands(specs ...*commandSpec) {
+	for _, spec := range specs {
+		if spec.is(flagWrite) {
+			spec.categories |= catWrite
+		} else if spec.keys.step != 0 || spec.getKeys != nil {
+			spec.categories |= catRead
+		}
+		commandTable[spec.name] = spec
+	}
+}
+
+func lookupCommand(name string) (*commandSpec, bool) {
+	spec, found := commandTable[strings.ToLower(name)]
+	return spec, found
+}
+
+/* ---------------- argument parsing ---------------- */
+
+// parseInt parses a base 10 signed integer the way Redis does: no sign other than
+// a leading '-', no leading zeros and no surrounding spaces
+func parseInt(s string) (int64, bool) {
+	if len(s) == 0 || len(s) > 20 {
+		return 0, false
+	}
+	digits := s
+	if digits[0] == '-' {
+		digits = digits[1:]
+	}
+	if len(digits) == 0 || (digits[0] == '0' && len(s) > 1) {
+		return 0, false
+	}
+	for i := 0; i < len(digits); i++ {
+		if digits[i] < '0' || digits[i] > '9' {
+			return 0, false
+		}
+	}
+	value, err := strconv.ParseInt(s, 10, 64)
+	return value, err == nil
+}
+
+// parseFloat parses a floating point number, rejecting NaN and surrounding spaces
+func parseFloat(s string) (float64, bool) {
+	if len(s) == 0 || s[0] == ' ' || s[len(s)-1] == ' ' {
+		return 0, false
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(value) {
+		return 0, false
+	}
+	return value, true
+}
+
+// formatFloat formats a float without exponent and trailing zeros
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,707 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Config holds the settings of the server
+type Config struct {
+	Bind       string // address the server listens on, all the interfaces if empty
+	Port       int    // port the server listens on, announced by a replica to its master
+	MaxClients int    // maximum number of connected clients
+	Timeout    int    // seconds after which an idle client is disconnected, 0 to never
+	LogLevel   string // debug, verbose, notice or warning
+	Databases  int    // number of databases, selected with SELECT
+
+	RequirePass string // password of the default user, none if empty
+	ACLFile     string // file the users are loaded from and saved to
+
+	Maxmemory        int    // bytes the datastore may use before keys are evicted, 0 for no limit
+	MaxmemoryPolicy  string // what is evicted once maxmemory is exceeded, see evict.go
+	MaxmemorySamples int    // keys sampled for each eviction
+	LFULogFactor     int    // how slowly the access counters of the LFU policies grow
+	LFUDecayTime     int    // minutes after which the access counters are decremented
+
+	Dir        string     // working directory, where the snapshot is written
+	DBFilename string     // name of the snapshot file
+	SaveRules  []SaveRule // a background snapshot is taken once any rule is met
+
+	AppendOnly     bool   // the write commands are logged to the append only file
+	AppendFilename string // name of the append only file
+	AppendFsync    string // when the append only file is synced: always, everysec or no
+
+	ReplicaOf       string // "host port" of the master the server replicates at startup
+	MasterUser      string // user a replica authenticates as with its master
+	MasterAuth      string // password a replica authenticates with to its master
+	ReplicaReadOnly bool   // a replica rejects the write commands of its clients
+	ReplBacklogSize int    // size of the replication backlog, for partial resynchronizations
+
+	SlowlogLogSlowerThan    int // microseconds after which a command is logged, negative to disable
+	SlowlogMaxLen           int // entries kept by the slow log
+	LatencyMonitorThreshold int // milliseconds after which a latency spike is recorded, 0 to disable
+	MetricsPort             int // port of the HTTP /metrics endpoint, 0 to disable
+
+	NotifyKeyspaceEvents string         // classes of the keyspace events published, none if empty, see notify.go
+	notifyEvents         keyspaceEvents // the classes parsed once set, read for each event
+
+	EventLoop bool // the commands run on a single goroutine rather than under a lock, see eventloop.go
+
+	path string // config file the configuration was loaded from, for CONFIG REWRITE
+}
+
+// SaveRule takes a background snapshot when at least @Changes changes were made and
+// @Seconds elapsed since the last snapshot, like the save directive of redis.conf
+type SaveRule struct {
+	Seconds int64
+	Changes int64
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Port:       6379,
+		MaxClients: 10000,
+		LogLevel:   "notice",
+		Databases:  16,
+
+		MaxmemoryPolicy:  PolicyNoEviction,
+		MaxmemorySamples: 5,
+		LFULogFactor:     10,
+		LFUDecayTime:     1,
+
+		Dir:        ".",
+		DBFilename: "dump.rdb",
+		SaveRules:  []SaveRule{{Seconds: 3600, Changes: 1}, {Seconds: 300, Changes: 100}, {Seconds: 60, Changes: 10000}},
+
+		AppendFilename: "appendonly.aof",
+		AppendFsync:    FsyncEverySec,
+
+		ReplicaReadOnly: true,
+		ReplBacklogSize: 1 << 20,
+
+		SlowlogLogSlowerThan: 10000,
+		SlowlogMaxLen:        128,
+	}
+}
+
+// SnapshotPath is the path of the snapshot file
+func (c *Config) SnapshotPath() string {
+	return filepath.Join(c.Dir, c.DBFilename)
+}
+
+// AppendOnlyPath is the path of the append only file
+func (c *Config) AppendOnlyPath() string {
+	return filepath.Join(c.Dir, c.AppendFilename)
+}
+
+// Addr is the address the server listens on
+func (c *Config) Addr() string {
+	return net.JoinHostPort(c.Bind, strconv.Itoa(c.Port))
+}
+
+// MetricsAddr is the address of the metrics endpoint
+func (c *Config) MetricsAddr() string {
+	return net.JoinHostPort(c.Bind, strconv.Itoa(c.MetricsPort))
+}
+
+// met reports whether the rule is met after @dirty changes, @elapsed since the last snapshot
+func (rule SaveRule) met(dirty int64, elapsed time.Duration) bool {
+	return dirty >= rule.Changes && elapsed >= time.Duration(rule.Seconds)*time.Second
+}
+
+/* ---------------- logging ---------------- */
+
+// logLevel is the level of the loggers of the server, set by the loglevel option
+var logLevel = zap.NewAtomicLevelAt(zapcore.InfoLevel)
+
+// newLogger returns a production logger at the configured level
+func newLogger() *zap.Logger {
+	config := zap.NewProductionConfig()
+	config.Level = logLevel
+	logger, _ := config.Build()
+	return logger
+}
+
+// logLevels maps the levels of redis.conf to the levels of zap
+var logLevels = map[string]zapcore.Level{
+	"debug":   zapcore.DebugLevel,
+	"verbose": zapcore.InfoLevel,
+	"notice":  zapcore.InfoLevel,
+	"warning": zapcore.WarnLevel,
+}
+
+/* ---------------- options ---------------- */
+
+// configOption is an option of the config file, also given as a command line flag
+// and read with CONFIG GET. Options which are not @mutable can't be changed by CONFIG SET.
+type configOption struct {
+	name    string
+	mutable bool
+	get     func(c *Config) string
+	set     func(c *Config, args []string) error
+	// apply makes the executor follow the option once it was changed by CONFIG SET
+	apply func(re *RedisExecutorImpl)
+}
+
+var errConfigArgs = errors.New("wrong number of arguments")
+
+func stringOption(name string, mutable bool, field func(c *Config) *string) *configOption {
+	return &configOption{
+		name:    name,
+		mutable: mutable,
+		get:     func(c *Config) string { return *field(c) },
+		set: func(c *Config, args []string) error {
+			if len(args) != 1 {
+				return errConfigArgs
+			}
+			*field(c) = args[0]
+			return nil
+		},
+	}
+}
+
+func enumOption(name string, values []string, field func(c *Config) *string) *configOption {
+	option := stringOption(name, true, field)
+	set := option.set
+	option.set = func(c *Config, args []string) error {
+		if len(args) == 1 {
+			for _, value := range values {
+				if strings.EqualFold(args[0], value) {
+					return set(c, []string{value})
+				}
+			}
+			return fmt.Errorf("argument must be one of the following: %s", strings.Join(values, ", "))
+		}
+		return set(c, args)
+	}
+	return option
+}
+
+func intOption(name string, mutable bool, min, max int64, field func(c *Config) *int) *configOption {
+	return &configOption{
+		name:    name,
+		mutable: mutable,
+		get:     func(c *Config) string { return strconv.Itoa(*field(c)) },
+		set: func(c *Config, args []string) error {
+			if len(args) != 1 {
+				return errConfigArgs
+			}
+			n, ok := parseMemory(args[0])
+			if !ok {
+				return errors.New("argument couldn't be parsed into an integer")
+			}
+			if n < min || n > max {
+				return fmt.Errorf("argument must be between %d and %d inclusive", min, max)
+			}
+			*field(c) = int(n)
+			return nil
+		},
+	}
+}
+
+func boolOption(name string, mutable bool, field func(c *Config) *bool) *configOption {
+	return &configOption{
+		name:    name,
+		mutable: mutable,
+		get: func(c *Config) string {
+			if *field(c) {
+				return "yes"
+			}
+			return "no"
+		},
+		set: func(c *Config, args []string) error {
+			if len(args) != 1 {
+				return errConfigArgs
+			}
+			switch strings.ToLower(args[0]) {
+			case "yes":
+				*field(c) = true
+			case "no":
+				*field(c) = false
+			default:
+				return errors.New("argument must be 'yes' or 'no'")
+			}
+			return nil
+		},
+	}
+}
+
+func withApply(option *configOption, apply func(re *RedisExecutorImpl)) *configOption {
+	option.apply = apply
+	return option
+}
+
+// configOptions are the options of the server, in the order CONFIG GET reports them
+var configOptions = []*configOption{
+	stringOption("bind", false, func(c *Config) *string { return &c.Bind }),
+	intOption("port", false, 0, 65535, func(c *Config) *int { return &c.Port }),
+	intOption("maxclients", true, 1, 1<<31-1, func(c *Config) *int { return &c.MaxClients }),
+	intOption("timeout", true, 0, 1<<31-1, func(c *Config) *int { return &c.Timeout }),
+	withApply(enumOption("loglevel", []string{"debug", "verbose", "notice", "warning"}, func(c *Config) *string { return &c.LogLevel }),
+		func(re *RedisExecutorImpl) { logLevel.SetLevel(logLevels[re.config.LogLevel]) }),
+	intOption("databases", false, 1, 1<<31-1, func(c *Config) *int { return &c.Databases }),
+	withApply(stringOption("requirepass", true, func(c *Config) *string { return &c.RequirePass }), (*RedisExecutorImpl).applyRequirePass),
+	stringOption("aclfile", false, func(c *Config) *string { return &c.ACLFile }),
+	withApply(intOption("maxmemory", true, 0, 1<<62, func(c *Config) *int { return &c.Maxmemory }), (*RedisExecutorImpl).applyMaxmemory),
+	enumOption("maxmemory-policy", maxmemoryPolicies, func(c *Config) *string { return &c.MaxmemoryPolicy }),
+	intOption("maxmemory-samples", true, 1, 64, func(c *Config) *int { return &c.MaxmemorySamples }),
+	intOption("lfu-log-factor", true, 0, 1<<31-1, func(c *Config) *int { return &c.LFULogFactor }),
+	intOption("lfu-decay-time", true, 0, 1<<31-1, func(c *Config) *int { return &c.LFUDecayTime }),
+	{
+		name:    "dir",
+		mutable: true,
+		get:     func(c *Config) string { return c.Dir },
+		set: func(c *Config, args []string) error {
+			if len(args) != 1 {
+				return errConfigArgs
+			}
+			if info, err := os.Stat(args[0]); err != nil || !info.IsDir() {
+				return fmt.Errorf("no such directory: %s", args[0])
+			}
+			c.Dir = args[0]
+			return nil
+		},
+	},
+	stringOption("dbfilename", true, func(c *Config) *string { return &c.DBFilename }),
+	{
+		name:    "save",
+		mutable: true,
+		get:     func(c *Config) string { return formatSaveRules(c.SaveRules) },
+		set:     setSaveRules,
+	},
+	withApply(boolOption("appendonly", true, func(c *Config) *bool { return &c.AppendOnly }), (*RedisExecutorImpl).applyAppendOnly),
+	stringOption("appendfilename", false, func(c *Config) *string { return &c.AppendFilename }),
+	enumOption("appendfsync", []string{FsyncAlways, FsyncEverySec, FsyncNo}, func(c *Config) *string { return &c.AppendFsync }),
+	{
+		name: "replicaof",
+		get:  func(c *Config) string { return c.ReplicaOf },
+		set: func(c *Config, args []string) error {
+			if len(args) != 2 {
+				return errConfigArgs
+			}
+			if n, ok := parseInt(args[1]); !ok || n <= 0 || n > 65535 {
+				return ErrInvalidMasterPort
+			}
+			c.ReplicaOf = args[0] + " " + args[1]
+			return nil
+		},
+	},
+	stringOption("masteruser", true, func(c *Config) *string { return &c.MasterUser }),
+	stringOption("masterauth", true, func(c *Config) *string { return &c.MasterAuth }),
+	boolOption("replica-read-only", true, func(c *Config) *bool { return &c.ReplicaReadOnly }),
+	withApply(intOption("repl-backlog-size", true, 1, 1<<40, func(c *Config) *int { return &c.ReplBacklogSize }), (*RedisExecutorImpl).resizeReplBacklog),
+	intOption("slowlog-log-slower-than", true, -1, 1<<62, func(c *Config) *int { return &c.SlowlogLogSlowerThan }),
+	withApply(intOption("slowlog-max-len", true, 0, 1<<31-1, func(c *Config) *int { return &c.SlowlogMaxLen }), (*RedisExecutorImpl).trimSlowlog),
+	intOption("latency-monitor-threshold", true, 0, 1<<62, func(c *Config) *int { return &c.LatencyMonitorThreshold }),
+	{
+		name:    "notify-keyspace-events",
+		mutable: true,
+		get:     func(c *Config) string { return c.NotifyKeyspaceEvents },
+		set: func(c *Config, args []string) error {
+			if len(args) != 1 {
+				return errConfigArgs
+			}
+			flags, err := parseKeyspaceEvents(args[0])
+			if err != nil {
+				return err
+			}
+			c.NotifyKeyspaceEvents, c.notifyEvents = formatKeyspaceEvents(flags), flags
+			return nil
+		},
+	},
+	intOption("metrics-port", false, 0, 65535, func(c *Config) *int { return &c.MetricsPort }),
+	boolOption("event-loop", false, func(c *Config) *bool { return &c.EventLoop }),
+}
+
+// configAliases are the former names of options
+var configAliases = map[string]string{
+	"slaveof":         "replicaof",
+	"slave-read-only": "replica-read-only",
+}
+
+func lookupConfigOption(name string) (*configOption, bool) {
+	name = strings.ToLower(name)
+	if alias, found := configAliases[name]; found {
+		name = alias
+	}
+	for _, option := range configOptions {
+		if option.name == name {
+			return option, true
+		}
+	}
+	return nil, false
+}
+
+// parseMemory parses an integer with an optional unit: k, m and g are powers of 1000,
+// kb, mb and gb powers of 1024
+func parseMemory(s string) (int64, bool) {
+	units := []struct {
+		suffix string
+		scale  int64
+	}{{"gb", 1 << 30}, {"mb", 1 << 20}, {"kb", 1 << 10}, {"g", 1e9}, {"m", 1e6}, {"k", 1e3}, {"b", 1}}
+	lower := strings.ToLower(s)
+	for _, unit := range units {
+		if digits, found := strings.CutSuffix(lower, unit.suffix); found {
+			n, ok := parseInt(digits)
+			if !ok || n > (1<<63-1)/unit.scale || n < -(1<<63-1)/unit.scale {
+				return 0, false
+			}
+			return n * unit.scale, true
+		}
+	}
+	return parseInt(s)
+}
+
+// setSaveRules parses the save rules "<seconds> <changes> [<seconds> <changes> ...]",
+// an empty argument disables the snapshots
+func setSaveRules(c *Config, args []string) error {
+	if len(args) == 1 {
+		args = strings.Fields(args[0])
+	}
+	if len(args)%2 != 0 {
+		return errors.New("invalid save parameters")
+	}
+	var rules []SaveRule
+	for i := 0; i < len(args); i += 2 {
+		seconds, ok1 := parseInt(args[i])
+		changes, ok2 := parseInt(args[i+1])
+		if !ok1 || !ok2 || seconds < 1 || changes < 0 {
+			return errors.New("invalid save parameters")
+		}
+		rules = append(rules, SaveRule{Seconds: seconds, Changes: changes})
+	}
+	c.SaveRules = rules
+	return nil
+}
+
+func formatSaveRules(rules []SaveRule) string {
+	parts := make([]string, 0, 2*len(rules))
+	for _, rule := range rules {
+		parts = append(parts, strconv.FormatInt(rule.Seconds, 10), strconv.FormatInt(rule.Changes, 10))
+	}
+	return strings.Join(parts, " ")
+}
+
+/* ---------------- config file ---------------- */
+
+// ParseArgs builds the configuration from the command line of the server, like
+// redis-server: [/path/to/redis.conf] [--option value ...]. The options given as
+// flags override those of the file.
+func ParseArgs(args []string) (*Config, error) {
+	config := DefaultConfig()
+	var lines [][]string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "--") {
+		path, err := filepath.Abs(args[0])
+		if err != nil {
+			return nil, err
+		}
+		if lines, err = readConfigFile(path); err != nil {
+			return nil, err
+		}
+		config.path = path
+		args = args[1:]
+	}
+	flags := len(lines)
+	for _, arg := range args {
+		if name, found := strings.CutPrefix(arg, "--"); found {
+			lines = append(lines, []string{name})
+		} else if len(lines) > flags {
+			lines[len(lines)-1] = append(lines[len(lines)-1], arg)
+		} else {
+			return nil, fmt.Errorf("invalid argument '%s', the options are given as --option value", arg)
+		}
+	}
+	if err := config.load(lines); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// readConfigFile reads the directives of a config file, skipping the comments
+func readConfigFile(path string) ([][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var lines [][]string
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		args, err := splitArgs(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		if len(args) > 0 {
+			lines = append(lines, args)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// load applies the directives of a config file. Like Redis, the first save
+// directive replaces the default save rules.
+func (c *Config) load(lines [][]string) error {
+	saveSeen := false
+	for _, line := range lines {
+		option, found := lookupConfigOption(line[0])
+		if !found {
+			return fmt.Errorf("bad directive '%s'", line[0])
+		}
+		args := line[1:]
+		if option.name == "save" {
+			if !saveSeen {
+				c.SaveRules = nil
+				saveSeen = true
+			}
+			rules := c.SaveRules
+			if err := setSaveRules(c, args); err != nil {
+				return fmt.Errorf("'%s': %w", strings.Join(line, " "), err)
+			}
+			c.SaveRules = append(rules, c.SaveRules...)
+			continue
+		}
+		if err := option.set(c, args); err != nil {
+			return fmt.Errorf("'%s': %w", strings.Join(line, " "), err)
+		}
+	}
+	return nil
+}
+
+// directives returns the lines of the config file setting the option to its value
+func (option *configOption) directives(c *Config) []string {
+	if option.name == "save" {
+		if len(c.SaveRules) == 0 {
+			return []string{`save ""`}
+		}
+		lines := make([]string, 0, len(c.SaveRules))
+		for _, rule := range c.SaveRules {
+			lines = append(lines, fmt.Sprintf("save %d %d", rule.Seconds, rule.Changes))
+		}
+		return lines
+	}
+	value := option.get(c)
+	if option.name == "replicaof" {
+		if value == "" {
+			return nil
+		}
+		return []string{"replicaof " + value}
+	}
+	return []string{option.name + " " + quoteArg(value)}
+}
+
+// rewrite writes the configuration back to its config file. The lines of the options
+// are replaced in place, the comments and the order of the file are kept. The options
+// missing from the file are appended, unless they have their default value.
+func (c *Config) rewrite() error {
+	if c.path == "" {
+		return errors.New("The server is running without a config file")
+	}
+	content, err := os.ReadFile(c.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	var out []string
+	written := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimRight(string(content), "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		args, err := splitArgs(trimmed)
+		if trimmed == "" || trimmed[0] == '#' || err != nil || len(args) == 0 {
+			out = append(out, line)
+			continue
+		}
+		option, found := lookupConfigOption(args[0])
+		if !found {
+			out = append(out, line)
+			continue
+		}
+		// the option is written at its first line, its other lines are dropped
+		if !written[option.name] {
+			out = append(out, option.directives(c)...)
+			written[option.name] = true
+		}
+	}
+
+	defaults := DefaultConfig()
+	header := false
+	for _, option := range configOptions {
+		if written[option.name] || option.get(c) == option.get(defaults) {
+			continue
+		}
+		if !header {
+			out = append(out, "# Generated by CONFIG REWRITE")
+			header = true
+		}
+		out = append(out, option.directives(c)...)
+	}
+	if len(out) > 0 && out[0] == "" && len(content) == 0 {
+		out = out[1:]
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(c.path), "temp-config-*.conf")
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(strings.Join(out, "\n") + "\n")
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), c.path)
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+	}
+	return err
+}
+
+/* ---------------- arguments ---------------- */
+
+var ErrUnbalancedQuotes = errors.New("unbalanced quotes")
+
+// splitArgs splits a line into arguments like sdssplitargs of Redis. Arguments are
+// separated by spaces, and may be quoted: "double quotes" support the escapes \n, \r,
+// \t, \b, \a, \xHH and \<char>, 'single quotes' only \'. A closing quote must be
+// followed by a space or the end of the line.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	i := 0
+	for {
+		for i < len(line) && isArgSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+		var arg []byte
+		inDouble, inSingle, done := false, false, false
+		for !done {
+			switch {
+			case inDouble:
+				if i == len(line) {
+					return nil, ErrUnbalancedQuotes
+				}
+				c := line[i]
+				if c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]) {
+					b, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+					arg = append(arg, byte(b))
+					i += 3
+				} else if c == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						arg = append(arg, '\n')
+					case 'r':
+						arg = append(arg, '\r')
+					case 't':
+						arg = append(arg, '\t')
+					case 'b':
+						arg = append(arg, '\b')
+					case 'a':
+						arg = append(arg, '\a')
+					default:
+						arg = append(arg, line[i])
+					}
+				} else if c == '"' {
+					if i+1 < len(line) && !isArgSpace(line[i+1]) {
+						return nil, ErrUnbalancedQuotes
+					}
+					done = true
+				} else {
+					arg = append(arg, c)
+				}
+			case inSingle:
+				if i == len(line) {
+					return nil, ErrUnbalancedQuotes
+				}
+				c := line[i]
+				if c == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					arg = append(arg, '\'')
+				} else if c == '\'' {
+					if i+1 < len(line) && !isArgSpace(line[i+1]) {
+						return nil, ErrUnbalancedQuotes
+					}
+					done = true
+				} else {
+					arg = append(arg, c)
+				}
+			default:
+				if i == len(line) {
+					done = true
+					break
+				}
+				switch c := line[i]; {
+				case isArgSpace(c):
+					done = true
+				case c == '"':
+					inDouble = true
+				case c == '\'':
+					inSingle = true
+				default:
+					arg = append(arg, c)
+				}
+			}
+			if i < len(line) {
+				i++
+			}
+		}
+		args = append(args, string(arg))
+	}
+}
+
+func isArgSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\v' || c == '\f'
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// quoteArg quotes an argument of the config file if needed, so that splitArgs
+// parses it back
+func quoteArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\r\n\v\f\"'\\") {
+		return arg
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(arg); i++ {
+		switch c := arg[i]; c {
+		case '\\', '"':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if c < 0x20 || c >= 0x7f {
+				fmt.Fprintf(&b, `\x%02x`, c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"strings"
+)
+
+func init() {
+	registerCommands(
+		&commandSpec{name: "config", arity: -2, handler: configCommand, flags: flagSkipSlowlog, categories: catAdmin | catDangerous},
+	)
+}
+
+// ConfigSetError is the error of CONFIG SET when the value of an option is rejected
+func ConfigSetError(name string, err error) error {
+	return fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%s') - %v", name, err)
+}
+
+// CONFIG GET pattern [pattern ...] | SET option value [option value ...] | REWRITE
+func configCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	switch subcommand := strings.ToLower(args[0]); {
+	case subcommand == "get" && len(args) >= 2:
+		return configGet(re, args[1:])
+	case subcommand == "set" && len(args) >= 3 && len(args)%2 == 1:
+		return configSet(re, args[1:])
+	case subcommand == "rewrite" && len(args) == 1:
+		if err := re.config.rewrite(); err != nil {
+			return ErrorResponse(fmt.Errorf("ERR %v", err))
+		}
+		re.Info("configuration rewritten", zap.String("path", re.config.path))
+		return OKResponse()
+	case subcommand == "set" || subcommand == "get":
+		return ErrorResponse(WrongArgsError("config|" + subcommand))
+	}
+	return ErrorResponse(UnknownSubcommandError(cmd))
+}
+
+// configGet replies the options matching any of the patterns, as name value pairs
+func configGet(re *RedisExecutorImpl, patterns []string) *RedisResponse {
+	var values []string
+	for _, option := range configOptions {
+		for _, pattern := range patterns {
+			if globMatch(pattern, option.name, true) {
+				values = append(values, option.name, option.get(re.config))
+				break
+			}
+		}
+	}
+	return BulkArrayResponse(values)
+}
+
+// configSet changes the options atomically: the values are checked on a copy of the
+// configuration, which replaces it only if all of them are valid. The executor then
+// follows the options which changed.
+func configSet(re *RedisExecutorImpl, args []string) *RedisResponse {
+	updated := *re.config
+	var changed []*configOption
+	for i := 0; i < len(args); i += 2 {
+		option, found := lookupConfigOption(args[i])
+		switch {
+		case !found:
+			return ErrorResponse(fmt.Errorf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", args[i]))
+		case !option.mutable:
+			return ErrorResponse(ConfigSetError(option.name, fmt.Errorf("can't set immutable config")))
+		}
+		for _, other := range changed {
+			if other == option {
+				return ErrorResponse(ConfigSetError(option.name, fmt.Errorf("duplicate parameter")))
+			}
+		}
+		if err := option.set(&updated, args[i+1:i+2]); err != nil {
+			return ErrorResponse(ConfigSetError(option.name, err))
+		}
+		changed = append(changed, option)
+	}
+	*re.config = updated
+	for _, option := range changed {
+		if option.apply != nil {
+			option.apply(re)
+		}
+	}
+	return OKResponse()
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeConfigFile writes a config file in a temporary directory and returns its path
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "redis.conf")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseArgs(t *testing.T) {
+	path := writeConfigFile(t, `# a comment
+port 7000
+bind 127.0.0.1
+save 900 1
+save 300 10
+appendonly yes
+requirepass "a b\x21"
+slave-read-only no
+`)
+	config, err := ParseArgs([]string{path, "--port", "7001", "--maxclients", "10", "--appendfsync", "ALWAYS", "--repl-backlog-size", "2mb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DefaultConfig()
+	want.Bind, want.Port, want.MaxClients = "127.0.0.1", 7001, 10
+	want.SaveRules = []SaveRule{{900, 1}, {300, 10}}
+	want.AppendOnly, want.AppendFsync = true, FsyncAlways
+	want.RequirePass = "a b!"
+	want.ReplicaReadOnly, want.ReplBacklogSize = false, 2<<20
+	want.path = path
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("got %+v, want %+v", config, want)
+	}
+	if config.Addr() != "127.0.0.1:7001" {
+		t.Errorf("addr: got %s", config.Addr())
+	}
+
+	for _, args := range [][]string{
+		{"--port", "x"},
+		{"--port", "1", "2"},
+		{"--unknown", "1"},
+		{"--appendfsync", "sometimes"},
+		{"--save", "60"},
+		{"--replicaof", "localhost", "0"},
+		{"value"},
+		{writeConfigFile(t, "port 1\nbind \"unbalanced\n")},
+	} {
+		if _, err := ParseArgs(args); err == nil {
+			t.Errorf("%q: no error", args)
+		}
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"set key value", []string{"set", "key", "value"}},
+		{"  set   key\tvalue  ", []string{"set", "key", "value"}},
+		{`set "a key" 'a value'`, []string{"set", "a key", "a value"}},
+		{`set "\x41\n\"\\" '\'x\n'`, []string{"set", "A\n\"\\", `'x\n`}},
+		{`set "" ''`, []string{"set", "", ""}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		got, err := splitArgs(tt.line)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %q %v, want %q", tt.line, got, err, tt.want)
+		}
+	}
+	for _, line := range []string{`set "key`, `set 'key`, `set "key"value`} {
+		if _, err := splitArgs(line); err != ErrUnbalancedQuotes {
+			t.Errorf("%q: got %v, want %v", line, err, ErrUnbalancedQuotes)
+		}
+	}
+	for _, arg := range []string{"plain", "", "a b", "\"'\\", "\r\n\t\x00\xff"} {
+		if got, err := splitArgs(quoteArg(arg)); err != nil || len(got) != 1 || got[0] != arg {
+			t.Errorf("%q: quoted %s parsed as %q %v", arg, quoteArg(arg), got, err)
+		}
+	}
+}
+
+func TestConfigGetSet(t *testing.T) {
+	re := newTestExecutor()
+	runSteps(t, re, []testStep{
+		{cmd("CONFIG", "GET", "port"), "*2\r\n$4\r\nport\r\n$4\r\n6379\r\n"},
+		{cmd("CONFIG", "GET", "append*", "DBFILENAME"), "*8\r\n$10\r\ndbfilename\r\n$8\r\ndump.rdb\r\n$10\r\nappendonly\r\n$2\r\nno\r\n$14\r\nappendfilename\r\n$14\r\nappendonly.aof\r\n$11\r\nappendfsync\r\n$8\r\neverysec\r\n"},
+		{cmd("CONFIG", "GET", "save"), "*2\r\n$4\r\nsave\r\n$23\r\n3600 1 300 100 60 10000\r\n"},
+		{cmd("CONFIG", "GET", "nothing"), "*0\r\n"},
+		{cmd("CONFIG", "SET", "maxclients", "100", "save", "60 1", "timeout", "30"), "+OK\r\n"},
+		{cmd("CONFIG", "GET", "maxclients"), "*2\r\n$10\r\nmaxclients\r\n$3\r\n100\r\n"},
+		{cmd("CONFIG", "GET", "save"), "*2\r\n$4\r\nsave\r\n$4\r\n60 1\r\n"},
+		{cmd("CONFIG", "SET", "save", ""), "+OK\r\n"},
+		{cmd("CONFIG", "SET", "slave-read-only", "no"), "+OK\r\n"},
+		{cmd("CONFIG", "GET", "replica-read-only"), "*2\r\n$17\r\nreplica-read-only\r\n$2\r\nno\r\n"},
+
+		// the options are set all or none
+		{cmd("CONFIG", "SET", "maxclients", "200", "timeout", "-1"), "-ERR CONFIG SET failed (possibly related to argument 'timeout') - argument must be between 0 and 2147483647 inclusive\r\n"},
+		{cmd("CONFIG", "GET", "maxclients"), "*2\r\n$10\r\nmaxclients\r\n$3\r\n100\r\n"},
+		{cmd("CONFIG", "SET", "port", "7000"), "-ERR CONFIG SET failed (possibly related to argument 'port') - can't set immutable config\r\n"},
+		{cmd("CONFIG", "SET", "timeout", "1", "timeout", "2"), "-ERR CONFIG SET failed (possibly related to argument 'timeout') - duplicate parameter\r\n"},
+		{cmd("CONFIG", "SET", "appendonly", "maybe"), "-ERR CONFIG SET failed (possibly related to argument 'appendonly') - argument must be 'yes' or 'no'\r\n"},
+		{cmd("CONFIG", "SET", "dir", "/nonexistent"), "-ERR CONFIG SET failed (possibly related to argument 'dir') - no such directory: /nonexistent\r\n"},
+		{cmd("CONFIG", "SET", "foo", "bar"), "-ERR Unknown option or number of arguments for CONFIG SET - 'foo'\r\n"},
+		{cmd("CONFIG", "SET", "timeout"), "-ERR wrong number of arguments for 'config|set' command\r\n"},
+		{cmd("CONFIG", "REWRITE"), "-ERR The server is running without a config file\r\n"},
+		{cmd("CONFIG", "FOO"), "-ERR unknown subcommand 'FOO'. Try CONFIG HELP.\r\n"},
+	})
+	if len(re.config.SaveRules) != 0 || re.config.ReplicaReadOnly {
+		t.Errorf("config: got %+v", re.config)
+	}
+}
+
+func TestConfigSetAppendOnly(t *testing.T) {
+	re := newTestExecutor()
+	re.config.Dir = t.TempDir()
+	execute(re, "SET", "k", "v")
+	runSteps(t, re, []testStep{{cmd("CONFIG", "SET", "appendonly", "yes"), "+OK\r\n"}})
+	waitRewrite(re)
+	execute(re, "SET", "a", "1")
+
+	got := readAppendOnly(t, re.config.AppendOnlyPath())
+	// the database is selected again after the rewrite
+	want := []string{"select 0", "set k v", "select 0", "set a 1"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	runSteps(t, re, []testStep{{cmd("CONFIG", "SET", "appendonly", "no"), "+OK\r\n"}})
+	if re.aof.file != nil {
+		t.Error("the append only file is still open")
+	}
+}
+
+func TestConfigRewrite(t *testing.T) {
+	path := writeConfigFile(t, `# the port
+port 7000
+
+# snapshots
+save 900 1
+save 300 10
+timeout 10
+appendonly no
+timeout 20
+`)
+	config, err := ParseArgs([]string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	re := newTestExecutor()
+	re.config = config
+	runSteps(t, re, []testStep{
+		{cmd("CONFIG", "SET", "save", "", "timeout", "0", "requirepass", "p w", "appendfsync", "always"), "+OK\r\n"},
+		{cmd("CONFIG", "REWRITE"), "+OK\r\n"},
+	})
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# the port
+port 7000
+
+# snapshots
+save ""
+timeout 0
+appendonly no
+# Generated by CONFIG REWRITE
+requirepass "p w"
+appendfsync always
+`
+	if string(content) != want {
+		t.Errorf("got %q, want %q", content, want)
+	}
+
+	// the rewritten file loads the same configuration, and is rewritten as is
+	loaded, err := ParseArgs([]string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, re.config) {
+		t.Errorf("got %+v, want %+v", loaded, re.config)
+	}
+	if err := loaded.rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := os.ReadFile(path); string(again) != string(content) {
+		t.Errorf("rewritten again: got %q, want %q", again, content)
+	}
+}
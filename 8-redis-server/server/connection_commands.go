@@ -0,0 +1,30 @@
+package server
+
+import "strings"
+
+func init() {
+	registerCommands(
+		&commandSpec{name: "ping", arity: -1, handler: pingCommand, flags: flagPubSub, categories: catConnection},
+		&commandSpec{name: "echo", arity: 2, handler: echoCommand, categories: catConnection},
+	)
+}
+
+// PING [message]
+// In subscriber mode the reply is the pong message followed by the message (empty by default).
+func pingCommand(_ *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	if client := cmd.Client(); client != nil && client.subscriptions() > 0 && len(cmd.Args()) <= 1 {
+		return BulkArrayResponse([]string{"pong", strings.Join(cmd.Args(), "")})
+	}
+	switch len(cmd.Args()) {
+	case 0:
+		return SimpleStringResponse("PONG")
+	case 1:
+		return BulkResponse(cmd.Arg(0))
+	}
+	return ErrorResponse(WrongArgsError(cmd.Name()))
+}
+
+// ECHO message
+func echoCommand(_ *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	return BulkResponse(cmd.Arg(0))
+}
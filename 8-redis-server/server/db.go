@@ -0,0 +1,205 @@
+package server
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrDBIndexRange = errors.New("ERR DB index is out of range")
+	ErrSameObject   = errors.New("ERR source and destination objects are the same")
+)
+
+func init() {
+	registerCommands(
+		&commandSpec{name: "select", arity: 2, handler: selectCommand, categories: catConnection},
+		&commandSpec{name: "dbsize", arity: 1, handler: dbsizeCommand, categories: catKeyspace | catRead},
+		&commandSpec{name: "flushdb", arity: -1, handler: flushdbCommand, flags: flagWrite, categories: catKeyspace | catDangerous},
+		&commandSpec{name: "flushall", arity: -1, handler: flushdbCommand, flags: flagWrite, categories: catKeyspace | catDangerous},
+		&commandSpec{name: "swapdb", arity: 3, handler: swapdbCommand, flags: flagWrite, categories: catKeyspace | catDangerous},
+	)
+}
+
+// dbKey is a key of one of the databases, e.g. a key clients are blocked on or watch
+type dbKey struct {
+	db  int
+	key string
+}
+
+// newDatabases creates @n empty databases
+func newDatabases(n int) []RedisCacher {
+	dbs := make([]RedisCacher, n)
+	for i := range dbs {
+		dbs[i] = NewRedisCacherImpl()
+	}
+	return dbs
+}
+
+// selectDB makes the database at index @id the one the commands run against, it is
+// selected for each command as the database of its client (SELECT)
+func (re *RedisExecutorImpl) selectDB(id int) {
+	re.db = id
+	re.RedisCacher = re.dbs[id]
+}
+
+// clientDB returns the database selected by the client, the first one for the
+// commands of the server itself
+func clientDB(client *Client) int {
+	if client == nil {
+		return 0
+	}
+	return client.db
+}
+
+// signalModifiedKeyInDB signals a key modified, and possibly pushed to, in the
+// database at index @id rather than in the selected one
+func (re *RedisExecutorImpl) signalModifiedKeyInDB(id int, key string) {
+	selected := re.db
+	re.selectDB(id)
+	re.signalModifiedKey(key)
+	re.signalKeyAsReady(key)
+	re.selectDB(selected)
+}
+
+// parseDBIndex parses the index of a database
+func (re *RedisExecutorImpl) parseDBIndex(arg string) (int, error) {
+	id, ok := parseInt(arg)
+	if !ok {
+		return 0, ErrNotInteger
+	}
+	if id < 0 || id >= int64(len(re.dbs)) {
+		return 0, ErrDBIndexRange
+	}
+	return int(id), nil
+}
+
+// usedMemory is the estimated memory used by the items of all the databases
+func (re *RedisExecutorImpl) usedMemory() int64 {
+	var used int64
+	for _, db := range re.dbs {
+		used += db.UsedMemory()
+	}
+	return used
+}
+
+// keyCount is the number of keys of all the databases
+func (re *RedisExecutorImpl) keyCount() int {
+	count := 0
+	for _, db := range re.dbs {
+		count += db.Len()
+	}
+	return count
+}
+
+// emptyDB removes every key of the database at index @id, of all the databases if -1.
+// The database is replaced with an empty one, so emptying it is O(1) and the memory
+// of its items is reclaimed by the garbage collector in the background.
+func (re *RedisExecutorImpl) emptyDB(id int) {
+	for i := range re.dbs {
+		if id != -1 && i != id {
+			continue
+		}
+		re.touchWatchedKeysInDB(i, nil)
+		re.rdb.dirty += int64(re.dbs[i].Len())
+		re.stats.expiredKeys += re.dbs[i].ExpiredKeys()
+		re.dbs[i] = re.watchExpiry(NewRedisCacherImpl())
+	}
+	re.evict.pool = re.evict.pool[:0]
+	re.selectDB(re.db)
+}
+
+// swapDB swaps the content of two databases, the clients of one seeing the keys of
+// the other from now on
+func (re *RedisExecutorImpl) swapDB(a, b int) {
+	re.touchWatchedKeysInDB(a, re.dbs[b])
+	re.touchWatchedKeysInDB(b, re.dbs[a])
+	re.dbs[a], re.dbs[b] = re.dbs[b], re.dbs[a]
+	re.selectDB(re.db)
+	// the clients blocked on a key the other database holds may be served
+	for key := range re.blocked {
+		if key.db == a || key.db == b {
+			if found, _ := re.dbs[key.db].Contains(key.key); found {
+				re.readyKeys = append(re.readyKeys, key)
+			}
+		}
+	}
+	re.rdb.dirty++
+}
+
+// touchWatchedKeysInDB fails the transactions of the clients watching a key of the
+// database at index @id which exists, in it or in @other, before it is emptied or
+// swapped with @other
+func (re *RedisExecutorImpl) touchWatchedKeysInDB(id int, other RedisCacher) {
+	for key, clients := range re.watchedKeys {
+		if key.db != id {
+			continue
+		}
+		found, _ := re.dbs[id].Contains(key.key)
+		if !found && other != nil {
+			found, _ = other.Contains(key.key)
+		}
+		if found {
+			for c := range clients {
+				c.dirtyCAS = true
+			}
+		}
+	}
+}
+
+/* ---------------- commands ---------------- */
+
+// SELECT index
+func selectCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	client := cmd.Client()
+	if client == nil {
+		return ErrorResponse(ErrNoClient)
+	}
+	id, err := re.parseDBIndex(cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	client.db = id
+	re.selectDB(id)
+	return OKResponse()
+}
+
+// DBSIZE, the number of keys of the selected database
+func dbsizeCommand(re *RedisExecutorImpl, _ *Cmd) *RedisResponse {
+	return IntegerResponse(int64(re.Len()))
+}
+
+// FLUSHDB [ASYNC | SYNC], also FLUSHALL. Emptying a database is O(1) either way, see
+// emptyDB, ASYNC is accepted for compatibility.
+func flushdbCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	if len(args) > 1 || (len(args) == 1 && !strings.EqualFold(args[0], "async") && !strings.EqualFold(args[0], "sync")) {
+		return ErrorResponse(ErrSyntax)
+	}
+	if cmd.Name() == "flushall" {
+		re.emptyDB(-1)
+	} else {
+		re.emptyDB(re.db)
+	}
+	// propagated even if nothing was removed
+	re.rdb.dirty++
+	return OKResponse()
+}
+
+// SWAPDB index1 index2
+func swapdbCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	var ids [2]int
+	for i, name := range []string{"first", "second"} {
+		id, ok := parseInt(cmd.Arg(i))
+		if !ok {
+			return ErrorResponse(errors.New("ERR invalid " + name + " DB index"))
+		}
+		if id < 0 || id >= int64(len(re.dbs)) {
+			return ErrorResponse(ErrDBIndexRange)
+		}
+		ids[i] = int(id)
+	}
+	if ids[0] != ids[1] {
+		re.swapDB(ids[0], ids[1])
+	}
+	return OKResponse()
+}
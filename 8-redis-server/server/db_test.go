@@ -0,0 +1,244 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSelect(t *testing.T) {
+	re := newTestExecutor()
+	c := NewClient(&bufferConn{})
+	runClientSteps(t, re, c, []testStep{
+		{cmd("SET", "k", "db0"), "+OK\r\n"},
+		{cmd("SELECT", "1"), "+OK\r\n"},
+		{cmd("GET", "k"), "$-1\r\n"},
+		{cmd("SET", "k", "db1"), "+OK\r\n"},
+		{cmd("SET", "other", "v"), "+OK\r\n"},
+		{cmd("DBSIZE"), ":2\r\n"},
+		{cmd("SELECT", "16"), "-" + ErrDBIndexRange.Error() + "\r\n"},
+		{cmd("SELECT", "one"), "-" + ErrNotInteger.Error() + "\r\n"},
+		{cmd("GET", "k"), "$3\r\ndb1\r\n"},
+	})
+	// each client has its own database selected
+	runClientSteps(t, re, NewClient(&bufferConn{}), []testStep{
+		{cmd("GET", "k"), "$3\r\ndb0\r\n"},
+		{cmd("DBSIZE"), ":1\r\n"},
+	})
+	runSteps(t, re, []testStep{{cmd("SELECT", "1"), "-" + ErrNoClient.Error() + "\r\n"}})
+}
+
+func TestFlushdb(t *testing.T) {
+	re := newTestExecutor()
+	c := NewClient(&bufferConn{})
+	runClientSteps(t, re, c, []testStep{
+		{cmd("MSET", "a", "1", "b", "2"), "+OK\r\n"},
+		{cmd("SELECT", "2"), "+OK\r\n"},
+		{cmd("SET", "c", "3"), "+OK\r\n"},
+		{cmd("FLUSHDB", "LATER"), "-" + ErrSyntax.Error() + "\r\n"},
+		{cmd("FLUSHDB", "ASYNC"), "+OK\r\n"},
+		{cmd("DBSIZE"), ":0\r\n"},
+		{cmd("SELECT", "0"), "+OK\r\n"},
+		{cmd("DBSIZE"), ":2\r\n"},
+		{cmd("SELECT", "2"), "+OK\r\n"},
+		{cmd("SET", "c", "3"), "+OK\r\n"},
+	})
+
+	// the transactions watching a removed key fail
+	watcher := NewClient(&bufferConn{})
+	runClientSteps(t, re, watcher, []testStep{
+		{cmd("WATCH", "a"), "+OK\r\n"},
+		{cmd("MULTI"), "+OK\r\n"},
+		{cmd("SET", "a", "x"), "+QUEUED\r\n"},
+	})
+	runClientSteps(t, re, c, []testStep{{cmd("FLUSHALL"), "+OK\r\n"}})
+	runClientSteps(t, re, watcher, []testStep{
+		{cmd("EXEC"), "*-1\r\n"},
+		{cmd("DBSIZE"), ":0\r\n"},
+	})
+	if re.keyCount() != 0 {
+		t.Errorf("got %d keys", re.keyCount())
+	}
+}
+
+func TestSwapdb(t *testing.T) {
+	re := newTestExecutor()
+	c := NewClient(&bufferConn{})
+	runClientSteps(t, re, c, []testStep{
+		{cmd("SET", "k", "db0"), "+OK\r\n"},
+		{cmd("SELECT", "1"), "+OK\r\n"},
+		{cmd("RPUSH", "list", "a"), ":1\r\n"},
+		{cmd("SWAPDB", "0", "1"), "+OK\r\n"},
+		{cmd("GET", "k"), "$3\r\ndb0\r\n"},
+		{cmd("SWAPDB", "x", "1"), "-ERR invalid first DB index\r\n"},
+		{cmd("SWAPDB", "0", "x"), "-ERR invalid second DB index\r\n"},
+		{cmd("SWAPDB", "0", "16"), "-" + ErrDBIndexRange.Error() + "\r\n"},
+	})
+	runSteps(t, re, []testStep{
+		{cmd("LPOP", "list"), "$1\r\na\r\n"},
+		{cmd("EXISTS", "k"), ":0\r\n"},
+	})
+}
+
+func TestMove(t *testing.T) {
+	re := newTestExecutor()
+	c := NewClient(&bufferConn{})
+	runClientSteps(t, re, c, []testStep{
+		{cmd("SET", "k", "v", "EX", "100"), "+OK\r\n"},
+		{cmd("MOVE", "k", "0"), "-" + ErrSameObject.Error() + "\r\n"},
+		{cmd("MOVE", "k", "16"), "-" + ErrDBIndexRange.Error() + "\r\n"},
+		{cmd("MOVE", "missing", "1"), ":0\r\n"},
+		{cmd("MOVE", "k", "1"), ":1\r\n"},
+		{cmd("EXISTS", "k"), ":0\r\n"},
+		{cmd("SET", "k", "again"), "+OK\r\n"},
+		// the key already exists in the target database
+		{cmd("MOVE", "k", "1"), ":0\r\n"},
+		{cmd("SELECT", "1"), "+OK\r\n"},
+		{cmd("GET", "k"), "$1\r\nv\r\n"},
+		{cmd("TTL", "k"), ":100\r\n"},
+	})
+}
+
+func TestCopy(t *testing.T) {
+	re := newTestExecutor()
+	c := NewClient(&bufferConn{})
+	runClientSteps(t, re, c, []testStep{
+		{cmd("RPUSH", "src", "a", "b"), ":2\r\n"},
+		{cmd("COPY", "src", "src"), "-" + ErrSameObject.Error() + "\r\n"},
+		{cmd("COPY", "src", "dst", "DB"), "-" + ErrSyntax.Error() + "\r\n"},
+		{cmd("COPY", "missing", "dst"), ":0\r\n"},
+		{cmd("COPY", "src", "dst"), ":1\r\n"},
+		{cmd("RPUSH", "dst", "c"), ":3\r\n"},
+		// the copy doesn't share the value
+		{cmd("LRANGE", "src", "0", "-1"), "*2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{cmd("COPY", "src", "dst"), ":0\r\n"},
+		{cmd("COPY", "src", "dst", "REPLACE"), ":1\r\n"},
+		{cmd("LLEN", "dst"), ":2\r\n"},
+		{cmd("COPY", "src", "src", "DB", "3"), ":1\r\n"},
+		{cmd("SELECT", "3"), "+OK\r\n"},
+		{cmd("LRANGE", "src", "0", "-1"), "*2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+	})
+}
+
+func TestRename(t *testing.T) {
+	re := newTestExecutor()
+	runSteps(t, re, []testStep{
+		{cmd("SET", "a", "1", "EX", "100"), "+OK\r\n"},
+		{cmd("SET", "b", "2"), "+OK\r\n"},
+		{cmd("RENAME", "missing", "x"), "-" + ErrNoSuchKey.Error() + "\r\n"},
+		{cmd("RENAMENX", "a", "b"), ":0\r\n"},
+		{cmd("RENAMENX", "a", "a"), ":0\r\n"},
+		{cmd("RENAME", "a", "a"), "+OK\r\n"},
+		{cmd("RENAME", "a", "b"), "+OK\r\n"},
+		{cmd("EXISTS", "a"), ":0\r\n"},
+		{cmd("GET", "b"), "$1\r\n1\r\n"},
+		{cmd("TTL", "b"), ":100\r\n"},
+		{cmd("RENAMENX", "b", "c"), ":1\r\n"},
+		{cmd("DBSIZE"), ":1\r\n"},
+	})
+}
+
+func TestKeysRandomkey(t *testing.T) {
+	re := newTestExecutor()
+	runSteps(t, re, []testStep{
+		{cmd("RANDOMKEY"), "$-1\r\n"},
+		{cmd("MSET", "hello", "1", "hallo", "2", "world", "3"), "+OK\r\n"},
+		{cmd("KEYS", "w*"), "*1\r\n$5\r\nworld\r\n"},
+		{cmd("KEYS", "h[^e]llo"), "*1\r\n$5\r\nhallo\r\n"},
+		{cmd("KEYS", "x*"), "*0\r\n"},
+		{cmd("SET", "gone", "v", "PX", "100000"), "+OK\r\n"},
+	})
+	item, _ := re.Get("gone")
+	item.ExpireAt = nowMs() - 1
+	if got := execute(re, "KEYS", "*"); !strings.HasPrefix(got, "*3\r\n") {
+		t.Errorf("KEYS *: got %q", got)
+	}
+	for i := 0; i < 10; i++ {
+		if got := execute(re, "RANDOMKEY"); got == "$4\r\ngone\r\n" {
+			t.Errorf("RANDOMKEY returned an expired key")
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	re := newTestExecutor()
+	for i := 0; i < 100; i++ {
+		execute(re, "SET", "key:"+strconv.Itoa(i), "v")
+	}
+	execute(re, "RPUSH", "key:list", "a")
+	runSteps(t, re, []testStep{
+		{cmd("SCAN", "0", "TYPE", "json"), "-ERR unknown type name 'json'\r\n"},
+		{cmd("SCAN", "0", "COUNT", "0"), "-" + ErrSyntax.Error() + "\r\n"},
+		{cmd("SCAN", "0", "TYPE", "list", "COUNT", "1000"), "*2\r\n$1\r\n0\r\n*1\r\n$8\r\nkey:list\r\n"},
+	})
+
+	// the keys present for the whole iteration are returned even if the keyspace grows
+	seen := make(map[string]bool)
+	cursor := "0"
+	added := 0
+	for {
+		reply := re.Execute(CreateCommandFromTokens(bulkTokens(
+			[]string{"SCAN", cursor, "MATCH", "key:*", "TYPE", "string", "COUNT", "5"})))
+		cursor = reply.Items[0].Value
+		for _, item := range reply.Items[1].Items {
+			seen[item.Value] = true
+		}
+		for i := 0; i < 20; i++ {
+			execute(re, "SET", "new:"+strconv.Itoa(added), "v")
+			added++
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if !seen["key:"+strconv.Itoa(i)] {
+			t.Errorf("key:%d wasn't returned", i)
+		}
+	}
+	if seen["key:list"] {
+		t.Error("a list was returned for TYPE string")
+	}
+}
+
+func TestDatabasesPersistence(t *testing.T) {
+	dir := t.TempDir()
+	re := newAppendOnlyExecutor(t, dir)
+	c := NewClient(&bufferConn{})
+	runClientSteps(t, re, c, []testStep{
+		{cmd("SET", "k", "db0"), "+OK\r\n"},
+		{cmd("SELECT", "5"), "+OK\r\n"},
+		{cmd("SET", "k", "db5"), "+OK\r\n"},
+		{cmd("SET", "other", "v"), "+OK\r\n"},
+	})
+	runSteps(t, re, []testStep{{cmd("SET", "last", "db0"), "+OK\r\n"}})
+	got := readAppendOnly(t, re.config.AppendOnlyPath())
+	want := []string{"select 0", "set k db0", "select 5", "set k db5", "set other v", "select 0", "set last db0"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	check := func(re *RedisExecutorImpl) {
+		t.Helper()
+		runClientSteps(t, re, NewClient(&bufferConn{}), []testStep{
+			{cmd("DBSIZE"), ":2\r\n"},
+			{cmd("GET", "k"), "$3\r\ndb0\r\n"},
+			{cmd("SELECT", "5"), "+OK\r\n"},
+			{cmd("GET", "k"), "$3\r\ndb5\r\n"},
+			{cmd("DBSIZE"), ":2\r\n"},
+		})
+	}
+	check(newAppendOnlyExecutor(t, dir))
+
+	runSteps(t, re, []testStep{{cmd("BGREWRITEAOF"), "+Background append only file rewriting started\r\n"}})
+	waitRewrite(re)
+	check(newAppendOnlyExecutor(t, dir))
+
+	runSteps(t, re, []testStep{{cmd("SAVE"), "+OK\r\n"}})
+	loaded := newTestExecutor()
+	loaded.config.Dir = dir
+	if err := loaded.LoadSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	check(loaded)
+}
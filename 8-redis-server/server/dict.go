@@ -0,0 +1,341 @@
+package server
+
+import (
+	"hash/maphash"
+	"math/bits"
+	"math/rand"
+)
+
+const dictInitialSize = 4
+
+var dictSeed = maphash.MakeSeed()
+
+// Dict is a hash table with incremental rehashing, modelled after the Redis dict.
+// While the table is resized the entries are moved from tables[0] to tables[1],
+// one bucket per operation, so that no single operation pays for the whole resize.
+// It is not safe for concurrent use.
+type Dict[V any] struct {
+	tables    [2]dictTable[V]
+	rehashIdx int // next bucket of tables[0] to move, -1 when not rehashing
+}
+
+type dictTable[V any] struct {
+	buckets []*dictEntry[V]
+	used    int
+}
+
+type dictEntry[V any] struct {
+	key   string
+	value V
+	next  *dictEntry[V]
+}
+
+func NewDict[V any]() *Dict[V] {
+	return &Dict[V]{rehashIdx: -1}
+}
+
+func (d *Dict[V]) Len() int {
+	return d.tables[0].used + d.tables[1].used
+}
+
+func (d *Dict[V]) Get(key string) (V, bool) {
+	if e := d.find(key); e != nil {
+		return e.value, true
+	}
+	var zero V
+	return zero, false
+}
+
+func (d *Dict[V]) Contains(key string) bool {
+	return d.find(key) != nil
+}
+
+// Set adds or replaces the value of key, it returns true if the key was added
+func (d *Dict[V]) Set(key string, value V) bool {
+	if e := d.find(key); e != nil {
+		e.value = value
+		return false
+	}
+	d.expandIfNeeded()
+	table := &d.tables[0]
+	if d.isRehashing() {
+		table = &d.tables[1]
+	}
+	idx := hashKey(key) & table.mask()
+	table.buckets[idx] = &dictEntry[V]{key: key, value: value, next: table.buckets[idx]}
+	table.used++
+	return true
+}
+
+// Delete removes the key, returning its value
+func (d *Dict[V]) Delete(key string) (V, bool) {
+	var zero V
+	if d.Len() == 0 {
+		return zero, false
+	}
+	d.rehashStep()
+	h := hashKey(key)
+	for t := 0; t <= 1; t++ {
+		table := &d.tables[t]
+		if table.used == 0 {
+			continue
+		}
+		idx := h & table.mask()
+		var prev *dictEntry[V]
+		for e := table.buckets[idx]; e != nil; prev, e = e, e.next {
+			if e.key != key {
+				continue
+			}
+			if prev == nil {
+				table.buckets[idx] = e.next
+			} else {
+				prev.next = e.next
+			}
+			table.used--
+			d.shrinkIfNeeded()
+			return e.value, true
+		}
+	}
+	return zero, false
+}
+
+// ForEach calls fn for every entry until it returns false.
+// The dict must not be modified by fn.
+func (d *Dict[V]) ForEach(fn func(key string, value V) bool) {
+	for t := 0; t <= 1; t++ {
+		for _, e := range d.tables[t].buckets {
+			for ; e != nil; e = e.next {
+				if !fn(e.key, e.value) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Clone returns a copy of the dict, the values are copied shallowly
+func (d *Dict[V]) Clone() *Dict[V] {
+	clone := NewDict[V]()
+	d.ForEach(func(key string, value V) bool {
+		clone.Set(key, value)
+		return true
+	})
+	return clone
+}
+
+// Keys returns all the keys in no particular order
+func (d *Dict[V]) Keys() []string {
+	keys := make([]string, 0, d.Len())
+	d.ForEach(func(key string, _ V) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// RandomEntry returns a random entry, picking a random non-empty bucket first
+func (d *Dict[V]) RandomEntry() (string, V, bool) {
+	var zero V
+	if d.Len() == 0 {
+		return "", zero, false
+	}
+	d.rehashStep()
+	var e *dictEntry[V]
+	for e == nil {
+		if d.isRehashing() {
+			// buckets of tables[0] below rehashIdx are empty
+			s0 := len(d.tables[0].buckets)
+			idx := d.rehashIdx + rand.Intn(s0+len(d.tables[1].buckets)-d.rehashIdx)
+			if idx >= s0 {
+				e = d.tables[1].buckets[idx-s0]
+			} else {
+				e = d.tables[0].buckets[idx]
+			}
+		} else {
+			e = d.tables[0].buckets[rand.Intn(len(d.tables[0].buckets))]
+		}
+	}
+	length := 0
+	for c := e; c != nil; c = c.next {
+		length++
+	}
+	for n := rand.Intn(length); n > 0; n-- {
+		e = e.next
+	}
+	return e.key, e.value, true
+}
+
+// RandomKeys returns @count random keys. Distinct keys are returned if @unique is set,
+// up to the number of keys in the dict, otherwise a key may be returned more than once.
+func (d *Dict[V]) RandomKeys(count int, unique bool) []string {
+	if d.Len() == 0 {
+		return nil
+	}
+	if unique {
+		count = min(count, d.Len())
+	}
+	// the count isn't bounded without @unique, the keys are appended as they are drawn
+	keys := make([]string, 0, min(count, d.Len()))
+	switch {
+	case !unique:
+		for len(keys) < count {
+			key, _, _ := d.RandomEntry()
+			keys = append(keys, key)
+		}
+	case count >= d.Len():
+		keys = d.Keys()
+	case count*3 > d.Len():
+		// for a large share of the keys shuffling all of them is cheaper than sampling
+		keys = d.Keys()
+		rand.Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+		keys = keys[:count]
+	default:
+		seen := make(map[string]bool, count)
+		for len(keys) < count {
+			if key, _, _ := d.RandomEntry(); !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// Scan calls fn for the entries of the bucket at cursor and returns the next cursor,
+// 0 once the iteration is complete. The cursor is incremented with its bits reversed,
+// which guarantees that the entries present for the whole iteration are returned at
+// least once even if the table is resized between the calls. Entries may be returned
+// more than once. The dict must not be modified by fn.
+func (d *Dict[V]) Scan(cursor uint64, fn func(key string, value V)) uint64 {
+	if d.Len() == 0 {
+		return 0
+	}
+	emit := func(e *dictEntry[V]) {
+		for ; e != nil; e = e.next {
+			fn(e.key, e.value)
+		}
+	}
+
+	if !d.isRehashing() {
+		t0 := &d.tables[0]
+		m0 := t0.mask()
+		emit(t0.buckets[cursor&m0])
+		// set the unmasked bits so that incrementing the reversed cursor
+		// operates on the masked bits only
+		cursor |= ^m0
+		return bits.Reverse64(bits.Reverse64(cursor) + 1)
+	}
+
+	t0, t1 := &d.tables[0], &d.tables[1]
+	if len(t0.buckets) > len(t1.buckets) {
+		t0, t1 = t1, t0
+	}
+	m0, m1 := t0.mask(), t1.mask()
+	emit(t0.buckets[cursor&m0])
+	// visit the buckets of the larger table which are expansions of the bucket
+	// of the smaller table
+	for {
+		emit(t1.buckets[cursor&m1])
+		cursor |= ^m1
+		cursor = bits.Reverse64(bits.Reverse64(cursor) + 1)
+		if cursor&(m0^m1) == 0 {
+			break
+		}
+	}
+	return cursor
+}
+
+func (d *Dict[V]) isRehashing() bool {
+	return d.rehashIdx >= 0
+}
+
+func (d *Dict[V]) find(key string) *dictEntry[V] {
+	if d.Len() == 0 {
+		return nil
+	}
+	d.rehashStep()
+	h := hashKey(key)
+	for t := 0; t <= 1; t++ {
+		table := &d.tables[t]
+		if table.used == 0 {
+			continue
+		}
+		for e := table.buckets[h&table.mask()]; e != nil; e = e.next {
+			if e.key == key {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// expandIfNeeded grows the table once it holds as many entries as buckets
+func (d *Dict[V]) expandIfNeeded() {
+	if d.isRehashing() {
+		return
+	}
+	if len(d.tables[0].buckets) == 0 {
+		d.resize(dictInitialSize)
+	} else if d.tables[0].used >= len(d.tables[0].buckets) {
+		d.resize(2 * d.tables[0].used)
+	}
+}
+
+// shrinkIfNeeded shrinks the table once less than 1/8 of the buckets are used
+func (d *Dict[V]) shrinkIfNeeded() {
+	size := len(d.tables[0].buckets)
+	if !d.isRehashing() && size > dictInitialSize && d.tables[0].used*8 < size {
+		d.resize(d.tables[0].used)
+	}
+}
+
+// resize allocates a table of the next power of two >= size and starts rehashing into it
+func (d *Dict[V]) resize(size int) {
+	size = max(size, dictInitialSize)
+	table := dictTable[V]{buckets: make([]*dictEntry[V], 1<<bits.Len(uint(size-1)))}
+	if len(d.tables[0].buckets) == 0 {
+		d.tables[0] = table
+		return
+	}
+	d.tables[1] = table
+	d.rehashIdx = 0
+}
+
+// rehashStep moves one bucket from tables[0] to tables[1], visiting at most
+// 10 empty buckets
+func (d *Dict[V]) rehashStep() {
+	if !d.isRehashing() {
+		return
+	}
+	t0, t1 := &d.tables[0], &d.tables[1]
+	for emptyVisits := 10; t0.used > 0 && t0.buckets[d.rehashIdx] == nil; d.rehashIdx++ {
+		if emptyVisits--; emptyVisits == 0 {
+			return
+		}
+	}
+	if t0.used > 0 {
+		for e := t0.buckets[d.rehashIdx]; e != nil; {
+			next := e.next
+			idx := hashKey(e.key) & t1.mask()
+			e.next = t1.buckets[idx]
+			t1.buckets[idx] = e
+			t0.used--
+			t1.used++
+			e = next
+		}
+		t0.buckets[d.rehashIdx] = nil
+		d.rehashIdx++
+	}
+	if t0.used == 0 {
+		d.tables[0], d.tables[1] = *t1, dictTable[V]{}
+		d.rehashIdx = -1
+	}
+}
+
+func (t *dictTable[V]) mask() uint64 {
+	return uint64(len(t.buckets) - 1)
+}
+
+func hashKey(key string) uint64 {
+	return maphash.String(dictSeed, key)
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestDictSetGetDelete(t *testing.T) {
+	d := NewDict[int]()
+	for i := 0; i < 1000; i++ {
+		if !d.Set(strconv.Itoa(i), i) {
+			t.Fatalf("key %d reported as existing", i)
+		}
+	}
+	if d.Set("7", 70) {
+		t.Errorf("existing key reported as added")
+	}
+	for i := 0; i < 1000; i += 2 {
+		if _, found := d.Delete(strconv.Itoa(i)); !found {
+			t.Fatalf("key %d not deleted", i)
+		}
+	}
+	if d.Len() != 500 {
+		t.Errorf("got %d keys, want 500", d.Len())
+	}
+	if value, _ := d.Get("7"); value != 70 {
+		t.Errorf("got %d, want 70", value)
+	}
+	if d.Contains("8") {
+		t.Errorf("deleted key still present")
+	}
+}
+
+// TestDictScanWhileResizing checks that the keys present during the whole scan
+// are returned even though the table grows and shrinks between the calls
+func TestDictScanWhileResizing(t *testing.T) {
+	d := NewDict[struct{}]()
+	for i := 0; i < 500; i++ {
+		d.Set("stable-"+strconv.Itoa(i), struct{}{})
+	}
+
+	seen := make(map[string]bool)
+	cursor, round := uint64(0), 0
+	for {
+		cursor = d.Scan(cursor, func(key string, _ struct{}) { seen[key] = true })
+		if cursor == 0 {
+			break
+		}
+		round++
+		// grow during the first half of the scan, shrink afterwards
+		for i := 0; i < 20; i++ {
+			if round < 50 {
+				d.Set("temp-"+strconv.Itoa(round*20+i), struct{}{})
+			} else {
+				d.Delete("temp-" + strconv.Itoa((round-50)*20+i))
+			}
+		}
+	}
+
+	for i := 0; i < 500; i++ {
+		if key := "stable-" + strconv.Itoa(i); !seen[key] {
+			t.Errorf("key %s was not returned by the scan", key)
+		}
+	}
+}
+
+func TestDictRandomKeysLargeCount(t *testing.T) {
+	d := NewDict[int]()
+	for i := 0; i < 10; i++ {
+		d.Set(strconv.Itoa(i), i)
+	}
+	// a unique count is bounded by the keys, a count allowing repeats is not
+	// preallocated beyond them
+	if keys := d.RandomKeys(99999999999999, true); len(keys) != 10 {
+		t.Errorf("unique: got %d keys, want 10", len(keys))
+	}
+	if keys := d.RandomKeys(1000, false); len(keys) != 1000 {
+		t.Errorf("repeats: got %d keys, want 1000", len(keys))
+	}
+}
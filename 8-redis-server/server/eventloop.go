@@ -0,0 +1,137 @@
+package server
+
+import "sync"
+
+// eventLoopQueue is the number of requests submitted to the event loop before the
+// connections wait for it
+const eventLoopQueue = 1024
+
+// request is a command submitted to the event loop by a connection, or a task of
+// another goroutine (see exclusive)
+type request struct {
+	cmd    *Cmd
+	spec   *commandSpec // nil for an unknown command
+	err    error        // the command is rejected with this error, e.g. its arity
+	reply  chan *RedisResponse
+	resume <-chan struct{} // set once the command is paused, closed when the pause ends
+	task   func()          // run instead of a command
+}
+
+// eventLoopState tells whether the event loop still runs, guarded by its own lock:
+// read to submit a request, written to stop the loop
+type eventLoopState struct {
+	mu      sync.RWMutex
+	stopped bool          // requestChan is closed, the commands run under the executor lock
+	done    chan struct{} // closed once the loop returned
+}
+
+// startEventLoop runs the commands on a single goroutine, see eventLoop
+func (re *RedisExecutorImpl) startEventLoop() {
+	re.requestChan = make(chan *request, eventLoopQueue)
+	re.loop.done = make(chan struct{})
+	go re.eventLoop()
+}
+
+// eventLoop runs the commands submitted by the connections one at a time, like the
+// event loop of Redis: the goroutines of the connections only parse the commands and
+// write the replies. The loop owns the datastore and doesn't take the executor lock,
+// the background tasks (cron, background saves, replication) are submitted to it.
+func (re *RedisExecutorImpl) eventLoop() {
+	defer close(re.loop.done)
+	for req := range re.requestChan {
+		if req.task != nil {
+			req.task()
+		} else {
+			re.serve(req)
+		}
+	}
+}
+
+// stopEventLoop stops the event loop once the requests already submitted ran, the
+// commands then run under the executor lock. It must not be called by the event loop.
+func (re *RedisExecutorImpl) stopEventLoop() {
+	if re.requestChan == nil {
+		return
+	}
+	re.loop.mu.Lock()
+	if !re.loop.stopped {
+		re.loop.stopped = true
+		close(re.requestChan)
+	}
+	re.loop.mu.Unlock()
+	<-re.loop.done
+}
+
+// send submits the request to the event loop, unless it doesn't run
+func (re *RedisExecutorImpl) send(req *request) bool {
+	if re.requestChan == nil {
+		return false
+	}
+	re.loop.mu.RLock()
+	defer re.loop.mu.RUnlock()
+	if re.loop.stopped {
+		return false
+	}
+	re.requestChan <- req
+	return true
+}
+
+// serve runs a command of the event loop and sends its reply. A paused command is
+// replied nil, it waits for the end of the pause out of the event loop.
+func (re *RedisExecutorImpl) serve(req *request) {
+	client := req.cmd.Client()
+	if req.err == nil && re.pausedFor(client, req.spec) {
+		client.Flush()
+		client.paused = true
+		req.resume = re.pauseResumed()
+		req.reply <- nil
+		return
+	}
+	if client != nil {
+		client.paused = false
+	}
+	req.reply <- re.run(req.spec, req.cmd, req.err)
+}
+
+// submit sends the command to the event loop and waits for its reply. A paused
+// command waits for the end of the pause, then is submitted again. It reports false
+// if the event loop doesn't run.
+func (re *RedisExecutorImpl) submit(req *request) (*RedisResponse, bool) {
+	if re.requestChan == nil {
+		return nil, false
+	}
+	req.reply = make(chan *RedisResponse, 1)
+	for re.send(req) {
+		if response := <-req.reply; response != nil {
+			return response, true
+		}
+		<-req.resume
+	}
+	return nil, false
+}
+
+// exclusive runs @f with the datastore to itself: on the event loop while it runs,
+// under the executor lock otherwise. The goroutines other than the connections (cron,
+// background saves, replication, timers) access the datastore through it, the commands
+// must not call it.
+func (re *RedisExecutorImpl) exclusive(f func()) {
+	done := make(chan struct{})
+	if re.send(&request{task: func() {
+		defer close(done)
+		f()
+	}}) {
+		<-done
+		return
+	}
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	f()
+}
+
+// executorMode is how the commands are run, for INFO
+func (re *RedisExecutorImpl) executorMode() string {
+	if re.requestChan != nil {
+		return "event-loop"
+	}
+	return "lock"
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newEventLoopExecutor returns a test executor running the commands on its event loop
+func newEventLoopExecutor() *RedisExecutorImpl {
+	re := newTestExecutor()
+	re.startEventLoop()
+	return re
+}
+
+func TestEventLoop(t *testing.T) {
+	re := newEventLoopExecutor()
+	runSteps(t, re, []testStep{
+		{cmd("FOO"), "-ERR unknown command 'foo', with args beginning with: \r\n"},
+		{cmd("GET"), "-ERR wrong number of arguments for 'get' command\r\n"},
+		{cmd("SET", "k", "v"), "+OK\r\n"},
+		{cmd("GET", "k"), "$1\r\nv\r\n"},
+	})
+
+	// the commands of concurrent clients run one at a time
+	const clients, incrs = 8, 200
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < incrs; j++ {
+				execute(re, "INCR", "counter")
+			}
+		}()
+	}
+	wg.Wait()
+	runSteps(t, re, []testStep{{cmd("GET", "counter"), "$4\r\n" + strconv.Itoa(clients*incrs) + "\r\n"}})
+
+	if info := execute(re, "INFO", "server"); !strings.Contains(info, "executor_mode:event-loop\r\n") {
+		t.Errorf("INFO: got %q", info)
+	}
+}
+
+func TestEventLoopBlocking(t *testing.T) {
+	re := newEventLoopExecutor()
+	c, _ := newTestClient(t, re, "10.0.0.1:1000")
+	popped := make(chan string)
+	go func() {
+		popped <- re.Execute(CreateCommandFromTokens(bulkTokens(cmd("BLPOP", "q", "0"))).SetClient(c)).Serialize()
+	}()
+	// a blocked client doesn't hold up the event loop
+	for {
+		var blocked bool
+		re.exclusive(func() { blocked = c.blocked != nil })
+		if blocked {
+			break
+		}
+		runSteps(t, re, []testStep{{cmd("PING"), "+PONG\r\n"}})
+		time.Sleep(time.Millisecond)
+	}
+	runSteps(t, re, []testStep{{cmd("RPUSH", "q", "a"), ":1\r\n"}})
+	if got := <-popped; got != "*2\r\n$1\r\nq\r\n$1\r\na\r\n" {
+		t.Errorf("BLPOP: got %q", got)
+	}
+}
+
+func TestEventLoopPause(t *testing.T) {
+	re := newEventLoopExecutor()
+	c, _ := newTestClient(t, re, "10.0.0.1:1000")
+	runSteps(t, re, []testStep{{cmd("CLIENT", "PAUSE", "10000", "WRITE"), "+OK\r\n"}})
+
+	// a paused write waits out of the event loop, the other commands go on
+	set := make(chan string)
+	go func() {
+		set <- re.Execute(CreateCommandFromTokens(bulkTokens(cmd("SET", "k", "v"))).SetClient(c)).Serialize()
+	}()
+	time.Sleep(20 * time.Millisecond)
+	runSteps(t, re, []testStep{
+		{cmd("EXISTS", "k"), ":0\r\n"},
+		{cmd("CLIENT", "UNPAUSE"), "+OK\r\n"},
+	})
+	if got := <-set; got != "+OK\r\n" {
+		t.Errorf("SET: got %q", got)
+	}
+	runSteps(t, re, []testStep{{cmd("GET", "k"), "$1\r\nv\r\n"}})
+}
+
+func TestEventLoopOwnsDatastore(t *testing.T) {
+	re := newEventLoopExecutor()
+	re.config.Dir = t.TempDir()
+	// the event loop doesn't take the executor lock: the commands, the timeouts of the
+	// blocked clients and the end of the background saves run while it is held
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	runSteps(t, re, []testStep{
+		{cmd("SET", "k", "v"), "+OK\r\n"},
+		{cmd("BLPOP", "q", "0.01"), "*-1\r\n"},
+		{cmd("BGSAVE"), "+Background saving started\r\n"},
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(execute(re, "INFO", "persistence"), "rdb_bgsave_in_progress:0\r\n") {
+		if time.Now().After(deadline) {
+			t.Fatal("the background save didn't complete")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestEventLoopClose(t *testing.T) {
+	re := newEventLoopExecutor()
+	re.config.Dir, re.config.SaveRules = t.TempDir(), nil
+	if err := re.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the event loop returned, the commands are rejected under the executor lock
+	select {
+	case <-re.loop.done:
+	default:
+		t.Error("the event loop still runs")
+	}
+	runSteps(t, re, []testStep{{cmd("GET", "k"), "-" + ErrShuttingDown.Error() + "\r\n"}})
+}
+
+// BenchmarkExecute compares the executor modes, the commands of concurrent clients
+// running under the executor lock or on the event loop
+func BenchmarkExecute(b *testing.B) {
+	for _, mode := range []struct {
+		name string
+		new  func() *RedisExecutorImpl
+	}{
+		{"lock", newTestExecutor},
+		{"event-loop", newEventLoopExecutor},
+	} {
+		for _, command := range []string{"SET", "GET"} {
+			b.Run(mode.name+"/"+command, func(b *testing.B) {
+				re := mode.new()
+				execute(re, "SET", "key:0", "value")
+				var clients atomic.Int64
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					key := "key:" + strconv.FormatInt(clients.Add(1)%8, 10)
+					args := bulkTokens([]string{command, key, "value"})
+					if command == "GET" {
+						args = args[:4]
+					}
+					for pb.Next() {
+						re.Execute(CreateCommandFromTokens(args))
+					}
+				})
+			})
+		}
+	}
+}
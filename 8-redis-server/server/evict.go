@@ -0,0 +1,319 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxmemory policies, what is evicted once the datastore uses more than maxmemory
+const (
+	PolicyNoEviction     = "noeviction"      // nothing, the commands which need memory fail
+	PolicyAllKeysLRU     = "allkeys-lru"     // the least recently used keys
+	PolicyVolatileLRU    = "volatile-lru"    // the least recently used keys with a time to live
+	PolicyAllKeysLFU     = "allkeys-lfu"     // the least frequently used keys
+	PolicyVolatileLFU    = "volatile-lfu"    // the least frequently used keys with a time to live
+	PolicyAllKeysRandom  = "allkeys-random"  // random keys
+	PolicyVolatileRandom = "volatile-random" // random keys with a time to live
+	PolicyVolatileTTL    = "volatile-ttl"    // the keys with the nearest expiry time
+)
+
+var maxmemoryPolicies = []string{
+	PolicyNoEviction, PolicyAllKeysLRU, PolicyVolatileLRU, PolicyAllKeysLFU,
+	PolicyVolatileLFU, PolicyAllKeysRandom, PolicyVolatileRandom, PolicyVolatileTTL,
+}
+
+const (
+	// evictionPoolSize is the number of best candidates kept between evictions
+	evictionPoolSize = 16
+	// lfuInitValue is the counter of a new key, so that it is not evicted right away
+	lfuInitValue = 5
+	// sizeSamples is the number of elements of a collection measured to estimate its size
+	sizeSamples = 5
+)
+
+var ErrOOM = errors.New("OOM command not allowed when used memory > 'maxmemory'.")
+
+/* ---------------- memory accounting ---------------- */
+
+// Estimated sizes in bytes of the structures holding the items and their elements,
+// modelled after the allocations of Redis on a 64-bit system
+const (
+	itemOverhead        = 56 // dict entry, key header and object header
+	expireOverhead      = 32 // entry in the dict of the keys with a time to live
+	stringOverhead      = 16
+	listNodeOverhead    = 16
+	dictNodeOverhead    = 32
+	zsetNodeOverhead    = 64 // skiplist node and dict entry
+	streamEntryOverhead = 24 // ID and header of an entry in a node
+)
+
+// itemSize estimates the memory used by an item. The size of a collection is
+// extrapolated from the size of its first @samples elements, all of them if 0.
+func itemSize(key string, item *CacheItem, samples int) int64 {
+	size := int64(itemOverhead + len(key))
+	if item.ExpireAt > 0 {
+		size += expireOverhead
+	}
+	var n, sampled, sampledSize int
+	measure := func(elementSize int) bool {
+		sampled++
+		sampledSize += elementSize
+		return samples == 0 || sampled < samples
+	}
+	switch item.Type {
+	case StringType:
+		return size + int64(stringOverhead+len(item.StringValue()))
+	case ListType:
+		list := item.ListValue()
+		n = list.Len()
+		for i := 0; i < n && measure(listNodeOverhead+stringOverhead+len(list.Index(i))); i++ {
+		}
+	case HashType:
+		hash := item.HashValue()
+		n = hash.Len()
+		hash.ForEach(func(field string, value string) bool {
+			return measure(dictNodeOverhead + 2*stringOverhead + len(field) + len(value))
+		})
+	case SetType:
+		set := item.SetValue()
+		n = set.Len()
+		set.ForEach(func(member string, _ struct{}) bool {
+			return measure(dictNodeOverhead + stringOverhead + len(member))
+		})
+	case ZSetType:
+		zset := item.ZSetValue()
+		n = zset.Len()
+		limit := n
+		if samples > 0 {
+			limit = min(samples, n)
+		}
+		for _, node := range zset.RangeByRank(0, limit-1, false) {
+			if !measure(zsetNodeOverhead + stringOverhead + len(node.member)) {
+				break
+			}
+		}
+	case StreamType:
+		stream := item.StreamValue()
+		n = stream.Len()
+	entries:
+		for _, node := range stream.nodes {
+			for _, entry := range node.entries {
+				entrySize := streamEntryOverhead
+				for _, field := range entry.fields {
+					entrySize += stringOverhead + len(field)
+				}
+				if !measure(entrySize) {
+					break entries
+				}
+			}
+		}
+	}
+	if sampled > 0 {
+		size += int64(float64(sampledSize) / float64(sampled) * float64(n))
+	}
+	return size
+}
+
+/* ---------------- access tracking ---------------- */
+
+// touch records an access to the item: its access time for the LRU policies, its
+// access counter for the LFU ones
+func (re *RedisExecutorImpl) touch(item *CacheItem) {
+	now := nowMs()
+	if re.lfuPolicy() {
+		item.lfu = re.lfuDecay(item, now)
+		item.lfu = lfuLogIncr(item.lfu, re.config.LFULogFactor)
+		item.lfuDecremented = now / 60000
+	}
+	item.accessed = now
+}
+
+func (re *RedisExecutorImpl) lfuPolicy() bool {
+	return re.config.MaxmemoryPolicy == PolicyAllKeysLFU || re.config.MaxmemoryPolicy == PolicyVolatileLFU
+}
+
+// lfuLogIncr increments the counter logarithmically: the more it was accessed, the
+// less likely it is incremented. It saturates at 255, after about a million accesses
+// with the default factor of 10.
+func lfuLogIncr(counter uint8, factor int) uint8 {
+	if counter == math.MaxUint8 {
+		return counter
+	}
+	base := float64(counter) - lfuInitValue
+	if base < 0 {
+		base = 0
+	}
+	if rand.Float64() < 1/(base*float64(factor)+1) {
+		counter++
+	}
+	return counter
+}
+
+// lfuDecay returns the counter of the item decremented once per lfu-decay-time
+// minutes elapsed since its last decrement, so that keys used once are evicted
+// eventually
+func (re *RedisExecutorImpl) lfuDecay(item *CacheItem, now int64) uint8 {
+	if re.config.LFUDecayTime == 0 {
+		return item.lfu
+	}
+	periods := (now/60000 - item.lfuDecremented) / int64(re.config.LFUDecayTime)
+	if periods >= int64(item.lfu) {
+		return 0
+	}
+	return item.lfu - uint8(periods)
+}
+
+/* ---------------- eviction ---------------- */
+
+// evictionCandidate is a sampled key, the greater @idle the better it is to evict
+type evictionCandidate struct {
+	dbKey
+	idle int64
+}
+
+// idleScore scores the item for the eviction with the policy
+func (re *RedisExecutorImpl) idleScore(item *CacheItem, now int64) int64 {
+	switch re.config.MaxmemoryPolicy {
+	case PolicyAllKeysLFU, PolicyVolatileLFU:
+		return math.MaxUint8 - int64(re.lfuDecay(item, now))
+	case PolicyVolatileTTL:
+		return math.MaxInt64 - item.ExpireAt
+	}
+	return now - item.accessed
+}
+
+// evictionState is the state of the eviction of keys once maxmemory is exceeded
+type evictionState struct {
+	pool        []evictionCandidate // best candidates sampled so far, by increasing idle score
+	nextDB      int                 // database the random policies evict from next
+	evictedKeys int64
+}
+
+// populateEvictionPool samples maxmemory-samples keys of each database and adds them
+// to the pool of candidates, which keeps the best ones
+func (re *RedisExecutorImpl) populateEvictionPool(volatile bool) {
+	now := nowMs()
+	pool := re.evict.pool
+	for id, db := range re.dbs {
+		db.Sample(re.config.MaxmemorySamples, volatile, func(key string, item *CacheItem) bool {
+			for _, candidate := range pool {
+				if candidate.dbKey == (dbKey{id, key}) {
+					return true
+				}
+			}
+			pool = append(pool, evictionCandidate{dbKey{id, key}, re.idleScore(item, now)})
+			return true
+		})
+	}
+	sort.SliceStable(pool, func(i, j int) bool { return pool[i].idle < pool[j].idle })
+	if len(pool) > evictionPoolSize {
+		pool = append(pool[:0], pool[len(pool)-evictionPoolSize:]...)
+	}
+	re.evict.pool = pool
+}
+
+// evictionKey returns the key to evict next with the policy, false if there is none.
+// Like Redis, the LRU, LFU and TTL policies evict the best candidate of a pool
+// refilled with a few random keys of each database each time, an approximation of
+// the exact policy. The random policies evict from each database in turn.
+func (re *RedisExecutorImpl) evictionKey() (dbKey, bool) {
+	policy := re.config.MaxmemoryPolicy
+	volatile := strings.HasPrefix(policy, "volatile-")
+	switch policy {
+	case PolicyNoEviction:
+		return dbKey{}, false
+	case PolicyAllKeysRandom, PolicyVolatileRandom:
+		for range re.dbs {
+			id := re.evict.nextDB
+			re.evict.nextDB = (id + 1) % len(re.dbs)
+			var evicted dbKey
+			found := false
+			re.dbs[id].Sample(1, volatile, func(key string, _ *CacheItem) bool {
+				evicted, found = dbKey{id, key}, true
+				return false
+			})
+			if found {
+				return evicted, true
+			}
+		}
+		return dbKey{}, false
+	}
+
+	re.populateEvictionPool(volatile)
+	for pool := re.evict.pool; len(pool) > 0; {
+		candidate := pool[len(pool)-1]
+		pool = pool[:len(pool)-1]
+		re.evict.pool = pool
+		// the candidate may have been deleted, or persisted, since it was sampled
+		item, found := re.dbs[candidate.db].Get(candidate.key)
+		if found && (!volatile || item.ExpireAt > 0) {
+			return candidate.dbKey, true
+		}
+	}
+	return dbKey{}, false
+}
+
+// freeMemory evicts keys with the maxmemory policy until the datastore uses less
+// than maxmemory. The evictions are propagated as DEL. It reports false if not
+// enough keys could be evicted.
+func (re *RedisExecutorImpl) freeMemory() bool {
+	evicted := false
+	selected := re.db
+	start := time.Now()
+	defer func() {
+		re.selectDB(selected)
+		if evicted {
+			re.latencyAddSample("eviction-cycle", time.Since(start))
+			re.flushAppendOnly()
+		}
+	}()
+	for re.usedMemory() > int64(re.config.Maxmemory) {
+		key, found := re.evictionKey()
+		if !found {
+			return false
+		}
+		re.selectDB(key.db)
+		_ = re.Remove(key.key)
+		re.signalModifiedKey(key.key)
+		re.notifyKeyspaceEvent(notifyEvicted, "evicted", key.key)
+		re.propagate("del", key.key)
+		re.evict.evictedKeys++
+		evicted = true
+	}
+	return true
+}
+
+// checkMemory evicts keys before a command runs if maxmemory is exceeded. The
+// commands which may need more memory fail if the policy couldn't free enough.
+// A replica leaves the eviction to its master, and the commands of the server
+// itself (clients without connection) are not limited. Nothing is evicted while
+// the clients are paused.
+func (re *RedisExecutorImpl) checkMemory(client *Client, spec *commandSpec) error {
+	if re.config.Maxmemory == 0 || re.repl.master != nil || client == nil || client.writer == nil || re.clientsPaused() {
+		return nil
+	}
+	if !re.freeMemory() && spec.is(flagDenyOOM) {
+		return ErrOOM
+	}
+	return nil
+}
+
+// applyMaxmemory follows the maxmemory option changed by CONFIG SET, evicting keys
+// right away if it was lowered
+func (re *RedisExecutorImpl) applyMaxmemory() {
+	if re.config.Maxmemory > 0 && re.repl.master == nil {
+		re.freeMemory()
+	}
+}
+
+// updateSizes measures the keys modified by the current command again
+func (re *RedisExecutorImpl) updateSizes() {
+	for _, key := range re.modifiedKeys {
+		re.dbs[key.db].UpdateSize(key.key)
+	}
+	re.modifiedKeys = re.modifiedKeys[:0]
+}
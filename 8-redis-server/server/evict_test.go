@@ -0,0 +1,147 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMaxmemoryNoEviction(t *testing.T) {
+	re := newTestExecutor()
+	c := NewClient(&bufferConn{})
+	runClientSteps(t, re, c, []testStep{
+		{cmd("MSET", "k1", "v1", "k2", "v2", "k3", "v3"), "+OK\r\n"},
+		{cmd("CONFIG", "SET", "maxmemory", "200"), "+OK\r\n"},
+		{cmd("SET", "k4", "v4"), "-" + ErrOOM.Error() + "\r\n"},
+		{cmd("GET", "k1"), "$2\r\nv1\r\n"},
+		{cmd("DEL", "k1"), ":1\r\n"},
+		{cmd("SET", "k4", "v4"), "+OK\r\n"},
+		{cmd("LPUSH", "list", "a"), "-" + ErrOOM.Error() + "\r\n"},
+	})
+	// the server itself is not limited
+	runSteps(t, re, []testStep{{cmd("LPUSH", "list", "a"), ":1\r\n"}})
+}
+
+func TestMaxmemoryAllKeysLRU(t *testing.T) {
+	re := newAppendOnlyExecutor(t, t.TempDir())
+	keys := []string{"k0", "k1", "k2", "k3", "k4", "k5", "k6", "k7", "k8", "k9"}
+	now := nowMs()
+	for i, key := range keys {
+		execute(re, "SET", key, "v")
+		item, _ := re.Get(key)
+		item.accessed = now - int64(len(keys)-i)*1000
+	}
+	c := NewClient(&bufferConn{})
+	runClientSteps(t, re, c, []testStep{
+		{cmd("CONFIG", "SET", "maxmemory-policy", "allkeys-lru", "maxmemory-samples", "64"), "+OK\r\n"},
+		// lowering maxmemory evicts right away
+		{cmd("CONFIG", "SET", "maxmemory", "600"), "+OK\r\n"},
+		{cmd("SET", "ka", "v"), "+OK\r\n"},
+		{cmd("GET", "k9"), "$1\r\nv\r\n"},
+		{cmd("EXISTS", "k0", "k1", "k2", "k3"), ":1\r\n"},
+	})
+	if re.UsedMemory() > 600 || re.evict.evictedKeys != 3 {
+		t.Errorf("used %d, evicted %d", re.UsedMemory(), re.evict.evictedKeys)
+	}
+
+	var deleted []string
+	for _, command := range readAppendOnly(t, re.config.AppendOnlyPath()) {
+		if strings.HasPrefix(command, "del ") {
+			deleted = append(deleted, command)
+		}
+	}
+	if want := []string{"del k0", "del k1", "del k2"}; strings.Join(deleted, ",") != strings.Join(want, ",") {
+		t.Errorf("got %q, want %q", deleted, want)
+	}
+}
+
+func TestMaxmemoryAllKeysLFU(t *testing.T) {
+	re := newTestExecutor()
+	re.config.MaxmemoryPolicy = PolicyAllKeysLFU
+	re.config.MaxmemorySamples = 64
+	for key, counter := range map[string]uint8{"a": 10, "b": 1, "c": 20} {
+		execute(re, "SET", key, "v")
+		item, _ := re.Get(key)
+		item.lfu = counter
+	}
+	runSteps(t, re, []testStep{
+		{cmd("CONFIG", "SET", "maxmemory", "150"), "+OK\r\n"},
+		{cmd("EXISTS", "a", "c"), ":2\r\n"},
+		{cmd("EXISTS", "b"), ":0\r\n"},
+	})
+}
+
+func TestMaxmemoryVolatile(t *testing.T) {
+	re := newTestExecutor()
+	c := NewClient(&bufferConn{})
+	runClientSteps(t, re, c, []testStep{
+		{cmd("MSET", "a", "v", "b", "v"), "+OK\r\n"},
+		{cmd("SET", "v1", "v", "EX", "100"), "+OK\r\n"},
+		{cmd("SET", "v2", "v", "EX", "100"), "+OK\r\n"},
+		{cmd("CONFIG", "SET", "maxmemory-policy", "volatile-lru", "maxmemory", "150"), "+OK\r\n"},
+		{cmd("EXISTS", "v1", "v2"), ":0\r\n"},
+		{cmd("SET", "c", "v"), "+OK\r\n"},
+		// only the keys with a time to live are evicted
+		{cmd("SET", "d", "v"), "-" + ErrOOM.Error() + "\r\n"},
+		{cmd("EXISTS", "a", "b", "c"), ":3\r\n"},
+	})
+
+	re = newTestExecutor()
+	runClientSteps(t, re, c, []testStep{
+		{cmd("SET", "t1", "v", "EX", "100"), "+OK\r\n"},
+		{cmd("SET", "t2", "v", "EX", "10"), "+OK\r\n"},
+		{cmd("SET", "t3", "v", "EX", "1000"), "+OK\r\n"},
+		{cmd("CONFIG", "SET", "maxmemory-policy", "volatile-ttl", "maxmemory", "250"), "+OK\r\n"},
+		{cmd("EXISTS", "t1", "t3"), ":2\r\n"},
+		{cmd("EXISTS", "t2"), ":0\r\n"},
+	})
+}
+
+func TestMemoryUsage(t *testing.T) {
+	re := newTestExecutor()
+	runSteps(t, re, []testStep{
+		{cmd("SET", "k", "v"), "+OK\r\n"},
+		{cmd("MEMORY", "USAGE", "k"), ":74\r\n"},
+		{cmd("RPUSH", "l", "x", "x", "x", "x", "x", "yyyyyyyyyy"), ":6\r\n"},
+		// extrapolated from the first 5 elements by default
+		{cmd("MEMORY", "USAGE", "l"), ":255\r\n"},
+		{cmd("MEMORY", "USAGE", "l", "SAMPLES", "0"), ":264\r\n"},
+		{cmd("MEMORY", "USAGE", "l", "SAMPLES", "-1"), "-" + ErrNotInteger.Error() + "\r\n"},
+		{cmd("MEMORY", "USAGE", "missing"), "$-1\r\n"},
+		{cmd("MEMORY", "DOCTOR"), "-ERR unknown subcommand 'DOCTOR'. Try MEMORY HELP.\r\n"},
+	})
+	if used := re.UsedMemory(); used != 74+255 {
+		t.Errorf("used %d, want %d", used, 74+255)
+	}
+	execute(re, "APPEND", "k", "vv")
+	execute(re, "DEL", "l")
+	if used := re.UsedMemory(); used != 76 {
+		t.Errorf("used %d, want %d", used, 76)
+	}
+}
+
+func TestObjectFreqIdletime(t *testing.T) {
+	re := newTestExecutor()
+	runSteps(t, re, []testStep{
+		{cmd("SET", "k", "v"), "+OK\r\n"},
+		{cmd("OBJECT", "FREQ", "k"), "-" + ErrNoLFUPolicy.Error() + "\r\n"},
+		{cmd("OBJECT", "IDLETIME", "k"), ":0\r\n"},
+		{cmd("OBJECT", "IDLETIME", "missing"), "$-1\r\n"},
+		{cmd("OBJECT", "ENCODING", "k"), "-ERR unknown subcommand 'ENCODING'. Try OBJECT HELP.\r\n"},
+	})
+	item, _ := re.Get("k")
+	item.accessed -= 10000
+	runSteps(t, re, []testStep{
+		{cmd("OBJECT", "IDLETIME", "k"), ":10\r\n"},
+		{cmd("GET", "k"), "$1\r\nv\r\n"},
+		{cmd("OBJECT", "IDLETIME", "k"), ":0\r\n"},
+		{cmd("CONFIG", "SET", "maxmemory-policy", "allkeys-lfu"), "+OK\r\n"},
+		{cmd("OBJECT", "IDLETIME", "k"), "-" + ErrLFUPolicy.Error() + "\r\n"},
+		{cmd("OBJECT", "FREQ", "k"), ":5\r\n"},
+		// a new counter is always incremented
+		{cmd("GET", "k"), "$1\r\nv\r\n"},
+		{cmd("OBJECT", "FREQ", "k"), ":6\r\n"},
+	})
+	// the counter decays by one each lfu-decay-time minutes
+	item.lfuDecremented -= 2
+	runSteps(t, re, []testStep{{cmd("OBJECT", "FREQ", "k"), ":4\r\n"}})
+}
@@ -3,84 +3,321 @@ package server
 import (
 	"fmt"
 	"go.uber.org/zap"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// activeExpireInterval is the period of the background expiry of keys
+	activeExpireInterval = 100 * time.Millisecond
+	// activeExpireSamples is the number of volatile keys checked per round
+	activeExpireSamples = 20
 )
 
 // RedisExecutor is the interface for executing commands on Redis server
 type RedisExecutor interface {
 	Execute(cmd *Cmd) *RedisResponse
+	// RegisterClient registers a client once it connected, it fails once maxclients
+	// clients are connected
+	RegisterClient(client *Client) error
+	// FreeClient releases the state held for a client once it disconnected
+	FreeClient(client *Client)
+	// ShutdownRequested is closed once a client ran SHUTDOWN
+	ShutdownRequested() <-chan struct{}
+	// Close persists the datastore before the server exits
+	Close() error
 }
 
-// RedisExecutorImpl executes the commands on Redis datastore
+// RedisExecutorImpl executes the commands on Redis datastore.
+// Commands are executed one at a time, on the event loop or guarded by @mu. The state
+// said to be guarded by the executor lock is owned by the event loop while it runs,
+// the other goroutines access it through exclusive.
 type RedisExecutorImpl struct {
 	*zap.Logger
-	RedisCacher
-	requestChan chan *Cmd
+	RedisCacher // the database selected by the running command, see selectDB
+	dbs         []RedisCacher
+	db          int           // index of the selected database
+	requestChan chan *request // commands submitted to the event loop, nil unless enabled
+	loop        eventLoopState
+	mu          sync.Mutex
+	config      *Config
+
+	blocked   map[dbKey][]*blockedClient // clients blocked on a key, in FIFO order
+	readyKeys []dbKey                    // keys pushed to by the current command
+	pubsub    *pubSub
+
+	watchedKeys map[dbKey]map[*Client]struct{} // clients watching a key (WATCH)
+	rdb         snapshotState
+	aof         aofState
+	repl        replicationState
+	acl         aclState
+	evict       evictionState
+	stats       statsState
+	clients     clientsState
+	shutdown    shutdownState
+	// shared holds the items whose value is shared with a background save (BGSAVE or
+	// BGREWRITEAOF). The value is copied before a command accesses it (copy-on-write),
+	// so that the save can read it without holding the executor lock.
+	shared map[*CacheItem]struct{}
+
+	// keys modified by the current command, whose size is measured again once it completes
+	modifiedKeys []dbKey
+
+	// propagation of the current command
+	propagation    [][]string // commands propagated in place of the current command
+	inExec         bool       // the commands of a transaction are running
+	execPropagated bool       // MULTI was propagated for the running transaction
 }
 
-func NewRedisExecutorImpl() *RedisExecutorImpl {
-	logger, _ := zap.NewProduction()
-	return &RedisExecutorImpl{
-		RedisCacher: GetCacherInstance(),
-		requestChan: make(chan *Cmd, 1000),
-		Logger:      logger,
+func NewRedisExecutorImpl(config *Config) *RedisExecutorImpl {
+	dbs := newDatabases(config.Databases)
+	re := &RedisExecutorImpl{
+		RedisCacher: dbs[0],
+		dbs:         dbs,
+		Logger:      newLogger(),
+		config:      config,
+		pubsub:      newPubSub(),
+		rdb:         snapshotState{lastSave: time.Now(), lastBgsaveOK: true},
+		repl:        newReplicationState(),
+		acl:         newACLState(config),
+		stats:       newStatsState(),
+		shutdown:    newShutdownState(),
+	}
+	for _, db := range dbs {
+		re.watchExpiry(db)
 	}
+	if config.EventLoop {
+		re.startEventLoop()
+	}
+	go re.cron()
+	return re
 }
 
-// Execute executes the command on Redis datastore
+// Execute executes the command on Redis datastore. The commands run one at a time:
+// under the executor lock, or on the goroutine of the event loop if it is enabled.
 func (re *RedisExecutorImpl) Execute(cmd *Cmd) *RedisResponse {
-	var argValue interface{}
-
-	response := &RedisResponse{
-		Error: fmt.Errorf("invalid command received"),
+	if cmd.IsInvalid() {
+		return ErrorResponse(ErrInvalidCommand)
+	}
+	var err error
+	spec, found := lookupCommand(cmd.Name())
+	if !found {
+		err = UnknownCommandError(cmd)
+	} else if !spec.acceptsArgs(len(cmd.Args())) {
+		err = WrongArgsError(spec.name)
 	}
 
-	defer func() {
-		re.Info("RESPONSE", zap.String("command", response.Serialize()))
-	}()
-	re.Info("executing command", zap.String("command", cmd.Name()))
+	response, queued := re.submit(&request{cmd: cmd, spec: spec, err: err})
+	if !queued {
+		response = re.runLocked(spec, cmd, err)
+	}
 
-	// TODO : Use enums for Cmd type instead
-	switch cmd.Name() {
+	if response.blocked != nil {
+		// the replies to the commands pipelined before are sent meanwhile, and the
+		// connection is watched so that the client stops waiting once it is closed
+		if client := cmd.Client(); client != nil {
+			client.Flush()
+			if client.watchClose != nil {
+				stop := client.watchClose()
+				defer stop()
+			}
+		}
+		response = re.waitBlocked(response.blocked)
+	}
+	return response
+}
 
-	case "get":
-		argValue = cmd.GetArg("key")
-		key := argValue.(string)
+// runLocked runs the command under the executor lock once the client isn't paused.
+// The lock is released by a defer, so that a panicking command doesn't leave it held.
+func (re *RedisExecutorImpl) runLocked(spec *commandSpec, cmd *Cmd, err error) *RedisResponse {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	if err == nil {
+		re.waitUnpaused(cmd.Client(), spec)
+	}
+	return re.run(spec, cmd, err)
+}
 
-		response = NotFoundResponse(key)
-		if value, found := re.Get(key); found {
-			response = &RedisResponse{Item: value}
+// run runs the command of a client, the executor lock being held. A blocked client
+// waits for its reply once the lock is released.
+func (re *RedisExecutorImpl) run(spec *commandSpec, cmd *Cmd, err error) *RedisResponse {
+	if re.shutdown.closed {
+		return ErrorResponse(ErrShuttingDown)
+	}
+	client := cmd.Client()
+	if client != nil {
+		client.lastInteraction = time.Now()
+		if spec != nil {
+			client.lastCmd = spec.name
 		}
+	}
+	response := re.dispatch(spec, cmd, err)
+	if bc := response.blocked; bc != nil && client != nil {
+		bc.client, client.blocked = client, bc
+	}
+	return response
+}
 
-	case "set":
-		symbol := cmd.GetArg("type").(byte)
-		respType, _ := GetRespType(symbol)
-		item := CacheItem{
-			Key:      cmd.GetArg("key").(string),
-			Value:    fmt.Sprintf("%v", cmd.GetArg("value")),
-			DataType: respType,
+// dispatch runs, queues or rejects the command of a client, the executor lock being held
+func (re *RedisExecutorImpl) dispatch(spec *commandSpec, cmd *Cmd, err error) *RedisResponse {
+	client := cmd.Client()
+	inMulti := client != nil && client.multi != nil
+	if err == nil {
+		err = re.checkAccess(client, spec, cmd)
+	}
+	if err == nil {
+		err = re.checkMemory(client, spec)
+	}
+	switch {
+	case err != nil:
+		// the transaction fails at EXEC if a command can't be queued
+		if inMulti {
+			client.multi.aborted = true
 		}
-
-		response = OKResponse()
-		if err := re.Set(item.GetKey(), item); err != nil {
-			response = ErrorResponse(err)
+		return re.rejectCommand(spec, err)
+	case client != nil && client.subscriptions() > 0 && !spec.is(flagPubSub):
+		return re.rejectCommand(spec, SubscriberModeError(cmd))
+	case re.repl.master != nil && re.config.ReplicaReadOnly && spec.is(flagWrite) && (client == nil || !client.master):
+		if inMulti {
+			client.multi.aborted = true
 		}
+		return re.rejectCommand(spec, ErrReadOnlyReplica)
+	case inMulti && spec.is(flagNoMulti):
+		client.multi.aborted = true
+		return re.rejectCommand(spec, ErrNotInMulti)
+	case inMulti && !spec.is(flagNoQueue):
+		return queueMultiCommand(client, spec, cmd)
+	}
+	re.selectDB(clientDB(client))
+	response := re.call(spec, cmd)
+	re.handleReadyKeys()
+	re.updateSizes()
+	re.flushAppendOnly()
+	return response
+}
 
-	case "ping":
-		response = &RedisResponse{
-			Item: CacheItem{
-				Key:   "ping",
-				Value: "PONG",
-			},
+// call runs the handler of the command and accounts it in the stats. A command which
+// modified the datastore is propagated to the append only file, unless its handler
+// rewrote it (rewriteCommand).
+func (re *RedisExecutorImpl) call(spec *commandSpec, cmd *Cmd) *RedisResponse {
+	dirty := re.rdb.dirty
+	start := time.Now()
+	response := spec.handler(re, cmd)
+	re.recordCommand(spec, cmd, response, time.Since(start))
+	if re.propagation == nil && re.rdb.dirty != dirty {
+		re.propagate(append([]string{cmd.Name()}, cmd.Args()...)...)
+	}
+	re.flushPropagation()
+	return response
+}
+
+// rewriteCommand propagates @argv in place of the current command, e.g. a relative
+// expiry is propagated as an absolute one so that replaying the command is idempotent.
+// It may be called several times to propagate several commands.
+func (re *RedisExecutorImpl) rewriteCommand(argv ...string) {
+	re.propagation = append(re.propagation, argv)
+}
+
+func (re *RedisExecutorImpl) flushPropagation() {
+	for _, argv := range re.propagation {
+		re.propagate(argv...)
+	}
+	re.propagation = nil
+}
+
+// propagate appends the command to the append only file and to the replication stream,
+// both following the selected database. The commands of a transaction are wrapped in
+// MULTI and EXEC, so that they are replayed atomically.
+func (re *RedisExecutorImpl) propagate(argv ...string) {
+	if re.aof.loading {
+		return
+	}
+	if re.inExec && !re.execPropagated {
+		re.execPropagated = true
+		re.propagate("multi")
+	}
+	re.feedAppendOnly(re.db, argv)
+	re.feedReplication(re.db, argv)
+}
+
+// executeLocal runs a command of the append only file or of the master, the executor
+// lock being held. The reply is discarded.
+func (re *RedisExecutorImpl) executeLocal(client *Client, argv []string) error {
+	cmd := NewCmd(strings.ToLower(argv[0])).SetArgs(argv[1:]...).SetClient(client)
+	spec, found := lookupCommand(cmd.Name())
+	if !found || !spec.acceptsArgs(len(cmd.Args())) {
+		return fmt.Errorf("unknown command '%s'", cmd.Name())
+	}
+	if response := re.dispatch(spec, cmd, nil); response.blocked != nil {
+		re.unblock(response.blocked)
+	}
+	return nil
+}
+
+func (re *RedisExecutorImpl) FreeClient(client *Client) {
+	re.exclusive(func() {
+		delete(re.clients.byID, client.id)
+		if client.blocked != nil {
+			re.unblock(client.blocked)
+		}
+		re.pubsub.unsubscribeAll(client)
+		re.unwatchAll(client)
+		if client.replica != nil {
+			re.removeReplica(client)
 		}
+	})
+}
 
-	case "echo":
-		response = &RedisResponse{
-			Item: CacheItem{
-				Key:   "echo",
-				Value: cmd.GetArg("value").(string),
-			},
+// signalModifiedKey is called by the commands modifying a key. The change is counted
+// for the save rules and the transactions of the clients watching the key fail.
+func (re *RedisExecutorImpl) signalModifiedKey(key string) {
+	re.rdb.dirty++
+	re.modifiedKeys = append(re.modifiedKeys, dbKey{re.db, key})
+	for c := range re.watchedKeys[dbKey{re.db, key}] {
+		c.dirtyCAS = true
+	}
+}
+
+// cron runs the periodic tasks of the executor: the active expiry of keys, the save
+// rules, the sync of the append only file, the sampling of the stats and the timeout
+// of the idle clients, until the executor is closed
+func (re *RedisExecutorImpl) cron() {
+	ticker := time.NewTicker(activeExpireInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		closed := false
+		re.exclusive(func() {
+			if closed = re.shutdown.closed; closed {
+				return
+			}
+			re.activeExpire()
+			re.checkSaveRules(now)
+			re.appendOnlyCron(now)
+			re.sampleOps(now)
+			re.trackPeakMemory()
+			re.closeIdleClients(now)
+		})
+		if closed {
+			return
 		}
 	}
+}
 
-	return response
+// activeExpire removes expired keys which are never accessed again. Like Redis,
+// a round is repeated while more than 25% of the sampled keys of a database expired.
+func (re *RedisExecutorImpl) activeExpire() {
+	if re.clientsPaused() {
+		return
+	}
+	start := time.Now()
+	defer func() { re.latencyAddSample("expire-cycle", time.Since(start)) }()
+	for _, db := range re.dbs {
+		for {
+			checked, expired := db.ExpireCycle(activeExpireSamples)
+			if checked == 0 || expired*4 <= checked {
+				break
+			}
+		}
+	}
 }
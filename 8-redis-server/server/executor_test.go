@@ -0,0 +1,98 @@
+package server
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// newTestExecutor returns an executor backed by an empty datastore
+func newTestExecutor() *RedisExecutorImpl {
+	dbs := newDatabases(16)
+	re := &RedisExecutorImpl{
+		RedisCacher: dbs[0],
+		dbs:         dbs,
+		Logger:      zap.NewNop(),
+		config:      DefaultConfig(),
+		pubsub:      newPubSub(),
+		rdb:         snapshotState{lastBgsaveOK: true},
+		repl:        newReplicationState(),
+		acl:         newACLState(DefaultConfig()),
+		stats:       newStatsState(),
+		shutdown:    newShutdownState(),
+	}
+	for _, db := range dbs {
+		re.watchExpiry(db)
+	}
+	return re
+}
+
+// execute runs a command given as a list of tokens and returns the serialized response
+func execute(re *RedisExecutorImpl, args ...string) string {
+	return re.Execute(CreateCommandFromTokens(bulkTokens(args))).Serialize()
+}
+
+func bulkTokens(args []string) []string {
+	var tokens []string
+	for _, arg := range args {
+		tokens = append(tokens, "$", arg)
+	}
+	return tokens
+}
+
+type testStep struct {
+	args []string
+	want string
+}
+
+func cmd(args ...string) []string { return args }
+
+func runSteps(t *testing.T, re *RedisExecutorImpl, steps []testStep) {
+	t.Helper()
+	for _, step := range steps {
+		if got := execute(re, step.args...); got != step.want {
+			t.Errorf("%v: got %q, want %q", step.args, got, step.want)
+		}
+	}
+}
+
+// runClientSteps runs the commands as sent by the client, checking their response
+func runClientSteps(t *testing.T, re *RedisExecutorImpl, c *Client, steps []testStep) {
+	t.Helper()
+	for _, step := range steps {
+		cmd := CreateCommandFromTokens(bulkTokens(step.args)).SetClient(c)
+		if got := re.Execute(cmd).Serialize(); got != step.want {
+			t.Errorf("%v: got %q, want %q", step.args, got, step.want)
+		}
+	}
+}
+
+func TestExecuteUnknownCommand(t *testing.T) {
+	runSteps(t, newTestExecutor(), []testStep{
+		{cmd("FOO", "x"), "-ERR unknown command 'foo', with args beginning with: 'x' \r\n"},
+		{cmd("GET"), "-ERR wrong number of arguments for 'get' command\r\n"},
+		{cmd("PING"), "+PONG\r\n"},
+		{cmd("echo", "hi"), "$2\r\nhi\r\n"},
+	})
+}
+
+func TestExecutePanicReleasesLock(t *testing.T) {
+	re := newTestExecutor()
+	spec := &commandSpec{name: "boom", arity: 1, handler: func(*RedisExecutorImpl, *Cmd) *RedisResponse {
+		panic("boom")
+	}}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("the command didn't panic")
+			}
+		}()
+		re.runLocked(spec, NewCmd("boom"), nil)
+	}()
+	// the lock was released while the panic unwound
+	if !re.mu.TryLock() {
+		t.Fatal("the executor lock is still held")
+	}
+	re.mu.Unlock()
+	runSteps(t, re, []testStep{{cmd("PING"), "+PONG\r\n"}})
+}
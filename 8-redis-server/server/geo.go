@@ -0,0 +1,358 @@
+package server
+
+import "math"
+
+// The geo sets of Redis are sorted sets whose scores are 52 bits geohashes: the
+// longitude and the latitude are each divided in 2^26 intervals and the bits of the
+// two interval indexes are interleaved, the latitude on the even bits. The latitudes
+// are limited to the ones of the Web Mercator projection.
+const (
+	geoStepMax = 26
+	geoLatMin  = -85.05112878
+	geoLatMax  = 85.05112878
+	geoLonMin  = -180
+	geoLonMax  = 180
+	// the radius of the Earth used by Redis for the haversine distance
+	earthRadius = 6372797.560856
+	// mercatorMax is half the circumference of the Earth on the Web Mercator projection
+	mercatorMax = 20037726.37
+)
+
+type geoRange struct {
+	min, max float64
+}
+
+var (
+	geoLonRange = geoRange{geoLonMin, geoLonMax}
+	geoLatRange = geoRange{geoLatMin, geoLatMax}
+)
+
+// geoHash is a geohash of @step bits for each coordinate
+type geoHash struct {
+	bits uint64
+	step uint
+}
+
+func (h geoHash) isZero() bool {
+	return h.bits == 0 && h.step == 0
+}
+
+// align52 returns the geohash as a 52 bits score
+func (h geoHash) align52() uint64 {
+	return h.bits << (52 - 2*h.step)
+}
+
+// geoArea is the cell of a geohash
+type geoArea struct {
+	hash     geoHash
+	lon, lat geoRange
+}
+
+// interleave64 interleaves the bits of x on the even bits and the ones of y on the
+// odd bits
+func interleave64(x, y uint32) uint64 {
+	masks := [...]uint64{0x5555555555555555, 0x3333333333333333, 0x0f0f0f0f0f0f0f0f, 0x00ff00ff00ff00ff, 0x0000ffff0000ffff}
+	shifts := [...]uint{1, 2, 4, 8, 16}
+	xx, yy := uint64(x), uint64(y)
+	for i := len(masks) - 1; i >= 0; i-- {
+		xx = (xx | xx<<shifts[i]) & masks[i]
+		yy = (yy | yy<<shifts[i]) & masks[i]
+	}
+	return xx | yy<<1
+}
+
+// deinterleave64 reverses interleave64, the even bits in the low 32 bits of the result
+// and the odd bits in the high ones
+func deinterleave64(interleaved uint64) uint64 {
+	masks := [...]uint64{0x5555555555555555, 0x3333333333333333, 0x0f0f0f0f0f0f0f0f, 0x00ff00ff00ff00ff, 0x0000ffff0000ffff, 0x00000000ffffffff}
+	shifts := [...]uint{0, 1, 2, 4, 8, 16}
+	x, y := interleaved, interleaved>>1
+	for i := range masks {
+		x = (x | x>>shifts[i]) & masks[i]
+		y = (y | y>>shifts[i]) & masks[i]
+	}
+	return x | y<<32
+}
+
+// validLonLat reports whether the coordinates can be indexed
+func validLonLat(lon, lat float64) bool {
+	return lon >= geoLonMin && lon <= geoLonMax && lat >= geoLatMin && lat <= geoLatMax
+}
+
+// geoEncode returns the geohash of the coordinates within the ranges, false if they
+// are out of them
+func geoEncode(lonRange, latRange geoRange, lon, lat float64, step uint) (geoHash, bool) {
+	if !validLonLat(lon, lat) || lon < lonRange.min || lon > lonRange.max || lat < latRange.min || lat > latRange.max {
+		return geoHash{}, false
+	}
+	latOffset := (lat - latRange.min) / (latRange.max - latRange.min) * float64(uint64(1)<<step)
+	lonOffset := (lon - lonRange.min) / (lonRange.max - lonRange.min) * float64(uint64(1)<<step)
+	return geoHash{bits: interleave64(uint32(latOffset), uint32(lonOffset)), step: step}, true
+}
+
+// geoDecode returns the cell of the geohash
+func geoDecode(lonRange, latRange geoRange, hash geoHash) geoArea {
+	separated := deinterleave64(hash.bits)
+	latIndex, lonIndex := float64(uint32(separated)), float64(uint32(separated>>32))
+	cells := float64(uint64(1) << hash.step)
+	latScale, lonScale := latRange.max-latRange.min, lonRange.max-lonRange.min
+	return geoArea{
+		hash: hash,
+		lat:  geoRange{latRange.min + latIndex/cells*latScale, latRange.min + (latIndex+1)/cells*latScale},
+		lon:  geoRange{lonRange.min + lonIndex/cells*lonScale, lonRange.min + (lonIndex+1)/cells*lonScale},
+	}
+}
+
+// center returns the coordinates of the center of the cell
+func (a geoArea) center() (float64, float64) {
+	lon := min(max((a.lon.min+a.lon.max)/2, geoLonMin), geoLonMax)
+	lat := min(max((a.lat.min+a.lat.max)/2, geoLatMin), geoLatMax)
+	return lon, lat
+}
+
+// geoScore returns the score of the coordinates in a geo set
+func geoScore(lon, lat float64) float64 {
+	hash, _ := geoEncode(geoLonRange, geoLatRange, lon, lat, geoStepMax)
+	return float64(hash.align52())
+}
+
+// geoScoreLonLat returns the coordinates of the center of the cell of a score
+func geoScoreLonLat(score float64) (float64, float64) {
+	hash := geoHash{bits: uint64(score), step: geoStepMax}
+	return geoDecode(geoLonRange, geoLatRange, hash).center()
+}
+
+const geoAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// geoHashString returns the standard 11 characters geohash of the coordinates, which
+// uses the latitudes from -90 to 90
+func geoHashString(lon, lat float64) string {
+	hash, _ := geoEncode(geoLonRange, geoRange{-90, 90}, lon, lat, geoStepMax)
+	buf := make([]byte, 11)
+	for i := range buf {
+		// the 11th character would need 55 bits, it is always 0
+		var index uint64
+		if i < 10 {
+			index = hash.bits >> (52 - (i+1)*5) & 0x1f
+		}
+		buf[i] = geoAlphabet[index]
+	}
+	return string(buf)
+}
+
+/* ---------------- distances ---------------- */
+
+func degToRad(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func radToDeg(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+// geoLatDistance returns the distance in meters between two latitudes on a meridian
+func geoLatDistance(lat1, lat2 float64) float64 {
+	return earthRadius * math.Abs(degToRad(lat2)-degToRad(lat1))
+}
+
+// geoDistance returns the haversine distance in meters between two points
+func geoDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	v := math.Sin((degToRad(lon2) - degToRad(lon1)) / 2)
+	if v == 0 {
+		return geoLatDistance(lat1, lat2)
+	}
+	lat1r, lat2r := degToRad(lat1), degToRad(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	a := u*u + math.Cos(lat1r)*math.Cos(lat2r)*v*v
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+/* ---------------- search ---------------- */
+
+// geoShape is the area searched around a center, a circle of @radius or a box of
+// @width and @height, the lengths being in the unit of @conversion meters
+type geoShape struct {
+	lon, lat      float64
+	box           bool
+	radius        float64
+	width, height float64
+	conversion    float64
+}
+
+// contains returns the distance in meters from the center to the point, false if the
+// point is out of the shape
+func (s *geoShape) contains(lon, lat float64) (float64, bool) {
+	if !s.box {
+		distance := geoDistance(s.lon, s.lat, lon, lat)
+		return distance, distance <= s.radius*s.conversion
+	}
+	// the latitude distance is the cheapest one
+	if geoLatDistance(lat, s.lat) > s.height*s.conversion/2 ||
+		geoDistance(lon, lat, s.lon, lat) > s.width*s.conversion/2 {
+		return 0, false
+	}
+	return geoDistance(s.lon, s.lat, lon, lat), true
+}
+
+// boundingBox returns the minimum and maximum longitudes and latitudes of the shape
+func (s *geoShape) boundingBox() (minLon, minLat, maxLon, maxLat float64) {
+	height, width := s.radius, s.radius
+	if s.box {
+		height, width = s.height/2, s.width/2
+	}
+	height *= s.conversion
+	width *= s.conversion
+	latDelta := radToDeg(height / earthRadius)
+	lonDeltaTop := radToDeg(width / earthRadius / math.Cos(degToRad(s.lat+latDelta)))
+	lonDeltaBottom := radToDeg(width / earthRadius / math.Cos(degToRad(s.lat-latDelta)))
+	// the longitudes span the most on the side closest to the pole
+	lonDelta := lonDeltaTop
+	if s.lat < 0 {
+		lonDelta = lonDeltaBottom
+	}
+	return s.lon - lonDelta, s.lat - latDelta, s.lon + lonDelta, s.lat + latDelta
+}
+
+// geoEstimateStep returns the precision of the geohash cells so that the cell of the
+// center and its neighbours cover a circle of the radius
+func geoEstimateStep(radius, lat float64) uint {
+	if radius == 0 {
+		return geoStepMax
+	}
+	step := 1
+	for ; radius < mercatorMax; radius *= 2 {
+		step++
+	}
+	step -= 2
+	// the cells are narrower towards the poles
+	if lat > 66 || lat < -66 {
+		step--
+		if lat > 80 || lat < -80 {
+			step--
+		}
+	}
+	return uint(min(max(step, 1), geoStepMax))
+}
+
+// moveX moves the geohash by @d cells along the longitudes
+func (h geoHash) moveX(d int) geoHash {
+	const odd, even = 0xaaaaaaaaaaaaaaaa, 0x5555555555555555
+	x, y := h.bits&odd, h.bits&even
+	zz := uint64(even) >> (64 - 2*h.step)
+	if d > 0 {
+		x += zz + 1
+	} else {
+		x = (x | zz) - (zz + 1)
+	}
+	x &= odd >> (64 - 2*h.step)
+	return geoHash{bits: x | y, step: h.step}
+}
+
+// moveY moves the geohash by @d cells along the latitudes
+func (h geoHash) moveY(d int) geoHash {
+	const odd, even = 0xaaaaaaaaaaaaaaaa, 0x5555555555555555
+	x, y := h.bits&odd, h.bits&even
+	zz := uint64(odd) >> (64 - 2*h.step)
+	if d > 0 {
+		y += zz + 1
+	} else {
+		y = (y | zz) - (zz + 1)
+	}
+	y &= even >> (64 - 2*h.step)
+	return geoHash{bits: x | y, step: h.step}
+}
+
+// searchAreas returns the geohash cells covering the shape: the cell of the center
+// then its north, south, east, west, north east, north west, south east and south west
+// neighbours, the zero geohash standing for the neighbours out of the shape
+func (s *geoShape) searchAreas() [9]geoHash {
+	minLon, minLat, maxLon, maxLat := s.boundingBox()
+	radius := s.radius
+	if s.box {
+		radius = math.Sqrt(s.width*s.width/4 + s.height*s.height/4)
+	}
+	step := geoEstimateStep(radius*s.conversion, s.lat)
+
+	cells := func(step uint) (geoArea, [9]geoHash) {
+		hash, _ := geoEncode(geoLonRange, geoLatRange, s.lon, s.lat, step)
+		north, south := hash.moveY(1), hash.moveY(-1)
+		return geoDecode(geoLonRange, geoLatRange, hash), [9]geoHash{
+			hash, north, south, hash.moveX(1), hash.moveX(-1),
+			north.moveX(1), north.moveX(-1), south.moveX(1), south.moveX(-1),
+		}
+	}
+	area, neighbours := cells(step)
+	// the neighbours may not reach the edges of the shape when the center is near
+	// the edge of its cell
+	north := geoDecode(geoLonRange, geoLatRange, neighbours[1])
+	south := geoDecode(geoLonRange, geoLatRange, neighbours[2])
+	east := geoDecode(geoLonRange, geoLatRange, neighbours[3])
+	west := geoDecode(geoLonRange, geoLatRange, neighbours[4])
+	if step > 1 && (north.lat.max < maxLat || south.lat.min > minLat || east.lon.max < maxLon || west.lon.min > minLon) {
+		step--
+		area, neighbours = cells(step)
+	}
+
+	// the neighbours beyond the edges of the shape are useless
+	if step >= 2 {
+		exclude := func(indexes ...int) {
+			for _, i := range indexes {
+				neighbours[i] = geoHash{}
+			}
+		}
+		if area.lat.min < minLat {
+			exclude(2, 7, 8)
+		}
+		if area.lat.max > maxLat {
+			exclude(1, 5, 6)
+		}
+		if area.lon.min < minLon {
+			exclude(4, 6, 8)
+		}
+		if area.lon.max > maxLon {
+			exclude(3, 5, 7)
+		}
+	}
+	return neighbours
+}
+
+// geoPoint is a member of a geo set found by a search
+type geoPoint struct {
+	member   string
+	score    float64
+	lon, lat float64
+	distance float64 // in meters
+}
+
+// Search returns the members of the geo set within the shape, in the order of the
+// cells covering it. The search stops once @limit members are found, unless it is 0.
+func (s *geoShape) Search(zs *ZSet, limit int) []geoPoint {
+	var points []geoPoint
+	areas := s.searchAreas()
+	last := 0
+	for i, hash := range areas {
+		if hash.isZero() {
+			continue
+		}
+		// with a huge radius, the neighbours can be the same cell
+		if last != 0 && hash == areas[last] {
+			continue
+		}
+		if limit > 0 && len(points) >= limit {
+			break
+		}
+		next := geoHash{bits: hash.bits + 1, step: hash.step}
+		r := &zrangeSpec{min: float64(hash.align52()), max: float64(next.align52()), maxex: true}
+		for _, node := range zs.RangeByScore(r, false, 0, -1) {
+			lon, lat := geoScoreLonLat(node.score)
+			if distance, ok := s.contains(lon, lat); ok {
+				points = append(points, geoPoint{member: node.member, score: node.score, lon: lon, lat: lat, distance: distance})
+				if limit > 0 && len(points) >= limit {
+					break
+				}
+			}
+		}
+		last = i
+	}
+	return points
+}
@@ -0,0 +1,405 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrGeoUnit         = errors.New("ERR unsupported unit provided. please use M, KM, FT, MI")
+	ErrGeoMember       = errors.New("ERR could not decode requested zset member")
+	ErrGeoRadius       = errors.New("ERR radius cannot be negative")
+	ErrGeoBox          = errors.New("ERR height or width cannot be negative")
+	ErrGeoCount        = errors.New("ERR COUNT must be > 0")
+	ErrGeoAnyCount     = errors.New("ERR the ANY argument requires COUNT argument")
+	ErrGeoStoreOptions = errors.New("ERR GEOSEARCHSTORE is not compatible with WITHDIST, WITHHASH and WITHCOORD options")
+)
+
+func init() {
+	registerCommands(
+		&commandSpec{name: "geoadd", arity: -5, handler: geoaddCommand, flags: flagWrite | flagDenyOOM, keys: firstKey, categories: catGeo},
+		&commandSpec{name: "geopos", arity: -2, handler: geoposCommand, keys: firstKey, categories: catGeo},
+		&commandSpec{name: "geodist", arity: -4, handler: geodistCommand, keys: firstKey, categories: catGeo},
+		&commandSpec{name: "geohash", arity: -2, handler: geohashCommand, keys: firstKey, categories: catGeo},
+		&commandSpec{name: "geosearch", arity: -7, handler: geosearchCommand, keys: firstKey, categories: catGeo},
+		&commandSpec{name: "geosearchstore", arity: -8, handler: geosearchCommand, flags: flagWrite | flagDenyOOM, keys: keySpec{0, 1, 1}, categories: catGeo},
+	)
+}
+
+/* ---------------- helpers ---------------- */
+
+// parseLonLat parses a longitude and a latitude which can be indexed
+func parseLonLat(lonArg, latArg string) (float64, float64, error) {
+	lon, ok := parseFloat(lonArg)
+	if !ok {
+		return 0, 0, ErrNotFloat
+	}
+	lat, ok := parseFloat(latArg)
+	if !ok {
+		return 0, 0, ErrNotFloat
+	}
+	if !validLonLat(lon, lat) {
+		return 0, 0, fmt.Errorf("ERR invalid longitude,latitude pair %f,%f", lon, lat)
+	}
+	return lon, lat, nil
+}
+
+// parseGeoUnit returns the meters in the unit
+func parseGeoUnit(unit string) (float64, error) {
+	switch strings.ToLower(unit) {
+	case "m":
+		return 1, nil
+	case "km":
+		return 1000, nil
+	case "ft":
+		return 0.3048, nil
+	case "mi":
+		return 1609.34, nil
+	}
+	return 0, ErrGeoUnit
+}
+
+// parseGeoLength parses a non-negative distance or returns @negative
+func parseGeoLength(s string, negative error) (float64, error) {
+	length, ok := parseFloat(s)
+	if !ok {
+		return 0, ErrNotFloat
+	}
+	if length < 0 {
+		return 0, negative
+	}
+	return length, nil
+}
+
+// formatGeoDistance formats a distance with 4 decimals
+func formatGeoDistance(distance float64) string {
+	return strconv.FormatFloat(distance, 'f', 4, 64)
+}
+
+// formatGeoCoord formats a coordinate with 17 decimals, without the trailing zeros
+func formatGeoCoord(coord float64) string {
+	formatted := strings.TrimRight(strconv.FormatFloat(coord, 'f', 17, 64), "0")
+	return strings.TrimSuffix(formatted, ".")
+}
+
+func geoCoordsResponse(lon, lat float64) *RedisResponse {
+	return ArrayResponse(BulkResponse(formatGeoCoord(lon)), BulkResponse(formatGeoCoord(lat)))
+}
+
+/* ---------------- commands ---------------- */
+
+// GEOADD key [NX | XX] [CH] longitude latitude member [longitude latitude member ...]
+func geoaddCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	var flags zaddFlags
+	var ch bool
+	args := cmd.Args()[1:]
+	for len(args) > 0 {
+		switch strings.ToLower(args[0]) {
+		case "nx":
+			flags.nx = true
+		case "xx":
+			flags.xx = true
+		case "ch":
+			ch = true
+		default:
+			goto elements
+		}
+		args = args[1:]
+	}
+
+elements:
+	if len(args) == 0 || len(args)%3 != 0 || (flags.nx && flags.xx) {
+		return ErrorResponse(ErrSyntax)
+	}
+	scores := make([]float64, len(args)/3)
+	for i := range scores {
+		lon, lat, err := parseLonLat(args[3*i], args[3*i+1])
+		if err != nil {
+			return ErrorResponse(err)
+		}
+		scores[i] = geoScore(lon, lat)
+	}
+
+	key := cmd.Arg(0)
+	zset, found, err := lookupZSet(re, key)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		if flags.xx {
+			return IntegerResponse(0)
+		}
+		zset = NewZSet()
+	}
+	var added, updated int64
+	for i, score := range scores {
+		result, _, err := zset.Add(score, args[3*i+2], flags)
+		if err != nil {
+			return ErrorResponse(err)
+		}
+		switch result {
+		case zaddAdded:
+			added++
+		case zaddUpdated:
+			updated++
+		}
+	}
+	if !found {
+		storeZSet(re, key, zset, "zadd")
+	} else if added+updated > 0 {
+		re.signalModifiedKey(key)
+		re.notifyKeyspaceEvent(notifyZSet, "zadd", key)
+	}
+	if ch {
+		return IntegerResponse(added + updated)
+	}
+	return IntegerResponse(added)
+}
+
+// GEOPOS key [member ...]
+func geoposCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	zset, found, err := lookupZSet(re, cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	items := make([]*RedisResponse, 0, len(cmd.Args())-1)
+	for _, member := range cmd.Args()[1:] {
+		var score float64
+		var ok bool
+		if found {
+			score, ok = zset.Score(member)
+		}
+		if !ok {
+			items = append(items, NullArrayResponse())
+			continue
+		}
+		items = append(items, geoCoordsResponse(geoScoreLonLat(score)))
+	}
+	return ArrayResponse(items...)
+}
+
+// GEODIST key member1 member2 [M | KM | FT | MI]
+func geodistCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	conversion := 1.0
+	switch {
+	case len(args) > 4:
+		return ErrorResponse(ErrSyntax)
+	case len(args) == 4:
+		var err error
+		if conversion, err = parseGeoUnit(args[3]); err != nil {
+			return ErrorResponse(err)
+		}
+	}
+	zset, found, err := lookupZSet(re, args[0])
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return NilResponse()
+	}
+	score1, found1 := zset.Score(args[1])
+	score2, found2 := zset.Score(args[2])
+	if !found1 || !found2 {
+		return NilResponse()
+	}
+	lon1, lat1 := geoScoreLonLat(score1)
+	lon2, lat2 := geoScoreLonLat(score2)
+	return BulkResponse(formatGeoDistance(geoDistance(lon1, lat1, lon2, lat2) / conversion))
+}
+
+// GEOHASH key [member ...]
+func geohashCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	zset, found, err := lookupZSet(re, cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	items := make([]*RedisResponse, 0, len(cmd.Args())-1)
+	for _, member := range cmd.Args()[1:] {
+		var score float64
+		var ok bool
+		if found {
+			score, ok = zset.Score(member)
+		}
+		if !ok {
+			items = append(items, NilResponse())
+			continue
+		}
+		items = append(items, BulkResponse(geoHashString(geoScoreLonLat(score))))
+	}
+	return ArrayResponse(items...)
+}
+
+// GEOSEARCH key FROMMEMBER member | FROMLONLAT longitude latitude
+// BYRADIUS radius unit | BYBOX width height unit [ASC | DESC] [COUNT count [ANY]]
+// [WITHCOORD] [WITHDIST] [WITHHASH]
+//
+// GEOSEARCHSTORE destination source ... [STOREDIST], storing the members found with
+// their geohash or their distance as score
+func geosearchCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	store := cmd.Name() == "geosearchstore"
+	var dest string
+	if store {
+		dest, args = args[0], args[1:]
+	}
+	zset, found, err := lookupZSet(re, args[0])
+	if err != nil {
+		return ErrorResponse(err)
+	}
+
+	var shape geoShape
+	var fromMember, fromLonLat, byRadius, byBox bool
+	var withDist, withHash, withCoord, storeDist, anyFound bool
+	var count int64
+	reverse, sorted := false, false
+	for i := 1; i < len(args); i++ {
+		remaining := len(args) - i - 1
+		switch option := strings.ToLower(args[i]); {
+		case option == "withdist":
+			withDist = true
+		case option == "withhash":
+			withHash = true
+		case option == "withcoord":
+			withCoord = true
+		case option == "storedist" && store:
+			storeDist = true
+		case option == "any":
+			anyFound = true
+		case option == "asc" || option == "desc":
+			sorted, reverse = true, option == "desc"
+		case option == "count" && remaining >= 1:
+			var ok bool
+			if count, ok = parseInt(args[i+1]); !ok {
+				return ErrorResponse(ErrNotInteger)
+			}
+			if count <= 0 {
+				return ErrorResponse(ErrGeoCount)
+			}
+			i++
+		case option == "frommember" && remaining >= 1:
+			if fromMember || fromLonLat {
+				return ErrorResponse(ErrSyntax)
+			}
+			var score float64
+			var ok bool
+			if found {
+				score, ok = zset.Score(args[i+1])
+			}
+			if !ok {
+				return ErrorResponse(ErrGeoMember)
+			}
+			shape.lon, shape.lat = geoScoreLonLat(score)
+			fromMember = true
+			i++
+		case option == "fromlonlat" && remaining >= 2:
+			if fromMember || fromLonLat {
+				return ErrorResponse(ErrSyntax)
+			}
+			if shape.lon, shape.lat, err = parseLonLat(args[i+1], args[i+2]); err != nil {
+				return ErrorResponse(err)
+			}
+			fromLonLat = true
+			i += 2
+		case option == "byradius" && remaining >= 2:
+			if byRadius || byBox {
+				return ErrorResponse(ErrSyntax)
+			}
+			if shape.radius, err = parseGeoLength(args[i+1], ErrGeoRadius); err != nil {
+				return ErrorResponse(err)
+			}
+			if shape.conversion, err = parseGeoUnit(args[i+2]); err != nil {
+				return ErrorResponse(err)
+			}
+			byRadius = true
+			i += 2
+		case option == "bybox" && remaining >= 3:
+			if byRadius || byBox {
+				return ErrorResponse(ErrSyntax)
+			}
+			if shape.width, err = parseGeoLength(args[i+1], ErrGeoBox); err != nil {
+				return ErrorResponse(err)
+			}
+			if shape.height, err = parseGeoLength(args[i+2], ErrGeoBox); err != nil {
+				return ErrorResponse(err)
+			}
+			if shape.conversion, err = parseGeoUnit(args[i+3]); err != nil {
+				return ErrorResponse(err)
+			}
+			shape.box, byBox = true, true
+			i += 3
+		default:
+			return ErrorResponse(ErrSyntax)
+		}
+	}
+	switch {
+	case store && (withDist || withHash || withCoord):
+		return ErrorResponse(ErrGeoStoreOptions)
+	case !fromMember && !fromLonLat:
+		return ErrorResponse(fmt.Errorf("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for %s", cmd.Name()))
+	case !byRadius && !byBox:
+		return ErrorResponse(fmt.Errorf("ERR exactly one of BYRADIUS and BYBOX can be specified for %s", cmd.Name()))
+	case anyFound && count == 0:
+		return ErrorResponse(ErrGeoAnyCount)
+	}
+
+	var points []geoPoint
+	if found {
+		// the closest members are returned unless any of them will do
+		if count > 0 && !sorted && !anyFound {
+			sorted = true
+		}
+		limit := 0
+		if anyFound {
+			limit = int(count)
+		}
+		points = shape.Search(zset, limit)
+	}
+	if sorted {
+		sort.SliceStable(points, func(i, j int) bool {
+			if reverse {
+				return points[i].distance > points[j].distance
+			}
+			return points[i].distance < points[j].distance
+		})
+	}
+	if count > 0 && int64(len(points)) > count {
+		points = points[:count]
+	}
+
+	if store {
+		result := NewZSet()
+		for _, point := range points {
+			score := point.score
+			if storeDist {
+				score = point.distance / shape.conversion
+			}
+			_, _, _ = result.Add(score, point.member, zaddFlags{})
+		}
+		storeZSet(re, dest, result, "geosearchstore")
+		return IntegerResponse(int64(len(points)))
+	}
+
+	items := make([]*RedisResponse, 0, len(points))
+	for _, point := range points {
+		if !withDist && !withHash && !withCoord {
+			items = append(items, BulkResponse(point.member))
+			continue
+		}
+		fields := []*RedisResponse{BulkResponse(point.member)}
+		if withDist {
+			fields = append(fields, BulkResponse(formatGeoDistance(point.distance/shape.conversion)))
+		}
+		if withHash {
+			fields = append(fields, IntegerResponse(int64(point.score)))
+		}
+		if withCoord {
+			fields = append(fields, geoCoordsResponse(point.lon, point.lat))
+		}
+		items = append(items, ArrayResponse(fields...))
+	}
+	return ArrayResponse(items...)
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// the examples of the Redis documentation
+func newSicily() *RedisExecutorImpl {
+	re := newTestExecutor()
+	execute(re, "GEOADD", "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania")
+	return re
+}
+
+func TestGeoCommands(t *testing.T) {
+	runSteps(t, newSicily(), []testStep{
+		{cmd("ZRANGE", "Sicily", "0", "-1", "WITHSCORES"), "*4\r\n$7\r\nPalermo\r\n$16\r\n3479099956230698\r\n$7\r\nCatania\r\n$16\r\n3479447370796909\r\n"},
+		{cmd("GEODIST", "Sicily", "Palermo", "Catania"), "$11\r\n166274.1516\r\n"},
+		{cmd("GEODIST", "Sicily", "Palermo", "Catania", "km"), "$8\r\n166.2742\r\n"},
+		{cmd("GEODIST", "Sicily", "Palermo", "Catania", "MI"), "$8\r\n103.3182\r\n"},
+		{cmd("GEODIST", "Sicily", "Palermo", "Catania", "yd"), "-ERR unsupported unit provided. please use M, KM, FT, MI\r\n"},
+		{cmd("GEODIST", "Sicily", "Palermo", "Catania", "km", "x"), "-ERR syntax error\r\n"},
+		{cmd("GEODIST", "Sicily", "Palermo", "Agrigento"), "$-1\r\n"},
+		{cmd("GEODIST", "missing", "Palermo", "Catania"), "$-1\r\n"},
+		{cmd("GEOPOS", "Sicily", "Palermo", "Catania", "Agrigento"), "*3\r\n" +
+			"*2\r\n$20\r\n13.36138933897018433\r\n$20\r\n38.11555639549629859\r\n" +
+			"*2\r\n$20\r\n15.08726745843887329\r\n$20\r\n37.50266842333162032\r\n*-1\r\n"},
+		{cmd("GEOPOS", "missing", "Palermo"), "*1\r\n*-1\r\n"},
+		{cmd("GEOHASH", "Sicily", "Palermo", "Catania", "Agrigento"), "*3\r\n$11\r\nsqc8b49rny0\r\n$11\r\nsqdtr74hyu0\r\n$-1\r\n"},
+
+		{cmd("GEOADD", "Sicily", "13.361389", "38.115556", "Palermo"), ":0\r\n"},
+		{cmd("GEOADD", "Sicily", "CH", "13.5", "38.1", "Palermo", "13.6", "38.1", "Agrigento"), ":2\r\n"},
+		{cmd("GEOADD", "Sicily", "XX", "13.583333", "37.316667", "Agrigento", "15.55", "38.183333", "Messina"), ":0\r\n"},
+		{cmd("GEOADD", "Sicily", "NX", "0", "0", "Agrigento", "15.55", "38.183333", "Messina"), ":1\r\n"},
+		{cmd("GEOHASH", "Sicily", "Agrigento", "Messina"), "*2\r\n$11\r\nsq9sm1716e0\r\n$11\r\nsqg11z54j30\r\n"},
+		{cmd("GEOADD", "Sicily", "NX", "XX", "0", "0", "Trapani"), "-ERR syntax error\r\n"},
+		{cmd("GEOADD", "Sicily", "0", "0", "Trapani", "0"), "-ERR syntax error\r\n"},
+		{cmd("GEOADD", "Sicily", "x", "0", "Trapani"), "-ERR value is not a valid float\r\n"},
+		{cmd("GEOADD", "Sicily", "12.5", "86", "Trapani"), "-ERR invalid longitude,latitude pair 12.500000,86.000000\r\n"},
+		{cmd("GEOADD", "Sicily", "-181", "0", "Trapani"), "-ERR invalid longitude,latitude pair -181.000000,0.000000\r\n"},
+		{cmd("SET", "s", "v"), "+OK\r\n"},
+		{cmd("GEOADD", "s", "0", "0", "a"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{cmd("GEOPOS", "s", "a"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	})
+}
+
+func TestGeoSearch(t *testing.T) {
+	re := newSicily()
+	execute(re, "GEOADD", "Sicily", "12.758489", "38.788135", "edge1", "17.241510", "38.788135", "edge2")
+	runSteps(t, re, []testStep{
+		// without an order, the members are in the order of the geohash cells
+		{cmd("GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "WITHDIST"),
+			"*2\r\n*2\r\n$7\r\nPalermo\r\n$8\r\n190.4424\r\n*2\r\n$7\r\nCatania\r\n$7\r\n56.4413\r\n"},
+		{cmd("GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC"),
+			"*2\r\n$7\r\nCatania\r\n$7\r\nPalermo\r\n"},
+		{cmd("GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "ASC", "WITHCOORD", "WITHDIST", "WITHHASH"), "*4\r\n" +
+			"*4\r\n$7\r\nCatania\r\n$7\r\n56.4413\r\n:3479447370796909\r\n*2\r\n$20\r\n15.08726745843887329\r\n$20\r\n37.50266842333162032\r\n" +
+			"*4\r\n$7\r\nPalermo\r\n$8\r\n190.4424\r\n:3479099956230698\r\n*2\r\n$20\r\n13.36138933897018433\r\n$20\r\n38.11555639549629859\r\n" +
+			"*4\r\n$5\r\nedge2\r\n$8\r\n279.7403\r\n:3481342659049484\r\n*2\r\n$20\r\n17.24151045083999634\r\n$20\r\n38.78813451624225195\r\n" +
+			"*4\r\n$5\r\nedge1\r\n$8\r\n279.7405\r\n:3479273021651468\r\n*2\r\n$19\r\n12.7584877610206604\r\n$20\r\n38.78813451624225195\r\n"},
+		{cmd("GEOSEARCH", "Sicily", "FROMMEMBER", "Palermo", "BYRADIUS", "500", "km", "DESC", "COUNT", "2"),
+			"*2\r\n$5\r\nedge2\r\n$7\r\nCatania\r\n"},
+		// COUNT returns the closest members unless ANY is given
+		{cmd("GEOSEARCH", "Sicily", "FROMMEMBER", "Palermo", "BYRADIUS", "500", "km", "COUNT", "2"),
+			"*2\r\n$7\r\nPalermo\r\n$5\r\nedge1\r\n"},
+		{cmd("GEOSEARCH", "Sicily", "FROMMEMBER", "Palermo", "BYRADIUS", "500", "km", "COUNT", "1", "ANY"),
+			"*1\r\n$7\r\nPalermo\r\n"},
+		{cmd("GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYBOX", "100", "100", "mi", "WITHDIST"),
+			"*1\r\n*2\r\n$7\r\nCatania\r\n$7\r\n35.0711\r\n"},
+		{cmd("GEOSEARCH", "Sicily", "FROMLONLAT", "0", "0", "BYRADIUS", "10", "m"), "*0\r\n"},
+		{cmd("GEOSEARCH", "missing", "FROMLONLAT", "0", "0", "BYRADIUS", "10", "m"), "*0\r\n"},
+
+		{cmd("GEOSEARCH", "Sicily", "BYRADIUS", "10", "m", "ASC", "WITHDIST"), "-ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for geosearch\r\n"},
+		{cmd("GEOSEARCH", "Sicily", "FROMLONLAT", "0", "0", "FROMMEMBER", "Palermo"), "-ERR syntax error\r\n"},
+		{cmd("GEOSEARCH", "Sicily", "FROMLONLAT", "0", "0", "ASC", "WITHDIST"), "-ERR exactly one of BYRADIUS and BYBOX can be specified for geosearch\r\n"},
+		{cmd("GEOSEARCH", "Sicily", "FROMMEMBER", "Agrigento", "BYRADIUS", "10", "m"), "-ERR could not decode requested zset member\r\n"},
+		{cmd("GEOSEARCH", "Sicily", "FROMLONLAT", "0", "0", "BYRADIUS", "-1", "m"), "-ERR radius cannot be negative\r\n"},
+		{cmd("GEOSEARCH", "Sicily", "FROMLONLAT", "0", "0", "BYBOX", "1", "-1", "m"), "-ERR height or width cannot be negative\r\n"},
+		{cmd("GEOSEARCH", "Sicily", "FROMLONLAT", "0", "0", "BYRADIUS", "1", "yd"), "-ERR unsupported unit provided. please use M, KM, FT, MI\r\n"},
+		{cmd("GEOSEARCH", "Sicily", "FROMLONLAT", "0", "0", "BYRADIUS", "1", "m", "COUNT", "0"), "-ERR COUNT must be > 0\r\n"},
+		{cmd("GEOSEARCH", "Sicily", "FROMLONLAT", "0", "0", "BYRADIUS", "1", "m", "ANY"), "-ERR the ANY argument requires COUNT argument\r\n"},
+		{cmd("GEOSEARCH", "Sicily", "FROMLONLAT", "0", "0", "BYRADIUS", "1", "m", "STOREDIST"), "-ERR syntax error\r\n"},
+	})
+}
+
+func TestGeoSearchStore(t *testing.T) {
+	re := newSicily()
+	execute(re, "GEOADD", "Sicily", "12.758489", "38.788135", "edge1", "17.241510", "38.788135", "edge2")
+	runSteps(t, re, []testStep{
+		{cmd("GEOSEARCHSTORE", "dest", "Sicily", "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "ASC", "COUNT", "3"), ":3\r\n"},
+		{cmd("ZRANGE", "dest", "0", "-1", "WITHSCORES"), "*6\r\n$7\r\nPalermo\r\n$16\r\n3479099956230698\r\n" +
+			"$7\r\nCatania\r\n$16\r\n3479447370796909\r\n$5\r\nedge2\r\n$16\r\n3481342659049484\r\n"},
+		{cmd("GEOSEARCHSTORE", "dest", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "STOREDIST"), ":2\r\n"},
+		{cmd("ZRANGE", "dest", "0", "-1"), "*2\r\n$7\r\nCatania\r\n$7\r\nPalermo\r\n"},
+		{cmd("GEOSEARCHSTORE", "dest", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "WITHDIST"),
+			"-ERR GEOSEARCHSTORE is not compatible with WITHDIST, WITHHASH and WITHCOORD options\r\n"},
+	})
+	// the distances in the unit of the search, like Redis within the floating point error
+	for member, want := range map[string]float64{"Catania": 56.441257870158204, "Palermo": 190.44242984775784} {
+		got, err := strconv.ParseFloat(strings.Split(execute(re, "ZSCORE", "dest", member), "\r\n")[1], 64)
+		if err != nil || math.Abs(got-want) > 1e-9 {
+			t.Errorf("ZSCORE %s: got %v, want %v", member, got, want)
+		}
+	}
+	// an empty result deletes the destination
+	runSteps(t, re, []testStep{
+		{cmd("GEOSEARCHSTORE", "dest", "Sicily", "FROMLONLAT", "0", "0", "BYRADIUS", "10", "m"), ":0\r\n"},
+		{cmd("EXISTS", "dest"), ":0\r\n"},
+		{cmd("GEOSEARCHSTORE", "dest", "missing", "FROMLONLAT", "0", "0", "BYRADIUS", "10", "m"), ":0\r\n"},
+	})
+}
+
+// TestGeoSearchAreas checks that the geohash cells searched cover the whole shape
+func TestGeoSearchAreas(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	zs := NewZSet()
+	type point struct{ lon, lat float64 }
+	points := make(map[string]point)
+	for i := 0; i < 2000; i++ {
+		// around the poles, the antimeridian and the equator too
+		lon, lat := rng.Float64()*360-180, rng.Float64()*170-85
+		member := strconv.Itoa(i)
+		_, _, _ = zs.Add(geoScore(lon, lat), member, zaddFlags{})
+		// the members are at the center of their cell
+		score, _ := zs.Score(member)
+		lon, lat = geoScoreLonLat(score)
+		points[member] = point{lon, lat}
+	}
+	for i := 0; i < 200; i++ {
+		shape := geoShape{lon: rng.Float64()*360 - 180, lat: rng.Float64()*170 - 85, conversion: 1000}
+		if i%2 == 0 {
+			shape.radius = math.Pow(10, rng.Float64()*4)
+		} else {
+			shape.box = true
+			shape.width, shape.height = math.Pow(10, rng.Float64()*4), math.Pow(10, rng.Float64()*4)
+		}
+		found := make(map[string]bool)
+		for _, p := range shape.Search(zs, 0) {
+			found[p.member] = true
+		}
+		for member, p := range points {
+			if _, inside := shape.contains(p.lon, p.lat); inside != found[member] {
+				t.Errorf("%+v: member %s at %v,%v found %v", shape, member, p.lon, p.lat, found[member])
+			}
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package server
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	registerCommands(
+		&commandSpec{name: "hset", arity: -4, handler: hsetCommand, flags: flagWrite | flagDenyOOM, keys: firstKey, categories: catHash},
+		&commandSpec{name: "hmset", arity: -4, handler: hsetCommand, flags: flagWrite | flagDenyOOM, keys: firstKey, categories: catHash},
+		&commandSpec{name: "hsetnx", arity: 4, handler: hsetnxCommand, flags: flagWrite | flagDenyOOM, keys: firstKey, categories: catHash},
+		&commandSpec{name: "hget", arity: 3, handler: hgetCommand, keys: firstKey, categories: catHash},
+		&commandSpec{name: "hmget", arity: -3, handler: hmgetCommand, keys: firstKey, categories: catHash},
+		&commandSpec{name: "hdel", arity: -3, handler: hdelCommand, flags: flagWrite, keys: firstKey, categories: catHash},
+		&commandSpec{name: "hexists", arity: 3, handler: hexistsCommand, keys: firstKey, categories: catHash},
+		&commandSpec{name: "hlen", arity: 2, handler: hlenCommand, keys: firstKey, categories: catHash},
+		&commandSpec{name: "hkeys", arity: 2, handler: hgetallCommand, keys: firstKey, categories: catHash},
+		&commandSpec{name: "hvals", arity: 2, handler: hgetallCommand, keys: firstKey, categories: catHash},
+		&commandSpec{name: "hgetall", arity: 2, handler: hgetallCommand, keys: firstKey, categories: catHash},
+		&commandSpec{name: "hincrby", arity: 4, handler: hincrbyCommand, flags: flagWrite | flagDenyOOM, keys: firstKey, categories: catHash},
+		&commandSpec{name: "hincrbyfloat", arity: 4, handler: hincrbyfloatCommand, flags: flagWrite | flagDenyOOM, keys: firstKey, categories: catHash},
+		&commandSpec{name: "hrandfield", arity: -2, handler: hrandfieldCommand, keys: firstKey, categories: catHash},
+		&commandSpec{name: "hscan", arity: -3, handler: hscanCommand, keys: firstKey, categories: catHash},
+	)
+}
+
+/* ---------------- helpers ---------------- */
+
+// lookupHash returns the hash stored at key
+func lookupHash(re *RedisExecutorImpl, key string) (*Dict[string], bool, error) {
+	item, found, err := lookupTyped(re, key, HashType)
+	if !found || err != nil {
+		return nil, found, err
+	}
+	return item.HashValue(), true, nil
+}
+
+// lookupOrCreateHash returns the hash stored at key, creating an empty one if needed
+func lookupOrCreateHash(re *RedisExecutorImpl, key string) (*Dict[string], error) {
+	hash, found, err := lookupHash(re, key)
+	if err != nil || found {
+		return hash, err
+	}
+	hash = NewDict[string]()
+	_ = re.Set(key, &CacheItem{Key: key, Value: hash, Type: HashType})
+	return hash, nil
+}
+
+/* ---------------- commands ---------------- */
+
+// HSET key field value [field value ...], also HMSET
+func hsetCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	if len(args)%2 == 0 {
+		return ErrorResponse(WrongArgsError(cmd.Name()))
+	}
+	hash, err := lookupOrCreateHash(re, args[0])
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	var added int64
+	for i := 1; i < len(args); i += 2 {
+		if hash.Set(args[i], args[i+1]) {
+			added++
+		}
+	}
+	re.signalModifiedKey(args[0])
+	re.notifyKeyspaceEvent(notifyHash, "hset", args[0])
+	if cmd.Name() == "hmset" {
+		return OKResponse()
+	}
+	return IntegerResponse(added)
+}
+
+// HSETNX key field value
+func hsetnxCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	hash, err := lookupOrCreateHash(re, cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if hash.Contains(cmd.Arg(1)) {
+		return IntegerResponse(0)
+	}
+	hash.Set(cmd.Arg(1), cmd.Arg(2))
+	re.signalModifiedKey(cmd.Arg(0))
+	re.notifyKeyspaceEvent(notifyHash, "hset", cmd.Arg(0))
+	return IntegerResponse(1)
+}
+
+// HGET key field
+func hgetCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	hash, found, err := lookupHash(re, cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return NilResponse()
+	}
+	if value, found := hash.Get(cmd.Arg(1)); found {
+		return BulkResponse(value)
+	}
+	return NilResponse()
+}
+
+// HMGET key field [field ...]
+func hmgetCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	hash, found, err := lookupHash(re, cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	fields := cmd.Args()[1:]
+	items := make([]*RedisResponse, len(fields))
+	for i, field := range fields {
+		items[i] = NilResponse()
+		if !found {
+			continue
+		}
+		if value, exists := hash.Get(field); exists {
+			items[i] = BulkResponse(value)
+		}
+	}
+	return ArrayResponse(items...)
+}
+
+// HDEL key field [field ...]
+func hdelCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	key := cmd.Arg(0)
+	hash, found, err := lookupHash(re, key)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return IntegerResponse(0)
+	}
+	var deleted int64
+	for _, field := range cmd.Args()[1:] {
+		if _, found := hash.Delete(field); found {
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		re.notifyKeyspaceEvent(notifyHash, "hdel", key)
+		if hash.Len() == 0 {
+			_ = re.Remove(key)
+			re.notifyKeyspaceEvent(notifyGeneric, "del", key)
+		}
+		re.signalModifiedKey(key)
+	}
+	return IntegerResponse(deleted)
+}
+
+// HEXISTS key field
+func hexistsCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	hash, found, err := lookupHash(re, cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if found && hash.Contains(cmd.Arg(1)) {
+		return IntegerResponse(1)
+	}
+	return IntegerResponse(0)
+}
+
+// HLEN key
+func hlenCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	hash, found, err := lookupHash(re, cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return IntegerResponse(0)
+	}
+	return IntegerResponse(int64(hash.Len()))
+}
+
+// HGETALL key, also HKEYS and HVALS
+func hgetallCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	hash, found, err := lookupHash(re, cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return ArrayResponse()
+	}
+	withFields, withValues := cmd.Name() != "hvals", cmd.Name() != "hkeys"
+	var values []string
+	hash.ForEach(func(field, value string) bool {
+		if withFields {
+			values = append(values, field)
+		}
+		if withValues {
+			values = append(values, value)
+		}
+		return true
+	})
+	return BulkArrayResponse(values)
+}
+
+// HINCRBY key field increment
+func hincrbyCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	by, ok := parseInt(cmd.Arg(2))
+	if !ok {
+		return ErrorResponse(ErrNotInteger)
+	}
+	hash, err := lookupOrCreateHash(re, cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	var value int64
+	if current, found := hash.Get(cmd.Arg(1)); found {
+		if value, ok = parseInt(current); !ok {
+			return ErrorResponse(ErrHashNotInteger)
+		}
+	}
+	if (by < 0 && value < 0 && by < math.MinInt64-value) ||
+		(by > 0 && value > 0 && by > math.MaxInt64-value) {
+		return ErrorResponse(ErrOverflow)
+	}
+	value += by
+	hash.Set(cmd.Arg(1), strconv.FormatInt(value, 10))
+	re.signalModifiedKey(cmd.Arg(0))
+	re.notifyKeyspaceEvent(notifyHash, "hincrby", cmd.Arg(0))
+	return IntegerResponse(value)
+}
+
+// HINCRBYFLOAT key field increment
+func hincrbyfloatCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	by, ok := parseFloat(cmd.Arg(2))
+	if !ok {
+		return ErrorResponse(ErrNotFloat)
+	}
+	hash, err := lookupOrCreateHash(re, cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	var value float64
+	if current, found := hash.Get(cmd.Arg(1)); found {
+		if value, ok = parseFloat(current); !ok {
+			return ErrorResponse(ErrHashNotFloat)
+		}
+	}
+	value += by
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return ErrorResponse(ErrIncrNaN)
+	}
+	result := formatFloat(value)
+	hash.Set(cmd.Arg(1), result)
+	re.signalModifiedKey(cmd.Arg(0))
+	re.notifyKeyspaceEvent(notifyHash, "hincrbyfloat", cmd.Arg(0))
+	// propagate the result, the float arithmetic might differ when replayed
+	re.rewriteCommand("hset", cmd.Arg(0), cmd.Arg(1), result)
+	return BulkResponse(result)
+}
+
+// HRANDFIELD key [count [WITHVALUES]]. A negative count allows the same field
+// to be returned multiple times.
+func hrandfieldCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	if len(args) > 3 || (len(args) == 3 && strings.ToLower(args[2]) != "withvalues") {
+		return ErrorResponse(ErrSyntax)
+	}
+	hash, found, err := lookupHash(re, args[0])
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if len(args) == 1 {
+		if !found {
+			return NilResponse()
+		}
+		field, _, _ := hash.RandomEntry()
+		return BulkResponse(field)
+	}
+
+	count, ok := parseInt(args[1])
+	if !ok {
+		return ErrorResponse(ErrNotInteger)
+	}
+	if count == math.MinInt64 {
+		// its opposite doesn't fit
+		return ErrorResponse(ErrValueRange)
+	}
+	if !found || count == 0 {
+		return ArrayResponse()
+	}
+	unique := count > 0
+	if !unique {
+		count = -count
+	}
+	var values []string
+	for _, field := range hash.RandomKeys(int(count), unique) {
+		values = append(values, field)
+		if len(args) == 3 {
+			value, _ := hash.Get(field)
+			values = append(values, value)
+		}
+	}
+	return BulkArrayResponse(values)
+}
+
+// HSCAN key cursor [MATCH pattern] [COUNT count] [NOVALUES]
+func hscanCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	opts, err := parseScanArgs(cmd.Args()[1:], true, false)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	hash, found, err := lookupHash(re, cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return scanResponse(0, nil)
+	}
+	var values []string
+	cursor := scanDict(hash, opts, func(field, value string) {
+		values = append(values, field)
+		if !opts.noValues {
+			values = append(values, value)
+		}
+	})
+	return scanResponse(cursor, values)
+}
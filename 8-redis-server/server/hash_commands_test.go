@@ -0,0 +1,65 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHashCommands(t *testing.T) {
+	runSteps(t, newTestExecutor(), []testStep{
+		{cmd("HSET", "h", "name", "ada", "age", "36"), ":2\r\n"},
+		{cmd("HSET", "h", "age", "37"), ":0\r\n"},
+		{cmd("HSET", "h", "odd"), "-ERR wrong number of arguments for 'hset' command\r\n"},
+		{cmd("HGET", "h", "age"), "$2\r\n37\r\n"},
+		{cmd("HMGET", "h", "name", "missing"), "*2\r\n$3\r\nada\r\n$-1\r\n"},
+		{cmd("HSETNX", "h", "name", "bob"), ":0\r\n"},
+		{cmd("HEXISTS", "h", "name"), ":1\r\n"},
+		{cmd("HLEN", "h"), ":2\r\n"},
+		{cmd("HINCRBY", "h", "age", "3"), ":40\r\n"},
+		{cmd("HINCRBY", "h", "name", "1"), "-ERR hash value is not an integer\r\n"},
+		{cmd("HINCRBYFLOAT", "h", "score", "1.5"), "$3\r\n1.5\r\n"},
+		{cmd("HINCRBYFLOAT", "h", "name", "1"), "-ERR hash value is not a float\r\n"},
+		{cmd("HDEL", "h", "name", "score", "missing"), ":2\r\n"},
+		{cmd("HGETALL", "h"), "*2\r\n$3\r\nage\r\n$2\r\n40\r\n"},
+		{cmd("HKEYS", "h"), "*1\r\n$3\r\nage\r\n"},
+		{cmd("HVALS", "h"), "*1\r\n$2\r\n40\r\n"},
+		{cmd("HRANDFIELD", "h"), "$3\r\nage\r\n"},
+		{cmd("HRANDFIELD", "h", "-3"), "*3\r\n$3\r\nage\r\n$3\r\nage\r\n$3\r\nage\r\n"},
+		{cmd("HRANDFIELD", "h", "5", "WITHVALUES"), "*2\r\n$3\r\nage\r\n$2\r\n40\r\n"},
+		{cmd("HRANDFIELD", "h", "99999999999999"), "*1\r\n$3\r\nage\r\n"},
+		{cmd("HRANDFIELD", "h", "-9223372036854775808"), "-ERR value is out of range\r\n"},
+		{cmd("HRANDFIELD", "missing", "-9223372036854775808"), "-ERR value is out of range\r\n"},
+		{cmd("HDEL", "h", "age"), ":1\r\n"},
+		{cmd("EXISTS", "h"), ":0\r\n"},
+		{cmd("SET", "s", "v"), "+OK\r\n"},
+		{cmd("HGET", "s", "f"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	})
+}
+
+func TestHscan(t *testing.T) {
+	re := newTestExecutor()
+	for i := 0; i < 100; i++ {
+		execute(re, "HSET", "h", "field:"+strconv.Itoa(i), "v")
+	}
+	seen := make(map[string]bool)
+	cursor := "0"
+	for {
+		reply := re.Execute(CreateCommandFromTokens(bulkTokens(
+			[]string{"HSCAN", "h", cursor, "MATCH", "field:1*", "NOVALUES"})))
+		cursor = reply.Items[0].Value
+		for _, item := range reply.Items[1].Items {
+			if !strings.HasPrefix(item.Value, "field:1") {
+				t.Errorf("unexpected field %s", item.Value)
+			}
+			seen[item.Value] = true
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	// field:1 and field:10 to field:19
+	if len(seen) != 11 {
+		t.Errorf("got %d fields, want 11", len(seen))
+	}
+}
@@ -0,0 +1,314 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+)
+
+// The HyperLogLog of Redis, stored as a string value so that it can be copied with GET
+// and SET between servers: a 16 bytes header then the registers, either dense or sparse.
+//
+//	+------+---+-----+----------+
+//	| HYLL | E | N/U | Cardin.  |
+//	+------+---+-----+----------+
+//
+// E is the encoding, N/U are unused and Cardin. is the cached cardinality, little
+// endian, its most significant bit being set when the cache is stale.
+const (
+	hllP            = 14 // the bits of the hash selecting a register
+	hllQ            = 64 - hllP
+	hllRegisters    = 1 << hllP
+	hllBits         = 6 // the bits of a dense register
+	hllRegisterMax  = 1<<hllBits - 1
+	hllHeaderSize   = 16
+	hllDenseSize    = hllHeaderSize + (hllRegisters*hllBits+7)/8
+	hllDense        = 0
+	hllSparse       = 1
+	hllSparseValMax = 32 // the greatest value of a sparse register
+	hllHashSeed     = 0xadc83b19
+	// hllSparseMaxBytes is the size from which a sparse HyperLogLog is converted to a
+	// dense one, like hll-sparse-max-bytes
+	hllSparseMaxBytes = 3000
+	hllAlphaInf       = 0.721347520444481703680 // 0.5/ln(2)
+)
+
+var hllMagic = []byte("HYLL")
+
+var (
+	ErrNotHLL       = errors.New("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	ErrCorruptedHLL = errors.New("INVALIDOBJ Corrupted HLL object detected")
+)
+
+// murmurHash64A is the 64 bits MurmurHash2 by Austin Appleby, as hashed by Redis
+func murmurHash64A(key []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+	h := seed ^ uint64(len(key))*m
+	for ; len(key) >= 8; key = key[8:] {
+		k := binary.LittleEndian.Uint64(key)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+	}
+	if len(key) > 0 {
+		for i := len(key) - 1; i >= 0; i-- {
+			h ^= uint64(key[i]) << (8 * i)
+		}
+		h *= m
+	}
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// hllPatLen returns the register of the element and the length of the pattern 000..1
+// of its hash, the value of the register being the longest pattern seen
+func hllPatLen(element []byte) (int, uint8) {
+	hash := murmurHash64A(element, hllHashSeed)
+	index := int(hash & (hllRegisters - 1))
+	// the bit Q makes sure the pattern ends
+	hash = hash>>hllP | 1<<hllQ
+	return index, uint8(bits.TrailingZeros64(hash) + 1)
+}
+
+/* ---------------- dense ---------------- */
+
+// The dense registers are 6 bits each, from the least significant bits of the bytes
+
+func hllDenseGet(registers []byte, i int) uint8 {
+	b := i * hllBits / 8
+	fb := uint(i*hllBits) & 7
+	v := uint(registers[b]) >> fb
+	if b+1 < len(registers) {
+		v |= uint(registers[b+1]) << (8 - fb)
+	}
+	return uint8(v & hllRegisterMax)
+}
+
+func hllDenseSet(registers []byte, i int, value uint8) {
+	b := i * hllBits / 8
+	fb := uint(i*hllBits) & 7
+	registers[b] &^= hllRegisterMax << fb
+	registers[b] |= value << fb
+	if b+1 < len(registers) {
+		registers[b+1] &^= hllRegisterMax >> (8 - fb)
+		registers[b+1] |= value >> (8 - fb)
+	}
+}
+
+/* ---------------- sparse ---------------- */
+
+// The sparse registers are a sequence of runs of registers with the same value:
+//
+//	ZERO   00xxxxxx           1 to 64 registers set to 0
+//	XZERO  01xxxxxx yyyyyyyy  1 to 16384 registers set to 0
+//	VAL    1vvvvvxx           1 to 4 registers set to 1 to 32
+
+// hllSparseDecode returns the values of the sparse registers
+func hllSparseDecode(runs []byte) ([]uint8, error) {
+	registers := make([]uint8, hllRegisters)
+	i := 0
+	for p := 0; p < len(runs); {
+		var n int
+		var value uint8
+		switch op := runs[p]; {
+		case op&0xc0 == 0:
+			n = int(op&0x3f) + 1
+			p++
+		case op&0xc0 == 0x40:
+			if p+1 == len(runs) {
+				return nil, ErrCorruptedHLL
+			}
+			n = int(op&0x3f)<<8 | int(runs[p+1]) + 1
+			p += 2
+		default:
+			value = op>>2&0x1f + 1
+			n = int(op&3) + 1
+			p++
+		}
+		if i+n > hllRegisters {
+			return nil, ErrCorruptedHLL
+		}
+		for end := i + n; i < end; i++ {
+			registers[i] = value
+		}
+	}
+	if i != hllRegisters {
+		return nil, ErrCorruptedHLL
+	}
+	return registers, nil
+}
+
+// hllSparseEncode appends the registers to @dst as sparse runs, it returns false if a
+// register is too large for the sparse encoding
+func hllSparseEncode(dst []byte, registers []uint8) ([]byte, bool) {
+	for i := 0; i < len(registers); {
+		value := registers[i]
+		n := 1
+		for i+n < len(registers) && registers[i+n] == value {
+			n++
+		}
+		i += n
+		switch {
+		case value > hllSparseValMax:
+			return nil, false
+		case value > 0:
+			for ; n > 0; n -= 4 {
+				dst = append(dst, 0x80|(value-1)<<2|byte(min(n, 4)-1))
+			}
+		case n <= 64:
+			dst = append(dst, byte(n-1))
+		default:
+			dst = append(dst, 0x40|byte((n-1)>>8), byte(n-1))
+		}
+	}
+	return dst, true
+}
+
+/* ---------------- HyperLogLog ---------------- */
+
+// newHLL returns an empty HyperLogLog, sparse
+func newHLL() []byte {
+	value := make([]byte, hllHeaderSize, hllHeaderSize+2)
+	copy(value, hllMagic)
+	value[4] = hllSparse
+	value, _ = hllSparseEncode(value, make([]uint8, hllRegisters))
+	return value
+}
+
+// isHLL reports whether the string holds a HyperLogLog
+func isHLL(value []byte) bool {
+	return len(value) >= hllHeaderSize && string(value[:4]) == string(hllMagic) &&
+		(value[4] == hllSparse || (value[4] == hllDense && len(value) == hllDenseSize))
+}
+
+// hllRegisterValues returns the values of the registers of the HyperLogLog
+func hllRegisterValues(value []byte) ([]uint8, error) {
+	if value[4] == hllSparse {
+		return hllSparseDecode(value[hllHeaderSize:])
+	}
+	registers := make([]uint8, hllRegisters)
+	for i := range registers {
+		registers[i] = hllDenseGet(value[hllHeaderSize:], i)
+	}
+	return registers, nil
+}
+
+// hllEncode returns the HyperLogLog with the header and the registers, sparse unless
+// it is too large, and with a stale cached cardinality
+func hllEncode(header []byte, registers []uint8) []byte {
+	value := append(make([]byte, 0, hllHeaderSize+256), header[:hllHeaderSize]...)
+	value[4] = hllSparse
+	if sparse, ok := hllSparseEncode(value, registers); ok && len(sparse) <= hllSparseMaxBytes {
+		hllInvalidateCache(sparse)
+		return sparse
+	}
+	value = append(value, make([]byte, hllDenseSize-hllHeaderSize)...)
+	value[4] = hllDense
+	for i, register := range registers {
+		hllDenseSet(value[hllHeaderSize:], i, register)
+	}
+	hllInvalidateCache(value)
+	return value
+}
+
+// hllAdd adds the elements to the HyperLogLog, it returns the HyperLogLog, a sparse one
+// being encoded again, and whether a register changed
+func hllAdd(value []byte, elements []string) ([]byte, bool, error) {
+	changed := false
+	if value[4] == hllDense {
+		for _, element := range elements {
+			i, count := hllPatLen([]byte(element))
+			if count > hllDenseGet(value[hllHeaderSize:], i) {
+				hllDenseSet(value[hllHeaderSize:], i, count)
+				changed = true
+			}
+		}
+		if changed {
+			hllInvalidateCache(value)
+		}
+		return value, changed, nil
+	}
+	registers, err := hllSparseDecode(value[hllHeaderSize:])
+	if err != nil {
+		return nil, false, err
+	}
+	for _, element := range elements {
+		if i, count := hllPatLen([]byte(element)); count > registers[i] {
+			registers[i] = count
+			changed = true
+		}
+	}
+	if !changed {
+		return value, false, nil
+	}
+	return hllEncode(value, registers), true, nil
+}
+
+func hllInvalidateCache(value []byte) {
+	value[15] |= 1 << 7
+}
+
+// hllCachedCount returns the cached cardinality of the HyperLogLog, false if it is stale
+func hllCachedCount(value []byte) (uint64, bool) {
+	return binary.LittleEndian.Uint64(value[8:16]), value[15]&(1<<7) == 0
+}
+
+func hllSetCachedCount(value []byte, count uint64) {
+	binary.LittleEndian.PutUint64(value[8:16], count)
+}
+
+// hllCount estimates the cardinality from the values of the registers, with the
+// estimator of Otmar Ertl used by Redis ("New cardinality estimation algorithms for
+// HyperLogLog sketches")
+func hllCount(registers []uint8) uint64 {
+	var histogram [hllRegisterMax + 1]int
+	for _, register := range registers {
+		histogram[register]++
+	}
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		previous := z
+		z += x * y
+		y += y
+		if z == previous {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		previous := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if z == previous {
+			return z / 3
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+func init() {
+	registerCommands(
+		&commandSpec{name: "pfadd", arity: -2, handler: pfaddCommand, flags: flagWrite | flagDenyOOM, keys: firstKey, categories: catHyperLogLog},
+		&commandSpec{name: "pfcount", arity: -2, handler: pfcountCommand, keys: allKeys, categories: catHyperLogLog},
+		&commandSpec{name: "pfmerge", arity: -2, handler: pfmergeCommand, flags: flagWrite | flagDenyOOM, keys: allKeys, categories: catHyperLogLog},
+	)
+}
+
+// lookupHLL returns the string item holding a HyperLogLog stored at key
+func lookupHLL(re *RedisExecutorImpl, key string) (*CacheItem, bool, error) {
+	item, found, err := lookupString(re, key)
+	if err != nil || !found {
+		return nil, false, err
+	}
+	if !isHLL(item.StringValue()) {
+		return nil, false, ErrNotHLL
+	}
+	return item, true, nil
+}
+
+// PFADD key [element ...]
+func pfaddCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	key := cmd.Arg(0)
+	item, found, err := lookupHLL(re, key)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	value := newHLL()
+	if found {
+		value = item.StringValue()
+	}
+	value, changed, err := hllAdd(value, cmd.Args()[1:])
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	switch {
+	case !found:
+		setString(re, key, value, 0)
+	case changed:
+		item.Value = value
+		re.signalModifiedKey(key)
+	default:
+		return IntegerResponse(0)
+	}
+	re.notifyKeyspaceEvent(notifyString, "pfadd", key)
+	return IntegerResponse(1)
+}
+
+// PFCOUNT key [key ...], the cardinality of the union of the HyperLogLogs. The
+// cardinality of a single one is cached in its header.
+func pfcountCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	if len(cmd.Args()) == 1 {
+		item, found, err := lookupHLL(re, cmd.Arg(0))
+		if err != nil {
+			return ErrorResponse(err)
+		}
+		if !found {
+			return IntegerResponse(0)
+		}
+		value := item.StringValue()
+		if count, ok := hllCachedCount(value); ok {
+			return IntegerResponse(int64(count))
+		}
+		registers, err := hllRegisterValues(value)
+		if err != nil {
+			return ErrorResponse(err)
+		}
+		count := hllCount(registers)
+		hllSetCachedCount(value, count)
+		return IntegerResponse(int64(count))
+	}
+
+	union, err := hllUnion(re, cmd.Args())
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	return IntegerResponse(int64(hllCount(union)))
+}
+
+// hllUnion returns the greatest value of each register among the HyperLogLogs stored
+// at the keys, the missing keys being skipped
+func hllUnion(re *RedisExecutorImpl, keys []string) ([]uint8, error) {
+	union := make([]uint8, hllRegisters)
+	for _, key := range keys {
+		item, found, err := lookupHLL(re, key)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		registers, err := hllRegisterValues(item.StringValue())
+		if err != nil {
+			return nil, err
+		}
+		for i, register := range registers {
+			union[i] = max(union[i], register)
+		}
+	}
+	return union, nil
+}
+
+// PFMERGE destkey [sourcekey ...], the union includes the destination
+func pfmergeCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	union, err := hllUnion(re, cmd.Args())
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	key := cmd.Arg(0)
+	item, found, _ := lookupHLL(re, key)
+	if !found {
+		setString(re, key, hllEncode(newHLL(), union), 0)
+		re.notifyKeyspaceEvent(notifyString, "pfadd", key)
+		return OKResponse()
+	}
+	// the time to live of the destination is retained
+	item.Value = hllEncode(item.StringValue(), union)
+	re.signalModifiedKey(key)
+	re.notifyKeyspaceEvent(notifyString, "pfadd", key)
+	return OKResponse()
+}
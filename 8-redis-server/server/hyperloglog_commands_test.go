@@ -0,0 +1,131 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHyperLogLogCommands(t *testing.T) {
+	re := newTestExecutor()
+	runSteps(t, re, []testStep{
+		{cmd("PFADD", "h", "a", "b", "c"), ":1\r\n"},
+		{cmd("PFADD", "h", "a"), ":0\r\n"},
+		{cmd("PFCOUNT", "h"), ":3\r\n"},
+		{cmd("PFCOUNT", "missing"), ":0\r\n"},
+		{cmd("PFADD", "empty"), ":1\r\n"},
+		{cmd("PFADD", "empty"), ":0\r\n"},
+		{cmd("PFCOUNT", "empty"), ":0\r\n"},
+		{cmd("GETRANGE", "h", "0", "4"), "$5\r\nHYLL\x01\r\n"},
+		{cmd("PFADD", "h2", "c", "d", "e"), ":1\r\n"},
+		{cmd("PFCOUNT", "h", "h2", "missing"), ":5\r\n"},
+		{cmd("PFMERGE", "dst", "h", "h2"), "+OK\r\n"},
+		{cmd("PFCOUNT", "dst"), ":5\r\n"},
+		{cmd("PFMERGE", "h", "h2"), "+OK\r\n"},
+		{cmd("PFCOUNT", "h"), ":5\r\n"},
+		{cmd("SET", "s", "foo"), "+OK\r\n"},
+		{cmd("PFADD", "s", "a"), "-WRONGTYPE Key is not a valid HyperLogLog string value.\r\n"},
+		{cmd("PFCOUNT", "h", "s"), "-WRONGTYPE Key is not a valid HyperLogLog string value.\r\n"},
+		{cmd("RPUSH", "l", "a"), ":1\r\n"},
+		{cmd("PFMERGE", "dst", "l"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		// a sparse HyperLogLog with a single register and a stale cached cardinality
+		{cmd("SET", "bad", "HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80\x00"), "+OK\r\n"},
+		{cmd("PFCOUNT", "bad"), "-INVALIDOBJ Corrupted HLL object detected\r\n"},
+	})
+
+	// a HyperLogLog is a string, copied by GET and SET
+	value := execute(re, "GET", "h")
+	value = value[strings.Index(value, "\r\n")+2 : len(value)-2]
+	runSteps(t, re, []testStep{
+		{cmd("SET", "copy", value), "+OK\r\n"},
+		{cmd("PFADD", "copy", "e"), ":0\r\n"},
+		{cmd("PFCOUNT", "copy"), ":5\r\n"},
+	})
+}
+
+func TestHyperLogLogAccuracy(t *testing.T) {
+	re := newTestExecutor()
+	elements := 0
+	for _, n := range []int{10, 100, 1000, 10000, 100000} {
+		for elements < n {
+			args := cmd("PFADD", "h")
+			for ; elements < n && len(args) < 1000; elements++ {
+				args = append(args, "element:"+strconv.Itoa(elements))
+			}
+			execute(re, args...)
+		}
+		count, err := strconv.Atoi(strings.Trim(execute(re, "PFCOUNT", "h"), ":\r\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the standard error is 0.81%
+		if diff := count - n; diff*diff > max(1, n*n/1600) {
+			t.Errorf("PFCOUNT: got %d, want about %d", count, n)
+		}
+
+		// the small HyperLogLogs are sparse
+		encoding := execute(re, "GETRANGE", "h", "4", "4")
+		if want := "$1\r\n\x01\r\n"; n > 1000 {
+			want = "$1\r\n\x00\r\n"
+			if encoding != want {
+				t.Errorf("%d elements: got encoding %q, want dense", n, encoding)
+			}
+		} else if encoding != want {
+			t.Errorf("%d elements: got encoding %q, want sparse", n, encoding)
+		}
+	}
+}
+
+func TestHyperLogLogEncodings(t *testing.T) {
+	registers := make([]uint8, hllRegisters)
+	for i := 0; i < 1000; i++ {
+		index, count := hllPatLen([]byte(strconv.Itoa(i)))
+		registers[index] = max(registers[index], count)
+	}
+	// the largest values of the registers are dense only
+	for _, last := range []uint8{0, hllSparseValMax + 1} {
+		registers[hllRegisters-1] = last
+		value := hllEncode(newHLL(), registers)
+		want := uint8(hllSparse)
+		if last > hllSparseValMax {
+			want = hllDense
+		}
+		if value[4] != want {
+			t.Errorf("register %d: got encoding %d, want %d", last, value[4], want)
+		}
+		got, err := hllRegisterValues(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, registers) {
+			t.Errorf("encoding %d: the registers differ", value[4])
+		}
+	}
+}
+
+func TestHyperLogLogOverConnection(t *testing.T) {
+	re := newTestExecutor()
+	conn, _ := startCountingServer(t, re)
+	r := bufio.NewReader(conn)
+	// the cached cardinality 2573 is 0x0a0d, stored little endian as CRLF
+	var value string
+	for i := 0; i < 5000 && !strings.Contains(value, "\r\n"); i++ {
+		execute(re, "PFADD", "h", strconv.Itoa(i))
+		execute(re, "PFCOUNT", "h")
+		item, _ := re.Get("h")
+		value = string(item.StringValue())
+	}
+	if !strings.Contains(value, "\r\n") {
+		t.Fatal("the HyperLogLog doesn't contain CRLF")
+	}
+
+	// a HyperLogLog is copied by GET and SET through the client
+	count := execute(re, "PFCOUNT", "h")
+	roundTrip(t, conn, r, cmd("GET", "h"), "$"+strconv.Itoa(len(value))+"\r\n"+value+"\r\n")
+	roundTrip(t, conn, r, cmd("SET", "copy", value), "+OK\r\n")
+	roundTrip(t, conn, r, cmd("PFCOUNT", "copy"), count)
+	roundTrip(t, conn, r, cmd("PFADD", "copy", "0", "100"), ":0\r\n")
+	roundTrip(t, conn, r, cmd("DBSIZE"), ":2\r\n")
+}
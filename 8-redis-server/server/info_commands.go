@@ -0,0 +1,253 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisVersion is the version of Redis the server reports being compatible with
+const redisVersion = "7.0.0"
+
+// avgTTLSamples is the number of keys sampled to estimate the average time to live
+const avgTTLSamples = 20
+
+func init() {
+	registerCommands(
+		&commandSpec{name: "info", arity: -1, handler: infoCommand, categories: catDangerous},
+	)
+}
+
+// infoField is a "name:value" line of INFO
+type infoField struct {
+	name, value string
+}
+
+// infoSection generates a section of INFO. The @extra sections are only reported when
+// asked for, or with all and everything.
+type infoSection struct {
+	name   string
+	fields func(re *RedisExecutorImpl) []infoField
+	extra  bool
+}
+
+// infoSections are the sections of INFO, in the order they are reported
+var infoSections = []infoSection{
+	{"server", serverInfo, false},
+	{"clients", clientsInfo, false},
+	{"memory", memoryInfo, false},
+	{"persistence", persistenceInfo, false},
+	{"stats", statsInfo, false},
+	{"replication", replicationInfo, false},
+	{"keyspace", keyspaceInfo, false},
+	{"commandstats", commandstatsInfo, true},
+}
+
+func infoBool(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func infoInt(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
+
+// bytesToHuman formats a number of bytes like Redis, e.g. 1.50M
+func bytesToHuman(n int64) string {
+	units := []string{"K", "M", "G", "T", "P"}
+	if n < 1024 {
+		return infoInt(n) + "B"
+	}
+	value := float64(n) / 1024
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	return strconv.FormatFloat(value, 'f', 2, 64) + units[unit]
+}
+
+func serverInfo(re *RedisExecutorImpl) []infoField {
+	now := time.Now()
+	uptime := int64(now.Sub(re.stats.startTime).Seconds())
+	executable, _ := os.Executable()
+	return []infoField{
+		{"redis_version", redisVersion},
+		{"redis_mode", "standalone"},
+		{"os", runtime.GOOS + " " + runtime.GOARCH},
+		{"arch_bits", strconv.Itoa(strconv.IntSize)},
+		{"go_version", runtime.Version()},
+		{"process_id", strconv.Itoa(os.Getpid())},
+		{"run_id", re.stats.runID},
+		{"tcp_port", strconv.Itoa(re.config.Port)},
+		{"server_time_usec", infoInt(now.UnixMicro())},
+		{"uptime_in_seconds", infoInt(uptime)},
+		{"uptime_in_days", infoInt(uptime / 86400)},
+		{"hz", strconv.Itoa(int(time.Second / activeExpireInterval))},
+		{"executor_mode", re.executorMode()},
+		{"executable", executable},
+		{"config_file", re.config.path},
+	}
+}
+
+func clientsInfo(re *RedisExecutorImpl) []infoField {
+	blocked := make(map[*blockedClient]struct{})
+	for _, clients := range re.blocked {
+		for _, bc := range clients {
+			blocked[bc] = struct{}{}
+		}
+	}
+	subscribers := make(map[*Client]struct{})
+	for _, subscriptions := range []map[string]map[*Client]struct{}{re.pubsub.channels, re.pubsub.patterns} {
+		for _, clients := range subscriptions {
+			for c := range clients {
+				subscribers[c] = struct{}{}
+			}
+		}
+	}
+	watching := make(map[*Client]struct{})
+	for _, clients := range re.watchedKeys {
+		for c := range clients {
+			watching[c] = struct{}{}
+		}
+	}
+	return []infoField{
+		{"connected_clients", strconv.Itoa(re.connectedClients())},
+		{"maxclients", strconv.Itoa(re.config.MaxClients)},
+		{"blocked_clients", strconv.Itoa(len(blocked))},
+		{"pubsub_clients", strconv.Itoa(len(subscribers))},
+		{"watching_clients", strconv.Itoa(len(watching))},
+		{"total_watched_keys", strconv.Itoa(len(re.watchedKeys))},
+	}
+}
+
+func memoryInfo(re *RedisExecutorImpl) []infoField {
+	used := re.trackPeakMemory()
+	maxmemory := int64(re.config.Maxmemory)
+	return []infoField{
+		{"used_memory", infoInt(used)},
+		{"used_memory_human", bytesToHuman(used)},
+		{"used_memory_peak", infoInt(re.stats.peakMemory)},
+		{"used_memory_peak_human", bytesToHuman(re.stats.peakMemory)},
+		{"maxmemory", infoInt(maxmemory)},
+		{"maxmemory_human", bytesToHuman(maxmemory)},
+		{"maxmemory_policy", re.config.MaxmemoryPolicy},
+	}
+}
+
+func persistenceInfo(re *RedisExecutorImpl) []infoField {
+	bgsaveStatus := "ok"
+	if !re.rdb.lastBgsaveOK {
+		bgsaveStatus = "err"
+	}
+	return []infoField{
+		{"loading", infoBool(re.aof.loading)},
+		{"rdb_changes_since_last_save", infoInt(re.rdb.dirty)},
+		{"rdb_bgsave_in_progress", infoBool(re.rdb.bgsave != nil)},
+		{"rdb_last_save_time", infoInt(re.rdb.lastSave.Unix())},
+		{"rdb_last_bgsave_status", bgsaveStatus},
+		{"aof_enabled", infoBool(re.config.AppendOnly)},
+		{"aof_rewrite_in_progress", infoBool(re.aof.rewrite != nil)},
+		{"aof_rewrite_scheduled", infoBool(re.aof.rewriteScheduled)},
+	}
+}
+
+func statsInfo(re *RedisExecutorImpl) []infoField {
+	return []infoField{
+		{"total_connections_received", infoInt(re.stats.totalConnections)},
+		{"total_commands_processed", infoInt(re.stats.totalCommands)},
+		{"rejected_connections", infoInt(re.stats.rejectedConnections)},
+		{"instantaneous_ops_per_sec", infoInt(re.instantaneousOps())},
+		{"expired_keys", infoInt(re.expiredKeysCount())},
+		{"evicted_keys", infoInt(re.evict.evictedKeys)},
+		{"keyspace_hits", infoInt(re.stats.keyspaceHits)},
+		{"keyspace_misses", infoInt(re.stats.keyspaceMisses)},
+		{"pubsub_channels", strconv.Itoa(len(re.pubsub.channels))},
+		{"pubsub_patterns", strconv.Itoa(len(re.pubsub.patterns))},
+		{"sync_full", infoInt(re.repl.fullSyncs)},
+		{"sync_partial_ok", infoInt(re.repl.partialSyncs)},
+		{"sync_partial_err", infoInt(re.repl.partialSyncErrors)},
+		{"total_error_replies", infoInt(re.stats.errorReplies)},
+	}
+}
+
+// keyspaceInfo reports the databases holding keys. Like Redis, the average time to
+// live is estimated from a sample of the keys having one.
+func keyspaceInfo(re *RedisExecutorImpl) []infoField {
+	var fields []infoField
+	now := nowMs()
+	for id, db := range re.dbs {
+		if db.Len() == 0 {
+			continue
+		}
+		var ttl, sampled int64
+		db.Sample(avgTTLSamples, true, func(_ string, item *CacheItem) bool {
+			if item.ExpireAt > now {
+				ttl += item.ExpireAt - now
+				sampled++
+			}
+			return true
+		})
+		if sampled > 0 {
+			ttl /= sampled
+		}
+		fields = append(fields, infoField{
+			"db" + strconv.Itoa(id),
+			fmt.Sprintf("keys=%d,expires=%d,avg_ttl=%d", db.Len(), db.Expires(), ttl),
+		})
+	}
+	return fields
+}
+
+func commandstatsInfo(re *RedisExecutorImpl) []infoField {
+	names := make([]string, 0, len(re.stats.commands))
+	for name := range re.stats.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fields := make([]infoField, 0, len(names))
+	for _, name := range names {
+		stats := re.stats.commands[name]
+		perCall := 0.0
+		if stats.calls > 0 {
+			perCall = float64(stats.usec) / float64(stats.calls)
+		}
+		fields = append(fields, infoField{
+			"cmdstat_" + name,
+			fmt.Sprintf("calls=%d,usec=%d,usec_per_call=%.2f,rejected_calls=%d,failed_calls=%d",
+				stats.calls, stats.usec, perCall, stats.rejected, stats.failed),
+		})
+	}
+	return fields
+}
+
+// INFO [section ...], the default sections without arguments
+func infoCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	wanted := make(map[string]bool)
+	for _, arg := range cmd.Args() {
+		wanted[strings.ToLower(arg)] = true
+	}
+	all := wanted["all"] || wanted["everything"]
+	defaults := len(wanted) == 0 || wanted["default"]
+
+	var b strings.Builder
+	for _, section := range infoSections {
+		if !all && !wanted[section.name] && (section.extra || !defaults) {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("# " + strings.ToUpper(section.name[:1]) + section.name[1:] + "\r\n")
+		for _, field := range section.fields(re) {
+			b.WriteString(field.name + ":" + field.value + "\r\n")
+		}
+	}
+	return BulkResponse(b.String())
+}
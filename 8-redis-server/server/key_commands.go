@@ -0,0 +1,351 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerCommands(
+		&commandSpec{name: "del", arity: -2, handler: delCommand, flags: flagWrite, keys: allKeys, categories: catKeyspace},
+		&commandSpec{name: "exists", arity: -2, handler: existsCommand, keys: allKeys, categories: catKeyspace},
+		&commandSpec{name: "type", arity: 2, handler: typeCommand, keys: firstKey, categories: catKeyspace},
+		&commandSpec{name: "expire", arity: -3, handler: expireCommand, flags: flagWrite, keys: firstKey, categories: catKeyspace},
+		&commandSpec{name: "pexpire", arity: -3, handler: expireCommand, flags: flagWrite, keys: firstKey, categories: catKeyspace},
+		&commandSpec{name: "expireat", arity: -3, handler: expireCommand, flags: flagWrite, keys: firstKey, categories: catKeyspace},
+		&commandSpec{name: "pexpireat", arity: -3, handler: expireCommand, flags: flagWrite, keys: firstKey, categories: catKeyspace},
+		&commandSpec{name: "ttl", arity: 2, handler: ttlCommand, keys: firstKey, categories: catKeyspace},
+		&commandSpec{name: "pttl", arity: 2, handler: ttlCommand, keys: firstKey, categories: catKeyspace},
+		&commandSpec{name: "persist", arity: 2, handler: persistCommand, flags: flagWrite, keys: firstKey, categories: catKeyspace},
+		&commandSpec{name: "keys", arity: 2, handler: keysCommand, categories: catKeyspace | catRead | catDangerous},
+		&commandSpec{name: "scan", arity: -2, handler: scanCommand, categories: catKeyspace | catRead},
+		&commandSpec{name: "randomkey", arity: 1, handler: randomkeyCommand, categories: catKeyspace | catRead},
+		&commandSpec{name: "rename", arity: 3, handler: renameCommand, flags: flagWrite, keys: keySpec{0, 1, 1}, categories: catKeyspace},
+		&commandSpec{name: "renamenx", arity: 3, handler: renameCommand, flags: flagWrite, keys: keySpec{0, 1, 1}, categories: catKeyspace},
+		&commandSpec{name: "copy", arity: -3, handler: copyCommand, flags: flagWrite | flagDenyOOM, keys: keySpec{0, 1, 1}, categories: catKeyspace},
+		&commandSpec{name: "move", arity: 3, handler: moveCommand, flags: flagWrite, keys: firstKey, categories: catKeyspace},
+	)
+}
+
+// nowMs returns the current unix time in milliseconds
+func nowMs() int64 {
+	return time.Now().UnixMilli()
+}
+
+// lookupKey returns the item stored at key, for a command which may access its value.
+// The access is recorded for the eviction, and a value shared with a background
+// snapshot is copied first.
+func lookupKey(re *RedisExecutorImpl, key string) (*CacheItem, bool) {
+	item, found := re.Get(key)
+	if found {
+		re.stats.keyspaceHits++
+		re.touch(item)
+		if re.shared != nil {
+			re.unshare(item)
+		}
+	} else {
+		re.stats.keyspaceMisses++
+	}
+	return item, found
+}
+
+// lookupTyped returns the item stored at key, failing if it holds a value of another type
+func lookupTyped(re *RedisExecutorImpl, key string, valueType ValueType) (*CacheItem, bool, error) {
+	item, found := lookupKey(re, key)
+	if found && item.Type != valueType {
+		return nil, false, ErrWrongType
+	}
+	return item, found, nil
+}
+
+// DEL key [key ...]
+func delCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	var deleted int64
+	for _, key := range cmd.Args() {
+		if found, _ := re.Contains(key); found {
+			_ = re.Remove(key)
+			re.signalModifiedKey(key)
+			re.notifyKeyspaceEvent(notifyGeneric, "del", key)
+			deleted++
+		}
+	}
+	return IntegerResponse(deleted)
+}
+
+// EXISTS key [key ...], a key mentioned multiple times is counted multiple times
+func existsCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	var count int64
+	for _, key := range cmd.Args() {
+		if found, _ := re.Contains(key); found {
+			count++
+		}
+	}
+	return IntegerResponse(count)
+}
+
+// TYPE key
+func typeCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	if item, found := re.Get(cmd.Arg(0)); found {
+		return SimpleStringResponse(item.Type.String())
+	}
+	return SimpleStringResponse("none")
+}
+
+// EXPIRE key seconds [NX | XX | GT | LT], also PEXPIRE, EXPIREAT and PEXPIREAT
+func expireCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	var nx, xx, gt, lt bool
+	for _, opt := range cmd.Args()[2:] {
+		switch strings.ToLower(opt) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "gt":
+			gt = true
+		case "lt":
+			lt = true
+		default:
+			return ErrorResponse(UnsupportedOptionError(opt))
+		}
+	}
+	if nx && (xx || gt || lt) {
+		return ErrorResponse(ErrExpireNXOptions)
+	}
+	if gt && lt {
+		return ErrorResponse(ErrExpireGTLT)
+	}
+
+	when, ok := parseInt(cmd.Arg(1))
+	if !ok {
+		return ErrorResponse(ErrNotInteger)
+	}
+	expireAt, ok := toUnixMs(cmd.Name(), when)
+	if !ok {
+		return ErrorResponse(InvalidExpireError(cmd.Name()))
+	}
+
+	key := cmd.Arg(0)
+	item, found := re.Get(key)
+	if !found {
+		return IntegerResponse(0)
+	}
+	// an item without time to live is treated as having an infinite one
+	current := item.ExpireAt
+	if current == 0 {
+		current = math.MaxInt64
+	}
+	if (nx && item.ExpireAt != 0) || (xx && item.ExpireAt == 0) ||
+		(gt && expireAt <= current) || (lt && expireAt >= current) {
+		return IntegerResponse(0)
+	}
+	// the expiry is propagated as an absolute one
+	if expireAt <= nowMs() {
+		_ = re.Remove(key)
+		re.rewriteCommand("del", key)
+		re.signalModifiedKey(key)
+		re.notifyKeyspaceEvent(notifyGeneric, "del", key)
+	} else {
+		re.SetExpire(key, expireAt)
+		re.rewriteCommand("pexpireat", key, strconv.FormatInt(expireAt, 10))
+		re.signalModifiedKey(key)
+		re.notifyKeyspaceEvent(notifyGeneric, "expire", key)
+	}
+	return IntegerResponse(1)
+}
+
+// toUnixMs converts the time argument of the expire commands to an absolute unix time
+// in milliseconds, reporting false on overflow
+func toUnixMs(name string, when int64) (int64, bool) {
+	relative := !strings.HasSuffix(name, "at")
+	if !strings.HasPrefix(name, "p") {
+		if when > math.MaxInt64/1000 || when < math.MinInt64/1000 {
+			return 0, false
+		}
+		when *= 1000
+	}
+	if relative {
+		now := nowMs()
+		if when > math.MaxInt64-now {
+			return 0, false
+		}
+		when += now
+	}
+	return when, true
+}
+
+// TTL key, also PTTL. -2 if the key doesn't exist, -1 if it has no time to live.
+func ttlCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	item, found := re.Get(cmd.Arg(0))
+	if !found {
+		return IntegerResponse(-2)
+	}
+	if item.ExpireAt == 0 {
+		return IntegerResponse(-1)
+	}
+	ttl := item.ExpireAt - nowMs()
+	if ttl < 0 {
+		ttl = 0
+	}
+	if cmd.Name() == "ttl" {
+		ttl = (ttl + 500) / 1000
+	}
+	return IntegerResponse(ttl)
+}
+
+// PERSIST key
+func persistCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	item, found := re.Get(cmd.Arg(0))
+	if !found || item.ExpireAt == 0 {
+		return IntegerResponse(0)
+	}
+	re.SetExpire(cmd.Arg(0), 0)
+	re.signalModifiedKey(cmd.Arg(0))
+	re.notifyKeyspaceEvent(notifyGeneric, "persist", cmd.Arg(0))
+	return IntegerResponse(1)
+}
+
+// KEYS pattern, the keys of the selected database matching the glob-style pattern.
+// It runs in O(n) of the number of keys, SCAN iterates them without blocking.
+func keysCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	pattern := cmd.Arg(0)
+	now := time.Now()
+	keys := []string{}
+	re.ForEach(func(key string, item *CacheItem) bool {
+		if !item.IsExpired(now) && (pattern == "*" || globMatch(pattern, key, false)) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	return BulkArrayResponse(keys)
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]. The keys present for the
+// whole iteration are returned at least once, even if the keyspace is resized meanwhile.
+func scanCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	opts, err := parseScanArgs(cmd.Args(), false, true)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if opts.valueType != "" {
+		known := false
+		for _, name := range valueTypeNames {
+			known = known || name == opts.valueType
+		}
+		if !known {
+			return ErrorResponse(fmt.Errorf("ERR unknown type name '%s'", opts.valueType))
+		}
+	}
+	now := time.Now()
+	var keys []string
+	cursor := scanDict(re.RedisCacher, opts, func(key string, item *CacheItem) {
+		if !item.IsExpired(now) && (opts.valueType == "" || item.Type.String() == opts.valueType) {
+			keys = append(keys, key)
+		}
+	})
+	return scanResponse(cursor, keys)
+}
+
+// RANDOMKEY
+func randomkeyCommand(re *RedisExecutorImpl, _ *Cmd) *RedisResponse {
+	key, found := re.RandomKey()
+	if !found {
+		return NilResponse()
+	}
+	return BulkResponse(key)
+}
+
+// RENAME key newkey, also RENAMENX which doesn't overwrite newkey. The value and the
+// time to live of key move to newkey.
+func renameCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	key, newKey := cmd.Arg(0), cmd.Arg(1)
+	nx := cmd.Name() == "renamenx"
+	item, found := re.Get(key)
+	if !found {
+		return ErrorResponse(ErrNoSuchKey)
+	}
+	if found, _ := re.Contains(newKey); found && (nx || key == newKey) {
+		if nx {
+			return IntegerResponse(0)
+		}
+		return OKResponse()
+	}
+	_ = re.Remove(key)
+	item.Key = newKey
+	_ = re.Set(newKey, item)
+	re.signalModifiedKey(key)
+	re.signalModifiedKey(newKey)
+	re.signalKeyAsReady(newKey)
+	re.notifyKeyspaceEvent(notifyGeneric, "rename_from", key)
+	re.notifyKeyspaceEvent(notifyGeneric, "rename_to", newKey)
+	if nx {
+		return IntegerResponse(1)
+	}
+	return OKResponse()
+}
+
+// COPY source destination [DB destination-db] [REPLACE], the value and the time to
+// live of source are copied
+func copyCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	source, destination := args[0], args[1]
+	db, replace := re.db, false
+	for i := 2; i < len(args); i++ {
+		switch option := strings.ToLower(args[i]); {
+		case option == "db" && i+1 < len(args):
+			var err error
+			if db, err = re.parseDBIndex(args[i+1]); err != nil {
+				return ErrorResponse(err)
+			}
+			i++
+		case option == "replace":
+			replace = true
+		default:
+			return ErrorResponse(ErrSyntax)
+		}
+	}
+	if source == destination && db == re.db {
+		return ErrorResponse(ErrSameObject)
+	}
+	item, found := lookupKey(re, source)
+	if !found {
+		return IntegerResponse(0)
+	}
+	if found, _ := re.dbs[db].Contains(destination); found && !replace {
+		return IntegerResponse(0)
+	}
+	copied := &CacheItem{Key: destination, Value: item.cloneValue(), Type: item.Type, ExpireAt: item.ExpireAt}
+	_ = re.dbs[db].Set(destination, copied)
+	re.signalModifiedKeyInDB(db, destination)
+	re.notifyKeyspaceEventInDB(db, notifyGeneric, "copy_to", destination)
+	return IntegerResponse(1)
+}
+
+// MOVE key db, moves the key of the selected database to another one unless it
+// already holds the key
+func moveCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	key := cmd.Arg(0)
+	db, err := re.parseDBIndex(cmd.Arg(1))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if db == re.db {
+		return ErrorResponse(ErrSameObject)
+	}
+	item, found := re.Get(key)
+	if !found {
+		return IntegerResponse(0)
+	}
+	if found, _ := re.dbs[db].Contains(key); found {
+		return IntegerResponse(0)
+	}
+	_ = re.Remove(key)
+	_ = re.dbs[db].Set(key, item)
+	re.signalModifiedKey(key)
+	re.signalModifiedKeyInDB(db, key)
+	re.notifyKeyspaceEvent(notifyGeneric, "move_from", key)
+	re.notifyKeyspaceEventInDB(db, notifyGeneric, "move_to", key)
+	return IntegerResponse(1)
+}
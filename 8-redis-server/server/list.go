@@ -0,0 +1,147 @@
+package server
+
+import "math"
+
+// List is a double-ended queue of strings backed by a ring buffer.
+// Pushing and popping at either end is O(1), positional access is O(1)
+// and inserting or removing in the middle is O(n).
+type List struct {
+	buf  []string
+	head int // position of the first element in @buf
+	size int
+}
+
+func NewList() *List {
+	return &List{buf: make([]string, 4)}
+}
+
+func (l *List) Len() int {
+	return l.size
+}
+
+// Index returns the i-th element, 0 <= i < Len()
+func (l *List) Index(i int) string {
+	return l.buf[(l.head+i)%len(l.buf)]
+}
+
+// Set replaces the i-th element, 0 <= i < Len()
+func (l *List) Set(i int, value string) {
+	l.buf[(l.head+i)%len(l.buf)] = value
+}
+
+func (l *List) PushFront(value string) {
+	l.grow()
+	l.head = (l.head - 1 + len(l.buf)) % len(l.buf)
+	l.buf[l.head] = value
+	l.size++
+}
+
+func (l *List) PushBack(value string) {
+	l.grow()
+	l.buf[(l.head+l.size)%len(l.buf)] = value
+	l.size++
+}
+
+func (l *List) PopFront() (string, bool) {
+	if l.size == 0 {
+		return "", false
+	}
+	value := l.buf[l.head]
+	l.buf[l.head] = ""
+	l.head = (l.head + 1) % len(l.buf)
+	l.size--
+	return value, true
+}
+
+func (l *List) PopBack() (string, bool) {
+	if l.size == 0 {
+		return "", false
+	}
+	tail := (l.head + l.size - 1) % len(l.buf)
+	value := l.buf[tail]
+	l.buf[tail] = ""
+	l.size--
+	return value, true
+}
+
+// Clone returns a copy of the list
+func (l *List) Clone() *List {
+	clone := &List{}
+	clone.reset(l.Range(0, l.size-1))
+	return clone
+}
+
+// Range returns the elements from @start to @end (inclusive), both within bounds
+func (l *List) Range(start, end int) []string {
+	values := make([]string, 0, end-start+1)
+	for i := start; i <= end; i++ {
+		values = append(values, l.Index(i))
+	}
+	return values
+}
+
+// Insert inserts the value before the i-th element, 0 <= i <= Len()
+func (l *List) Insert(i int, value string) {
+	values := l.Range(0, l.size-1)
+	values = append(values[:i], append([]string{value}, values[i:]...)...)
+	l.reset(values)
+}
+
+// Trim keeps the elements from @start to @end (inclusive), both within bounds
+func (l *List) Trim(start, end int) {
+	l.reset(l.Range(start, end))
+}
+
+// Remove removes up to @count occurrences of the value, scanning from the head,
+// or from the tail if @count is negative. A zero @count removes all of them.
+func (l *List) Remove(value string, count int) int {
+	values := l.Range(0, l.size-1)
+	fromTail := count < 0
+	if fromTail {
+		if count == math.MinInt {
+			// its opposite doesn't fit, there can't be as many occurrences anyway
+			count = 0
+		} else {
+			count = -count
+		}
+		reverse(values)
+	}
+	kept := values[:0]
+	removed := 0
+	for _, v := range values {
+		if v == value && (count == 0 || removed < count) {
+			removed++
+			continue
+		}
+		kept = append(kept, v)
+	}
+	if fromTail {
+		reverse(kept)
+	}
+	l.reset(kept)
+	return removed
+}
+
+// grow doubles the capacity of the ring buffer when it is full
+func (l *List) grow() {
+	if l.size < len(l.buf) {
+		return
+	}
+	buf := make([]string, 2*len(l.buf))
+	for i := 0; i < l.size; i++ {
+		buf[i] = l.Index(i)
+	}
+	l.buf, l.head = buf, 0
+}
+
+func (l *List) reset(values []string) {
+	l.buf = make([]string, max(4, len(values)))
+	copy(l.buf, values)
+	l.head, l.size = 0, len(values)
+}
+
+func reverse(values []string) {
+	for i, j := 0, len(values)-1; i < j; i, j = i+1, j-1 {
+		values[i], values[j] = values[j], values[i]
+	}
+}
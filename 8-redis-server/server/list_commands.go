@@ -0,0 +1,455 @@
+package server
+
+import (
+	"strings"
+)
+
+func init() {
+	registerCommands(
+		&commandSpec{name: "lpush", arity: -3, handler: pushCommand, flags: flagWrite | flagDenyOOM, keys: firstKey, categories: catList},
+		&commandSpec{name: "rpush", arity: -3, handler: pushCommand, flags: flagWrite | flagDenyOOM, keys: firstKey, categories: catList},
+		&commandSpec{name: "lpop", arity: -2, handler: popCommand, flags: flagWrite, keys: firstKey, categories: catList},
+		&commandSpec{name: "rpop", arity: -2, handler: popCommand, flags: flagWrite, keys: firstKey, categories: catList},
+		&commandSpec{name: "lrange", arity: 4, handler: lrangeCommand, keys: firstKey, categories: catList},
+		&commandSpec{name: "llen", arity: 2, handler: llenCommand, keys: firstKey, categories: catList},
+		&commandSpec{name: "lindex", arity: 3, handler: lindexCommand, keys: firstKey, categories: catList},
+		&commandSpec{name: "lset", arity: 4, handler: lsetCommand, flags: flagWrite | flagDenyOOM, keys: firstKey, categories: catList},
+		&commandSpec{name: "lrem", arity: 4, handler: lremCommand, flags: flagWrite, keys: firstKey, categories: catList},
+		&commandSpec{name: "ltrim", arity: 4, handler: ltrimCommand, flags: flagWrite, keys: firstKey, categories: catList},
+		&commandSpec{name: "linsert", arity: 5, handler: linsertCommand, flags: flagWrite | flagDenyOOM, keys: firstKey, categories: catList},
+		&commandSpec{name: "lmove", arity: 5, handler: lmoveCommand, flags: flagWrite | flagDenyOOM, keys: keySpec{0, 1, 1}, categories: catList},
+		&commandSpec{name: "blpop", arity: -3, handler: bpopCommand, flags: flagWrite, keys: keySpec{0, -2, 1}, categories: catList | catBlocking},
+		&commandSpec{name: "brpop", arity: -3, handler: bpopCommand, flags: flagWrite, keys: keySpec{0, -2, 1}, categories: catList | catBlocking},
+		&commandSpec{name: "blmove", arity: 6, handler: blmoveCommand, flags: flagWrite | flagDenyOOM, keys: keySpec{0, 1, 1}, categories: catList | catBlocking},
+	)
+}
+
+/* ---------------- helpers ---------------- */
+
+// lookupList returns the list stored at key
+func lookupList(re *RedisExecutorImpl, key string) (*List, bool, error) {
+	item, found, err := lookupTyped(re, key, ListType)
+	if !found || err != nil {
+		return nil, found, err
+	}
+	return item.ListValue(), true, nil
+}
+
+// pushList pushes the values to the head (left) or the tail of the list stored at key,
+// creating it if needed. Clients blocked on the key are signalled.
+// It returns the length of the list after the push.
+func pushList(re *RedisExecutorImpl, key string, list *List, left bool, values ...string) int {
+	if list == nil {
+		list = NewList()
+		_ = re.Set(key, &CacheItem{Key: key, Value: list, Type: ListType})
+	}
+	for _, value := range values {
+		if left {
+			list.PushFront(value)
+		} else {
+			list.PushBack(value)
+		}
+	}
+	re.signalModifiedKey(key)
+	re.signalKeyAsReady(key)
+	if left {
+		re.notifyKeyspaceEvent(notifyList, "lpush", key)
+	} else {
+		re.notifyKeyspaceEvent(notifyList, "rpush", key)
+	}
+	return list.Len()
+}
+
+// popEvent is the keyspace event of a pop from the head (left) or the tail of a list
+func popEvent(left bool) string {
+	if left {
+		return "lpop"
+	}
+	return "rpop"
+}
+
+// popList pops an element from the head (left) or the tail of a non-empty list
+func popList(list *List, left bool) string {
+	if left {
+		value, _ := list.PopFront()
+		return value
+	}
+	value, _ := list.PopBack()
+	return value
+}
+
+// deleteIfEmpty removes the key once its list has no more elements
+func deleteIfEmpty(re *RedisExecutorImpl, key string, list *List) {
+	if list.Len() == 0 {
+		_ = re.Remove(key)
+		re.notifyKeyspaceEvent(notifyGeneric, "del", key)
+	}
+}
+
+// listMove pops an element from the source list and pushes it to the destination
+func listMove(re *RedisExecutorImpl, src, dst string, srcList *List, fromLeft, toLeft bool) (string, error) {
+	dstList, _, err := lookupList(re, dst)
+	if err != nil {
+		return "", err
+	}
+	value := popList(srcList, fromLeft)
+	if src == dst {
+		dstList = srcList
+	}
+	pushList(re, dst, dstList, toLeft, value)
+	re.notifyKeyspaceEvent(notifyList, popEvent(fromLeft), src)
+	deleteIfEmpty(re, src, srcList)
+	re.signalModifiedKey(src)
+	return value, nil
+}
+
+// parseDirection parses the LEFT | RIGHT argument of the move commands
+func parseDirection(arg string) (left bool, ok bool) {
+	switch strings.ToLower(arg) {
+	case "left":
+		return true, true
+	case "right":
+		return false, true
+	}
+	return false, false
+}
+
+// normalizeRange converts the inclusive range [start, end], where negative offsets
+// are relative to the end, to indexes within a list of @length elements.
+// ok is false if the range is empty.
+func normalizeRange(start, end int64, length int) (int, int, bool) {
+	n := int64(length)
+	if start < 0 {
+		start += n
+	}
+	if end < 0 {
+		end += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > end || start >= n {
+		return 0, 0, false
+	}
+	if end >= n {
+		end = n - 1
+	}
+	return int(start), int(end), true
+}
+
+/* ---------------- commands ---------------- */
+
+// LPUSH key element [element ...], also RPUSH
+func pushCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	key := cmd.Arg(0)
+	list, _, err := lookupList(re, key)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	length := pushList(re, key, list, cmd.Name() == "lpush", cmd.Args()[1:]...)
+	return IntegerResponse(int64(length))
+}
+
+// LPOP key [count], also RPOP
+func popCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	if len(cmd.Args()) > 2 {
+		return ErrorResponse(WrongArgsError(cmd.Name()))
+	}
+	withCount := len(cmd.Args()) == 2
+	var count int64
+	if withCount {
+		var ok bool
+		if count, ok = parseInt(cmd.Arg(1)); !ok || count < 0 {
+			return ErrorResponse(ErrNotPositive)
+		}
+	}
+
+	key := cmd.Arg(0)
+	list, found, err := lookupList(re, key)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		if withCount {
+			return NullArrayResponse()
+		}
+		return NilResponse()
+	}
+
+	left := cmd.Name() == "lpop"
+	if !withCount {
+		value := popList(list, left)
+		re.notifyKeyspaceEvent(notifyList, popEvent(left), key)
+		deleteIfEmpty(re, key, list)
+		re.signalModifiedKey(key)
+		return BulkResponse(value)
+	}
+	var values []string
+	for count > 0 && list.Len() > 0 {
+		values = append(values, popList(list, left))
+		count--
+	}
+	re.notifyKeyspaceEvent(notifyList, popEvent(left), key)
+	deleteIfEmpty(re, key, list)
+	re.signalModifiedKey(key)
+	return BulkArrayResponse(values)
+}
+
+// LRANGE key start stop
+func lrangeCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	start, ok1 := parseInt(cmd.Arg(1))
+	end, ok2 := parseInt(cmd.Arg(2))
+	if !ok1 || !ok2 {
+		return ErrorResponse(ErrNotInteger)
+	}
+	list, found, err := lookupList(re, cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return ArrayResponse()
+	}
+	from, to, ok := normalizeRange(start, end, list.Len())
+	if !ok {
+		return ArrayResponse()
+	}
+	return BulkArrayResponse(list.Range(from, to))
+}
+
+// LLEN key
+func llenCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	list, found, err := lookupList(re, cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return IntegerResponse(0)
+	}
+	return IntegerResponse(int64(list.Len()))
+}
+
+// LINDEX key index
+func lindexCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	index, ok := parseInt(cmd.Arg(1))
+	if !ok {
+		return ErrorResponse(ErrNotInteger)
+	}
+	list, found, err := lookupList(re, cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return NilResponse()
+	}
+	if index < 0 {
+		index += int64(list.Len())
+	}
+	if index < 0 || index >= int64(list.Len()) {
+		return NilResponse()
+	}
+	return BulkResponse(list.Index(int(index)))
+}
+
+// LSET key index element
+func lsetCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	index, ok := parseInt(cmd.Arg(1))
+	if !ok {
+		return ErrorResponse(ErrNotInteger)
+	}
+	list, found, err := lookupList(re, cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return ErrorResponse(ErrNoSuchKey)
+	}
+	if index < 0 {
+		index += int64(list.Len())
+	}
+	if index < 0 || index >= int64(list.Len()) {
+		return ErrorResponse(ErrIndexRange)
+	}
+	list.Set(int(index), cmd.Arg(2))
+	re.signalModifiedKey(cmd.Arg(0))
+	re.notifyKeyspaceEvent(notifyList, "lset", cmd.Arg(0))
+	return OKResponse()
+}
+
+// LREM key count element
+func lremCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	count, ok := parseInt(cmd.Arg(1))
+	if !ok {
+		return ErrorResponse(ErrNotInteger)
+	}
+	key := cmd.Arg(0)
+	list, found, err := lookupList(re, key)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return IntegerResponse(0)
+	}
+	removed := list.Remove(cmd.Arg(2), int(count))
+	if removed > 0 {
+		re.notifyKeyspaceEvent(notifyList, "lrem", key)
+		deleteIfEmpty(re, key, list)
+		re.signalModifiedKey(key)
+	}
+	return IntegerResponse(int64(removed))
+}
+
+// LTRIM key start stop
+func ltrimCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	start, ok1 := parseInt(cmd.Arg(1))
+	end, ok2 := parseInt(cmd.Arg(2))
+	if !ok1 || !ok2 {
+		return ErrorResponse(ErrNotInteger)
+	}
+	key := cmd.Arg(0)
+	list, found, err := lookupList(re, key)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return OKResponse()
+	}
+	from, to, ok := normalizeRange(start, end, list.Len())
+	if !ok {
+		// an empty range trims every element
+		list.reset(nil)
+	} else {
+		list.Trim(from, to)
+	}
+	re.notifyKeyspaceEvent(notifyList, "ltrim", key)
+	deleteIfEmpty(re, key, list)
+	re.signalModifiedKey(key)
+	return OKResponse()
+}
+
+// LINSERT key BEFORE | AFTER pivot element
+func linsertCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	var after bool
+	switch strings.ToLower(cmd.Arg(1)) {
+	case "before":
+	case "after":
+		after = true
+	default:
+		return ErrorResponse(ErrSyntax)
+	}
+	list, found, err := lookupList(re, cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return IntegerResponse(0)
+	}
+	for i := 0; i < list.Len(); i++ {
+		if list.Index(i) != cmd.Arg(2) {
+			continue
+		}
+		if after {
+			i++
+		}
+		list.Insert(i, cmd.Arg(3))
+		re.signalModifiedKey(cmd.Arg(0))
+		re.notifyKeyspaceEvent(notifyList, "linsert", cmd.Arg(0))
+		return IntegerResponse(int64(list.Len()))
+	}
+	return IntegerResponse(-1)
+}
+
+// LMOVE source destination LEFT | RIGHT LEFT | RIGHT
+func lmoveCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	fromLeft, ok1 := parseDirection(cmd.Arg(2))
+	toLeft, ok2 := parseDirection(cmd.Arg(3))
+	if !ok1 || !ok2 {
+		return ErrorResponse(ErrSyntax)
+	}
+	src, dst := cmd.Arg(0), cmd.Arg(1)
+	srcList, found, err := lookupList(re, src)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return NilResponse()
+	}
+	value, err := listMove(re, src, dst, srcList, fromLeft, toLeft)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	return BulkResponse(value)
+}
+
+// BLPOP key [key ...] timeout, also BRPOP. The first non-empty list is popped,
+// otherwise the client blocks until an element is pushed to one of the keys.
+func bpopCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	keys := args[:len(args)-1]
+	timeout, err := parseTimeout(args[len(args)-1])
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	left := cmd.Name() == "blpop"
+
+	serve := func(key string) (*RedisResponse, bool) {
+		list, found, err := lookupList(re, key)
+		if !found || err != nil {
+			return nil, false
+		}
+		value := popList(list, left)
+		re.notifyKeyspaceEvent(notifyList, popEvent(left), key)
+		deleteIfEmpty(re, key, list)
+		re.signalModifiedKey(key)
+		re.rewriteCommand(strings.TrimPrefix(cmd.Name(), "b"), key)
+		return BulkArrayResponse([]string{key, value}), true
+	}
+
+	for _, key := range keys {
+		if _, _, err := lookupList(re, key); err != nil {
+			return ErrorResponse(err)
+		}
+		if response, served := serve(key); served {
+			return response
+		}
+	}
+	bc := &blockedClient{keys: keys, timeout: timeout, serve: serve}
+	re.block(bc)
+	return BlockedResponse(bc)
+}
+
+// BLMOVE source destination LEFT | RIGHT LEFT | RIGHT timeout
+func blmoveCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	fromLeft, ok1 := parseDirection(cmd.Arg(2))
+	toLeft, ok2 := parseDirection(cmd.Arg(3))
+	if !ok1 || !ok2 {
+		return ErrorResponse(ErrSyntax)
+	}
+	timeout, err := parseTimeout(cmd.Arg(4))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	src, dst := cmd.Arg(0), cmd.Arg(1)
+
+	serve := func(key string) (*RedisResponse, bool) {
+		srcList, found, err := lookupList(re, src)
+		if !found || err != nil {
+			return nil, false
+		}
+		value, err := listMove(re, src, dst, srcList, fromLeft, toLeft)
+		if err != nil {
+			return ErrorResponse(err), true
+		}
+		re.rewriteCommand("lmove", src, dst, cmd.Arg(2), cmd.Arg(3))
+		return BulkResponse(value), true
+	}
+
+	if _, _, err := lookupList(re, src); err != nil {
+		return ErrorResponse(err)
+	}
+	if response, served := serve(src); served {
+		return response
+	}
+	bc := &blockedClient{keys: []string{src}, timeout: timeout, serve: serve}
+	re.block(bc)
+	return BlockedResponse(bc)
+}
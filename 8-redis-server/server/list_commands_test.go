@@ -0,0 +1,124 @@
+package server
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestListCommands(t *testing.T) {
+	runSteps(t, newTestExecutor(), []testStep{
+		{cmd("RPUSH", "l", "a", "b", "c"), ":3\r\n"},
+		{cmd("LPUSH", "l", "z"), ":4\r\n"},
+		{cmd("LRANGE", "l", "0", "-1"), "*4\r\n$1\r\nz\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{cmd("LRANGE", "l", "-2", "100"), "*2\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{cmd("LINDEX", "l", "-1"), "$1\r\nc\r\n"},
+		{cmd("LINDEX", "l", "9"), "$-1\r\n"},
+		{cmd("LSET", "l", "9", "x"), "-ERR index out of range\r\n"},
+		{cmd("LSET", "missing", "0", "x"), "-ERR no such key\r\n"},
+		{cmd("LINSERT", "l", "AFTER", "a", "a"), ":5\r\n"},
+		{cmd("LINSERT", "l", "BEFORE", "nope", "x"), ":-1\r\n"},
+		{cmd("LREM", "l", "-1", "a"), ":1\r\n"},
+		{cmd("RPUSH", "r", "x", "y", "x", "x"), ":4\r\n"},
+		{cmd("LREM", "r", "-9223372036854775808", "x"), ":3\r\n"},
+		{cmd("LRANGE", "r", "0", "-1"), "*1\r\n$1\r\ny\r\n"},
+		{cmd("LTRIM", "l", "1", "-1"), "+OK\r\n"},
+		{cmd("LRANGE", "l", "0", "-1"), "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{cmd("LMOVE", "l", "m", "LEFT", "RIGHT"), "$1\r\na\r\n"},
+		{cmd("LPOP", "l", "5"), "*2\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{cmd("EXISTS", "l"), ":0\r\n"},
+		{cmd("LPOP", "l", "1"), "*-1\r\n"},
+		{cmd("RPOP", "m"), "$1\r\na\r\n"},
+		{cmd("LLEN", "m"), ":0\r\n"},
+		{cmd("SET", "s", "v"), "+OK\r\n"},
+		{cmd("LPUSH", "s", "v"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{cmd("GET", "s"), "$1\r\nv\r\n"},
+		{cmd("RPUSH", "t", "v"), ":1\r\n"},
+		{cmd("GET", "t"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{cmd("TYPE", "t"), "+list\r\n"},
+		{cmd("BLPOP", "t", "-1"), "-ERR timeout is negative\r\n"},
+		{cmd("BLPOP", "empty", "0.01"), "*-1\r\n"},
+	})
+}
+
+func TestBlockingPopFIFO(t *testing.T) {
+	re := newTestExecutor()
+	replies := make([]string, 3)
+	wg := &sync.WaitGroup{}
+	for i := range replies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			replies[i] = execute(re, "BRPOP", "q", "other", "5")
+		}(i)
+		// wait for the client to block before starting the next one
+		for {
+			re.mu.Lock()
+			blocked := len(re.blocked[dbKey{0, "q"}])
+			re.mu.Unlock()
+			if blocked == i+1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	runSteps(t, re, []testStep{{cmd("RPUSH", "q", "a", "b", "c"), ":3\r\n"}})
+	wg.Wait()
+	want := []string{"c", "b", "a"}
+	for i, reply := range replies {
+		if expected := "*2\r\n$1\r\nq\r\n$1\r\n" + want[i] + "\r\n"; reply != expected {
+			t.Errorf("client %d: got %q, want %q", i, reply, expected)
+		}
+	}
+	if len(re.blocked) != 0 {
+		t.Errorf("clients still blocked: %v", re.blocked)
+	}
+}
+
+func TestBlockedClientDisconnects(t *testing.T) {
+	for _, argv := range [][]string{
+		{"BLPOP", "q", "0"},
+		{"BLMOVE", "q", "dst", "LEFT", "LEFT", "0"},
+		{"XREAD", "BLOCK", "0", "STREAMS", "s", "$"},
+	} {
+		re := newTestExecutor()
+		conn, _ := startCountingServer(t, re)
+		if _, err := conn.Write(appendAOFCommand(nil, argv)); err != nil {
+			t.Fatal(err)
+		}
+		// the client stops waiting once its connection is closed
+		waitBlockedClients(t, re, 1)
+		_ = conn.Close()
+		waitBlockedClients(t, re, 0)
+		runSteps(t, re, []testStep{
+			{cmd("LPUSH", "q", "a"), ":1\r\n"},
+			{cmd("LLEN", "q"), ":1\r\n"},
+			{cmd("EXISTS", "dst"), ":0\r\n"},
+		})
+	}
+}
+
+// waitBlockedClients waits until @n clients are blocked
+func waitBlockedClients(t *testing.T, re *RedisExecutorImpl, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		blocked, queued := 0, 0
+		re.exclusive(func() {
+			for _, c := range re.clients.byID {
+				if c.blocked != nil {
+					blocked++
+				}
+			}
+			queued = len(re.blocked)
+		})
+		if blocked == n && (n > 0 || queued == 0) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d clients blocked, want %d", blocked, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// metricsPath is the path of the metrics endpoint
+const metricsPath = "/metrics"
+
+// writeMetrics writes the metrics of the server in the Prometheus text format
+func (re *RedisExecutorImpl) writeMetrics(w io.Writer) {
+	re.exclusive(func() { re.writeMetricsTo(w) })
+}
+
+// writeMetricsTo writes the metrics, the datastore being held
+func (re *RedisExecutorImpl) writeMetricsTo(w io.Writer) {
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	metric("redis_uptime_seconds", "gauge", "Seconds since the server started.")
+	fmt.Fprintf(w, "redis_uptime_seconds %d\n", int64(time.Since(re.stats.startTime).Seconds()))
+	metric("redis_connected_clients", "gauge", "Number of connected clients.")
+	fmt.Fprintf(w, "redis_connected_clients %d\n", re.connectedClients())
+	metric("redis_connections_received_total", "counter", "Connections accepted by the server.")
+	fmt.Fprintf(w, "redis_connections_received_total %d\n", re.stats.totalConnections)
+	metric("redis_memory_used_bytes", "gauge", "Estimated memory used by the keys.")
+	fmt.Fprintf(w, "redis_memory_used_bytes %d\n", re.trackPeakMemory())
+	metric("redis_expired_keys_total", "counter", "Keys removed once expired.")
+	fmt.Fprintf(w, "redis_expired_keys_total %d\n", re.expiredKeysCount())
+	metric("redis_evicted_keys_total", "counter", "Keys evicted because of maxmemory.")
+	fmt.Fprintf(w, "redis_evicted_keys_total %d\n", re.evict.evictedKeys)
+
+	metric("redis_db_keys", "gauge", "Number of keys of each database.")
+	for id, db := range re.dbs {
+		if db.Len() > 0 {
+			fmt.Fprintf(w, "redis_db_keys{db=\"db%d\"} %d\n", id, db.Len())
+		}
+	}
+	metric("redis_db_keys_expiring", "gauge", "Number of keys having a time to live of each database.")
+	for id, db := range re.dbs {
+		if db.Len() > 0 {
+			fmt.Fprintf(w, "redis_db_keys_expiring{db=\"db%d\"} %d\n", id, db.Expires())
+		}
+	}
+
+	names := make([]string, 0, len(re.stats.commands))
+	for name := range re.stats.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metric("redis_commands_total", "counter", "Calls of each command.")
+	for _, name := range names {
+		fmt.Fprintf(w, "redis_commands_total{cmd=%q} %d\n", name, re.stats.commands[name].calls)
+	}
+	metric("redis_commands_failed_total", "counter", "Calls of each command which replied an error.")
+	for _, name := range names {
+		fmt.Fprintf(w, "redis_commands_failed_total{cmd=%q} %d\n", name, re.stats.commands[name].failed)
+	}
+	metric("redis_commands_rejected_total", "counter", "Calls of each command rejected before running.")
+	for _, name := range names {
+		fmt.Fprintf(w, "redis_commands_rejected_total{cmd=%q} %d\n", name, re.stats.commands[name].rejected)
+	}
+	metric("redis_command_duration_seconds", "histogram", "Duration of the calls of each command.")
+	for _, name := range names {
+		stats := re.stats.commands[name]
+		for i, bound := range latencyBuckets {
+			le := strconv.FormatFloat(bound, 'g', -1, 64)
+			fmt.Fprintf(w, "redis_command_duration_seconds_bucket{cmd=%q,le=%q} %d\n", name, le, stats.buckets[i])
+		}
+		fmt.Fprintf(w, "redis_command_duration_seconds_bucket{cmd=%q,le=\"+Inf\"} %d\n", name, stats.calls)
+		fmt.Fprintf(w, "redis_command_duration_seconds_sum{cmd=%q} %g\n", name, float64(stats.usec)/1e6)
+		fmt.Fprintf(w, "redis_command_duration_seconds_count{cmd=%q} %d\n", name, stats.calls)
+	}
+}
+
+// metricsHandler serves the metrics of the executor on the metrics endpoint. They are
+// written to a buffer first, so that a slow scraper doesn't hold the executor lock.
+func metricsHandler(re *RedisExecutorImpl) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(metricsPath, func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		re.writeMetrics(&buf)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = w.Write(buf.Bytes())
+	})
+	return mux
+}
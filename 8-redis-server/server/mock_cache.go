@@ -2,19 +2,58 @@ package server
 
 import (
 	"sync"
+	"time"
 )
 
 type RedisCacher interface {
-	Get(key string) (CacheItem, bool)
-	Set(key string, value CacheItem) error
+	Get(key string) (*CacheItem, bool)
+	Set(key string, value *CacheItem) error
 	Contains(key string) (bool, error)
 	Remove(key string) error
+	SetExpire(key string, expireAt int64) bool
+	ExpireCycle(samples int) (checked, expired int)
+	Len() int
+	// Expires is the number of items having a time to live
+	Expires() int
+	// UsedMemory is the estimated memory used by the items, see itemSize
+	UsedMemory() int64
+	// UpdateSize measures the item stored at key again, once its value was modified
+	UpdateSize(key string)
+	// Sample calls fn for up to @count random items, only those with a time to live
+	// if @volatile is set, until it returns false
+	Sample(count int, volatile bool, fn func(key string, item *CacheItem) bool)
+	// Scan calls fn for the items of the bucket at cursor and returns the next cursor,
+	// see Dict.Scan. The expired items not removed yet are included.
+	Scan(cursor uint64, fn func(key string, item *CacheItem)) uint64
+	// RandomKey returns a random key which didn't expire, false if there is none
+	RandomKey() (string, bool)
+	// ExpiredKeys is the number of keys removed once they expired
+	ExpiredKeys() int64
+	// ForEach calls fn for every item, including the expired ones not removed yet,
+	// until it returns false. The datastore must not be modified by fn.
+	ForEach(fn func(key string, item *CacheItem) bool)
+	// OnExpired sets the function called with the key of each item removed once it
+	// expired, right after its removal
+	OnExpired(fn func(key string))
 }
 
+// RedisCacherImpl is an in-memory datastore, a database of the server. The items are
+// kept in a Dict so that they can be scanned while it grows. Items with a time to live
+// are tracked in @volatile so that expired items can be sampled and evicted.
+// The estimated size of every item is accounted in @used.
+// It is not safe for concurrent use, the RedisExecutor serializes the access.
 type RedisCacherImpl struct {
-	store map[string]CacheItem
+	store    *Dict[*CacheItem]
+	volatile map[string]*CacheItem
+	used     int64
+	expired  int64
+	// onExpired is called for each expired item removed, see OnExpired
+	onExpired func(key string)
 }
 
+// randomKeyTries is the number of expired keys RandomKey removes before giving up
+const randomKeyTries = 100
+
 var cacherInstance RedisCacher
 var once = &sync.Once{}
 
@@ -25,26 +64,164 @@ func GetCacherInstance() RedisCacher {
 	return cacherInstance
 }
 
-func (r *RedisCacherImpl) Get(key string) (CacheItem, bool) {
-	item, found := r.store[key]
+// Get returns the item stored at key. Expired items are removed lazily.
+func (r *RedisCacherImpl) Get(key string) (*CacheItem, bool) {
+	item, found := r.store.Get(key)
+	if found && item.IsExpired(time.Now()) {
+		r.deleteExpired(key)
+		return nil, false
+	}
 	return item, found
 }
 
-func (r *RedisCacherImpl) Set(key string, value CacheItem) error {
-	r.store[key] = value
+func (r *RedisCacherImpl) Set(key string, value *CacheItem) error {
+	if old, found := r.store.Get(key); found {
+		r.used -= old.size
+	}
+	if value.accessed == 0 {
+		// a new item, its access is tracked from now on
+		value.accessed = nowMs()
+		value.lfu, value.lfuDecremented = lfuInitValue, value.accessed/60000
+	}
+	value.size = itemSize(key, value, sizeSamples)
+	r.used += value.size
+	r.store.Set(key, value)
+	if value.ExpireAt > 0 {
+		r.volatile[key] = value
+	} else {
+		delete(r.volatile, key)
+	}
 	return nil
 }
 
 func (r *RedisCacherImpl) Contains(key string) (bool, error) {
-	return false, nil
+	_, found := r.Get(key)
+	return found, nil
 }
 
 func (r *RedisCacherImpl) Remove(key string) error {
+	r.delete(key)
 	return nil
 }
 
+// SetExpire updates the expiry time (unix milliseconds) of the item stored at key,
+// 0 removes the time to live. It returns false if the key doesn't exist.
+func (r *RedisCacherImpl) SetExpire(key string, expireAt int64) bool {
+	item, found := r.Get(key)
+	if !found {
+		return false
+	}
+	item.ExpireAt = expireAt
+	return r.Set(key, item) == nil
+}
+
+// ExpireCycle samples up to @samples items having a time to live and removes
+// the expired ones. It relies on the randomized map iteration order of Go.
+func (r *RedisCacherImpl) ExpireCycle(samples int) (checked, expired int) {
+	now := time.Now()
+	for key, item := range r.volatile {
+		if checked == samples {
+			break
+		}
+		checked++
+		if item.IsExpired(now) {
+			r.deleteExpired(key)
+			expired++
+		}
+	}
+	return checked, expired
+}
+
+func (r *RedisCacherImpl) Len() int {
+	return r.store.Len()
+}
+
+func (r *RedisCacherImpl) Expires() int {
+	return len(r.volatile)
+}
+
+func (r *RedisCacherImpl) UsedMemory() int64 {
+	return r.used
+}
+
+func (r *RedisCacherImpl) UpdateSize(key string) {
+	if item, found := r.store.Get(key); found {
+		size := itemSize(key, item, sizeSamples)
+		r.used += size - item.size
+		item.size = size
+	}
+}
+
+// Sample picks random entries of the dict, or relies on the randomized map iteration
+// order of Go for the volatile items, like ExpireCycle. An item may be sampled twice.
+func (r *RedisCacherImpl) Sample(count int, volatile bool, fn func(key string, item *CacheItem) bool) {
+	if !volatile {
+		for ; count > 0; count-- {
+			key, item, found := r.store.RandomEntry()
+			if !found || !fn(key, item) {
+				return
+			}
+		}
+		return
+	}
+	for key, item := range r.volatile {
+		if count == 0 || !fn(key, item) {
+			return
+		}
+		count--
+	}
+}
+
+func (r *RedisCacherImpl) Scan(cursor uint64, fn func(key string, item *CacheItem)) uint64 {
+	return r.store.Scan(cursor, fn)
+}
+
+// RandomKey removes the expired keys it picks. Like Redis, it gives up after a
+// number of tries if most keys expired, returning an expired key.
+func (r *RedisCacherImpl) RandomKey() (string, bool) {
+	now := time.Now()
+	for tries := 1; ; tries++ {
+		key, item, found := r.store.RandomEntry()
+		if !found {
+			return "", false
+		}
+		if !item.IsExpired(now) || tries == randomKeyTries {
+			return key, true
+		}
+		r.deleteExpired(key)
+	}
+}
+
+func (r *RedisCacherImpl) ExpiredKeys() int64 {
+	return r.expired
+}
+
+func (r *RedisCacherImpl) ForEach(fn func(key string, item *CacheItem) bool) {
+	r.store.ForEach(fn)
+}
+
+func (r *RedisCacherImpl) OnExpired(fn func(key string)) {
+	r.onExpired = fn
+}
+
+func (r *RedisCacherImpl) deleteExpired(key string) {
+	r.delete(key)
+	r.expired++
+	if r.onExpired != nil {
+		r.onExpired(key)
+	}
+}
+
+func (r *RedisCacherImpl) delete(key string) {
+	if item, found := r.store.Delete(key); found {
+		r.used -= item.size
+	}
+	delete(r.volatile, key)
+}
+
 func NewRedisCacherImpl() *RedisCacherImpl {
 	return &RedisCacherImpl{
-		store: make(map[string]CacheItem),
+		store:    NewDict[*CacheItem](),
+		volatile: make(map[string]*CacheItem),
 	}
 }
@@ -0,0 +1,88 @@
+package server
+
+// multiState is the transaction of a client, the commands it sent between MULTI and EXEC
+type multiState struct {
+	commands []*queuedCommand
+	aborted  bool // a command failed to be queued, EXEC discards the transaction
+}
+
+type queuedCommand struct {
+	spec *commandSpec
+	cmd  *Cmd
+}
+
+/* ---------------- watched keys ---------------- */
+
+// watch adds the key of the selected database to the keys watched by the client
+func (re *RedisExecutorImpl) watch(c *Client, name string) {
+	key := dbKey{re.db, name}
+	if _, found := c.watched[key]; found {
+		return
+	}
+	if re.watchedKeys == nil {
+		re.watchedKeys = make(map[dbKey]map[*Client]struct{})
+	}
+	if re.watchedKeys[key] == nil {
+		re.watchedKeys[key] = make(map[*Client]struct{})
+	}
+	re.watchedKeys[key][c] = struct{}{}
+
+	// besides being modified, a watched key is considered changed once it expires
+	var expireAt int64
+	if item, found := re.Get(name); found {
+		expireAt = item.ExpireAt
+	}
+	c.watched[key] = expireAt
+}
+
+// unwatchAll forgets the keys watched by the client and clears its dirty flag
+func (re *RedisExecutorImpl) unwatchAll(c *Client) {
+	for key := range c.watched {
+		delete(re.watchedKeys[key], c)
+		if len(re.watchedKeys[key]) == 0 {
+			delete(re.watchedKeys, key)
+		}
+	}
+	clear(c.watched)
+	c.dirtyCAS = false
+}
+
+// watchedKeyExpired reports whether a key watched by the client expired since,
+// the expired keys are deleted lazily so they are not signalled as modified
+func watchedKeyExpired(c *Client) bool {
+	now := nowMs()
+	for _, expireAt := range c.watched {
+		if expireAt != 0 && expireAt <= now {
+			return true
+		}
+	}
+	return false
+}
+
+/* ---------------- transaction ---------------- */
+
+// queueMultiCommand queues a command of a client in a transaction
+func queueMultiCommand(c *Client, spec *commandSpec, cmd *Cmd) *RedisResponse {
+	c.multi.commands = append(c.multi.commands, &queuedCommand{spec: spec, cmd: cmd})
+	return SimpleStringResponse("QUEUED")
+}
+
+// execMulti runs the queued commands of a transaction, the executor lock being held
+// for the whole transaction. Blocking commands don't block within a transaction,
+// they reply as if they timed out. The EXEC closing the propagated transaction is
+// propagated by the caller, as EXEC modified the datastore.
+func (re *RedisExecutorImpl) execMulti(multi *multiState) *RedisResponse {
+	re.inExec, re.execPropagated = true, false
+	defer func() { re.inExec = false }()
+
+	replies := make([]*RedisResponse, 0, len(multi.commands))
+	for _, queued := range multi.commands {
+		response := re.call(queued.spec, queued.cmd)
+		if response.blocked != nil {
+			re.unblock(response.blocked)
+			response = NullArrayResponse()
+		}
+		replies = append(replies, response)
+	}
+	return ArrayResponse(replies...)
+}
@@ -0,0 +1,89 @@
+package server
+
+import "errors"
+
+var (
+	ErrMultiNested      = errors.New("ERR MULTI calls can not be nested")
+	ErrExecWithoutMulti = errors.New("ERR EXEC without MULTI")
+	ErrDiscardNoMulti   = errors.New("ERR DISCARD without MULTI")
+	ErrWatchInMulti     = errors.New("ERR WATCH inside MULTI is not allowed")
+	ErrExecAbort        = errors.New("EXECABORT Transaction discarded because of previous errors.")
+	ErrNotInMulti       = errors.New("ERR Command not allowed inside a transaction")
+)
+
+func init() {
+	registerCommands(
+		&commandSpec{name: "multi", arity: 1, handler: multiCommand, flags: flagNoQueue, categories: catTransaction},
+		&commandSpec{name: "exec", arity: 1, handler: execCommand, flags: flagNoQueue | flagSkipSlowlog, categories: catTransaction},
+		&commandSpec{name: "discard", arity: 1, handler: discardCommand, flags: flagNoQueue, categories: catTransaction},
+		&commandSpec{name: "watch", arity: -2, handler: watchCommand, flags: flagNoQueue, keys: allKeys, categories: catTransaction},
+		&commandSpec{name: "unwatch", arity: 1, handler: unwatchCommand, categories: catTransaction},
+	)
+}
+
+// MULTI
+func multiCommand(_ *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	client := cmd.Client()
+	if client == nil {
+		return ErrorResponse(ErrNoClient)
+	}
+	if client.multi != nil {
+		return ErrorResponse(ErrMultiNested)
+	}
+	client.multi = &multiState{}
+	return OKResponse()
+}
+
+// EXEC runs the queued commands atomically. It replies with the null array,
+// running nothing, if one of the watched keys was modified.
+func execCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	client := cmd.Client()
+	if client == nil || client.multi == nil {
+		return ErrorResponse(ErrExecWithoutMulti)
+	}
+	multi := client.multi
+	client.multi = nil
+	defer re.unwatchAll(client)
+
+	if multi.aborted {
+		return ErrorResponse(ErrExecAbort)
+	}
+	if client.dirtyCAS || watchedKeyExpired(client) {
+		return NullArrayResponse()
+	}
+	return re.execMulti(multi)
+}
+
+// DISCARD
+func discardCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	client := cmd.Client()
+	if client == nil || client.multi == nil {
+		return ErrorResponse(ErrDiscardNoMulti)
+	}
+	client.multi = nil
+	re.unwatchAll(client)
+	return OKResponse()
+}
+
+// WATCH key [key ...]
+func watchCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	client := cmd.Client()
+	if client == nil {
+		return ErrorResponse(ErrNoClient)
+	}
+	if client.multi != nil {
+		return ErrorResponse(ErrWatchInMulti)
+	}
+	for _, key := range cmd.Args() {
+		re.watch(client, key)
+	}
+	return OKResponse()
+}
+
+// UNWATCH
+func unwatchCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	if client := cmd.Client(); client != nil {
+		re.unwatchAll(client)
+	}
+	return OKResponse()
+}
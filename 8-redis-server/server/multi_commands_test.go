@@ -0,0 +1,102 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMultiExec(t *testing.T) {
+	re := newTestExecutor()
+	c := NewClient(&bufferConn{})
+	runClientSteps(t, re, c, []testStep{
+		{cmd("EXEC"), "-ERR EXEC without MULTI\r\n"},
+		{cmd("MULTI"), "+OK\r\n"},
+		{cmd("MULTI"), "-ERR MULTI calls can not be nested\r\n"},
+		{cmd("SET", "k", "1"), "+QUEUED\r\n"},
+		{cmd("INCR", "k"), "+QUEUED\r\n"},
+		{cmd("LPUSH", "k", "x"), "+QUEUED\r\n"},
+		{cmd("BLPOP", "q", "0"), "+QUEUED\r\n"},
+		{cmd("WATCH", "k"), "-ERR WATCH inside MULTI is not allowed\r\n"},
+		{cmd("EXEC"), "*4\r\n+OK\r\n:2\r\n-WRONGTYPE Operation against a key holding the wrong kind of value\r\n*-1\r\n"},
+		{cmd("MULTI"), "+OK\r\n"},
+		{cmd("SET", "k", "3"), "+QUEUED\r\n"},
+		{cmd("DISCARD"), "+OK\r\n"},
+		{cmd("GET", "k"), "$1\r\n2\r\n"},
+		{cmd("DISCARD"), "-ERR DISCARD without MULTI\r\n"},
+	})
+	if len(re.blocked) != 0 {
+		t.Errorf("clients still blocked: %v", re.blocked)
+	}
+}
+
+func TestMultiQueueingErrors(t *testing.T) {
+	re := newTestExecutor()
+	c := NewClient(&bufferConn{})
+	runClientSteps(t, re, c, []testStep{
+		{cmd("MULTI"), "+OK\r\n"},
+		{cmd("SET", "k", "1"), "+QUEUED\r\n"},
+		{cmd("GET"), "-ERR wrong number of arguments for 'get' command\r\n"},
+		{cmd("SUBSCRIBE", "ch"), "-ERR Command not allowed inside a transaction\r\n"},
+		{cmd("EXEC"), "-EXECABORT Transaction discarded because of previous errors.\r\n"},
+		{cmd("EXISTS", "k"), ":0\r\n"},
+	})
+}
+
+func TestWatch(t *testing.T) {
+	re := newTestExecutor()
+	c := NewClient(&bufferConn{})
+	other := NewClient(&bufferConn{})
+	execute(re, "SET", "k", "1")
+
+	// a key modified by another client fails the transaction
+	runClientSteps(t, re, c, []testStep{
+		{cmd("WATCH", "k", "missing"), "+OK\r\n"},
+		{cmd("MULTI"), "+OK\r\n"},
+		{cmd("INCR", "k"), "+QUEUED\r\n"},
+	})
+	runClientSteps(t, re, other, []testStep{{cmd("SADD", "missing", "a"), ":1\r\n"}})
+	runClientSteps(t, re, c, []testStep{
+		{cmd("EXEC"), "*-1\r\n"},
+		{cmd("GET", "k"), "$1\r\n1\r\n"},
+	})
+
+	// EXEC unwatches the keys, commands which don't modify the key are ignored
+	runClientSteps(t, re, other, []testStep{{cmd("SET", "k", "2"), "+OK\r\n"}})
+	runClientSteps(t, re, c, []testStep{
+		{cmd("WATCH", "k"), "+OK\r\n"},
+	})
+	runClientSteps(t, re, other, []testStep{
+		{cmd("GET", "k"), "$1\r\n2\r\n"},
+		{cmd("SET", "k", "3", "NX"), "$-1\r\n"},
+	})
+	runClientSteps(t, re, c, []testStep{
+		{cmd("MULTI"), "+OK\r\n"},
+		{cmd("INCR", "k"), "+QUEUED\r\n"},
+		{cmd("EXEC"), "*1\r\n:3\r\n"},
+	})
+
+	// UNWATCH
+	runClientSteps(t, re, c, []testStep{
+		{cmd("WATCH", "k"), "+OK\r\n"},
+		{cmd("UNWATCH"), "+OK\r\n"},
+		{cmd("DEL", "k"), ":1\r\n"},
+		{cmd("MULTI"), "+OK\r\n"},
+		{cmd("EXEC"), "*0\r\n"},
+	})
+	if len(re.watchedKeys) != 0 {
+		t.Errorf("keys still watched: %v", re.watchedKeys)
+	}
+}
+
+func TestWatchExpiredKey(t *testing.T) {
+	re := newTestExecutor()
+	c := NewClient(&bufferConn{})
+	execute(re, "SET", "k", "1", "PX", "10")
+	runClientSteps(t, re, c, []testStep{
+		{cmd("WATCH", "k"), "+OK\r\n"},
+		{cmd("MULTI"), "+OK\r\n"},
+		{cmd("GET", "k"), "+QUEUED\r\n"},
+	})
+	time.Sleep(20 * time.Millisecond)
+	runClientSteps(t, re, c, []testStep{{cmd("EXEC"), "*-1\r\n"}})
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// keyspaceEvents are the classes of the keyspace events, enabled by the characters of
+// the notify-keyspace-events option like in Redis
+type keyspaceEvents int
+
+const (
+	notifyKeyspace keyspaceEvents = 1 << iota // K, published to __keyspace@<db>__:<key>
+	notifyKeyevent                            // E, published to __keyevent@<db>__:<event>
+	notifyGeneric                             // g, DEL, EXPIRE, RENAME...
+	notifyString                              // $
+	notifyList                                // l
+	notifySet                                 // s
+	notifyHash                                // h
+	notifyZSet                                // z
+	notifyExpired                             // x, a key removed once it expired
+	notifyEvicted                             // e, a key evicted by maxmemory
+	notifyStream                              // t
+
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash | notifyZSet |
+		notifyExpired | notifyEvicted | notifyStream // A
+)
+
+var ErrKeyspaceEvents = errors.New("Invalid event class character. Use 'Ag$lshzxeKEt'.")
+
+// keyspaceEventClasses are the characters of the classes, in the order CONFIG GET
+// reports them
+var keyspaceEventClasses = []struct {
+	char  byte
+	class keyspaceEvents
+}{
+	{'g', notifyGeneric},
+	{'$', notifyString},
+	{'l', notifyList},
+	{'s', notifySet},
+	{'h', notifyHash},
+	{'z', notifyZSet},
+	{'x', notifyExpired},
+	{'e', notifyEvicted},
+	{'t', notifyStream},
+	{'K', notifyKeyspace},
+	{'E', notifyKeyevent},
+}
+
+// parseKeyspaceEvents parses the classes of the notify-keyspace-events option
+func parseKeyspaceEvents(s string) (keyspaceEvents, error) {
+	var flags keyspaceEvents
+	for i := 0; i < len(s); i++ {
+		if s[i] == 'A' {
+			flags |= notifyAll
+			continue
+		}
+		found := false
+		for _, c := range keyspaceEventClasses {
+			if c.char == s[i] {
+				flags |= c.class
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, ErrKeyspaceEvents
+		}
+	}
+	return flags, nil
+}
+
+// formatKeyspaceEvents returns the canonical form of the classes, A standing for all
+// the classes of events
+func formatKeyspaceEvents(flags keyspaceEvents) string {
+	var b strings.Builder
+	if flags&notifyAll == notifyAll {
+		b.WriteByte('A')
+		flags &^= notifyAll
+	}
+	for _, c := range keyspaceEventClasses {
+		if flags&c.class != 0 {
+			b.WriteByte(c.char)
+		}
+	}
+	return b.String()
+}
+
+// notifyKeyspaceEvent publishes the event of the class which happened to the key of
+// the selected database, if the class is enabled
+func (re *RedisExecutorImpl) notifyKeyspaceEvent(class keyspaceEvents, event, key string) {
+	re.notifyKeyspaceEventInDB(re.db, class, event, key)
+}
+
+// notifyKeyspaceEventInDB publishes the event to the subscribers of the key (K) and of
+// the event (E) in the database at index @id
+func (re *RedisExecutorImpl) notifyKeyspaceEventInDB(id int, class keyspaceEvents, event, key string) {
+	flags := re.config.notifyEvents
+	if flags&class == 0 {
+		return
+	}
+	db := strconv.Itoa(id)
+	if flags&notifyKeyspace != 0 {
+		re.pubsub.publish("__keyspace@"+db+"__:"+key, event)
+	}
+	if flags&notifyKeyevent != 0 {
+		re.pubsub.publish("__keyevent@"+db+"__:"+event, key)
+	}
+}
+
+// watchExpiry publishes the expired event of the keys of the database removed once
+// they expired, in whichever database it is swapped to
+func (re *RedisExecutorImpl) watchExpiry(db RedisCacher) RedisCacher {
+	db.OnExpired(func(key string) {
+		for id := range re.dbs {
+			if re.dbs[id] == db {
+				re.notifyKeyspaceEventInDB(id, notifyExpired, "expired", key)
+				return
+			}
+		}
+	})
+	return db
+}
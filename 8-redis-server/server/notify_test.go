@@ -0,0 +1,147 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+// keyspaceEvent is an event expected on the channels of the keyspace notifications
+type keyspaceEvent struct {
+	db, event, key string
+}
+
+// keyspaceMessages returns the messages received by a subscriber of the pattern
+// __key*__:* for the events, each one published to the key then to the event
+func keyspaceMessages(events []keyspaceEvent) string {
+	var b strings.Builder
+	for _, e := range events {
+		b.WriteString(BulkArrayResponse([]string{"pmessage", "__key*__:*", "__keyspace@" + e.db + "__:" + e.key, e.event}).Serialize())
+		b.WriteString(BulkArrayResponse([]string{"pmessage", "__key*__:*", "__keyevent@" + e.db + "__:" + e.event, e.key}).Serialize())
+	}
+	return b.String()
+}
+
+// subscribeKeyspace returns a client subscribed to all the keyspace notifications
+func subscribeKeyspace(re *RedisExecutorImpl) (*bufferConn, *Client) {
+	conn := &bufferConn{}
+	c := NewClient(conn)
+	executeAs(re, c, "PSUBSCRIBE", "__key*__:*")
+	return conn, c
+}
+
+// checkKeyspaceMessages checks the messages received by the subscriber
+func checkKeyspaceMessages(t *testing.T, conn *bufferConn, c *Client, events []keyspaceEvent) {
+	t.Helper()
+	want := "*3\r\n$10\r\npsubscribe\r\n$10\r\n__key*__:*\r\n:1\r\n" + keyspaceMessages(events)
+	if got := conn.output(t, c); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestConfigNotifyKeyspaceEvents(t *testing.T) {
+	re := newTestExecutor()
+	runSteps(t, re, []testStep{
+		{cmd("CONFIG", "GET", "notify-keyspace-events"), "*2\r\n$22\r\nnotify-keyspace-events\r\n$0\r\n\r\n"},
+		{cmd("CONFIG", "SET", "notify-keyspace-events", "KEA"), "+OK\r\n"},
+		{cmd("CONFIG", "GET", "notify-keyspace-events"), "*2\r\n$22\r\nnotify-keyspace-events\r\n$3\r\nAKE\r\n"},
+	})
+	// the classes are parsed once, when the option is set
+	if want := notifyAll | notifyKeyspace | notifyKeyevent; re.config.notifyEvents != want {
+		t.Errorf("got %b, want %b", re.config.notifyEvents, want)
+	}
+	runSteps(t, re, []testStep{
+		{cmd("CONFIG", "SET", "notify-keyspace-events", "Elg"), "+OK\r\n"},
+		{cmd("CONFIG", "GET", "notify-keyspace-events"), "*2\r\n$22\r\nnotify-keyspace-events\r\n$3\r\nglE\r\n"},
+		{cmd("CONFIG", "SET", "notify-keyspace-events", "Kq"),
+			"-ERR CONFIG SET failed (possibly related to argument 'notify-keyspace-events') - " + ErrKeyspaceEvents.Error() + "\r\n"},
+		{cmd("CONFIG", "SET", "notify-keyspace-events", ""), "+OK\r\n"},
+		{cmd("CONFIG", "GET", "notify-keyspace-events"), "*2\r\n$22\r\nnotify-keyspace-events\r\n$0\r\n\r\n"},
+	})
+	if re.config.notifyEvents != 0 {
+		t.Errorf("got %b, want none", re.config.notifyEvents)
+	}
+}
+
+func TestKeyspaceNotifications(t *testing.T) {
+	re := newTestExecutor()
+	conn, c := subscribeKeyspace(re)
+	// nothing is published until the option is set
+	execute(re, "SET", "quiet", "v")
+	runSteps(t, re, []testStep{
+		{cmd("CONFIG", "SET", "notify-keyspace-events", "KEA"), "+OK\r\n"},
+		{cmd("SET", "k", "v", "EX", "100"), "+OK\r\n"},
+		{cmd("RENAME", "k", "k2"), "+OK\r\n"},
+		{cmd("PERSIST", "k2"), ":1\r\n"},
+		{cmd("RPUSH", "list", "a"), ":1\r\n"},
+		{cmd("LMOVE", "list", "other", "LEFT", "RIGHT"), "$1\r\na\r\n"},
+		{cmd("HSET", "h", "f", "v"), ":1\r\n"},
+		{cmd("HDEL", "h", "f"), ":1\r\n"},
+		{cmd("ZADD", "z", "1", "m"), ":1\r\n"},
+		{cmd("SADD", "s", "m"), ":1\r\n"},
+		{cmd("SINTERSTORE", "s2", "s", "missing"), ":0\r\n"},
+		{cmd("MOVE", "k2", "1"), ":1\r\n"},
+		{cmd("DEL", "quiet", "missing"), ":1\r\n"},
+	})
+	checkKeyspaceMessages(t, conn, c, []keyspaceEvent{
+		{"0", "set", "k"},
+		{"0", "expire", "k"},
+		{"0", "rename_from", "k"},
+		{"0", "rename_to", "k2"},
+		{"0", "persist", "k2"},
+		{"0", "rpush", "list"},
+		{"0", "rpush", "other"},
+		{"0", "lpop", "list"},
+		{"0", "del", "list"},
+		{"0", "hset", "h"},
+		{"0", "hdel", "h"},
+		{"0", "del", "h"},
+		{"0", "zadd", "z"},
+		{"0", "sadd", "s"},
+		{"0", "move_from", "k2"},
+		{"1", "move_to", "k2"},
+		{"0", "del", "quiet"},
+	})
+}
+
+func TestKeyspaceNotificationClasses(t *testing.T) {
+	re := newTestExecutor()
+	conn, c := subscribeKeyspace(re)
+	runSteps(t, re, []testStep{
+		{cmd("CONFIG", "SET", "notify-keyspace-events", "El"), "+OK\r\n"},
+		{cmd("SET", "k", "v"), "+OK\r\n"},
+		{cmd("LPUSH", "list", "a"), ":1\r\n"},
+		{cmd("DEL", "list"), ":1\r\n"},
+	})
+	want := "*3\r\n$10\r\npsubscribe\r\n$10\r\n__key*__:*\r\n:1\r\n" +
+		BulkArrayResponse([]string{"pmessage", "__key*__:*", "__keyevent@0__:lpush", "list"}).Serialize()
+	if got := conn.output(t, c); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestKeyspaceNotificationsExpiredEvicted(t *testing.T) {
+	re := newTestExecutor()
+	re.config.MaxmemoryPolicy = PolicyAllKeysLFU
+	re.config.MaxmemorySamples = 64
+	conn, c := subscribeKeyspace(re)
+	for key, counter := range map[string]uint8{"a": 10, "b": 1, "c": 20} {
+		execute(re, "SET", key, "v")
+		item, _ := re.Get(key)
+		item.lfu = counter
+	}
+	runSteps(t, re, []testStep{
+		{cmd("CONFIG", "SET", "notify-keyspace-events", "KExe"), "+OK\r\n"},
+		{cmd("SET", "gone", "v", "PX", "100000"), "+OK\r\n"},
+	})
+	item, _ := re.Get("gone")
+	item.ExpireAt = nowMs() - 1
+	runSteps(t, re, []testStep{
+		{cmd("GET", "gone"), "$-1\r\n"},
+		{cmd("CONFIG", "SET", "maxmemory", "150"), "+OK\r\n"},
+		{cmd("EXISTS", "a", "c"), ":2\r\n"},
+	})
+	checkKeyspaceMessages(t, conn, c, []keyspaceEvent{
+		{"0", "expired", "gone"},
+		{"0", "evicted", "b"},
+	})
+}
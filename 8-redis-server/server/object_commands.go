@@ -0,0 +1,67 @@
+package server
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrNoLFUPolicy = errors.New("ERR An LFU maxmemory policy is not selected, access frequency not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+	ErrLFUPolicy   = errors.New("ERR An LFU maxmemory policy is selected, idle time not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+)
+
+func init() {
+	registerCommands(
+		&commandSpec{name: "object", arity: -2, handler: objectCommand, keys: keySpec{1, 1, 1}, categories: catKeyspace},
+		&commandSpec{name: "memory", arity: -2, handler: memoryCommand, keys: keySpec{1, 1, 1}},
+	)
+}
+
+// OBJECT FREQ key | IDLETIME key, the access tracking of the key. Inspecting a key
+// is not an access.
+func objectCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	subcommand := strings.ToLower(args[0])
+	if (subcommand != "freq" && subcommand != "idletime") || len(args) != 2 {
+		return ErrorResponse(UnknownSubcommandError(cmd))
+	}
+	item, found := re.Get(args[1])
+	if !found {
+		return NilResponse()
+	}
+	if subcommand == "freq" {
+		if !re.lfuPolicy() {
+			return ErrorResponse(ErrNoLFUPolicy)
+		}
+		return IntegerResponse(int64(re.lfuDecay(item, nowMs())))
+	}
+	if re.lfuPolicy() {
+		return ErrorResponse(ErrLFUPolicy)
+	}
+	return IntegerResponse((nowMs() - item.accessed) / 1000)
+}
+
+// MEMORY USAGE key [SAMPLES count], the estimated memory used by the key in bytes.
+// The size of a collection is extrapolated from @count elements, 5 by default and
+// all of them with 0.
+func memoryCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	if strings.ToLower(args[0]) != "usage" || len(args) < 2 {
+		return ErrorResponse(UnknownSubcommandError(cmd))
+	}
+	samples := int64(sizeSamples)
+	switch {
+	case len(args) == 4 && strings.EqualFold(args[2], "samples"):
+		var ok bool
+		if samples, ok = parseInt(args[3]); !ok || samples < 0 {
+			return ErrorResponse(ErrNotInteger)
+		}
+	case len(args) != 2:
+		return ErrorResponse(ErrSyntax)
+	}
+	item, found := re.Get(args[1])
+	if !found {
+		return NilResponse()
+	}
+	return IntegerResponse(itemSize(args[1], item, int(samples)))
+}
@@ -0,0 +1,103 @@
+package server
+
+// pubSub holds the Pub/Sub subscriptions of the clients, it is guarded by the executor lock.
+// Each client also keeps its own subscriptions, to reply with its subscription count
+// and to unsubscribe from everything when it disconnects.
+type pubSub struct {
+	channels map[string]map[*Client]struct{} // subscribers of each channel
+	patterns map[string]map[*Client]struct{} // subscribers of each pattern
+}
+
+func newPubSub() *pubSub {
+	return &pubSub{
+		channels: make(map[string]map[*Client]struct{}),
+		patterns: make(map[string]map[*Client]struct{}),
+	}
+}
+
+// subscriptionResponse is the message confirming a (un)subscription, e.g. subscribe,
+// with the number of subscriptions of the client. A nil channel is sent by UNSUBSCRIBE
+// and PUNSUBSCRIBE when the client wasn't subscribed to anything.
+func subscriptionResponse(kind string, channel *string, count int) *RedisResponse {
+	name := NilResponse()
+	if channel != nil {
+		name = BulkResponse(*channel)
+	}
+	return ArrayResponse(BulkResponse(kind), name, IntegerResponse(int64(count)))
+}
+
+// subscribe subscribes the client to the channel, it returns false if it already was
+func (ps *pubSub) subscribe(c *Client, channel string) bool {
+	return ps.add(ps.channels, c.channels, c, channel)
+}
+
+func (ps *pubSub) unsubscribe(c *Client, channel string) bool {
+	return ps.remove(ps.channels, c.channels, c, channel)
+}
+
+// psubscribe subscribes the client to the channels matching the pattern
+func (ps *pubSub) psubscribe(c *Client, pattern string) bool {
+	return ps.add(ps.patterns, c.patterns, c, pattern)
+}
+
+func (ps *pubSub) punsubscribe(c *Client, pattern string) bool {
+	return ps.remove(ps.patterns, c.patterns, c, pattern)
+}
+
+func (ps *pubSub) add(subscribers map[string]map[*Client]struct{}, own map[string]struct{}, c *Client, name string) bool {
+	if _, found := own[name]; found {
+		return false
+	}
+	own[name] = struct{}{}
+	if subscribers[name] == nil {
+		subscribers[name] = make(map[*Client]struct{})
+	}
+	subscribers[name][c] = struct{}{}
+	return true
+}
+
+func (ps *pubSub) remove(subscribers map[string]map[*Client]struct{}, own map[string]struct{}, c *Client, name string) bool {
+	if _, found := own[name]; !found {
+		return false
+	}
+	delete(own, name)
+	delete(subscribers[name], c)
+	if len(subscribers[name]) == 0 {
+		delete(subscribers, name)
+	}
+	return true
+}
+
+// unsubscribeAll removes all the subscriptions of a client which disconnected
+func (ps *pubSub) unsubscribeAll(c *Client) {
+	for channel := range c.channels {
+		ps.unsubscribe(c, channel)
+	}
+	for pattern := range c.patterns {
+		ps.punsubscribe(c, pattern)
+	}
+}
+
+// publish sends the message to the subscribers of the channel and of the patterns
+// matching it, it returns the number of clients that received the message
+func (ps *pubSub) publish(channel, message string) int {
+	receivers := 0
+	if len(ps.channels[channel]) > 0 {
+		push := BulkArrayResponse([]string{"message", channel, message})
+		for c := range ps.channels[channel] {
+			c.Push(push)
+			receivers++
+		}
+	}
+	for pattern, subscribers := range ps.patterns {
+		if !globMatch(pattern, channel, false) {
+			continue
+		}
+		push := BulkArrayResponse([]string{"pmessage", pattern, channel, message})
+		for c := range subscribers {
+			c.Push(push)
+			receivers++
+		}
+	}
+	return receivers
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var ErrNoClient = errors.New("ERR command requires a client connection")
+
+func init() {
+	registerCommands(
+		&commandSpec{name: "subscribe", arity: -2, handler: subscribeCommand, flags: flagPubSub | flagNoMulti, categories: catPubSub},
+		&commandSpec{name: "unsubscribe", arity: -1, handler: unsubscribeCommand, flags: flagPubSub | flagNoMulti, categories: catPubSub},
+		&commandSpec{name: "psubscribe", arity: -2, handler: subscribeCommand, flags: flagPubSub | flagNoMulti, categories: catPubSub},
+		&commandSpec{name: "punsubscribe", arity: -1, handler: unsubscribeCommand, flags: flagPubSub | flagNoMulti, categories: catPubSub},
+		&commandSpec{name: "publish", arity: 3, handler: publishCommand, categories: catPubSub},
+		&commandSpec{name: "pubsub", arity: -2, handler: pubsubCommand, categories: catPubSub},
+	)
+}
+
+// SubscriberModeError is the error of a command run by a client in subscriber mode
+// which is not allowed in this context
+func SubscriberModeError(cmd *Cmd) error {
+	return fmt.Errorf("ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", cmd.Name())
+}
+
+// SUBSCRIBE channel [channel ...], also PSUBSCRIBE pattern [pattern ...]
+// A confirmation is sent for each channel, with the number of subscriptions of the client.
+func subscribeCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	client := cmd.Client()
+	if client == nil {
+		return ErrorResponse(ErrNoClient)
+	}
+	subscribe := re.pubsub.subscribe
+	if cmd.Name() == "psubscribe" {
+		subscribe = re.pubsub.psubscribe
+	}
+	for _, channel := range cmd.Args() {
+		subscribe(client, channel)
+		client.Write(subscriptionResponse(cmd.Name(), &channel, client.subscriptions()))
+	}
+	return NoReplyResponse()
+}
+
+// UNSUBSCRIBE [channel ...], also PUNSUBSCRIBE [pattern ...]
+// Without arguments the client is unsubscribed from all the channels (patterns).
+func unsubscribeCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	client := cmd.Client()
+	if client == nil {
+		return ErrorResponse(ErrNoClient)
+	}
+	unsubscribe, subscribed := re.pubsub.unsubscribe, client.channels
+	if cmd.Name() == "punsubscribe" {
+		unsubscribe, subscribed = re.pubsub.punsubscribe, client.patterns
+	}
+
+	channels := cmd.Args()
+	if len(channels) == 0 {
+		if len(subscribed) == 0 {
+			client.Write(subscriptionResponse(cmd.Name(), nil, client.subscriptions()))
+			return NoReplyResponse()
+		}
+		for channel := range subscribed {
+			channels = append(channels, channel)
+		}
+		sort.Strings(channels)
+	}
+	for _, channel := range channels {
+		unsubscribe(client, channel)
+		client.Write(subscriptionResponse(cmd.Name(), &channel, client.subscriptions()))
+	}
+	return NoReplyResponse()
+}
+
+// PUBLISH channel message
+func publishCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	return IntegerResponse(int64(re.pubsub.publish(cmd.Arg(0), cmd.Arg(1))))
+}
+
+// PUBSUB CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT
+func pubsubCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	switch subcommand := strings.ToLower(args[0]); {
+	case subcommand == "channels" && len(args) <= 2:
+		var channels []string
+		for channel := range re.pubsub.channels {
+			if len(args) == 1 || globMatch(args[1], channel, false) {
+				channels = append(channels, channel)
+			}
+		}
+		sort.Strings(channels)
+		return BulkArrayResponse(channels)
+	case subcommand == "numsub":
+		items := make([]*RedisResponse, 0, 2*(len(args)-1))
+		for _, channel := range args[1:] {
+			items = append(items, BulkResponse(channel), IntegerResponse(int64(len(re.pubsub.channels[channel]))))
+		}
+		return ArrayResponse(items...)
+	case subcommand == "numpat" && len(args) == 1:
+		return IntegerResponse(int64(len(re.pubsub.patterns)))
+	}
+	return ErrorResponse(UnknownSubcommandError(cmd))
+}
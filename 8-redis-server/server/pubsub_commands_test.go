@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// bufferConn records what is written to a connection
+type bufferConn struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (bc *bufferConn) Write(p []byte) (int, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.buf.Write(p)
+}
+
+func (bc *bufferConn) Close() error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.closed = true
+	return nil
+}
+
+func (bc *bufferConn) isClosed() bool {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.closed
+}
+
+// output flushes the client and returns everything written to its connection
+func (bc *bufferConn) output(t *testing.T, c *Client) string {
+	t.Helper()
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bc.buf.String()
+}
+
+// executeAs runs a command sent by the client and writes its reply, like the server does
+func executeAs(re *RedisExecutorImpl, c *Client, args ...string) {
+	c.Write(re.Execute(CreateCommandFromTokens(bulkTokens(args)).SetClient(c)))
+}
+
+func TestPubSub(t *testing.T) {
+	re := newTestExecutor()
+	connA, connB := &bufferConn{}, &bufferConn{}
+	a, b := NewClient(connA), NewClient(connB)
+
+	executeAs(re, a, "SUBSCRIBE", "news", "sport")
+	executeAs(re, b, "PSUBSCRIBE", "n*")
+	runSteps(t, re, []testStep{
+		{cmd("PUBLISH", "news", "hi"), ":2\r\n"},
+		{cmd("PUBLISH", "nothing", "x"), ":1\r\n"},
+		{cmd("PUBLISH", "weather", "x"), ":0\r\n"},
+		{cmd("PUBSUB", "CHANNELS"), "*2\r\n$4\r\nnews\r\n$5\r\nsport\r\n"},
+		{cmd("PUBSUB", "CHANNELS", "s*"), "*1\r\n$5\r\nsport\r\n"},
+		{cmd("PUBSUB", "NUMSUB", "news", "weather"), "*4\r\n$4\r\nnews\r\n:1\r\n$7\r\nweather\r\n:0\r\n"},
+		{cmd("PUBSUB", "NUMPAT"), ":1\r\n"},
+		{cmd("PUBSUB", "FOO"), "-ERR unknown subcommand 'FOO'. Try PUBSUB HELP.\r\n"},
+	})
+	executeAs(re, a, "GET", "k")
+	executeAs(re, a, "PING")
+	executeAs(re, a, "UNSUBSCRIBE")
+	executeAs(re, a, "UNSUBSCRIBE")
+	executeAs(re, a, "PING")
+
+	want := strings.Join([]string{
+		"*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n",
+		"*3\r\n$9\r\nsubscribe\r\n$5\r\nsport\r\n:2\r\n",
+		"*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$2\r\nhi\r\n",
+		"-ERR Can't execute 'get': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context\r\n",
+		"*2\r\n$4\r\npong\r\n$0\r\n\r\n",
+		"*3\r\n$11\r\nunsubscribe\r\n$4\r\nnews\r\n:1\r\n",
+		"*3\r\n$11\r\nunsubscribe\r\n$5\r\nsport\r\n:0\r\n",
+		"*3\r\n$11\r\nunsubscribe\r\n$-1\r\n:0\r\n",
+		"+PONG\r\n",
+	}, "")
+	if got := connA.output(t, a); got != want {
+		t.Errorf("subscriber: got %q, want %q", got, want)
+	}
+
+	re.FreeClient(b)
+	want = "*3\r\n$10\r\npsubscribe\r\n$2\r\nn*\r\n:1\r\n" +
+		"*4\r\n$8\r\npmessage\r\n$2\r\nn*\r\n$4\r\nnews\r\n$2\r\nhi\r\n" +
+		"*4\r\n$8\r\npmessage\r\n$2\r\nn*\r\n$7\r\nnothing\r\n$1\r\nx\r\n"
+	if got := connB.output(t, b); got != want {
+		t.Errorf("pattern subscriber: got %q, want %q", got, want)
+	}
+	runSteps(t, re, []testStep{
+		{cmd("PUBSUB", "NUMPAT"), ":0\r\n"},
+		{cmd("PUBLISH", "news", "bye"), ":0\r\n"},
+	})
+}
@@ -0,0 +1,663 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// The snapshot format follows the layout of the Redis RDB file:
+//
+//	"REDIS" <4 digits version>
+//	[0xFA <aux field name> <aux field value>]                              (for each aux field)
+//	0xFE <database index>                                                  (for each database)
+//	[0xFC <expire time, unix ms, 8 bytes LE>] <value type> <key> <value>   (for each key)
+//	0xFF <CRC64 of all the preceding bytes, 8 bytes LE>
+//
+// The keys of a database follow its SELECTDB opcode (0xFE), empty databases are
+// skipped. The aux fields hold information about the snapshot, e.g. the database
+// selected by the replication stream when it is sent to a replica (repl-stream-db).
+// Lengths are encoded on 1, 2, 5 or 9 bytes depending on their value. Strings are
+// length-prefixed, those holding a small integer are stored as the integer.
+const (
+	rdbMagic   = "REDIS"
+	rdbVersion = "0002" // version 1 had a single database and no aux fields
+
+	rdbTypeString = 0
+	rdbTypeList   = 1
+	rdbTypeSet    = 2
+	rdbTypeHash   = 4
+	rdbTypeZSet   = 5 // scores are binary doubles, like RDB_TYPE_ZSET_2
+	rdbTypeStream = 15
+
+	rdbOpcodeAux      = 0xFA
+	rdbOpcodeExpireMs = 0xFC
+	rdbOpcodeSelectDB = 0xFE
+	rdbOpcodeEOF      = 0xFF
+
+	// the 2 most significant bits of the first byte of a length
+	rdbLen6    = 0
+	rdbLen14   = 1
+	rdbEncoded = 3 // the string is encoded, the 6 remaining bits give the encoding
+	rdbLen32   = 0x80
+	rdbLen64   = 0x81
+
+	rdbEncInt8  = 0
+	rdbEncInt16 = 1
+	rdbEncInt32 = 2
+)
+
+// crcTable is the table of the CRC-64/Jones checksum used by Redis
+var crcTable = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+var (
+	ErrSnapshotFormat   = errors.New("bad snapshot format")
+	ErrSnapshotChecksum = errors.New("snapshot checksum mismatch")
+)
+
+func crc64Update(crc uint64, p []byte) uint64 {
+	for _, b := range p {
+		crc = crcTable[byte(crc)^b] ^ (crc >> 8)
+	}
+	return crc
+}
+
+/* ---------------- encoding ---------------- */
+
+// rdbEncoder writes a snapshot, keeping its checksum. The first error is kept
+// and the following writes are skipped.
+type rdbEncoder struct {
+	w   *bufio.Writer
+	crc uint64
+	buf [9]byte
+	err error
+}
+
+func (e *rdbEncoder) write(p []byte) {
+	if e.err != nil {
+		return
+	}
+	e.crc = crc64Update(e.crc, p)
+	_, e.err = e.w.Write(p)
+}
+
+func (e *rdbEncoder) writeByte(b byte) {
+	e.buf[0] = b
+	e.write(e.buf[:1])
+}
+
+func (e *rdbEncoder) writeLen(n uint64) {
+	switch {
+	case n < 1<<6:
+		e.writeByte(byte(n))
+	case n < 1<<14:
+		e.buf[0], e.buf[1] = rdbLen14<<6|byte(n>>8), byte(n)
+		e.write(e.buf[:2])
+	case n <= math.MaxUint32:
+		e.buf[0] = rdbLen32
+		binary.BigEndian.PutUint32(e.buf[1:], uint32(n))
+		e.write(e.buf[:5])
+	default:
+		e.buf[0] = rdbLen64
+		binary.BigEndian.PutUint64(e.buf[1:], n)
+		e.write(e.buf[:9])
+	}
+}
+
+func (e *rdbEncoder) writeString(s string) {
+	// strings holding an integer that round trips are stored as the integer
+	if value, ok := parseInt(s); ok && value >= math.MinInt32 && value <= math.MaxInt32 {
+		switch {
+		case value >= math.MinInt8 && value <= math.MaxInt8:
+			e.buf[0], e.buf[1] = rdbEncoded<<6|rdbEncInt8, byte(value)
+			e.write(e.buf[:2])
+		case value >= math.MinInt16 && value <= math.MaxInt16:
+			e.buf[0] = rdbEncoded<<6 | rdbEncInt16
+			binary.LittleEndian.PutUint16(e.buf[1:], uint16(value))
+			e.write(e.buf[:3])
+		default:
+			e.buf[0] = rdbEncoded<<6 | rdbEncInt32
+			binary.LittleEndian.PutUint32(e.buf[1:], uint32(value))
+			e.write(e.buf[:5])
+		}
+		return
+	}
+	e.writeLen(uint64(len(s)))
+	if e.err == nil {
+		e.crc = crc64Update(e.crc, []byte(s))
+		_, e.err = e.w.WriteString(s)
+	}
+}
+
+func (e *rdbEncoder) writeUint64(value uint64) {
+	binary.LittleEndian.PutUint64(e.buf[:8], value)
+	e.write(e.buf[:8])
+}
+
+func (e *rdbEncoder) writeItem(item *CacheItem) {
+	if item.ExpireAt > 0 {
+		e.writeByte(rdbOpcodeExpireMs)
+		e.writeUint64(uint64(item.ExpireAt))
+	}
+	switch item.Type {
+	case StringType:
+		e.writeByte(rdbTypeString)
+		e.writeString(item.Key)
+		e.writeString(string(item.StringValue()))
+	case ListType:
+		list := item.ListValue()
+		e.writeByte(rdbTypeList)
+		e.writeString(item.Key)
+		e.writeLen(uint64(list.Len()))
+		for i := 0; i < list.Len(); i++ {
+			e.writeString(list.Index(i))
+		}
+	case SetType:
+		set := item.SetValue()
+		e.writeByte(rdbTypeSet)
+		e.writeString(item.Key)
+		e.writeLen(uint64(set.Len()))
+		set.ForEach(func(member string, _ struct{}) bool {
+			e.writeString(member)
+			return true
+		})
+	case HashType:
+		hash := item.HashValue()
+		e.writeByte(rdbTypeHash)
+		e.writeString(item.Key)
+		e.writeLen(uint64(hash.Len()))
+		hash.ForEach(func(field, value string) bool {
+			e.writeString(field)
+			e.writeString(value)
+			return true
+		})
+	case ZSetType:
+		zset := item.ZSetValue()
+		e.writeByte(rdbTypeZSet)
+		e.writeString(item.Key)
+		e.writeLen(uint64(zset.Len()))
+		for node := zset.zsl.header.level[0].forward; node != nil; node = node.level[0].forward {
+			e.writeString(node.member)
+			e.writeUint64(math.Float64bits(node.score))
+		}
+	case StreamType:
+		e.writeByte(rdbTypeStream)
+		e.writeString(item.Key)
+		e.writeStream(item.StreamValue())
+	}
+}
+
+func (e *rdbEncoder) writeStreamID(id streamID) {
+	e.writeLen(id.ms)
+	e.writeLen(id.seq)
+}
+
+// writeStream writes the entries of a stream, its IDs and counters, then its consumer
+// groups: their pending entries and their consumers with the IDs of their entries
+func (e *rdbEncoder) writeStream(stream *Stream) {
+	e.writeLen(uint64(stream.Len()))
+	for _, node := range stream.nodes {
+		for _, entry := range node.entries {
+			e.writeStreamID(entry.id)
+			e.writeLen(uint64(len(entry.fields)))
+			for _, field := range entry.fields {
+				e.writeString(field)
+			}
+		}
+	}
+	e.writeStreamID(stream.lastID)
+	e.writeStreamID(stream.maxDeletedID)
+	e.writeLen(uint64(stream.entriesAdded))
+	groups := stream.sortedGroups()
+	e.writeLen(uint64(len(groups)))
+	for _, cg := range groups {
+		e.writeString(cg.name)
+		e.writeStreamID(cg.lastID)
+		e.writeLen(uint64(len(cg.pel)))
+		for _, nack := range cg.pel {
+			e.writeStreamID(nack.id)
+			e.writeUint64(uint64(nack.deliveryTime))
+			e.writeLen(uint64(nack.deliveryCount))
+		}
+		consumers := cg.sortedConsumers()
+		e.writeLen(uint64(len(consumers)))
+		for _, consumer := range consumers {
+			e.writeString(consumer.name)
+			e.writeUint64(uint64(consumer.seenTime))
+			e.writeUint64(uint64(consumer.activeTime))
+			e.writeLen(uint64(len(consumer.pending)))
+			for _, nack := range cg.pel {
+				if nack.consumer == consumer {
+					e.writeStreamID(nack.id)
+				}
+			}
+		}
+	}
+}
+
+// writeSnapshot writes the items of each database and the aux fields in the snapshot format
+func writeSnapshot(w io.Writer, dbs [][]CacheItem, aux map[string]string) error {
+	e := &rdbEncoder{w: bufio.NewWriter(w)}
+	e.write([]byte(rdbMagic + rdbVersion))
+	names := make([]string, 0, len(aux))
+	for name := range aux {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		e.writeByte(rdbOpcodeAux)
+		e.writeString(name)
+		e.writeString(aux[name])
+	}
+	for db, items := range dbs {
+		if len(items) == 0 {
+			continue
+		}
+		e.writeByte(rdbOpcodeSelectDB)
+		e.writeLen(uint64(db))
+		for i := range items {
+			e.writeItem(&items[i])
+		}
+	}
+	e.writeByte(rdbOpcodeEOF)
+	e.writeUint64(e.crc)
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+// saveSnapshotFile writes the snapshot to a temporary file which replaces the file at
+// @path once it is synced, so that a crash never leaves a partial snapshot behind
+func saveSnapshotFile(path string, dbs [][]CacheItem) (err error) {
+	file, err := os.CreateTemp(filepath.Dir(path), "temp-*.rdb")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+		}
+	}()
+	if err = writeSnapshot(file, dbs, nil); err != nil {
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+/* ---------------- decoding ---------------- */
+
+// rdbDecoder reads a snapshot, keeping its checksum
+type rdbDecoder struct {
+	r   *bufio.Reader
+	crc uint64
+	buf [9]byte
+}
+
+func (d *rdbDecoder) read(p []byte) error {
+	if _, err := io.ReadFull(d.r, p); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("%w: unexpected end of file", ErrSnapshotFormat)
+		}
+		return err
+	}
+	d.crc = crc64Update(d.crc, p)
+	return nil
+}
+
+func (d *rdbDecoder) readByte() (byte, error) {
+	err := d.read(d.buf[:1])
+	return d.buf[0], err
+}
+
+func (d *rdbDecoder) readUint64() (uint64, error) {
+	err := d.read(d.buf[:8])
+	return binary.LittleEndian.Uint64(d.buf[:8]), err
+}
+
+// readLen reads a length, or the encoding of a string if @encoded is set
+func (d *rdbDecoder) readLen() (n uint64, encoded bool, err error) {
+	first, err := d.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch {
+	case first>>6 == rdbLen6:
+		return uint64(first), false, nil
+	case first>>6 == rdbLen14:
+		second, err := d.readByte()
+		return uint64(first&0x3f)<<8 | uint64(second), false, err
+	case first>>6 == rdbEncoded:
+		return uint64(first & 0x3f), true, nil
+	case first == rdbLen32:
+		err = d.read(d.buf[:4])
+		return uint64(binary.BigEndian.Uint32(d.buf[:4])), false, err
+	case first == rdbLen64:
+		err = d.read(d.buf[:8])
+		return binary.BigEndian.Uint64(d.buf[:8]), false, err
+	}
+	return 0, false, fmt.Errorf("%w: unknown length encoding %#x", ErrSnapshotFormat, first)
+}
+
+// readCount reads the number of elements of a collection
+func (d *rdbDecoder) readCount() (int, error) {
+	n, encoded, err := d.readLen()
+	if err == nil && (encoded || n > math.MaxInt32) {
+		err = fmt.Errorf("%w: bad length", ErrSnapshotFormat)
+	}
+	return int(n), err
+}
+
+func (d *rdbDecoder) readString() (string, error) {
+	n, encoded, err := d.readLen()
+	if err != nil {
+		return "", err
+	}
+	if encoded {
+		switch n {
+		case rdbEncInt8:
+			b, err := d.readByte()
+			return strconv.Itoa(int(int8(b))), err
+		case rdbEncInt16:
+			err := d.read(d.buf[:2])
+			return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(d.buf[:2])))), err
+		case rdbEncInt32:
+			err := d.read(d.buf[:4])
+			return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(d.buf[:4])))), err
+		}
+		return "", fmt.Errorf("%w: unknown string encoding %d", ErrSnapshotFormat, n)
+	}
+	if n > maxStringLength {
+		return "", fmt.Errorf("%w: string too long", ErrSnapshotFormat)
+	}
+	buf := make([]byte, n)
+	err = d.read(buf)
+	return string(buf), err
+}
+
+// readUint reads an integer written as a length
+func (d *rdbDecoder) readUint() (uint64, error) {
+	n, encoded, err := d.readLen()
+	if err == nil && encoded {
+		err = fmt.Errorf("%w: bad integer", ErrSnapshotFormat)
+	}
+	return n, err
+}
+
+func (d *rdbDecoder) readStreamID() (id streamID, err error) {
+	if id.ms, err = d.readUint(); err == nil {
+		id.seq, err = d.readUint()
+	}
+	return id, err
+}
+
+// readStream reads a stream written by writeStream
+func (d *rdbDecoder) readStream() (*Stream, error) {
+	stream := NewStream()
+	n, err := d.readCount()
+	for ; n > 0 && err == nil; n-- {
+		var id streamID
+		var fields int
+		if id, err = d.readStreamID(); err == nil {
+			fields, err = d.readCount()
+		}
+		entry := make([]string, fields)
+		for i := range entry {
+			if entry[i], err = d.readString(); err != nil {
+				return nil, err
+			}
+		}
+		if err == nil && !stream.lastID.less(id) {
+			err = fmt.Errorf("%w: stream IDs out of order", ErrSnapshotFormat)
+		}
+		stream.Append(id, entry)
+	}
+	var entriesAdded uint64
+	if err == nil {
+		stream.lastID, err = d.readStreamID()
+	}
+	if err == nil {
+		stream.maxDeletedID, err = d.readStreamID()
+	}
+	if err == nil {
+		entriesAdded, err = d.readUint()
+		stream.entriesAdded = int64(entriesAdded)
+	}
+	var groups int
+	if err == nil {
+		groups, err = d.readCount()
+	}
+	for ; groups > 0 && err == nil; groups-- {
+		err = d.readStreamGroup(stream)
+	}
+	return stream, err
+}
+
+// readStreamGroup reads a consumer group of a stream
+func (d *rdbDecoder) readStreamGroup(stream *Stream) error {
+	name, err := d.readString()
+	if err != nil {
+		return err
+	}
+	lastID, err := d.readStreamID()
+	if err != nil {
+		return err
+	}
+	cg := newStreamCG(name, lastID)
+	stream.groups[name] = cg
+	n, err := d.readCount()
+	pel := make(map[streamID]*streamNACK, n)
+	for ; n > 0 && err == nil; n-- {
+		nack := &streamNACK{}
+		var deliveryTime, deliveryCount uint64
+		if nack.id, err = d.readStreamID(); err == nil {
+			if deliveryTime, err = d.readUint64(); err == nil {
+				deliveryCount, err = d.readUint()
+			}
+		}
+		nack.deliveryTime, nack.deliveryCount = int64(deliveryTime), int64(deliveryCount)
+		pel[nack.id] = nack
+	}
+	var consumers int
+	if err == nil {
+		consumers, err = d.readCount()
+	}
+	for ; consumers > 0 && err == nil; consumers-- {
+		var consumerName string
+		if consumerName, err = d.readString(); err != nil {
+			return err
+		}
+		consumer, _ := cg.consumer(consumerName, true, 0)
+		var seenTime, activeTime uint64
+		if seenTime, err = d.readUint64(); err == nil {
+			activeTime, err = d.readUint64()
+		}
+		consumer.seenTime, consumer.activeTime = int64(seenTime), int64(activeTime)
+		var pending int
+		if err == nil {
+			pending, err = d.readCount()
+		}
+		for ; pending > 0 && err == nil; pending-- {
+			var id streamID
+			if id, err = d.readStreamID(); err != nil {
+				return err
+			}
+			nack := pel[id]
+			if nack == nil || nack.consumer != nil {
+				return fmt.Errorf("%w: bad pending entry %s", ErrSnapshotFormat, id)
+			}
+			nack.consumer = consumer
+			cg.addPending(nack)
+		}
+	}
+	if err == nil && len(cg.pel) != len(pel) {
+		err = fmt.Errorf("%w: pending entry without consumer", ErrSnapshotFormat)
+	}
+	return err
+}
+
+func (d *rdbDecoder) readValue(valueType byte) (interface{}, ValueType, error) {
+	switch valueType {
+	case rdbTypeString:
+		value, err := d.readString()
+		return []byte(value), StringType, err
+	case rdbTypeStream:
+		stream, err := d.readStream()
+		return stream, StreamType, err
+	}
+	n, err := d.readCount()
+	if err != nil {
+		return nil, 0, err
+	}
+	switch valueType {
+	case rdbTypeList:
+		list := NewList()
+		for ; n > 0 && err == nil; n-- {
+			var value string
+			value, err = d.readString()
+			list.PushBack(value)
+		}
+		return list, ListType, err
+	case rdbTypeSet:
+		set := NewSet()
+		for ; n > 0 && err == nil; n-- {
+			var member string
+			member, err = d.readString()
+			set.Set(member, struct{}{})
+		}
+		return set, SetType, err
+	case rdbTypeHash:
+		hash := NewDict[string]()
+		for ; n > 0 && err == nil; n-- {
+			var field, value string
+			if field, err = d.readString(); err == nil {
+				value, err = d.readString()
+			}
+			hash.Set(field, value)
+		}
+		return hash, HashType, err
+	case rdbTypeZSet:
+		zset := NewZSet()
+		for ; n > 0 && err == nil; n-- {
+			var member string
+			var bits uint64
+			if member, err = d.readString(); err == nil {
+				bits, err = d.readUint64()
+			}
+			score := math.Float64frombits(bits)
+			if math.IsNaN(score) {
+				return nil, 0, fmt.Errorf("%w: NaN score", ErrSnapshotFormat)
+			}
+			_, _, _ = zset.Add(score, member, zaddFlags{})
+		}
+		return zset, ZSetType, err
+	}
+	return nil, 0, fmt.Errorf("%w: unknown value type %d", ErrSnapshotFormat, valueType)
+}
+
+// readSnapshot reads the items of a snapshot, calling load for each of them with the
+// index of its database, and returns its aux fields. The checksum is verified once
+// the whole snapshot is read. Reading stops at the first error returned by load.
+func readSnapshot(r io.Reader, load func(db int, item *CacheItem) error) (map[string]string, error) {
+	d := &rdbDecoder{r: bufio.NewReader(r)}
+	header := make([]byte, len(rdbMagic)+len(rdbVersion))
+	if err := d.read(header); err != nil {
+		return nil, err
+	}
+	if string(header[:len(rdbMagic)]) != rdbMagic {
+		return nil, fmt.Errorf("%w: wrong signature", ErrSnapshotFormat)
+	}
+	if version := string(header[len(rdbMagic):]); version < "0001" || version > rdbVersion {
+		return nil, fmt.Errorf("%w: unsupported version %s", ErrSnapshotFormat, version)
+	}
+
+	aux := make(map[string]string)
+	var db int
+	var expireAt int64
+	for {
+		opcode, err := d.readByte()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case rdbOpcodeEOF:
+			expected := d.crc
+			checksum, err := d.readUint64()
+			if err != nil {
+				return nil, err
+			}
+			if checksum != expected {
+				return nil, ErrSnapshotChecksum
+			}
+			return aux, nil
+		case rdbOpcodeAux:
+			name, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			if aux[name], err = d.readString(); err != nil {
+				return nil, err
+			}
+			continue
+		case rdbOpcodeSelectDB:
+			n, encoded, err := d.readLen()
+			if err != nil {
+				return nil, err
+			}
+			if encoded || n > math.MaxInt32 {
+				return nil, fmt.Errorf("%w: bad database index", ErrSnapshotFormat)
+			}
+			db = int(n)
+			continue
+		case rdbOpcodeExpireMs:
+			value, err := d.readUint64()
+			if err != nil {
+				return nil, err
+			}
+			expireAt = int64(value)
+			continue
+		}
+
+		key, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		value, valueType, err := d.readValue(opcode)
+		if err != nil {
+			return nil, err
+		}
+		if err := load(db, &CacheItem{Key: key, Value: value, Type: valueType, ExpireAt: expireAt}); err != nil {
+			return nil, err
+		}
+		expireAt = 0
+	}
+}
+
+// loadSnapshotFile reads the snapshot file at path, a missing file is not an error
+func loadSnapshotFile(path string, load func(db int, item *CacheItem) error) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = readSnapshot(file, load)
+	return err
+}
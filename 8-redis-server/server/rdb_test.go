@@ -0,0 +1,115 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// dump returns the content of the keys, as replied by the read commands
+func dump(re *RedisExecutorImpl) map[string]string {
+	keys := map[string][]string{
+		"str":  cmd("GET", "str"),
+		"int":  cmd("GET", "int"),
+		"big":  cmd("GET", "big"),
+		"list": cmd("LRANGE", "list", "0", "-1"),
+		"hash": cmd("HGET", "hash", "f"),
+		"set":  cmd("SISMEMBER", "set", "b"),
+		"zset": cmd("ZRANGE", "zset", "0", "-1", "WITHSCORES"),
+		"ttl":  cmd("TTL", "str"),
+
+		"stream":  cmd("XINFO", "STREAM", "stream"),
+		"pending": cmd("XPENDING", "stream", "grp"),
+		"groups":  cmd("XINFO", "GROUPS", "stream"),
+	}
+	content := make(map[string]string)
+	for name, args := range keys {
+		content[name] = execute(re, args...)
+	}
+	return content
+}
+
+func populate(re *RedisExecutorImpl) {
+	execute(re, "SET", "str", "value", "EX", "100")
+	execute(re, "SET", "int", "-123")
+	execute(re, "SET", "big", "123456789012")
+	execute(re, "RPUSH", "list", "a", "b", "300")
+	execute(re, "HSET", "hash", "f", "v", "g", "w")
+	execute(re, "SADD", "set", "a", "b")
+	execute(re, "ZADD", "zset", "1.5", "a", "-inf", "b", "70000", "c")
+	execute(re, "XADD", "stream", "1-1", "f", "v")
+	execute(re, "XADD", "stream", "2-0", "g", "w", "h", "300")
+	execute(re, "XADD", "stream", "3-0", "i", "x")
+	execute(re, "XDEL", "stream", "3-0")
+	execute(re, "XGROUP", "CREATE", "stream", "grp", "0")
+	execute(re, "XREADGROUP", "GROUP", "grp", "alice", "COUNT", "1", "STREAMS", "stream", ">")
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	re := newTestExecutor()
+	populate(re)
+	c := NewClient(&bufferConn{})
+	runClientSteps(t, re, c, []testStep{
+		{cmd("SELECT", "3"), "+OK\r\n"},
+		{cmd("SET", "other", "db"), "+OK\r\n"},
+	})
+	var buf bytes.Buffer
+	if err := writeSnapshot(&buf, re.snapshotItems(false), map[string]string{"repl-stream-db": "3"}); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := newTestExecutor()
+	aux, err := readSnapshot(bytes.NewReader(buf.Bytes()), func(db int, item *CacheItem) error {
+		return loaded.dbs[db].Set(item.Key, item)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aux["repl-stream-db"] != "3" {
+		t.Errorf("got aux fields %v", aux)
+	}
+	runClientSteps(t, loaded, NewClient(&bufferConn{}), []testStep{
+		{cmd("SELECT", "3"), "+OK\r\n"},
+		{cmd("GET", "other"), "$2\r\ndb\r\n"},
+		{cmd("DBSIZE"), ":1\r\n"},
+	})
+	want, got := dump(re), dump(loaded)
+	for name := range want {
+		if got[name] != want[name] {
+			t.Errorf("%s: got %q, want %q", name, got[name], want[name])
+		}
+	}
+	if loaded.Len() != re.Len() {
+		t.Errorf("got %d keys, want %d", loaded.Len(), re.Len())
+	}
+}
+
+func TestSnapshotCorruption(t *testing.T) {
+	re := newTestExecutor()
+	populate(re)
+	var buf bytes.Buffer
+	if err := writeSnapshot(&buf, re.snapshotItems(false), nil); err != nil {
+		t.Fatal(err)
+	}
+	load := func(int, *CacheItem) error { return nil }
+
+	flipped := bytes.Clone(buf.Bytes())
+	flipped[len(flipped)-12] ^= 1
+	if _, err := readSnapshot(bytes.NewReader(flipped), load); err == nil {
+		t.Error("corrupted snapshot loaded")
+	}
+	truncated := buf.Bytes()[:buf.Len()-20]
+	if _, err := readSnapshot(bytes.NewReader(truncated), load); !errors.Is(err, ErrSnapshotFormat) {
+		t.Errorf("truncated snapshot: got %v", err)
+	}
+	if _, err := readSnapshot(bytes.NewReader([]byte("REDIX0001")), load); !errors.Is(err, ErrSnapshotFormat) {
+		t.Errorf("wrong signature: got %v", err)
+	}
+}
+
+func TestCRC64(t *testing.T) {
+	// test vector of the Redis crc64
+	if crc := crc64Update(0, []byte("123456789")); crc != 0xe9c6d914c4b8d9ca {
+		t.Errorf("got %#x", crc)
+	}
+}
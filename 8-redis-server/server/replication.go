@@ -0,0 +1,718 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// replicaOutputLimit is the pending output beyond which a replica is disconnected,
+	// like the hard limit of the replica class of client-output-buffer-limit
+	replicaOutputLimit = 256 << 20
+	// replicationRetryDelay is the delay before a replica connects again to its master
+	replicationRetryDelay = time.Second
+	// replicationAckInterval is the period of the REPLCONF ACK sent by a replica
+	replicationAckInterval = time.Second
+	// replicationTimeout bounds the connection to the master and the handshake
+	replicationTimeout = 5 * time.Second
+)
+
+// states of the link of a replica to its master, as reported by ROLE
+const (
+	linkConnect    = "connect"    // waiting to connect
+	linkConnecting = "connecting" // connecting, or in the handshake
+	linkSync       = "sync"       // receiving the snapshot of the master
+	linkConnected  = "connected"  // receiving the stream of write commands
+)
+
+var (
+	ErrReadOnlyReplica   = errors.New("READONLY You can't write against a read only replica.")
+	ErrNoMasterLink      = errors.New("NOMASTERLINK Can't SYNC while not connected with my master")
+	ErrInvalidMasterPort = errors.New("ERR Invalid master port")
+)
+
+func init() {
+	registerCommands(
+		&commandSpec{name: "replicaof", arity: 3, handler: replicaofCommand, flags: flagNoMulti, categories: catAdmin | catDangerous},
+		&commandSpec{name: "slaveof", arity: 3, handler: replicaofCommand, flags: flagNoMulti, categories: catAdmin | catDangerous},
+		&commandSpec{name: "psync", arity: 3, handler: psyncCommand, flags: flagNoMulti, categories: catAdmin | catDangerous},
+		&commandSpec{name: "replconf", arity: -1, handler: replconfCommand, categories: catAdmin | catDangerous},
+		&commandSpec{name: "role", arity: 1, handler: roleCommand, categories: catAdmin | catDangerous},
+	)
+}
+
+// replicationState is the replication state of the server, guarded by the executor lock.
+// The write commands propagated by a master form its replication stream, the offset
+// counts the bytes of the stream so far. A replica receives the stream of its master
+// and keeps the same ID and offset, so that it may serve the stream in turn.
+type replicationState struct {
+	replID       string
+	replID2      string // ID of the former master, accepted for partial resynchronizations
+	secondOffset int64  // offset up to which replID2 is valid, -1 if unset
+	offset       int64
+	backlog      *replBacklog // the end of the stream, created when a replica connects
+	selectedDB   int          // database selected by the last SELECT of the stream, -1 if unknown
+	replicas     []*replicaInfo
+	master       *masterLink // nil unless the server is a replica
+
+	// resynchronizations served to the replicas
+	fullSyncs, partialSyncs, partialSyncErrors int64
+}
+
+// replicaInfo is a replica connected to the server
+type replicaInfo struct {
+	client    *Client
+	port      string // listening port announced by the replica (REPLCONF)
+	ackOffset int64  // offset acknowledged by the replica
+}
+
+// masterLink is the link of a replica to its master. It is stopped (REPLICAOF) by
+// closing @done and its connection.
+type masterLink struct {
+	host, port string
+	state      string
+	conn       net.Conn // nil unless connected
+	client     *Client  // runs the commands of the master
+	lastIO     time.Time
+	done       chan struct{}
+}
+
+func newReplicationState() replicationState {
+	return replicationState{replID: newReplicationID(), secondOffset: -1, selectedDB: -1}
+}
+
+// newReplicationID returns a random ID of 40 hex characters
+func newReplicationID() string {
+	id := make([]byte, 20)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func (link *masterLink) stopped() bool {
+	select {
+	case <-link.done:
+		return true
+	default:
+		return false
+	}
+}
+
+/* ---------------- backlog ---------------- */
+
+// replBacklog keeps the end of the replication stream in a ring buffer, a replica
+// which reconnects after a short disconnection is sent what it missed from it
+type replBacklog struct {
+	buf     []byte
+	idx     int // next write position in buf
+	histlen int // bytes of the stream in buf
+}
+
+func newReplBacklog(size int) *replBacklog {
+	return &replBacklog{buf: make([]byte, size)}
+}
+
+func (b *replBacklog) feed(p []byte) {
+	for len(p) > 0 {
+		n := copy(b.buf[b.idx:], p)
+		b.idx = (b.idx + n) % len(b.buf)
+		b.histlen = min(b.histlen+n, len(b.buf))
+		p = p[n:]
+	}
+}
+
+// since returns the stream from @offset, the stream ending at @end. It reports false
+// if the stream at @offset is not in the backlog anymore.
+func (b *replBacklog) since(offset, end int64) ([]byte, bool) {
+	start := end - int64(b.histlen) + 1
+	if offset < start || offset > end+1 {
+		return nil, false
+	}
+	n := int(end + 1 - offset)
+	data := make([]byte, 0, n)
+	from := (b.idx - n + len(b.buf)) % len(b.buf)
+	if from+n <= len(b.buf) {
+		return append(data, b.buf[from:from+n]...), true
+	}
+	data = append(data, b.buf[from:]...)
+	return append(data, b.buf[:n-(len(b.buf)-from)]...), true
+}
+
+// resizeReplBacklog follows the repl-backlog-size option changed by CONFIG SET,
+// keeping the end of the stream
+func (re *RedisExecutorImpl) resizeReplBacklog() {
+	b := re.repl.backlog
+	if b == nil || len(b.buf) == re.config.ReplBacklogSize {
+		return
+	}
+	resized := newReplBacklog(re.config.ReplBacklogSize)
+	n := min(b.histlen, len(resized.buf))
+	data, _ := b.since(re.repl.offset-int64(n)+1, re.repl.offset)
+	resized.feed(data)
+	re.repl.backlog = resized
+}
+
+/* ---------------- master ---------------- */
+
+// feedReplication appends a command propagated by a master in the database at index
+// @db to its replication stream, after a SELECT if the stream is in another database
+func (re *RedisExecutorImpl) feedReplication(db int, argv []string) {
+	if re.repl.master != nil || re.repl.backlog == nil {
+		return
+	}
+	var p []byte
+	if db != re.repl.selectedDB {
+		p = appendAOFCommand(p, []string{"select", strconv.Itoa(db)})
+		re.repl.selectedDB = db
+	}
+	re.replicationStream(appendAOFCommand(p, argv))
+}
+
+// streamDB is the database selected by the replication stream at its current offset.
+// A replica follows the stream of its master, run by the client of the link.
+func (re *RedisExecutorImpl) streamDB() int {
+	if link := re.repl.master; link != nil {
+		return clientDB(link.client)
+	}
+	return max(re.repl.selectedDB, 0)
+}
+
+// replicationStream adds data to the replication stream and sends it to the replicas
+func (re *RedisExecutorImpl) replicationStream(p []byte) {
+	re.repl.offset += int64(len(p))
+	if re.repl.backlog != nil {
+		re.repl.backlog.feed(p)
+	}
+	for _, replica := range re.repl.replicas {
+		replica.client.WriteRaw(p, replicaOutputLimit)
+	}
+}
+
+// partialResync returns the stream a replica missed from @offset, if the replica
+// followed this server or its former master and the stream is still in the backlog
+func (re *RedisExecutorImpl) partialResync(replID string, offset int64) ([]byte, bool) {
+	if re.repl.backlog == nil {
+		return nil, false
+	}
+	if replID != re.repl.replID && (replID != re.repl.replID2 || offset > re.repl.secondOffset) {
+		return nil, false
+	}
+	return re.repl.backlog.since(offset, re.repl.offset)
+}
+
+// fullResync sends the snapshot of the datastore, the replica receiving the stream
+// from its offset. The snapshot is encoded in memory while holding the executor lock,
+// so the replica doesn't miss nor repeat a command. It tells the replica the database
+// selected by the stream (repl-stream-db).
+func (re *RedisExecutorImpl) fullResync(client *Client) error {
+	var payload bytes.Buffer
+	aux := map[string]string{"repl-stream-db": strconv.Itoa(re.streamDB())}
+	if err := writeSnapshot(&payload, re.snapshotItems(false), aux); err != nil {
+		return err
+	}
+	header := fmt.Sprintf("+FULLRESYNC %s %d\r\n$%d\r\n", re.repl.replID, re.repl.offset, payload.Len())
+	client.WriteRaw([]byte(header), replicaOutputLimit)
+	client.WriteRaw(payload.Bytes(), replicaOutputLimit)
+	return nil
+}
+
+func (re *RedisExecutorImpl) removeReplica(client *Client) {
+	for i, replica := range re.repl.replicas {
+		if replica.client == client {
+			re.repl.replicas = append(re.repl.replicas[:i], re.repl.replicas[i+1:]...)
+			break
+		}
+	}
+	client.replica = nil
+}
+
+// disconnectReplicas closes the connection of the replicas, which connect again and
+// resynchronize, e.g. after the replication ID changed
+func (re *RedisExecutorImpl) disconnectReplicas() {
+	for _, replica := range re.repl.replicas {
+		replica.client.replica = nil
+		replica.client.Disconnect()
+	}
+	re.repl.replicas = nil
+}
+
+/* ---------------- replica ---------------- */
+
+// startReplication makes the server a replica of the master at host:port
+func (re *RedisExecutorImpl) startReplication(host, port string) {
+	re.stopReplication()
+	re.disconnectReplicas()
+	link := &masterLink{host: host, port: port, state: linkConnect, done: make(chan struct{})}
+	re.repl.master = link
+	re.Info("replicating the master", zap.String("host", host), zap.String("port", port))
+	go re.replicationLoop(link)
+}
+
+// stopReplication stops the link to the master, if any
+func (re *RedisExecutorImpl) stopReplication() {
+	link := re.repl.master
+	if link == nil {
+		return
+	}
+	close(link.done)
+	if link.conn != nil {
+		_ = link.conn.Close()
+	}
+	re.repl.master = nil
+}
+
+// shiftReplicationID gives a new ID to a replica promoted to master. The ID of the
+// former master stays valid up to the current offset, so that the other replicas of
+// the former master may partially resynchronize with it.
+func (re *RedisExecutorImpl) shiftReplicationID() {
+	re.repl.replID2 = re.repl.replID
+	re.repl.secondOffset = re.repl.offset + 1
+	re.repl.replID = newReplicationID()
+}
+
+// replicationLoop keeps the replica synchronized with its master until the link is
+// stopped, connecting again after a failure
+func (re *RedisExecutorImpl) replicationLoop(link *masterLink) {
+	for {
+		err := re.syncWithMaster(link)
+		if link.stopped() {
+			return
+		}
+		re.Warn("lost the link with the master", zap.Error(err))
+		re.exclusive(func() { link.state, link.conn = linkConnect, nil })
+		select {
+		case <-link.done:
+			return
+		case <-time.After(replicationRetryDelay):
+		}
+	}
+}
+
+// syncWithMaster connects to the master, resynchronizes and applies the stream of
+// the master until the connection is lost
+func (re *RedisExecutorImpl) syncWithMaster(link *masterLink) error {
+	re.exclusive(func() { link.state = linkConnecting })
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(link.host, link.port), replicationTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var replID, port string
+	var offset int64
+	var auth []string
+	stopped := false
+	re.exclusive(func() {
+		if stopped = link.stopped(); stopped {
+			return
+		}
+		link.conn = conn
+		replID, offset = re.repl.replID, re.repl.offset
+		port = strconv.Itoa(re.config.Port)
+		auth = []string{"auth", re.config.MasterAuth}
+		if re.config.MasterUser != "" {
+			auth = []string{"auth", re.config.MasterUser, re.config.MasterAuth}
+		}
+	})
+	if stopped {
+		return nil
+	}
+
+	r := bufio.NewReader(conn)
+	_ = conn.SetDeadline(time.Now().Add(replicationTimeout))
+	if auth[len(auth)-1] != "" {
+		if _, err := sendReplicationRequest(conn, r, auth); err != nil {
+			return err
+		}
+	}
+	for _, request := range [][]string{
+		{"ping"},
+		{"replconf", "listening-port", port},
+		{"replconf", "capa", "psync2"},
+	} {
+		if _, err := sendReplicationRequest(conn, r, request); err != nil {
+			return err
+		}
+	}
+	// the replica asks for the stream following what it already has
+	reply, err := sendReplicationRequest(conn, r, []string{"psync", replID, strconv.FormatInt(offset+1, 10)})
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(reply)
+	streamDB := 0
+	switch {
+	case len(fields) == 3 && fields[0] == "FULLRESYNC":
+		re.exclusive(func() { link.state = linkSync })
+		masterOffset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid FULLRESYNC reply: %q", reply)
+		}
+		_ = conn.SetDeadline(time.Time{})
+		payload, err := readSnapshotPayload(r)
+		if err != nil {
+			return err
+		}
+		if streamDB, err = re.loadMasterSnapshot(link, payload, fields[1], masterOffset); err != nil {
+			return err
+		}
+	case len(fields) >= 1 && fields[0] == "CONTINUE":
+		_ = conn.SetDeadline(time.Time{})
+		re.exclusive(func() {
+			if len(fields) == 2 && fields[1] != re.repl.replID {
+				// the master was promoted, its replicas follow its new ID
+				re.shiftReplicationID()
+				re.repl.replID = fields[1]
+				re.disconnectReplicas()
+			}
+		})
+	default:
+		return fmt.Errorf("unexpected PSYNC reply: %q", reply)
+	}
+
+	re.exclusive(func() {
+		if stopped = link.stopped(); stopped {
+			return
+		}
+		// after a partial resynchronization the stream continues in the same database
+		if link.client != nil && fields[0] == "CONTINUE" {
+			streamDB = link.client.db
+		}
+		link.state, link.client, link.lastIO = linkConnected, NewClient(nil), time.Now()
+		link.client.master = true
+		link.client.db = streamDB
+		if re.repl.backlog == nil {
+			re.repl.backlog = newReplBacklog(re.config.ReplBacklogSize)
+		}
+	})
+	if stopped {
+		return nil
+	}
+	re.Info("synchronized with the master", zap.String("reply", reply))
+
+	done := make(chan struct{})
+	defer close(done)
+	go re.sendAcks(conn, done)
+	return re.applyMasterStream(link, r, conn)
+}
+
+// sendReplicationRequest sends a command of the handshake and reads the reply
+func sendReplicationRequest(conn net.Conn, r *bufio.Reader, argv []string) (string, error) {
+	if _, err := conn.Write(appendAOFCommand(nil, argv)); err != nil {
+		return "", err
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if !strings.HasPrefix(line, "+") {
+		return "", fmt.Errorf("%s: %s", argv[0], line)
+	}
+	return line[1:], nil
+}
+
+// readSnapshotPayload reads the snapshot sent by the master as a bulk string
+// without the trailing CRLF
+func readSnapshotPayload(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	size, err := strconv.Atoi(strings.TrimRight(line, "\r\n")[1:])
+	if line[0] != '$' || err != nil || size < 0 {
+		return nil, fmt.Errorf("invalid snapshot payload: %q", line)
+	}
+	payload := make([]byte, size)
+	_, err = io.ReadFull(r, payload)
+	return payload, err
+}
+
+// loadMasterSnapshot replaces the datastore with the snapshot of the master, the
+// replica then following the stream of the master from @offset. It returns the
+// database selected by the stream.
+func (re *RedisExecutorImpl) loadMasterSnapshot(link *masterLink, payload []byte, replID string, offset int64) (streamDB int, err error) {
+	re.exclusive(func() { streamDB, err = re.replaceWithSnapshot(link, payload, replID, offset) })
+	return streamDB, err
+}
+
+func (re *RedisExecutorImpl) replaceWithSnapshot(link *masterLink, payload []byte, replID string, offset int64) (int, error) {
+	if link.stopped() {
+		return 0, nil
+	}
+	re.emptyDB(-1)
+	now := time.Now()
+	aux, err := readSnapshot(bytes.NewReader(payload), func(db int, item *CacheItem) error {
+		if db >= len(re.dbs) {
+			return fmt.Errorf("the master has more than %d databases", len(re.dbs))
+		}
+		if !item.IsExpired(now) {
+			_ = re.dbs[db].Set(item.Key, item)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	streamDB, err := strconv.Atoi(aux["repl-stream-db"])
+	if err != nil || streamDB < 0 || streamDB >= len(re.dbs) {
+		streamDB = 0
+	}
+	re.repl.replID, re.repl.replID2, re.repl.secondOffset = replID, "", -1
+	re.repl.offset = offset
+	re.repl.backlog = newReplBacklog(re.config.ReplBacklogSize)
+	// the replicas of the replica follow the new dataset
+	re.disconnectReplicas()
+	// the log doesn't describe the new dataset, it is rebuilt from it
+	if re.aof.file != nil {
+		_ = re.rewriteAppendOnly()
+	}
+	re.Info("loaded the snapshot of the master", zap.Int("keys", re.keyCount()))
+	return streamDB, nil
+}
+
+// applyMasterStream runs the commands sent by the master. The stream is forwarded
+// as is to the replicas of this server and kept in its backlog.
+func (re *RedisExecutorImpl) applyMasterStream(link *masterLink, r *bufio.Reader, conn net.Conn) error {
+	for {
+		argv, _, err := readAOFCommand(r)
+		if err != nil {
+			return err
+		}
+		stopped := false
+		re.exclusive(func() {
+			if stopped = link.stopped(); stopped {
+				return
+			}
+			link.lastIO = time.Now()
+			if len(argv) == 3 && strings.EqualFold(argv[0], "replconf") && strings.EqualFold(argv[1], "getack") {
+				_, _ = conn.Write(appendAOFCommand(nil, []string{"replconf", "ack", strconv.FormatInt(re.repl.offset, 10)}))
+			} else if err := re.executeLocal(link.client, argv); err != nil {
+				re.Warn("error while applying the stream of the master", zap.Error(err))
+			}
+			re.replicationStream(appendAOFCommand(nil, argv))
+		})
+		if stopped {
+			return nil
+		}
+	}
+}
+
+// sendAcks acknowledges the offset of the replica to its master periodically
+func (re *RedisExecutorImpl) sendAcks(conn net.Conn, done chan struct{}) {
+	ticker := time.NewTicker(replicationAckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		var ack []byte
+		re.exclusive(func() {
+			ack = appendAOFCommand(nil, []string{"replconf", "ack", strconv.FormatInt(re.repl.offset, 10)})
+		})
+		if _, err := conn.Write(ack); err != nil {
+			return
+		}
+	}
+}
+
+/* ---------------- commands ---------------- */
+
+// REPLICAOF host port | NO ONE
+func replicaofCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	host, port := cmd.Arg(0), cmd.Arg(1)
+	if strings.EqualFold(host, "no") && strings.EqualFold(port, "one") {
+		if re.repl.master != nil {
+			// the replicas, which follow the stream of the former master, resume in its database
+			re.repl.selectedDB = re.streamDB()
+			re.stopReplication()
+			re.shiftReplicationID()
+			re.disconnectReplicas()
+			re.Info("promoted to master")
+		}
+		return OKResponse()
+	}
+	if n, ok := parseInt(port); !ok || n <= 0 || n > 65535 {
+		return ErrorResponse(ErrInvalidMasterPort)
+	}
+	if link := re.repl.master; link != nil && link.host == host && link.port == port {
+		return SimpleStringResponse("OK Already connected to specified master")
+	}
+	re.startReplication(host, port)
+	return OKResponse()
+}
+
+// PSYNC replicationid offset, sent by a replica to receive the replication stream.
+// The stream is resumed from the backlog if possible, otherwise a snapshot is sent first.
+func psyncCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	client := cmd.Client()
+	if client == nil {
+		return ErrorResponse(ErrNoClient)
+	}
+	if link := re.repl.master; link != nil && link.state != linkConnected {
+		return ErrorResponse(ErrNoMasterLink)
+	}
+	if re.repl.backlog == nil {
+		re.repl.backlog = newReplBacklog(re.config.ReplBacklogSize)
+	}
+
+	offset, ok := parseInt(cmd.Arg(1))
+	if data, resumed := re.partialResync(cmd.Arg(0), offset); ok && resumed {
+		client.WriteRaw([]byte("+CONTINUE "+re.repl.replID+"\r\n"), replicaOutputLimit)
+		client.WriteRaw(data, replicaOutputLimit)
+		re.repl.partialSyncs++
+	} else {
+		if cmd.Arg(0) != "?" {
+			re.repl.partialSyncErrors++
+		}
+		if err := re.fullResync(client); err != nil {
+			return ErrorResponse(errors.New("ERR " + err.Error()))
+		}
+		re.repl.fullSyncs++
+	}
+
+	replica := &replicaInfo{client: client, ackOffset: re.repl.offset}
+	if client.replica != nil {
+		replica.port = client.replica.port
+	}
+	client.replica = replica
+	re.repl.replicas = append(re.repl.replicas, replica)
+	re.Info("replica synchronized", zap.String("addr", client.addr), zap.String("psync", cmd.String()))
+	return NoReplyResponse()
+}
+
+// REPLCONF option value [option value ...], sent by a replica
+func replconfCommand(_ *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	if len(args)%2 != 0 {
+		return ErrorResponse(ErrSyntax)
+	}
+	client := cmd.Client()
+	if client == nil {
+		return ErrorResponse(ErrNoClient)
+	}
+	for i := 0; i < len(args); i += 2 {
+		switch strings.ToLower(args[i]) {
+		case "listening-port":
+			if client.replica == nil {
+				client.replica = &replicaInfo{client: client}
+			}
+			client.replica.port = args[i+1]
+		case "ack":
+			// acknowledgements are not replied to
+			if client.replica != nil {
+				if offset, ok := parseInt(args[i+1]); ok {
+					client.replica.ackOffset = offset
+				}
+			}
+			return NoReplyResponse()
+		case "capa", "getack":
+		default:
+			return ErrorResponse(fmt.Errorf("ERR Unrecognized REPLCONF option: %s", args[i]))
+		}
+	}
+	return OKResponse()
+}
+
+// ROLE
+func roleCommand(re *RedisExecutorImpl, _ *Cmd) *RedisResponse {
+	if link := re.repl.master; link != nil {
+		port, _ := parseInt(link.port)
+		return ArrayResponse(
+			BulkResponse("slave"),
+			BulkResponse(link.host),
+			IntegerResponse(port),
+			BulkResponse(link.state),
+			IntegerResponse(re.repl.offset),
+		)
+	}
+	replicas := make([]*RedisResponse, 0, len(re.repl.replicas))
+	for _, replica := range re.repl.replicas {
+		replicas = append(replicas, BulkArrayResponse([]string{
+			replicaHost(replica), replica.port, strconv.FormatInt(replica.ackOffset, 10),
+		}))
+	}
+	return ArrayResponse(BulkResponse("master"), IntegerResponse(re.repl.offset), ArrayResponse(replicas...))
+}
+
+// replicaHost is the IP address of a replica
+func replicaHost(replica *replicaInfo) string {
+	host, _, err := net.SplitHostPort(replica.client.addr)
+	if err != nil {
+		return replica.client.addr
+	}
+	return host
+}
+
+// replicationInfo is the replication section of INFO
+func replicationInfo(re *RedisExecutorImpl) []infoField {
+	var fields []infoField
+	if link := re.repl.master; link != nil {
+		fields = append(fields,
+			infoField{"role", "slave"},
+			infoField{"master_host", link.host},
+			infoField{"master_port", link.port},
+			infoField{"master_link_status", linkStatus(link)},
+			infoField{"master_last_io_seconds_ago", strconv.Itoa(lastIOSecondsAgo(link))},
+			infoField{"master_sync_in_progress", infoBool(link.state == linkSync)},
+			infoField{"slave_repl_offset", strconv.FormatInt(re.repl.offset, 10)},
+			infoField{"slave_read_only", infoBool(re.config.ReplicaReadOnly)},
+		)
+	} else {
+		fields = append(fields, infoField{"role", "master"})
+	}
+	fields = append(fields, infoField{"connected_slaves", strconv.Itoa(len(re.repl.replicas))})
+	for i, replica := range re.repl.replicas {
+		fields = append(fields, infoField{
+			"slave" + strconv.Itoa(i),
+			fmt.Sprintf("ip=%s,port=%s,state=online,offset=%d,lag=0", replicaHost(replica), replica.port, replica.ackOffset),
+		})
+	}
+	replID2 := re.repl.replID2
+	if replID2 == "" {
+		replID2 = strings.Repeat("0", 40)
+	}
+	fields = append(fields,
+		infoField{"master_replid", re.repl.replID},
+		infoField{"master_replid2", replID2},
+		infoField{"master_repl_offset", strconv.FormatInt(re.repl.offset, 10)},
+		infoField{"second_repl_offset", strconv.FormatInt(re.repl.secondOffset, 10)},
+		infoField{"repl_backlog_active", infoBool(re.repl.backlog != nil)},
+		infoField{"repl_backlog_size", strconv.Itoa(re.config.ReplBacklogSize)},
+	)
+	if b := re.repl.backlog; b != nil {
+		fields = append(fields,
+			infoField{"repl_backlog_first_byte_offset", strconv.FormatInt(re.repl.offset-int64(b.histlen)+1, 10)},
+			infoField{"repl_backlog_histlen", strconv.Itoa(b.histlen)},
+		)
+	}
+	return fields
+}
+
+func linkStatus(link *masterLink) string {
+	if link.state == linkConnected {
+		return "up"
+	}
+	return "down"
+}
+
+// lastIOSecondsAgo is the number of seconds since the master sent something, -1 if
+// the replica is not connected
+func lastIOSecondsAgo(link *masterLink) int {
+	if link.state != linkConnected {
+		return -1
+	}
+	return int(time.Since(link.lastIO).Seconds())
+}
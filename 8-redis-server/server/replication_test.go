@@ -0,0 +1,229 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// startTestServer serves the executor on a random local port and returns the port
+func startTestServer(t *testing.T, re *RedisExecutorImpl) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	rs := &RedisServerImpl{RedisExecutor: re, RedisTokenizer: DefaultTokenizer(), Logger: zap.NewNop()}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go rs.handleConnection(conn)
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	return port
+}
+
+// eventually retries the command until it replies @want
+func eventually(t *testing.T, re *RedisExecutorImpl, want string, args ...string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := execute(re, args...)
+		if got == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%v: got %q, want %q", args, got, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// stopReplica promotes the replica at the end of the test, stopping its link
+func stopReplica(t *testing.T, re *RedisExecutorImpl) {
+	t.Cleanup(func() { execute(re, "REPLICAOF", "NO", "ONE") })
+}
+
+func TestReplication(t *testing.T) {
+	master := newTestExecutor()
+	populate(master)
+	port := startTestServer(t, master)
+
+	replica := newTestExecutor()
+	replica.config.Port = 6380
+	execute(replica, "SET", "stale", "x")
+	stopReplica(t, replica)
+	runSteps(t, replica, []testStep{
+		{cmd("REPLICAOF", "127.0.0.1", port), "+OK\r\n"},
+		{cmd("REPLICAOF", "127.0.0.1", port), "+OK Already connected to specified master\r\n"},
+	})
+	eventually(t, replica, "*5\r\n$5\r\nslave\r\n$9\r\n127.0.0.1\r\n:"+port+"\r\n$9\r\nconnected\r\n:0\r\n", "ROLE")
+
+	// the snapshot of the master replaced the datastore
+	want, got := dump(master), dump(replica)
+	for name := range want {
+		if got[name] != want[name] {
+			t.Errorf("%s: got %q, want %q", name, got[name], want[name])
+		}
+	}
+	runSteps(t, replica, []testStep{
+		{cmd("EXISTS", "stale"), ":0\r\n"},
+		{cmd("SET", "k", "v"), "-READONLY You can't write against a read only replica.\r\n"},
+		{cmd("GET", "str"), "$5\r\nvalue\r\n"},
+	})
+
+	// then the write commands of the master are streamed
+	execute(master, "SET", "k", "v")
+	execute(master, "INCRBYFLOAT", "f", "0.5")
+	execute(master, "DEL", "list")
+	eventually(t, replica, "$1\r\nv\r\n", "GET", "k")
+	eventually(t, replica, ":0\r\n", "EXISTS", "list")
+	runSteps(t, replica, []testStep{{cmd("GET", "f"), "$3\r\n0.5\r\n"}})
+
+	master.mu.Lock()
+	offset := master.repl.offset
+	master.mu.Unlock()
+	if offset == 0 {
+		t.Error("the offset of the master didn't move")
+	}
+	if role := execute(master, "ROLE"); !strings.HasPrefix(role, "*3\r\n$6\r\nmaster\r\n") || !strings.Contains(role, "$4\r\n6380\r\n") {
+		t.Errorf("ROLE: got %q", role)
+	}
+	info := execute(master, "INFO", "replication")
+	for _, field := range []string{"role:master", "connected_slaves:1", "slave0:ip=127.0.0.1,port=6380"} {
+		if !strings.Contains(info, field) {
+			t.Errorf("INFO: %q not in %q", field, info)
+		}
+	}
+}
+
+func TestPartialResync(t *testing.T) {
+	master := newTestExecutor()
+	port := startTestServer(t, master)
+	replica := newTestExecutor()
+	stopReplica(t, replica)
+	execute(replica, "REPLICAOF", "127.0.0.1", port)
+	execute(master, "SET", "a", "1")
+	eventually(t, replica, "$1\r\n1\r\n", "GET", "a")
+
+	// the link breaks, the replica resumes from the backlog of the master
+	replica.mu.Lock()
+	_ = replica.repl.master.conn.Close()
+	replica.mu.Unlock()
+	execute(master, "SET", "b", "2")
+	eventually(t, replica, "$1\r\n2\r\n", "GET", "b")
+
+	master.mu.Lock()
+	full, partial := master.repl.fullSyncs, master.repl.partialSyncs
+	master.mu.Unlock()
+	if full != 1 || partial != 1 {
+		t.Errorf("syncs: got %d full and %d partial, want 1 and 1", full, partial)
+	}
+	replica.mu.Lock()
+	masterOffset, replicaOffset := master.repl.offset, replica.repl.offset
+	replica.mu.Unlock()
+	if masterOffset != replicaOffset {
+		t.Errorf("offset: got %d, want %d", replicaOffset, masterOffset)
+	}
+}
+
+func TestReplicationDatabases(t *testing.T) {
+	master := newTestExecutor()
+	port := startTestServer(t, master)
+	c := NewClient(&bufferConn{})
+	runClientSteps(t, master, c, []testStep{
+		{cmd("SELECT", "3"), "+OK\r\n"},
+		{cmd("SET", "before", "v"), "+OK\r\n"},
+	})
+	replica := newTestExecutor()
+	stopReplica(t, replica)
+	execute(replica, "REPLICAOF", "127.0.0.1", port)
+	runClientSteps(t, master, c, []testStep{{cmd("SET", "after", "v"), "+OK\r\n"}})
+	execute(master, "SET", "db0", "v")
+	eventually(t, replica, "$1\r\nv\r\n", "GET", "db0")
+
+	// the link breaks, the stream resumes in the database selected by the master
+	replica.mu.Lock()
+	_ = replica.repl.master.conn.Close()
+	replica.mu.Unlock()
+	runClientSteps(t, master, c, []testStep{{cmd("SET", "resumed", "v"), "+OK\r\n"}})
+	execute(master, "SET", "last", "v")
+	eventually(t, replica, "$1\r\nv\r\n", "GET", "last")
+	runClientSteps(t, replica, NewClient(&bufferConn{}), []testStep{
+		{cmd("SELECT", "3"), "+OK\r\n"},
+		{cmd("EXISTS", "before", "after", "resumed"), ":3\r\n"},
+		{cmd("EXISTS", "db0"), ":0\r\n"},
+	})
+}
+
+func TestReplicaPromotion(t *testing.T) {
+	master := newTestExecutor()
+	port := startTestServer(t, master)
+	replica := newTestExecutor()
+	execute(replica, "REPLICAOF", "127.0.0.1", port)
+	execute(master, "SET", "a", "1")
+	eventually(t, replica, "$1\r\n1\r\n", "GET", "a")
+	masterID := master.repl.replID
+
+	runSteps(t, replica, []testStep{
+		{cmd("REPLICAOF", "NO", "ONE"), "+OK\r\n"},
+		{cmd("SET", "a", "2"), "+OK\r\n"},
+		{cmd("ROLE"), "*3\r\n$6\r\nmaster\r\n:" + strconv.FormatInt(replica.repl.offset+27, 10) + "\r\n*0\r\n"},
+	})
+	// the other replicas of the former master may resume from the promoted replica
+	if replica.repl.replID2 != masterID || replica.repl.replID == masterID {
+		t.Errorf("replication IDs: got %s and %s, former master %s", replica.repl.replID, replica.repl.replID2, masterID)
+	}
+	if _, ok := replica.partialResync(masterID, replica.repl.secondOffset); !ok {
+		t.Error("no partial resynchronization with the ID of the former master")
+	}
+	if _, ok := replica.partialResync(masterID, replica.repl.secondOffset+1); ok {
+		t.Error("partial resynchronization past the offset of the former master")
+	}
+}
+
+func TestReplBacklog(t *testing.T) {
+	b := newReplBacklog(8)
+	var end int64
+	for _, chunk := range []string{"abc", "defgh", "ijk"} {
+		b.feed([]byte(chunk))
+		end += int64(len(chunk))
+	}
+	tests := []struct {
+		offset int64
+		want   string
+		ok     bool
+	}{
+		{4, "defghijk", true},
+		{9, "ijk", true},
+		{12, "", true},
+		{3, "", false},
+		{13, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := b.since(tt.offset, end)
+		if string(got) != tt.want || ok != tt.ok {
+			t.Errorf("since(%d): got %q %v, want %q %v", tt.offset, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestReplicationAuth(t *testing.T) {
+	master := newTestExecutor()
+	execute(master, "CONFIG", "SET", "requirepass", "secret")
+	execute(master, "SET", "a", "1")
+	port := startTestServer(t, master)
+	replica := newTestExecutor()
+	stopReplica(t, replica)
+	execute(replica, "CONFIG", "SET", "masterauth", "secret")
+	execute(replica, "REPLICAOF", "127.0.0.1", port)
+	eventually(t, replica, "$1\r\n1\r\n", "GET", "a")
+}
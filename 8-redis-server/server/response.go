@@ -1,35 +1,124 @@
 package server
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"strconv"
+	"strings"
+	"time"
 )
 
 /* ---------------- RedisResponse ---------------- */
 
 // RedisResponse is the response sent back to the client.
+// Scalar replies carry their payload in Value, array replies in Items.
+// An array reply with nil Items is serialized as the null array.
 type RedisResponse struct {
-	Item  CacheItem
+	Type  RespType
+	Value string
+	Items []*RedisResponse
 	Error error
+
+	blocked *blockedClient // set if the command has to wait, see BlockedResponse
+	noReply bool           // set if the command wrote its replies itself, see NoReplyResponse
 }
 
 func (rr *RedisResponse) SerializeBytes() []byte {
-	return []byte(rr.Serialize())
+	return rr.AppendTo(nil)
 }
 
 func (rr *RedisResponse) Serialize() string {
+	return string(rr.AppendTo(nil))
+}
+
+// AppendTo appends the RESP encoding of the response to buf
+func (rr *RedisResponse) AppendTo(buf []byte) []byte {
+	if rr.noReply {
+		return buf
+	}
 	if rr.Error != nil {
-		return fmt.Sprintf("*1\r\n-\r\n%s\r\n", rr.Error.Error())
+		return append(append(append(buf, '-'), rr.Error.Error()...), "\r\n"...)
 	}
-	return "*1\r\n" + rr.Item.Serialize()
+	switch rr.Type {
+	case RespSimpleString:
+		buf = append(append(buf, '+'), rr.Value...)
+	case RespSimpleError:
+		buf = append(append(buf, '-'), rr.Value...)
+	case RespInteger:
+		buf = append(append(buf, ':'), rr.Value...)
+	case RespNull:
+		buf = append(buf, "$-1"...)
+	case RespArray:
+		if rr.Items == nil {
+			return append(buf, "*-1\r\n"...)
+		}
+		buf = strconv.AppendInt(append(buf, '*'), int64(len(rr.Items)), 10)
+		buf = append(buf, "\r\n"...)
+		for _, item := range rr.Items {
+			buf = item.AppendTo(buf)
+		}
+		return buf
+	default:
+		buf = strconv.AppendInt(append(buf, '$'), int64(len(rr.Value)), 10)
+		buf = append(append(buf, "\r\n"...), rr.Value...)
+	}
+	return append(buf, "\r\n"...)
 }
 
 /* ---------------- common errors & response ---------------- */
 
+var (
+	ErrInvalidCommand  = errors.New("ERR invalid command received")
+	ErrWrongType       = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	ErrNoSuchKey       = errors.New("ERR no such key")
+	ErrIndexRange      = errors.New("ERR index out of range")
+	ErrNotPositive     = errors.New("ERR value is out of range, must be positive")
+	ErrValueRange      = errors.New("ERR value is out of range")
+	ErrTimeoutNotFloat = errors.New("ERR timeout is not a float or out of range")
+	ErrTimeoutNegative = errors.New("ERR timeout is negative")
+	ErrHashNotInteger  = errors.New("ERR hash value is not an integer")
+	ErrHashNotFloat    = errors.New("ERR hash value is not a float")
+	ErrSyntax          = errors.New("ERR syntax error")
+	ErrNotInteger      = errors.New("ERR value is not an integer or out of range")
+	ErrNotFloat        = errors.New("ERR value is not a valid float")
+	ErrOverflow        = errors.New("ERR increment or decrement would overflow")
+	ErrDecrOverflow    = errors.New("ERR decrement would overflow")
+	ErrIncrNaN         = errors.New("ERR increment would produce NaN or Infinity")
+	ErrOffsetRange     = errors.New("ERR offset is out of range")
+	ErrStringTooLong   = errors.New("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+	ErrExpireNXOptions = errors.New("ERR NX and XX, GT or LT options at the same time are not compatible")
+	ErrExpireGTLT      = errors.New("ERR GT and LT options at the same time are not compatible")
+)
+
 func ItemNotFound(key string) error {
 	return fmt.Errorf("item not found, key=" + key)
 }
 
+func WrongArgsError(name string) error {
+	return fmt.Errorf("ERR wrong number of arguments for '%s' command", name)
+}
+
+func UnknownCommandError(cmd *Cmd) error {
+	var args strings.Builder
+	for _, arg := range cmd.Args() {
+		args.WriteString("'" + arg + "' ")
+	}
+	return fmt.Errorf("ERR unknown command '%s', with args beginning with: %s", cmd.Name(), args.String())
+}
+
+func InvalidExpireError(name string) error {
+	return fmt.Errorf("ERR invalid expire time in '%s' command", name)
+}
+
+func UnknownSubcommandError(cmd *Cmd) error {
+	return fmt.Errorf("ERR unknown subcommand '%s'. Try %s HELP.", cmd.Arg(0), strings.ToUpper(cmd.Name()))
+}
+
+func UnsupportedOptionError(option string) error {
+	return fmt.Errorf("ERR Unsupported option %s", option)
+}
+
 func NotFoundResponse(key string) *RedisResponse {
 	return &RedisResponse{
 		Error: ItemNotFound(key),
@@ -43,43 +132,157 @@ func ErrorResponse(err error) *RedisResponse {
 }
 
 func OKResponse() *RedisResponse {
-	return &RedisResponse{
-		Item: CacheItem{
-			Key:   "OK",
-			Value: "OK",
-		},
-		Error: nil,
+	return SimpleStringResponse("OK")
+}
+
+func SimpleStringResponse(value string) *RedisResponse {
+	return &RedisResponse{Type: RespSimpleString, Value: value}
+}
+
+func BulkResponse(value string) *RedisResponse {
+	return &RedisResponse{Type: RespBulkString, Value: value}
+}
+
+func IntegerResponse(value int64) *RedisResponse {
+	return &RedisResponse{Type: RespInteger, Value: strconv.FormatInt(value, 10)}
+}
+
+// NilResponse is the null bulk string, sent for missing values
+func NilResponse() *RedisResponse {
+	return &RedisResponse{Type: RespNull}
+}
+
+// ArrayResponse builds an array reply; an empty call yields an empty (not null) array
+func ArrayResponse(items ...*RedisResponse) *RedisResponse {
+	if items == nil {
+		items = []*RedisResponse{}
+	}
+	return &RedisResponse{Type: RespArray, Items: items}
+}
+
+func NullArrayResponse() *RedisResponse {
+	return &RedisResponse{Type: RespArray}
+}
+
+// NoReplyResponse is returned by the handler of a command which sent its replies to the
+// client itself, e.g. SUBSCRIBE replies once per channel. It serializes to nothing.
+func NoReplyResponse() *RedisResponse {
+	return &RedisResponse{noReply: true}
+}
+
+// BulkArrayResponse builds an array reply of bulk strings
+func BulkArrayResponse(values []string) *RedisResponse {
+	items := make([]*RedisResponse, len(values))
+	for i, value := range values {
+		items[i] = BulkResponse(value)
 	}
+	return ArrayResponse(items...)
 }
 
 /* ---------------- CacheItem ---------------- */
 
+// ValueType is the type of the value held by a CacheItem
+type ValueType int
+
+const (
+	StringType ValueType = iota // Value is a []byte
+	ListType                    // Value is a *List
+	HashType                    // Value is a *Dict[string]
+	SetType                     // Value is a *Dict[struct{}]
+	ZSetType                    // Value is a *ZSet
+	StreamType                  // Value is a *Stream
+)
+
+var valueTypeNames = map[ValueType]string{
+	StringType: "string",
+	ListType:   "list",
+	HashType:   "hash",
+	SetType:    "set",
+	ZSetType:   "zset",
+	StreamType: "stream",
+}
+
+func (vt ValueType) String() string {
+	return valueTypeNames[vt]
+}
+
 // CacheItem represents an item in the cache
 type CacheItem struct {
 	Key      string
-	Value    string
-	DataType RespType
-	// TODO : Support item expiration
-}
+	Value    interface{}
+	Type     ValueType
+	ExpireAt int64 // unix time in milliseconds, 0 if the item never expires
 
-func (ci *CacheItem) Serialize() string {
-	if ci.DataType == RespSimpleError {
-		return "-" + ci.Value + "\r\n"
-	}
-	if ci.DataType == RespInteger {
-		return ":\r\n" + ci.Value + "\r\n"
-	}
-	return "$" + strconv.Itoa(len(ci.Value)) + "\r\n" + ci.Value + "\r\n"
+	// memory accounting and access tracking for the eviction, see evict.go
+	size           int64 // estimated memory used by the item
+	accessed       int64 // unix time in milliseconds of the last access (LRU)
+	lfu            uint8 // logarithmic access counter (LFU)
+	lfuDecremented int64 // unix time in minutes of the last decrement of @lfu
 }
 
 func (ci *CacheItem) GetKey() string {
 	return ci.Key
 }
 
-func (ci *CacheItem) GetValue() string {
+func (ci *CacheItem) GetValue() interface{} {
+	return ci.Value
+}
+
+func (ci *CacheItem) GetType() ValueType {
+	return ci.Type
+}
+
+// StringValue returns the value of an item of StringType
+func (ci *CacheItem) StringValue() []byte {
+	return ci.Value.([]byte)
+}
+
+// ListValue returns the value of an item of ListType
+func (ci *CacheItem) ListValue() *List {
+	return ci.Value.(*List)
+}
+
+// HashValue returns the value of an item of HashType
+func (ci *CacheItem) HashValue() *Dict[string] {
+	return ci.Value.(*Dict[string])
+}
+
+// SetValue returns the value of an item of SetType
+func (ci *CacheItem) SetValue() *Dict[struct{}] {
+	return ci.Value.(*Dict[struct{}])
+}
+
+// ZSetValue returns the value of an item of ZSetType
+func (ci *CacheItem) ZSetValue() *ZSet {
+	return ci.Value.(*ZSet)
+}
+
+// StreamValue returns the value of an item of StreamType
+func (ci *CacheItem) StreamValue() *Stream {
+	return ci.Value.(*Stream)
+}
+
+// cloneValue returns a copy of the value of the item, which the item and its copy
+// can modify independently
+func (ci *CacheItem) cloneValue() interface{} {
+	switch ci.Type {
+	case StringType:
+		return bytes.Clone(ci.StringValue())
+	case ListType:
+		return ci.ListValue().Clone()
+	case HashType:
+		return ci.HashValue().Clone()
+	case SetType:
+		return ci.SetValue().Clone()
+	case ZSetType:
+		return ci.ZSetValue().Clone()
+	case StreamType:
+		return ci.StreamValue().Clone()
+	}
 	return ci.Value
 }
 
-func (ci *CacheItem) GetDataType() RespType {
-	return ci.DataType
+// IsExpired reports whether the item's time to live has elapsed at @now
+func (ci *CacheItem) IsExpired(now time.Time) bool {
+	return ci.ExpireAt > 0 && ci.ExpireAt <= now.UnixMilli()
 }
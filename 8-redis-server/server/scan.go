@@ -0,0 +1,88 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidCursor = errors.New("ERR invalid cursor")
+
+// scanOptions are the arguments of the SCAN family of commands:
+// cursor [MATCH pattern] [COUNT count] [NOVALUES] [TYPE type]
+type scanOptions struct {
+	cursor    uint64
+	match     string // empty if every element matches
+	count     int
+	noValues  bool
+	valueType string // empty if keys of every type match
+}
+
+// parseScanArgs parses the cursor and the options of a SCAN command.
+// NOVALUES is only accepted if @allowNoValues is set (HSCAN), TYPE if @allowType
+// is set (SCAN).
+func parseScanArgs(args []string, allowNoValues, allowType bool) (*scanOptions, error) {
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	opts := &scanOptions{cursor: cursor, count: 10}
+	for i := 1; i < len(args); i++ {
+		option := strings.ToLower(args[i])
+		switch {
+		case option == "match" && i+1 < len(args):
+			opts.match = args[i+1]
+			if opts.match == "*" {
+				opts.match = ""
+			}
+			i++
+		case option == "count" && i+1 < len(args):
+			count, ok := parseInt(args[i+1])
+			if !ok {
+				return nil, ErrNotInteger
+			}
+			if count < 1 {
+				return nil, ErrSyntax
+			}
+			opts.count = int(count)
+			i++
+		case option == "novalues" && allowNoValues:
+			opts.noValues = true
+		case option == "type" && allowType && i+1 < len(args):
+			opts.valueType = strings.ToLower(args[i+1])
+			i++
+		default:
+			return nil, ErrSyntax
+		}
+	}
+	return opts, nil
+}
+
+// scannable is a table scanned with a cursor, see Dict.Scan
+type scannable[V any] interface {
+	Scan(cursor uint64, fn func(key string, value V)) uint64
+}
+
+// scanDict scans the dict, or a database, from the cursor of @opts, calling emit for
+// the entries matching the pattern. Like Redis, it stops once about @count entries
+// were visited, and it returns the cursor to resume from (0 when done).
+func scanDict[V any](d scannable[V], opts *scanOptions, emit func(key string, value V)) uint64 {
+	cursor := opts.cursor
+	visited := 0
+	for iterations := opts.count * 10; ; iterations-- {
+		cursor = d.Scan(cursor, func(key string, value V) {
+			visited++
+			if opts.match == "" || globMatch(opts.match, key, false) {
+				emit(key, value)
+			}
+		})
+		if cursor == 0 || visited >= opts.count || iterations <= 1 {
+			return cursor
+		}
+	}
+}
+
+// scanResponse builds the [cursor, [elements]] reply of the SCAN commands
+func scanResponse(cursor uint64, elements []string) *RedisResponse {
+	return ArrayResponse(BulkResponse(strconv.FormatUint(cursor, 10)), BulkArrayResponse(elements))
+}
@@ -2,13 +2,27 @@ package server
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"go.uber.org/zap"
 	"io"
 	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
 )
 
+// readBufferSize is the size of the buffer the commands of a client are read to, like
+// the query buffer of Redis. The replies are flushed once it is drained.
+const readBufferSize = 16 << 10
+
 type RedisServer interface {
 	Start() error
+	// Stop stops accepting connections, lets the running commands complete and
+	// persists the datastore. The clients are disconnected once they received their
+	// replies, or once @ctx is done.
+	Stop(ctx context.Context) error
 	handleConnection(conn net.Conn) error
 }
 
@@ -16,59 +30,274 @@ type RedisServerImpl struct {
 	RedisExecutor
 	RedisTokenizer
 	*zap.Logger
+	config  *Config
+	metrics http.Handler // nil unless the metrics endpoint is enabled
+
+	mu            sync.Mutex
+	listener      net.Listener
+	metricsServer *http.Server
+	conns         map[net.Conn]struct{} // the open connections
+	handlers      sync.WaitGroup        // the running connection handlers
+	stopping      bool                  // Stop was called, the connections are refused
+	stopped       chan struct{}         // closed once Stop was called
+	stopOnce      sync.Once
+	stopErr       error
 }
 
-func NewRedisServer() *RedisServerImpl {
-	logger, _ := zap.NewProduction()
-	return &RedisServerImpl{
-		RedisExecutor:  NewRedisExecutorImpl(),
+// NewRedisServer creates the server with the configuration and loads the datastore
+// from the append only file or the snapshot, if any
+func NewRedisServer(config *Config) *RedisServerImpl {
+	logLevel.SetLevel(logLevels[config.LogLevel])
+	logger := newLogger()
+	executor := NewRedisExecutorImpl(config)
+	if err := executor.LoadACL(); err != nil {
+		logger.Fatal("error while loading the ACL file", zap.Error(err))
+	}
+	if err := executor.LoadData(); err != nil {
+		// like Redis, refuse to start rather than overwrite the data later
+		logger.Fatal("error while loading the data", zap.Error(err))
+	}
+	if host, port, found := strings.Cut(config.ReplicaOf, " "); found {
+		executor.exclusive(func() { executor.startReplication(host, port) })
+	}
+	rs := &RedisServerImpl{
+		RedisExecutor:  executor,
 		RedisTokenizer: DefaultTokenizer(),
 		Logger:         logger,
+		config:         config,
+	}
+	if config.MetricsPort != 0 {
+		rs.metrics = metricsHandler(executor)
 	}
+	return rs
 }
 
+// Start accepts the connections until the server is stopped, by Stop or SHUTDOWN. It
+// returns once the server stopped, with the error of Stop if any.
 func (rs *RedisServerImpl) Start() error {
-	rs.Info("starting server")
-	listener, err := net.Listen("tcp", ":6379")
+	rs.Info("starting server", zap.String("addr", rs.config.Addr()))
+	listener, err := net.Listen("tcp", rs.config.Addr())
 	if err != nil {
 		return err
 	}
+	rs.mu.Lock()
+	if rs.stopping {
+		rs.mu.Unlock()
+		return listener.Close()
+	}
+	rs.listener = listener
+	if rs.stopped == nil {
+		rs.stopped = make(chan struct{})
+	}
+	stopped := rs.stopped
+	if rs.metrics != nil {
+		rs.metricsServer = &http.Server{Addr: rs.config.MetricsAddr(), Handler: rs.metrics}
+		go rs.serveMetrics(rs.metricsServer)
+	}
+	rs.mu.Unlock()
+
+	go func() {
+		select {
+		case <-rs.ShutdownRequested():
+			ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+			defer cancel()
+			_ = rs.Stop(ctx)
+		case <-stopped:
+		}
+	}()
+
 	for {
-		c, err := listener.Accept()
-		rs.Info("accepted connection", zap.String("remote_addr", c.RemoteAddr().String()))
+		conn, err := listener.Accept()
 		if err != nil {
+			rs.mu.Lock()
+			stopping := rs.stopping
+			rs.mu.Unlock()
+			if !stopping {
+				return err
+			}
+			// wait for Stop to complete
+			err = rs.Stop(context.Background())
+			rs.Info("server stopped")
 			return err
 		}
-		go rs.handleConnection(c)
+		rs.Debug("accepted connection", zap.String("remote_addr", conn.RemoteAddr().String()))
+		go rs.handleConnection(conn)
+	}
+}
+
+// Addr is the address the server listens on, nil until it started
+func (rs *RedisServerImpl) Addr() net.Addr {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.listener == nil {
+		return nil
+	}
+	return rs.listener.Addr()
+}
+
+// Stop stops the server, only once. The connections stop being read so that the
+// commands already received complete, then the executor persists the datastore.
+func (rs *RedisServerImpl) Stop(ctx context.Context) error {
+	rs.stopOnce.Do(func() {
+		rs.Info("stopping server")
+		rs.mu.Lock()
+		rs.stopping = true
+		if rs.listener != nil {
+			_ = rs.listener.Close()
+		}
+		if rs.stopped != nil {
+			close(rs.stopped)
+		}
+		for conn := range rs.conns {
+			closeRead(conn)
+		}
+		metricsServer := rs.metricsServer
+		rs.mu.Unlock()
+
+		if metricsServer != nil {
+			if err := metricsServer.Shutdown(ctx); err != nil {
+				rs.Error("error while stopping the metrics endpoint", zap.Error(err))
+			}
+		}
+		if rs.stopErr = rs.Close(); rs.stopErr != nil {
+			rs.Error("error while persisting the datastore", zap.Error(rs.stopErr))
+		}
+
+		done := make(chan struct{})
+		go func() {
+			rs.handlers.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			rs.Warn("closing the connections of the clients still being written to")
+			rs.mu.Lock()
+			for conn := range rs.conns {
+				_ = conn.Close()
+			}
+			rs.mu.Unlock()
+			<-done
+			rs.stopErr = errors.Join(rs.stopErr, ctx.Err())
+		}
+	})
+	return rs.stopErr
+}
+
+// closeRead stops reading a connection, its pending replies are still written
+func closeRead(conn net.Conn) {
+	if tc, ok := conn.(interface{ CloseRead() error }); ok {
+		_ = tc.CloseRead()
+		return
+	}
+	_ = conn.Close()
+}
+
+// addConn tracks an open connection, it is refused once the server is stopping
+func (rs *RedisServerImpl) addConn(conn net.Conn) bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.stopping {
+		return false
+	}
+	if rs.conns == nil {
+		rs.conns = make(map[net.Conn]struct{})
+	}
+	rs.conns[conn] = struct{}{}
+	rs.handlers.Add(1)
+	return true
+}
+
+func (rs *RedisServerImpl) removeConn(conn net.Conn) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	delete(rs.conns, conn)
+	rs.handlers.Done()
+}
+
+// watchClose reads the connection of a client blocked by a command, like Redis which
+// keeps reading the connections of its blocked clients: the wait of the client is
+// cancelled once the peer closes the connection. The commands sent meanwhile stay in
+// the reader. The returned function stops watching, the reader may be used again once
+// it returned.
+func watchClose(conn net.Conn, reader *bufio.Reader, client *Client) func() {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for reader.Buffered() < reader.Size() {
+			if _, err := reader.Peek(reader.Buffered() + 1); err != nil {
+				// a timeout is the watch being stopped
+				var netErr net.Error
+				if !errors.As(err, &netErr) || !netErr.Timeout() {
+					client.connectionLost()
+				}
+				return
+			}
+		}
+	}()
+	return func() {
+		_ = conn.SetReadDeadline(time.Now())
+		<-done
+		_ = conn.SetReadDeadline(time.Time{})
+	}
+}
+
+// serveMetrics serves the metrics endpoint, in Prometheus text format
+func (rs *RedisServerImpl) serveMetrics(server *http.Server) {
+	rs.Info("serving metrics", zap.String("addr", server.Addr), zap.String("path", metricsPath))
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		rs.Error("error while serving metrics", zap.Error(err))
 	}
 }
 
 // handleConnection parses the request from a client, generates a command, executes the
-// command on redis executor and sends the response back to the client
+// command on redis executor and sends the response back to the client.
+// The responses are written through the client, which serializes them with the
+// messages pushed to the connection by other clients (Pub/Sub).
 func (rs *RedisServerImpl) handleConnection(conn net.Conn) (err error) {
 	connId := zap.String("remote_addr", conn.RemoteAddr().String())
-	rs.Info("handling connection", connId)
+	if !rs.addConn(conn) {
+		return conn.Close()
+	}
+	defer rs.removeConn(conn)
+	rs.Debug("handling connection", connId)
 
+	client := NewClient(conn)
+	if err := rs.RegisterClient(client); err != nil {
+		rs.Debug("connection refused", connId, zap.Error(err))
+		client.Write(ErrorResponse(err))
+		_ = client.Close()
+		return conn.Close()
+	}
 	defer func() {
+		rs.FreeClient(client)
+		if err := client.Close(); err != nil {
+			rs.Error("error while writing response", zap.Error(err))
+		}
 		err = conn.Close()
-		rs.Info("connection closed", connId)
+		rs.Debug("connection closed", connId)
 	}()
 
 	var tokens []string
-	reader := bufio.NewReader(conn)
-
-	var sendResponse = func(response string) {
-		_, err = conn.Write([]byte(response))
-		if err != nil {
-			rs.Error("error while writing response", zap.Error(err))
-		}
-	}
+	reader := bufio.NewReaderSize(conn, readBufferSize)
+	client.watchClose = func() func() { return watchClose(conn, reader, client) }
 
 	for {
 		// read the request and parse the tokens
 		if tokens, err = rs.GetTokens(reader); err != nil {
-			if err == io.EOF {
-				rs.Info("client closed connection", connId)
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				rs.Debug("client closed connection", connId)
+				return nil
+			}
+			if errors.Is(err, net.ErrClosed) {
+				// closed by the server, e.g. when the client can't keep up with its messages
+				rs.Debug("connection closed by the server", connId)
+				return nil
+			}
+			var protocolErr ProtocolError
+			if errors.As(err, &protocolErr) {
+				rs.Debug("protocol error", connId, zap.Error(err))
+				client.Write(ErrorResponse(err))
 				return nil
 			}
 			rs.Warn("error while reading request", zap.Error(err))
@@ -84,13 +313,28 @@ func (rs *RedisServerImpl) handleConnection(conn net.Conn) (err error) {
 			continue
 		}
 
-		// execute the command and generate response
-		rs.Info("executing command", zap.String("command", execCmd.String()))
-		response := rs.Execute(execCmd)
+		// execute the command and generate response, the commands are only logged at
+		// the debug level
+		if ce := rs.Check(zap.DebugLevel, "executing command"); ce != nil {
+			ce.Write(zap.String("command", execCmd.String()))
+		}
+		response := rs.Execute(execCmd.SetClient(client))
 
 		// send the response to the client
-		rs.Info("writing request", zap.String("command", response.Serialize()))
+		if ce := rs.Check(zap.DebugLevel, "writing response"); ce != nil {
+			ce.Write(zap.String("response", response.Serialize()))
+		}
 
-		sendResponse(response.Serialize())
+		// the replies to pipelined commands are sent together, once the commands
+		// already received ran
+		client.Write(response)
+		if client.closeAfterReply {
+			return nil
+		}
+		if reader.Buffered() == 0 {
+			client.Flush()
+		}
+		// a client which doesn't read its replies isn't read either
+		client.WaitOutput()
 	}
 }
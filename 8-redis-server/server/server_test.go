@@ -0,0 +1,270 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// countingConn counts the writes to the connections of the server
+type countingConn struct {
+	net.Conn
+	writes *atomic.Int64
+}
+
+func (c countingConn) Write(p []byte) (int, error) {
+	c.writes.Add(1)
+	return c.Conn.Write(p)
+}
+
+// startCountingServer is startTestServer, counting the writes of the server, i.e. the
+// system calls writing replies
+func startCountingServer(t testing.TB, re *RedisExecutorImpl) (net.Conn, *atomic.Int64) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	rs := &RedisServerImpl{RedisExecutor: re, RedisTokenizer: DefaultTokenizer(), Logger: zap.NewNop()}
+	writes := &atomic.Int64{}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go rs.handleConnection(countingConn{conn, writes})
+		}
+	}()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn, writes
+}
+
+// pipeline sends the commands at once, then reads as many replies as commands
+func pipeline(t testing.TB, conn net.Conn, r *bufio.Reader, commands [][]string) []string {
+	t.Helper()
+	var buf []byte
+	for _, argv := range commands {
+		buf = appendAOFCommand(buf, argv)
+	}
+	errs := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(buf)
+		errs <- err
+	}()
+	replies := make([]string, len(commands))
+	for i := range replies {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		replies[i] = line
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	return replies
+}
+
+// roundTrip sends the command over the connection and checks its reply
+func roundTrip(t *testing.T, conn net.Conn, r *bufio.Reader, argv []string, want string) {
+	t.Helper()
+	if _, err := conn.Write(appendAOFCommand(nil, argv)); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, len(want))
+	if _, err := io.ReadFull(r, reply); err != nil || string(reply) != want {
+		t.Errorf("%q: got %q, %v, want %q", argv, reply, err, want)
+	}
+}
+
+func TestPipelining(t *testing.T) {
+	re := newTestExecutor()
+	conn, writes := startCountingServer(t, re)
+
+	// more commands than the replies queued before they are flushed
+	n := 3*pipelineMaxReplies + 10
+	commands := make([][]string, 0, n)
+	for i := 0; i < n; i++ {
+		commands = append(commands, []string{"INCR", "counter"})
+	}
+	for i, reply := range pipeline(t, conn, bufio.NewReader(conn), commands) {
+		if want := ":" + strconv.Itoa(i+1) + "\r\n"; reply != want {
+			t.Fatalf("reply %d: got %q, want %q", i, reply, want)
+		}
+	}
+	// the replies are written by batches
+	if got := writes.Load(); got > int64(n/10) {
+		t.Errorf("%d writes for %d replies", got, n)
+	}
+}
+
+func TestPipeliningBlocked(t *testing.T) {
+	re := newTestExecutor()
+	conn, _ := startCountingServer(t, re)
+	r := bufio.NewReader(conn)
+
+	// the replies queued before a blocking command are sent while it is blocked
+	if _, err := conn.Write(appendAOFCommand(appendAOFCommand(nil, []string{"PING"}), []string{"BLPOP", "q", "0"})); err != nil {
+		t.Fatal(err)
+	}
+	if line, err := r.ReadString('\n'); err != nil || line != "+PONG\r\n" {
+		t.Fatalf("PING: got %q, %v", line, err)
+	}
+	runSteps(t, re, []testStep{{cmd("RPUSH", "q", "a"), ":1\r\n"}})
+	if line, err := r.ReadString('\n'); err != nil || line != "*2\r\n" {
+		t.Fatalf("BLPOP: got %q, %v", line, err)
+	}
+}
+
+func TestPipeliningUnreadReplies(t *testing.T) {
+	re := newTestExecutor()
+	conn, _ := startCountingServer(t, re)
+	value := strings.Repeat("v", 16<<10)
+	roundTrip(t, conn, bufio.NewReader(conn), []string{"SET", "k", value}, "+OK\r\n")
+
+	// the client sends many commands without reading their replies: once its
+	// connection is full, the server stops reading them rather than queuing the replies
+	const n = 4000
+	var buf []byte
+	for i := 0; i < n; i++ {
+		buf = appendAOFCommand(buf, []string{"GET", "k"})
+	}
+	if _, err := conn.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	re.mu.Lock()
+	var client *Client
+	for _, c := range re.clients.byID {
+		client = c
+	}
+	re.mu.Unlock()
+	maxPending := 0
+	for deadline := time.Now().Add(200 * time.Millisecond); time.Now().Before(deadline); {
+		client.writer.mu.Lock()
+		maxPending = max(maxPending, len(client.writer.pending))
+		client.writer.mu.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+	if maxPending > pipelineMaxOutput+len(value)+16 {
+		t.Errorf("%d bytes of replies pending", maxPending)
+	}
+
+	// all the replies are sent once the client reads them
+	reply := "$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"
+	replies := make([]byte, n*len(reply))
+	if _, err := io.ReadFull(conn, replies); err != nil {
+		t.Fatal(err)
+	}
+	if string(replies) != strings.Repeat(reply, n) {
+		t.Error("wrong replies")
+	}
+}
+
+// BenchmarkPipelinedSet measures the throughput of SET commands sent by batches of
+// 100, as by redis-benchmark -P 100, and the writes of their replies
+func BenchmarkPipelinedSet(b *testing.B) {
+	const depth = 100
+	re := newTestExecutor()
+	conn, writes := startCountingServer(b, re)
+	r := bufio.NewReader(conn)
+	commands := make([][]string, depth)
+	for i := range commands {
+		commands[i] = []string{"SET", "key:" + strconv.Itoa(i), "value"}
+	}
+
+	b.ResetTimer()
+	for sent := 0; sent < b.N; sent += depth {
+		pipeline(b, conn, r, commands[:min(depth, b.N-sent)])
+	}
+	b.ReportMetric(float64(writes.Load())/float64(b.N), "writes/op")
+}
+
+func TestInlineCommands(t *testing.T) {
+	re := newTestExecutor()
+	conn, _ := startCountingServer(t, re)
+	r := bufio.NewReader(conn)
+	for _, step := range []struct {
+		request, reply string
+	}{
+		{"PING\r\n", "+PONG\r\n"},
+		{"set foo \"hello world\"\n", "+OK\r\n"},
+		{"  \r\n", ""},
+		{"GET foo\n", "$11\r\nhello world\r\n"},
+		{"ECHO 'it\\'s' \"\\x41\\tb\"\n", "-ERR wrong number of arguments for 'echo' command\r\n"},
+		{"ECHO \"\\x41\\tb\"\n", "$3\r\nA\tb\r\n"},
+		// inline and RESP requests may be mixed
+		{"*2\r\n$4\r\nECHO\r\n$2\r\nhi\r\n", "$2\r\nhi\r\n"},
+		{"ECHO 'it\\'s'\n", "$4\r\nit's\r\n"},
+	} {
+		if _, err := conn.Write([]byte(step.request)); err != nil {
+			t.Fatal(err)
+		}
+		if step.reply == "" {
+			continue
+		}
+		reply := make([]byte, len(step.reply))
+		if _, err := io.ReadFull(r, reply); err != nil || string(reply) != step.reply {
+			t.Errorf("%q: got %q, %v, want %q", step.request, reply, err, step.reply)
+		}
+	}
+
+	// the client is disconnected after a protocol error
+	if _, err := conn.Write([]byte("SET \"foo bar\n")); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := io.ReadAll(r); err != nil || string(reply) != "-ERR Protocol error: unbalanced quotes in request\r\n" {
+		t.Errorf("got %q, %v", reply, err)
+	}
+}
+
+func TestBinarySafeRequests(t *testing.T) {
+	re := newTestExecutor()
+	conn, _ := startCountingServer(t, re)
+	r := bufio.NewReader(conn)
+	runSteps(t, re, []testStep{{cmd("SET", "other", "v"), "+OK\r\n"}})
+
+	// a bulk string is read as its length, the CRLF it contains is not a new command
+	value := "x\r\nFLUSHALL\r\n"
+	replies := pipeline(t, conn, r, [][]string{{"SET", "k", value}, {"DBSIZE"}, {"STRLEN", "k"}})
+	if want := []string{"+OK\r\n", ":2\r\n", ":" + strconv.Itoa(len(value)) + "\r\n"}; strings.Join(replies, "") != strings.Join(want, "") {
+		t.Errorf("got %q, want %q", replies, want)
+	}
+	runSteps(t, re, []testStep{{cmd("GET", "k"), "$13\r\n" + value + "\r\n"}})
+}
+
+func TestProtocolErrors(t *testing.T) {
+	for _, step := range []struct {
+		request, reply string
+	}{
+		{"*1\r\n+PING\r\n", "-ERR Protocol error: expected '$', got '+'\r\n"},
+		{"*1\r\n$-2\r\n", "-ERR Protocol error: invalid bulk length\r\n"},
+		{"*x\r\n", "-ERR Protocol error: invalid multibulk length\r\n"},
+		{"*1\r\n$4\r\nPINGxx", "-ERR Protocol error: expected CRLF after the bulk string\r\n"},
+		// the lines are limited while they are read
+		{strings.Repeat("a", inlineMaxSize+1), "-ERR Protocol error: too big inline request\r\n"},
+		{"*1\r\n$" + strings.Repeat("1", inlineMaxSize), "-ERR Protocol error: too big bulk count string\r\n"},
+	} {
+		conn, _ := startCountingServer(t, newTestExecutor())
+		go func() { _, _ = conn.Write([]byte(step.request)) }()
+		reply := make([]byte, len(step.reply))
+		if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != step.reply {
+			t.Errorf("%.20q: got %q, %v, want %q", step.request, reply, err, step.reply)
+		}
+		// the client is disconnected after the error, the rest of its request unread
+		if n, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Errorf("%.20q: read %d bytes after the error", step.request, n)
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package server
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+func init() {
+	registerCommands(
+		&commandSpec{name: "sadd", arity: -3, handler: saddCommand, flags: flagWrite | flagDenyOOM, keys: firstKey, categories: catSet},
+		&commandSpec{name: "srem", arity: -3, handler: sremCommand, flags: flagWrite, keys: firstKey, categories: catSet},
+		&commandSpec{name: "sismember", arity: 3, handler: sismemberCommand, keys: firstKey, categories: catSet},
+		&commandSpec{name: "smismember", arity: -3, handler: smismemberCommand, keys: firstKey, categories: catSet},
+		&commandSpec{name: "smembers", arity: 2, handler: smembersCommand, keys: firstKey, categories: catSet},
+		&commandSpec{name: "scard", arity: 2, handler: scardCommand, keys: firstKey, categories: catSet},
+		&commandSpec{name: "spop", arity: -2, handler: spopCommand, flags: flagWrite, keys: firstKey, categories: catSet},
+		&commandSpec{name: "srandmember", arity: -2, handler: srandmemberCommand, keys: firstKey, categories: catSet},
+		&commandSpec{name: "smove", arity: 4, handler: smoveCommand, flags: flagWrite, keys: keySpec{0, 1, 1}, categories: catSet},
+		&commandSpec{name: "sinter", arity: -2, handler: setAlgebraCommand, keys: allKeys, categories: catSet},
+		&commandSpec{name: "sunion", arity: -2, handler: setAlgebraCommand, keys: allKeys, categories: catSet},
+		&commandSpec{name: "sdiff", arity: -2, handler: setAlgebraCommand, keys: allKeys, categories: catSet},
+		&commandSpec{name: "sinterstore", arity: -3, handler: setAlgebraCommand, flags: flagWrite | flagDenyOOM, keys: allKeys, categories: catSet},
+		&commandSpec{name: "sunionstore", arity: -3, handler: setAlgebraCommand, flags: flagWrite | flagDenyOOM, keys: allKeys, categories: catSet},
+		&commandSpec{name: "sdiffstore", arity: -3, handler: setAlgebraCommand, flags: flagWrite | flagDenyOOM, keys: allKeys, categories: catSet},
+		&commandSpec{name: "sscan", arity: -3, handler: sscanCommand, keys: firstKey, categories: catSet},
+	)
+}
+
+/* ---------------- helpers ---------------- */
+
+// Set is the value of set keys, the members are the keys of the dict
+type Set = Dict[struct{}]
+
+func NewSet() *Set {
+	return NewDict[struct{}]()
+}
+
+// lookupSet returns the set stored at key
+func lookupSet(re *RedisExecutorImpl, key string) (*Set, bool, error) {
+	item, found, err := lookupTyped(re, key, SetType)
+	if !found || err != nil {
+		return nil, found, err
+	}
+	return item.SetValue(), true, nil
+}
+
+// storeSet stores the set at key, replacing its value, and notifies the event. An
+// empty set deletes the key.
+func storeSet(re *RedisExecutorImpl, key string, set *Set, event string) {
+	if set.Len() == 0 {
+		if found, _ := re.Contains(key); found {
+			_ = re.Remove(key)
+			re.notifyKeyspaceEvent(notifyGeneric, "del", key)
+		}
+		re.signalModifiedKey(key)
+		return
+	}
+	_ = re.Set(key, &CacheItem{Key: key, Value: set, Type: SetType})
+	re.signalModifiedKey(key)
+	re.notifyKeyspaceEvent(notifySet, event, key)
+}
+
+// deleteSetIfEmpty removes the key once its set has no more members
+func deleteSetIfEmpty(re *RedisExecutorImpl, key string, set *Set) {
+	if set.Len() == 0 {
+		_ = re.Remove(key)
+		re.notifyKeyspaceEvent(notifyGeneric, "del", key)
+	}
+}
+
+// lookupSets returns the sets stored at the keys, missing keys are empty sets
+func lookupSets(re *RedisExecutorImpl, keys []string) ([]*Set, error) {
+	sets := make([]*Set, len(keys))
+	for i, key := range keys {
+		set, found, err := lookupSet(re, key)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			set = NewSet()
+		}
+		sets[i] = set
+	}
+	return sets, nil
+}
+
+// setInter intersects the sets. The smallest set is iterated and its members are
+// looked up in the other sets, from the smallest to the largest.
+func setInter(sets []*Set) *Set {
+	sorted := append([]*Set(nil), sets...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Len() < sorted[j].Len() })
+	result := NewSet()
+	sorted[0].ForEach(func(member string, _ struct{}) bool {
+		for _, other := range sorted[1:] {
+			if !other.Contains(member) {
+				return true
+			}
+		}
+		result.Set(member, struct{}{})
+		return true
+	})
+	return result
+}
+
+func setUnion(sets []*Set) *Set {
+	result := NewSet()
+	for _, set := range sets {
+		set.ForEach(func(member string, _ struct{}) bool {
+			result.Set(member, struct{}{})
+			return true
+		})
+	}
+	return result
+}
+
+// setDiff subtracts the other sets from the first one. Like Redis it either looks up
+// every member of the first set in the others, largest first so that a member is
+// discarded early, or removes the members of the others from a copy of the first set,
+// whichever visits fewer members.
+func setDiff(sets []*Set) *Set {
+	first, others := sets[0], append([]*Set(nil), sets[1:]...)
+	lookupCost, removeCost := first.Len()*len(others), first.Len()
+	for _, other := range others {
+		removeCost += other.Len()
+	}
+
+	result := NewSet()
+	if lookupCost <= removeCost {
+		sort.Slice(others, func(i, j int) bool { return others[i].Len() > others[j].Len() })
+		first.ForEach(func(member string, _ struct{}) bool {
+			for _, other := range others {
+				if other.Contains(member) {
+					return true
+				}
+			}
+			result.Set(member, struct{}{})
+			return true
+		})
+		return result
+	}
+
+	result = setUnion(sets[:1])
+	for _, other := range others {
+		other.ForEach(func(member string, _ struct{}) bool {
+			result.Delete(member)
+			return result.Len() > 0
+		})
+	}
+	return result
+}
+
+/* ---------------- commands ---------------- */
+
+// SADD key member [member ...]
+func saddCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	key := cmd.Arg(0)
+	set, found, err := lookupSet(re, key)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		set = NewSet()
+	}
+	var added int64
+	for _, member := range cmd.Args()[1:] {
+		if set.Set(member, struct{}{}) {
+			added++
+		}
+	}
+	if !found {
+		storeSet(re, key, set, "sadd")
+	} else if added > 0 {
+		re.signalModifiedKey(key)
+		re.notifyKeyspaceEvent(notifySet, "sadd", key)
+	}
+	return IntegerResponse(added)
+}
+
+// SREM key member [member ...]
+func sremCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	key := cmd.Arg(0)
+	set, found, err := lookupSet(re, key)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return IntegerResponse(0)
+	}
+	var removed int64
+	for _, member := range cmd.Args()[1:] {
+		if _, found := set.Delete(member); found {
+			removed++
+		}
+	}
+	if removed > 0 {
+		re.notifyKeyspaceEvent(notifySet, "srem", key)
+		deleteSetIfEmpty(re, key, set)
+		re.signalModifiedKey(key)
+	}
+	return IntegerResponse(removed)
+}
+
+// SISMEMBER key member
+func sismemberCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	set, found, err := lookupSet(re, cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if found && set.Contains(cmd.Arg(1)) {
+		return IntegerResponse(1)
+	}
+	return IntegerResponse(0)
+}
+
+// SMISMEMBER key member [member ...]
+func smismemberCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	set, found, err := lookupSet(re, cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	members := cmd.Args()[1:]
+	items := make([]*RedisResponse, len(members))
+	for i, member := range members {
+		items[i] = IntegerResponse(0)
+		if found && set.Contains(member) {
+			items[i] = IntegerResponse(1)
+		}
+	}
+	return ArrayResponse(items...)
+}
+
+// SMEMBERS key
+func smembersCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	set, found, err := lookupSet(re, cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return ArrayResponse()
+	}
+	return BulkArrayResponse(set.Keys())
+}
+
+// SCARD key
+func scardCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	set, found, err := lookupSet(re, cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return IntegerResponse(0)
+	}
+	return IntegerResponse(int64(set.Len()))
+}
+
+// SPOP key [count]
+func spopCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	if len(args) > 2 {
+		return ErrorResponse(ErrSyntax)
+	}
+	var count int64
+	if len(args) == 2 {
+		var ok bool
+		if count, ok = parseInt(args[1]); !ok || count < 0 {
+			return ErrorResponse(ErrNotPositive)
+		}
+	}
+	key := args[0]
+	set, found, err := lookupSet(re, key)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		if len(args) == 2 {
+			return ArrayResponse()
+		}
+		return NilResponse()
+	}
+
+	if len(args) == 1 {
+		member, _, _ := set.RandomEntry()
+		set.Delete(member)
+		re.notifyKeyspaceEvent(notifySet, "spop", key)
+		deleteSetIfEmpty(re, key, set)
+		re.signalModifiedKey(key)
+		// the members are random, the ones removed are propagated
+		re.rewriteCommand("srem", key, member)
+		return BulkResponse(member)
+	}
+	members := set.RandomKeys(int(min(count, int64(set.Len()))), true)
+	for _, member := range members {
+		set.Delete(member)
+	}
+	if len(members) > 0 {
+		re.notifyKeyspaceEvent(notifySet, "spop", key)
+		deleteSetIfEmpty(re, key, set)
+		re.signalModifiedKey(key)
+		re.rewriteCommand(append([]string{"srem", key}, members...)...)
+	}
+	return BulkArrayResponse(members)
+}
+
+// SRANDMEMBER key [count]. A negative count allows the same member to be
+// returned multiple times.
+func srandmemberCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	if len(args) > 2 {
+		return ErrorResponse(ErrSyntax)
+	}
+	set, found, err := lookupSet(re, args[0])
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if len(args) == 1 {
+		if !found {
+			return NilResponse()
+		}
+		member, _, _ := set.RandomEntry()
+		return BulkResponse(member)
+	}
+
+	count, ok := parseInt(args[1])
+	if !ok {
+		return ErrorResponse(ErrNotInteger)
+	}
+	if count == math.MinInt64 {
+		// its opposite doesn't fit
+		return ErrorResponse(ErrValueRange)
+	}
+	if !found || count == 0 {
+		return ArrayResponse()
+	}
+	if count < 0 {
+		return BulkArrayResponse(set.RandomKeys(int(-count), false))
+	}
+	return BulkArrayResponse(set.RandomKeys(int(min(count, int64(set.Len()))), true))
+}
+
+// SMOVE source destination member
+func smoveCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	src, dst, member := cmd.Arg(0), cmd.Arg(1), cmd.Arg(2)
+	srcSet, found, err := lookupSet(re, src)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	dstSet, dstFound, err := lookupSet(re, dst)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found || !srcSet.Contains(member) {
+		return IntegerResponse(0)
+	}
+	if src == dst {
+		return IntegerResponse(1)
+	}
+
+	srcSet.Delete(member)
+	re.notifyKeyspaceEvent(notifySet, "srem", src)
+	deleteSetIfEmpty(re, src, srcSet)
+	re.signalModifiedKey(src)
+	if !dstFound {
+		dstSet = NewSet()
+	}
+	dstSet.Set(member, struct{}{})
+	if !dstFound {
+		storeSet(re, dst, dstSet, "sadd")
+	} else {
+		re.signalModifiedKey(dst)
+		re.notifyKeyspaceEvent(notifySet, "sadd", dst)
+	}
+	return IntegerResponse(1)
+}
+
+// SINTER key [key ...], SUNION and SDIFF, and their STORE variants which take
+// the destination key first and reply with the cardinality of the result
+func setAlgebraCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	keys := cmd.Args()
+	store := strings.HasSuffix(cmd.Name(), "store")
+	if store {
+		keys = keys[1:]
+	}
+	sets, err := lookupSets(re, keys)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+
+	var result *Set
+	switch strings.TrimSuffix(cmd.Name(), "store") {
+	case "sinter":
+		result = setInter(sets)
+	case "sunion":
+		result = setUnion(sets)
+	case "sdiff":
+		result = setDiff(sets)
+	}
+
+	if store {
+		storeSet(re, cmd.Arg(0), result, cmd.Name())
+		return IntegerResponse(int64(result.Len()))
+	}
+	return BulkArrayResponse(result.Keys())
+}
+
+// SSCAN key cursor [MATCH pattern] [COUNT count]
+func sscanCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	opts, err := parseScanArgs(cmd.Args()[1:], false, false)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	set, found, err := lookupSet(re, cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return scanResponse(0, nil)
+	}
+	var members []string
+	cursor := scanDict(set, opts, func(member string, _ struct{}) {
+		members = append(members, member)
+	})
+	return scanResponse(cursor, members)
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"sort"
+	"testing"
+)
+
+// sortedMembers runs a command replying with an array of members, sorted for comparison
+func sortedMembers(re *RedisExecutorImpl, args ...string) []string {
+	reply := re.Execute(CreateCommandFromTokens(bulkTokens(args)))
+	var members []string
+	for _, item := range reply.Items {
+		members = append(members, item.Value)
+	}
+	sort.Strings(members)
+	return members
+}
+
+func TestSetCommands(t *testing.T) {
+	runSteps(t, newTestExecutor(), []testStep{
+		{cmd("SADD", "s", "a", "b", "c", "a"), ":3\r\n"},
+		{cmd("SCARD", "s"), ":3\r\n"},
+		{cmd("SISMEMBER", "s", "a"), ":1\r\n"},
+		{cmd("SMISMEMBER", "s", "a", "z"), "*2\r\n:1\r\n:0\r\n"},
+		{cmd("SREM", "s", "a", "z"), ":1\r\n"},
+		{cmd("SMOVE", "s", "t", "b"), ":1\r\n"},
+		{cmd("SMOVE", "s", "t", "b"), ":0\r\n"},
+		{cmd("SMEMBERS", "t"), "*1\r\n$1\r\nb\r\n"},
+		{cmd("SPOP", "t"), "$1\r\nb\r\n"},
+		{cmd("EXISTS", "t"), ":0\r\n"},
+		{cmd("SPOP", "t", "2"), "*0\r\n"},
+		{cmd("SRANDMEMBER", "s", "-2"), "*2\r\n$1\r\nc\r\n$1\r\nc\r\n"},
+		{cmd("SPOP", "s", "-1"), "-ERR value is out of range, must be positive\r\n"},
+		{cmd("SPOP", "s", "-9223372036854775808"), "-ERR value is out of range, must be positive\r\n"},
+		{cmd("SRANDMEMBER", "s", "99999999999999"), "*1\r\n$1\r\nc\r\n"},
+		{cmd("SRANDMEMBER", "s", "-9223372036854775808"), "-ERR value is out of range\r\n"},
+		{cmd("SPOP", "s", "99999999999999"), "*1\r\n$1\r\nc\r\n"},
+		{cmd("EXISTS", "s"), ":0\r\n"},
+		{cmd("SET", "str", "v"), "+OK\r\n"},
+		{cmd("SADD", "str", "v"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{cmd("SINTER", "s", "str"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	})
+}
+
+func TestSetAlgebra(t *testing.T) {
+	re := newTestExecutor()
+	execute(re, "SADD", "a", "1", "2", "3", "4")
+	execute(re, "SADD", "b", "2", "3", "5")
+	execute(re, "SADD", "c", "3", "4", "2", "9", "10")
+
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{cmd("SINTER", "a", "b", "c"), []string{"2", "3"}},
+		{cmd("SINTER", "a", "missing"), nil},
+		{cmd("SUNION", "a", "b", "missing"), []string{"1", "2", "3", "4", "5"}},
+		{cmd("SDIFF", "a", "b"), []string{"1", "4"}},
+		{cmd("SDIFF", "c", "a", "b"), []string{"10", "9"}},
+	}
+	for _, test := range tests {
+		got := sortedMembers(re, test.args...)
+		if len(got) != len(test.want) {
+			t.Errorf("%v: got %v, want %v", test.args, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%v: got %v, want %v", test.args, got, test.want)
+				break
+			}
+		}
+	}
+
+	runSteps(t, re, []testStep{
+		{cmd("SINTERSTORE", "dst", "a", "b"), ":2\r\n"},
+		{cmd("SUNIONSTORE", "dst", "dst", "c"), ":5\r\n"},
+		{cmd("SDIFFSTORE", "dst", "a", "a"), ":0\r\n"},
+		{cmd("EXISTS", "dst"), ":0\r\n"},
+	})
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ShutdownTimeout is the time the server waits for its clients to receive their
+// last replies once it stops, before closing their connections
+const ShutdownTimeout = 10 * time.Second
+
+var (
+	ErrShutdown     = errors.New("ERR Errors trying to SHUTDOWN. Check logs.")
+	ErrShuttingDown = errors.New("ERR the server is shutting down")
+)
+
+func init() {
+	registerCommands(
+		&commandSpec{name: "shutdown", arity: -1, handler: shutdownCommand, flags: flagNoMulti, categories: catAdmin | catDangerous},
+	)
+}
+
+// shutdownMode tells whether the datastore is saved before the server exits
+type shutdownMode int
+
+const (
+	shutdownDefault shutdownMode = iota // saved if save rules are configured
+	shutdownSave
+	shutdownNoSave
+)
+
+// shutdownState is guarded by the executor lock
+type shutdownState struct {
+	requested chan struct{} // closed by SHUTDOWN, the server then stops
+	closed    bool          // the datastore was persisted, the commands are rejected
+}
+
+func newShutdownState() shutdownState {
+	return shutdownState{requested: make(chan struct{})}
+}
+
+// ShutdownRequested is closed once SHUTDOWN persisted the datastore
+func (re *RedisExecutorImpl) ShutdownRequested() <-chan struct{} {
+	return re.shutdown.requested
+}
+
+// Close persists the datastore once the server stopped accepting commands, the
+// blocked clients being unblocked, then stops the event loop. The commands are
+// rejected afterwards.
+func (re *RedisExecutorImpl) Close() (err error) {
+	re.exclusive(func() { err = re.prepareShutdown(shutdownDefault) })
+	re.stopEventLoop()
+	return err
+}
+
+// prepareShutdown waits for the background saves, saves the snapshot and syncs the
+// append only file, then stops the replication and the cron. The paused and blocked
+// clients are woken up. The server keeps running if the snapshot can't be saved.
+func (re *RedisExecutorImpl) prepareShutdown(mode shutdownMode) error {
+	if re.shutdown.closed {
+		return nil
+	}
+	// the files written in the background would replace those written below
+	if state := re.rdb.bgsave; state != nil {
+		<-state.written
+		re.bgsaveDone(state)
+	}
+	if state := re.aof.rewrite; state != nil {
+		<-state.written
+		re.rewriteDone(state)
+	}
+
+	if mode == shutdownSave || (mode == shutdownDefault && len(re.config.SaveRules) > 0) {
+		if err := re.save(); err != nil {
+			return err
+		}
+	}
+	if file := re.aof.file; file != nil {
+		re.flushAppendOnly()
+		if err := file.Sync(); err != nil {
+			re.Error("error while syncing the append only file", zap.Error(err))
+			return err
+		}
+		if err := file.Close(); err != nil {
+			re.Error("error while closing the append only file", zap.Error(err))
+		}
+		re.aof.file = nil
+	}
+	re.stopReplication()
+	re.unpauseClients(true)
+	for _, c := range re.clients.byID {
+		if bc := c.blocked; bc != nil {
+			re.unblock(bc)
+			bc.reply <- NullArrayResponse()
+		}
+	}
+	re.shutdown.closed = true
+	re.Info("datastore persisted, ready to exit")
+	return nil
+}
+
+// SHUTDOWN [NOSAVE|SAVE], the client is disconnected without reply once the server
+// is ready to exit
+func shutdownCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	mode := shutdownDefault
+	if len(cmd.Args()) > 1 {
+		return ErrorResponse(ErrSyntax)
+	}
+	for _, arg := range cmd.Args() {
+		switch strings.ToLower(arg) {
+		case "save":
+			mode = shutdownSave
+		case "nosave":
+			mode = shutdownNoSave
+		default:
+			return ErrorResponse(ErrSyntax)
+		}
+	}
+	if err := re.prepareShutdown(mode); err != nil {
+		return ErrorResponse(ErrShutdown)
+	}
+	re.Info("shutdown requested by a client")
+	close(re.shutdown.requested)
+	if client := cmd.Client(); client != nil {
+		client.closeAfterReply = true
+	}
+	return NoReplyResponse()
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// startServer starts the server of the executor on a random local port, the error of
+// Start being sent once it returns
+func startServer(t *testing.T, re *RedisExecutorImpl) (*RedisServerImpl, chan error) {
+	t.Helper()
+	re.config.Bind, re.config.Port = "127.0.0.1", 0
+	rs := &RedisServerImpl{RedisExecutor: re, RedisTokenizer: DefaultTokenizer(), Logger: zap.NewNop(), config: re.config}
+	errs := make(chan error, 1)
+	go func() { errs <- rs.Start() }()
+	deadline := time.Now().Add(5 * time.Second)
+	for rs.Addr() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the server didn't start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return rs, errs
+}
+
+// sendCommand writes a command to the connection in RESP format
+func sendCommand(t *testing.T, conn net.Conn, args ...string) {
+	t.Helper()
+	if _, err := conn.Write(appendAOFCommand(nil, args)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	re := newTestExecutor()
+	re.config.Dir = t.TempDir()
+	re.config.SaveRules = nil
+	runSteps(t, re, []testStep{
+		{cmd("SET", "k", "v"), "+OK\r\n"},
+		{cmd("SHUTDOWN", "FOO"), "-" + ErrSyntax.Error() + "\r\n"},
+		{cmd("SHUTDOWN", "SAVE", "NOSAVE"), "-" + ErrSyntax.Error() + "\r\n"},
+		{cmd("SHUTDOWN", "NOSAVE"), ""},
+		{cmd("GET", "k"), "-" + ErrShuttingDown.Error() + "\r\n"},
+	})
+	select {
+	case <-re.ShutdownRequested():
+	default:
+		t.Error("the shutdown wasn't requested")
+	}
+	if _, err := os.Stat(re.config.SnapshotPath()); !os.IsNotExist(err) {
+		t.Errorf("snapshot: got %v", err)
+	}
+	// closing the executor again does nothing
+	if err := re.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestShutdownSave(t *testing.T) {
+	for _, test := range []struct {
+		args  []string
+		rules []SaveRule
+		saved bool
+	}{
+		{[]string{"SHUTDOWN"}, nil, false},
+		{[]string{"SHUTDOWN"}, DefaultConfig().SaveRules, true},
+		{[]string{"SHUTDOWN", "SAVE"}, nil, true},
+		{[]string{"SHUTDOWN", "NOSAVE"}, DefaultConfig().SaveRules, false},
+	} {
+		re := newTestExecutor()
+		re.config.Dir = t.TempDir()
+		re.config.SaveRules = test.rules
+		execute(re, "SET", "k", "v")
+		execute(re, test.args...)
+		if _, err := os.Stat(re.config.SnapshotPath()); (err == nil) != test.saved {
+			t.Errorf("%v with rules %v: got %v", test.args, test.rules, err)
+		}
+	}
+
+	// the server keeps running if the snapshot can't be saved
+	re := newTestExecutor()
+	re.config.Dir = filepath.Join(t.TempDir(), "missing")
+	runSteps(t, re, []testStep{
+		{cmd("SHUTDOWN", "SAVE"), "-" + ErrShutdown.Error() + "\r\n"},
+		{cmd("PING"), "+PONG\r\n"},
+	})
+}
+
+func TestServerStop(t *testing.T) {
+	dir := t.TempDir()
+	re := newAppendOnlyExecutor(t, dir)
+	re.config.SaveRules = nil
+	rs, errs := startServer(t, re)
+
+	writer, err := net.Dial("tcp", rs.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	sendCommand(t, writer, "SET", "k", "v")
+	writerReader := bufio.NewReader(writer)
+	if line, err := writerReader.ReadString('\n'); err != nil || line != "+OK\r\n" {
+		t.Fatalf("SET: got %q, %v", line, err)
+	}
+
+	blocked, err := net.Dial("tcp", rs.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blocked.Close()
+	sendCommand(t, blocked, "BLPOP", "q", "0")
+	for {
+		re.mu.Lock()
+		n := len(re.blocked)
+		re.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := rs.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Errorf("Start: got %v", err)
+	}
+	// the blocked client is replied, then the connections are closed
+	if reply, err := io.ReadAll(blocked); err != nil || string(reply) != "*-1\r\n" {
+		t.Errorf("BLPOP: got %q, %v", reply, err)
+	}
+	if _, err := writerReader.ReadString('\n'); err != io.EOF {
+		t.Errorf("got %v", err)
+	}
+	if _, err := net.Dial("tcp", writer.RemoteAddr().String()); err == nil {
+		t.Error("connected to the stopped server")
+	}
+	if got := strings.Join(readAppendOnly(t, re.config.AppendOnlyPath()), " "); !strings.Contains(got, "set k v") {
+		t.Errorf("append only file: got %q", got)
+	}
+	if err := rs.Stop(ctx); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestShutdownCommandStopsServer(t *testing.T) {
+	re := newTestExecutor()
+	re.config.SaveRules = nil
+	rs, errs := startServer(t, re)
+	conn, err := net.Dial("tcp", rs.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	sendCommand(t, conn, "SHUTDOWN")
+	// no reply, the connection is closed
+	if reply, err := io.ReadAll(conn); err != nil || len(reply) != 0 {
+		t.Errorf("SHUTDOWN: got %q, %v", reply, err)
+	}
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Errorf("Start: got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server didn't stop")
+	}
+}
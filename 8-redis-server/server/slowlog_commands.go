@@ -0,0 +1,92 @@
+package server
+
+import (
+	"sort"
+	"strings"
+)
+
+func init() {
+	registerCommands(
+		&commandSpec{name: "slowlog", arity: -2, handler: slowlogCommand, categories: catAdmin | catDangerous},
+		&commandSpec{name: "latency", arity: -2, handler: latencyCommand, categories: catAdmin | catDangerous},
+	)
+}
+
+// SLOWLOG GET [count] | LEN | RESET. GET replies the @count most recent entries, 10 by
+// default and all of them with -1.
+func slowlogCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	switch subcommand := strings.ToLower(args[0]); {
+	case subcommand == "get" && len(args) <= 2:
+		count := int64(10)
+		if len(args) == 2 {
+			var ok bool
+			if count, ok = parseInt(args[1]); !ok || count < -1 {
+				return ErrorResponse(ErrNotInteger)
+			}
+		}
+		entries := re.stats.slowlog
+		if count >= 0 && count < int64(len(entries)) {
+			entries = entries[:count]
+		}
+		replies := make([]*RedisResponse, len(entries))
+		for i, entry := range entries {
+			replies[i] = ArrayResponse(
+				IntegerResponse(entry.id),
+				IntegerResponse(entry.time),
+				IntegerResponse(entry.duration),
+				BulkArrayResponse(entry.args),
+				BulkResponse(entry.addr),
+				BulkResponse(entry.name),
+			)
+		}
+		return ArrayResponse(replies...)
+	case subcommand == "len" && len(args) == 1:
+		return IntegerResponse(int64(len(re.stats.slowlog)))
+	case subcommand == "reset" && len(args) == 1:
+		re.stats.slowlog = nil
+		return OKResponse()
+	}
+	return ErrorResponse(UnknownSubcommandError(cmd))
+}
+
+// LATENCY LATEST | RESET [event ...]. LATEST replies the name, the time and the
+// duration in milliseconds of the latest spike of each event, and its worst spike.
+// RESET removes the spikes of the events, of all of them without arguments.
+func latencyCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	switch subcommand := strings.ToLower(args[0]); {
+	case subcommand == "latest" && len(args) == 1:
+		names := make([]string, 0, len(re.stats.latency))
+		for name := range re.stats.latency {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		replies := make([]*RedisResponse, len(names))
+		for i, name := range names {
+			event := re.stats.latency[name]
+			replies[i] = ArrayResponse(
+				BulkResponse(name),
+				IntegerResponse(event.time),
+				IntegerResponse(event.latest),
+				IntegerResponse(event.max),
+			)
+		}
+		return ArrayResponse(replies...)
+	case subcommand == "reset":
+		if len(args) == 1 {
+			reset := len(re.stats.latency)
+			clear(re.stats.latency)
+			return IntegerResponse(int64(reset))
+		}
+		var reset int64
+		for _, name := range args[1:] {
+			if _, found := re.stats.latency[name]; found {
+				delete(re.stats.latency, name)
+				reset++
+			}
+		}
+		return IntegerResponse(reset)
+	}
+	return ErrorResponse(UnknownSubcommandError(cmd))
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// bgsaveRetryDelay is the delay before a save rule triggers a snapshot again
+// after a failed background snapshot
+const bgsaveRetryDelay = 5 * time.Second
+
+var (
+	ErrBgsaveInProgress = errors.New("ERR Background save already in progress")
+	ErrBgsaveDuringAOF  = errors.New("ERR An AOF log rewriting in progress: can't BGSAVE right now")
+)
+
+func init() {
+	registerCommands(
+		&commandSpec{name: "save", arity: 1, handler: saveCommand, flags: flagNoMulti, categories: catAdmin | catDangerous},
+		&commandSpec{name: "bgsave", arity: 1, handler: bgsaveCommand, categories: catAdmin | catDangerous},
+		&commandSpec{name: "lastsave", arity: 1, handler: lastsaveCommand, categories: catAdmin | catDangerous},
+	)
+}
+
+// snapshotState tracks the snapshots of the datastore, it is guarded by the executor lock
+type snapshotState struct {
+	dirty        int64     // changes since the last snapshot
+	lastSave     time.Time // time of the last successful snapshot
+	lastBgsave   time.Time // start of the last background snapshot
+	lastBgsaveOK bool
+
+	// set while a background snapshot is written
+	bgsave *bgsaveState
+}
+
+type bgsaveState struct {
+	dirty   int64 // changes counted when the snapshot started
+	err     error
+	written chan struct{} // closed once the file is written, see bgsaveDone
+	done    chan struct{} // closed once the save completed
+}
+
+/* ---------------- snapshots ---------------- */
+
+// snapshotItems copies the items of each database. The values are not copied, if @share
+// is set they are marked as shared with the background save instead.
+func (re *RedisExecutorImpl) snapshotItems(share bool) [][]CacheItem {
+	now := time.Now()
+	if share {
+		re.shared = make(map[*CacheItem]struct{})
+	}
+	dbs := make([][]CacheItem, len(re.dbs))
+	for id, db := range re.dbs {
+		items := make([]CacheItem, 0, db.Len())
+		db.ForEach(func(key string, item *CacheItem) bool {
+			if item.IsExpired(now) {
+				return true
+			}
+			items = append(items, *item)
+			if share {
+				re.shared[item] = struct{}{}
+			}
+			return true
+		})
+		dbs[id] = items
+	}
+	return dbs
+}
+
+// unshare copies the value of an item shared with the background save
+func (re *RedisExecutorImpl) unshare(item *CacheItem) {
+	if _, shared := re.shared[item]; shared {
+		item.Value = item.cloneValue()
+		delete(re.shared, item)
+	}
+}
+
+// save writes the snapshot, blocking every client until it is written
+func (re *RedisExecutorImpl) save() error {
+	if err := saveSnapshotFile(re.config.SnapshotPath(), re.snapshotItems(false)); err != nil {
+		re.Error("error while saving the snapshot", zap.Error(err))
+		return err
+	}
+	re.rdb.dirty = 0
+	re.rdb.lastSave = time.Now()
+	re.Info("snapshot saved", zap.String("path", re.config.SnapshotPath()))
+	return nil
+}
+
+// bgsave writes the snapshot in the background. The commands keep running meanwhile,
+// the values they access being copied first if the snapshot didn't write them yet.
+func (re *RedisExecutorImpl) bgsave() error {
+	if re.rdb.bgsave != nil {
+		return ErrBgsaveInProgress
+	}
+	// the values can only be shared with one background save
+	if re.aof.rewrite != nil {
+		return ErrBgsaveDuringAOF
+	}
+	dbs := re.snapshotItems(true)
+	state := &bgsaveState{dirty: re.rdb.dirty, written: make(chan struct{}), done: make(chan struct{})}
+	re.rdb.bgsave = state
+	re.rdb.lastBgsave = time.Now()
+	path := re.config.SnapshotPath()
+
+	go func() {
+		state.err = saveSnapshotFile(path, dbs)
+		close(state.written)
+		re.exclusive(func() { re.bgsaveDone(state) })
+	}()
+	return nil
+}
+
+// bgsaveDone completes the background save once its file is written, unless the
+// shutdown completed it already
+func (re *RedisExecutorImpl) bgsaveDone(state *bgsaveState) {
+	if re.rdb.bgsave != state {
+		return
+	}
+	err := state.err
+	re.rdb.lastBgsaveOK = err == nil
+	if err != nil {
+		re.Error("error while saving the snapshot in the background", zap.Error(err))
+	} else {
+		// changes made while the snapshot was written are not part of it
+		re.rdb.dirty -= re.rdb.bgsave.dirty
+		re.rdb.lastSave = time.Now()
+		re.Info("background snapshot saved", zap.String("path", re.config.SnapshotPath()))
+	}
+	re.rdb.bgsave = nil
+	re.shared = nil
+	close(state.done)
+}
+
+// checkSaveRules starts a background snapshot once a save rule is met
+func (re *RedisExecutorImpl) checkSaveRules(now time.Time) {
+	if re.config == nil || re.rdb.bgsave != nil || re.aof.rewrite != nil {
+		return
+	}
+	// after a failure, wait a bit before trying again
+	if !re.rdb.lastBgsaveOK && now.Sub(re.rdb.lastBgsave) < bgsaveRetryDelay {
+		return
+	}
+	for _, rule := range re.config.SaveRules {
+		if rule.met(re.rdb.dirty, now.Sub(re.rdb.lastSave)) {
+			re.Info("save rule met, saving", zap.Int64("seconds", rule.Seconds), zap.Int64("changes", rule.Changes))
+			_ = re.bgsave()
+			return
+		}
+	}
+}
+
+// LoadSnapshot loads the snapshot file into the datastore, the keys which
+// already expired are skipped
+func (re *RedisExecutorImpl) LoadSnapshot() (err error) {
+	re.exclusive(func() { err = re.loadSnapshot() })
+	return err
+}
+
+func (re *RedisExecutorImpl) loadSnapshot() error {
+	now := time.Now()
+	loaded := 0
+	err := loadSnapshotFile(re.config.SnapshotPath(), func(db int, item *CacheItem) error {
+		if db >= len(re.dbs) {
+			return fmt.Errorf("the snapshot has more than %d databases, see the databases option", len(re.dbs))
+		}
+		if !item.IsExpired(now) {
+			_ = re.dbs[db].Set(item.Key, item)
+			loaded++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	re.rdb.lastSave = now
+	re.Info("snapshot loaded", zap.String("path", re.config.SnapshotPath()), zap.Int("keys", loaded))
+	return nil
+}
+
+/* ---------------- commands ---------------- */
+
+// SAVE
+func saveCommand(re *RedisExecutorImpl, _ *Cmd) *RedisResponse {
+	if re.rdb.bgsave != nil {
+		return ErrorResponse(ErrBgsaveInProgress)
+	}
+	if err := re.save(); err != nil {
+		return ErrorResponse(errors.New("ERR " + err.Error()))
+	}
+	return OKResponse()
+}
+
+// BGSAVE
+func bgsaveCommand(re *RedisExecutorImpl, _ *Cmd) *RedisResponse {
+	if err := re.bgsave(); err != nil {
+		return ErrorResponse(err)
+	}
+	return SimpleStringResponse("Background saving started")
+}
+
+// LASTSAVE, the unix time of the last successful snapshot
+func lastsaveCommand(re *RedisExecutorImpl, _ *Cmd) *RedisResponse {
+	return IntegerResponse(re.rdb.lastSave.Unix())
+}
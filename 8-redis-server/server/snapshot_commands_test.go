@@ -0,0 +1,105 @@
+package server
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// waitBgsave waits for the background snapshot to complete
+func waitBgsave(re *RedisExecutorImpl) {
+	re.mu.Lock()
+	state := re.rdb.bgsave
+	re.mu.Unlock()
+	if state != nil {
+		<-state.done
+	}
+}
+
+func TestSave(t *testing.T) {
+	re := newTestExecutor()
+	re.config.Dir = t.TempDir()
+	populate(re)
+	start := time.Now().Unix()
+	runSteps(t, re, []testStep{{cmd("SAVE"), "+OK\r\n"}})
+	if lastSave := execute(re, "LASTSAVE"); lastSave < ":"+strconv.FormatInt(start, 10) {
+		t.Errorf("LASTSAVE: got %q, want at least %d", lastSave, start)
+	}
+	if re.rdb.dirty != 0 {
+		t.Errorf("dirty: got %d, want 0", re.rdb.dirty)
+	}
+
+	loaded := newTestExecutor()
+	loaded.config = re.config
+	if err := loaded.LoadSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	want, got := dump(re), dump(loaded)
+	for name := range want {
+		if got[name] != want[name] {
+			t.Errorf("%s: got %q, want %q", name, got[name], want[name])
+		}
+	}
+}
+
+func TestBgsaveCopyOnWrite(t *testing.T) {
+	re := newTestExecutor()
+	re.config.Dir = t.TempDir()
+	populate(re)
+	want := dump(re)
+
+	runSteps(t, re, []testStep{
+		{cmd("BGSAVE"), "+Background saving started\r\n"},
+		{cmd("RPUSH", "list", "d"), ":4\r\n"},
+		{cmd("HSET", "hash", "f", "changed"), ":0\r\n"},
+		{cmd("SREM", "set", "b"), ":1\r\n"},
+		{cmd("ZADD", "zset", "0", "a"), ":0\r\n"},
+		{cmd("SET", "int", "1"), "+OK\r\n"},
+		{cmd("SETRANGE", "str", "0", "V"), ":5\r\n"},
+		{cmd("DEL", "big"), ":1\r\n"},
+	})
+	waitBgsave(re)
+	if re.rdb.dirty != 7 {
+		t.Errorf("dirty: got %d, want the 7 changes made during the snapshot", re.rdb.dirty)
+	}
+
+	loaded := newTestExecutor()
+	loaded.config = re.config
+	if err := loaded.LoadSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	got := dump(loaded)
+	for name := range want {
+		if got[name] != want[name] {
+			t.Errorf("%s: got %q, want %q", name, got[name], want[name])
+		}
+	}
+}
+
+func TestSaveRules(t *testing.T) {
+	re := newTestExecutor()
+	re.config.Dir = t.TempDir()
+	re.config.SaveRules = []SaveRule{{Seconds: 0, Changes: 2}}
+	execute(re, "SET", "a", "1")
+
+	re.mu.Lock()
+	re.checkSaveRules(re.rdb.lastSave)
+	started := re.rdb.bgsave != nil
+	re.mu.Unlock()
+	if started {
+		t.Fatal("snapshot started after 1 change")
+	}
+
+	execute(re, "SET", "b", "2")
+	re.mu.Lock()
+	re.checkSaveRules(re.rdb.lastSave)
+	started = re.rdb.bgsave != nil
+	re.mu.Unlock()
+	if !started {
+		t.Fatal("snapshot not started after 2 changes")
+	}
+	waitBgsave(re)
+	if re.rdb.dirty != 0 || !re.rdb.lastBgsaveOK {
+		t.Errorf("dirty: %d, last bgsave ok: %v", re.rdb.dirty, re.rdb.lastBgsaveOK)
+	}
+}
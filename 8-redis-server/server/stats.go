@@ -0,0 +1,227 @@
+package server
+
+import (
+	"strconv"
+	"time"
+)
+
+const (
+	// opsSamples is the number of samples averaged by instantaneous_ops_per_sec
+	opsSamples = 16
+	// opsSampleInterval is the period of the samples of instantaneous_ops_per_sec
+	opsSampleInterval = 100 * time.Millisecond
+
+	// slowlogMaxArgs is the number of arguments of a command kept by the slow log
+	slowlogMaxArgs = 32
+	// slowlogMaxArgLen is the number of bytes of an argument kept by the slow log
+	slowlogMaxArgLen = 128
+)
+
+// latencyBuckets are the upper bounds of the buckets of the latency histograms, in seconds
+var latencyBuckets = []float64{0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1}
+
+// statsState holds the counters of the server reported by INFO, SLOWLOG, LATENCY and
+// the metrics endpoint, it is guarded by the executor lock
+type statsState struct {
+	startTime           time.Time
+	runID               string // random ID of this run of the server
+	totalConnections    int64
+	rejectedConnections int64 // refused once maxclients clients were connected
+	totalCommands       int64
+	errorReplies        int64
+	keyspaceHits        int64
+	keyspaceMisses      int64
+	expiredKeys         int64 // keys expired in the databases emptied since, see expiredKeysCount
+	peakMemory          int64
+
+	commands map[string]*commandStats // by command name
+
+	// instantaneous_ops_per_sec is averaged over the last samples taken by the cron
+	opsSamples      [opsSamples]int64
+	opsSampleIndex  int
+	opsSampleTime   time.Time
+	opsSampleCounts int64
+
+	slowlog       []*slowlogEntry // the most recent entry first
+	slowlogNextID int64
+	latency       map[string]*latencyEvent // by event name
+}
+
+// commandStats are the calls of a command, reported by INFO commandstats
+type commandStats struct {
+	calls    int64
+	usec     int64
+	rejected int64 // rejected before running, e.g. by the ACL or with the wrong arity
+	failed   int64 // ran and replied an error
+	// buckets counts the calls taking up to each of latencyBuckets
+	buckets []int64
+}
+
+// slowlogEntry is a command which took longer than slowlog-log-slower-than
+type slowlogEntry struct {
+	id       int64
+	time     int64 // unix time in seconds
+	duration int64 // microseconds
+	args     []string
+	addr     string
+	name     string // of the client
+}
+
+// latencyEvent is the latest and the worst latency spike of an event, reported by
+// LATENCY LATEST
+type latencyEvent struct {
+	time   int64 // unix time in seconds of the latest spike
+	latest int64 // milliseconds
+	max    int64
+}
+
+func newStatsState() statsState {
+	now := time.Now()
+	return statsState{
+		startTime:     now,
+		runID:         newReplicationID(),
+		commands:      make(map[string]*commandStats),
+		opsSampleTime: now,
+		latency:       make(map[string]*latencyEvent),
+	}
+}
+
+// command returns the stats of the command, created on its first call
+func (s *statsState) command(name string) *commandStats {
+	stats, found := s.commands[name]
+	if !found {
+		stats = &commandStats{buckets: make([]int64, len(latencyBuckets))}
+		s.commands[name] = stats
+	}
+	return stats
+}
+
+// recordCommand accounts a call of the command, adding it to the slow log and to the
+// latency events if it was slow
+func (re *RedisExecutorImpl) recordCommand(spec *commandSpec, cmd *Cmd, response *RedisResponse, duration time.Duration) {
+	stats := re.stats.command(spec.name)
+	stats.calls++
+	stats.usec += duration.Microseconds()
+	for i, bound := range latencyBuckets {
+		if duration.Seconds() <= bound {
+			stats.buckets[i]++
+		}
+	}
+	re.stats.totalCommands++
+	if response.Error != nil {
+		stats.failed++
+		re.stats.errorReplies++
+	}
+	if !spec.is(flagSkipSlowlog) {
+		re.slowlogPush(cmd, duration)
+		re.latencyAddSample("command", duration)
+	}
+}
+
+// rejectCommand replies an error to a command which didn't run
+func (re *RedisExecutorImpl) rejectCommand(spec *commandSpec, err error) *RedisResponse {
+	if spec != nil {
+		re.stats.command(spec.name).rejected++
+	}
+	re.stats.errorReplies++
+	return ErrorResponse(err)
+}
+
+// expiredKeysCount is the number of keys removed once they expired
+func (re *RedisExecutorImpl) expiredKeysCount() int64 {
+	expired := re.stats.expiredKeys
+	for _, db := range re.dbs {
+		expired += db.ExpiredKeys()
+	}
+	return expired
+}
+
+// trackPeakMemory updates the peak of the used memory
+func (re *RedisExecutorImpl) trackPeakMemory() int64 {
+	used := re.usedMemory()
+	re.stats.peakMemory = max(re.stats.peakMemory, used)
+	return used
+}
+
+// sampleOps samples the commands processed per second, called by the cron
+func (re *RedisExecutorImpl) sampleOps(now time.Time) {
+	elapsed := now.Sub(re.stats.opsSampleTime)
+	if elapsed < opsSampleInterval {
+		return
+	}
+	ops := (re.stats.totalCommands - re.stats.opsSampleCounts) * int64(time.Second) / int64(elapsed)
+	re.stats.opsSamples[re.stats.opsSampleIndex] = ops
+	re.stats.opsSampleIndex = (re.stats.opsSampleIndex + 1) % opsSamples
+	re.stats.opsSampleTime = now
+	re.stats.opsSampleCounts = re.stats.totalCommands
+}
+
+// instantaneousOps is the average of the samples of the commands processed per second
+func (re *RedisExecutorImpl) instantaneousOps() int64 {
+	var sum int64
+	for _, ops := range re.stats.opsSamples {
+		sum += ops
+	}
+	return sum / opsSamples
+}
+
+/* ---------------- slow log ---------------- */
+
+// slowlogPush logs the command if it ran for at least slowlog-log-slower-than
+// microseconds, a negative threshold disabling the slow log
+func (re *RedisExecutorImpl) slowlogPush(cmd *Cmd, duration time.Duration) {
+	threshold := re.config.SlowlogLogSlowerThan
+	if threshold < 0 || duration.Microseconds() < int64(threshold) {
+		return
+	}
+	argv := append([]string{cmd.Name()}, cmd.Args()...)
+	args := make([]string, 0, min(len(argv), slowlogMaxArgs))
+	for i, arg := range argv {
+		if i == slowlogMaxArgs-1 && len(argv) > slowlogMaxArgs {
+			args = append(args, "... ("+strconv.Itoa(len(argv)-i)+" more arguments)")
+			break
+		}
+		if len(arg) > slowlogMaxArgLen {
+			arg = arg[:slowlogMaxArgLen] + "... (" + strconv.Itoa(len(arg)-slowlogMaxArgLen) + " more bytes)"
+		}
+		args = append(args, arg)
+	}
+	entry := &slowlogEntry{
+		id:       re.stats.slowlogNextID,
+		time:     time.Now().Unix(),
+		duration: duration.Microseconds(),
+		args:     args,
+	}
+	if client := cmd.Client(); client != nil {
+		entry.addr, entry.name = client.addr, client.name
+	}
+	re.stats.slowlogNextID++
+	re.stats.slowlog = append([]*slowlogEntry{entry}, re.stats.slowlog...)
+	re.trimSlowlog()
+}
+
+// trimSlowlog drops the oldest entries beyond slowlog-max-len
+func (re *RedisExecutorImpl) trimSlowlog() {
+	if len(re.stats.slowlog) > re.config.SlowlogMaxLen {
+		clear(re.stats.slowlog[re.config.SlowlogMaxLen:])
+		re.stats.slowlog = re.stats.slowlog[:re.config.SlowlogMaxLen]
+	}
+}
+
+/* ---------------- latency monitor ---------------- */
+
+// latencyAddSample records a latency spike of the event if it lasted at least
+// latency-monitor-threshold milliseconds, 0 disabling the latency monitor
+func (re *RedisExecutorImpl) latencyAddSample(event string, duration time.Duration) {
+	threshold := re.config.LatencyMonitorThreshold
+	ms := duration.Milliseconds()
+	if threshold == 0 || ms < int64(threshold) {
+		return
+	}
+	e, found := re.stats.latency[event]
+	if !found {
+		e = &latencyEvent{}
+		re.stats.latency[event] = e
+	}
+	e.time, e.latest, e.max = time.Now().Unix(), ms, max(e.max, ms)
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInfo(t *testing.T) {
+	re := newTestExecutor()
+	re.RegisterClient(NewClient(&bufferConn{}))
+	c := NewClient(&bufferConn{})
+	runClientSteps(t, re, c, []testStep{
+		{cmd("SET", "k", "v", "EX", "100"), "+OK\r\n"},
+		{cmd("GET", "k"), "$1\r\nv\r\n"},
+		{cmd("GET", "missing"), "$-1\r\n"},
+		{cmd("LPUSH", "k", "a"), "-" + ErrWrongType.Error() + "\r\n"},
+		{cmd("SELECT", "2"), "+OK\r\n"},
+		{cmd("SET", "other", "v"), "+OK\r\n"},
+		{cmd("GET"), "-ERR wrong number of arguments for 'get' command\r\n"},
+	})
+
+	info := execute(re, "INFO")
+	for _, field := range []string{
+		"# Server\r\n", "redis_version:" + redisVersion, "tcp_port:6379", "executor_mode:lock",
+		"# Clients\r\n", "connected_clients:1",
+		"# Memory\r\n", "maxmemory_policy:noeviction",
+		"# Persistence\r\n", "rdb_changes_since_last_save:2",
+		"# Stats\r\n", "total_connections_received:1", "keyspace_hits:2", "keyspace_misses:1", "total_error_replies:2",
+		"# Replication\r\n", "role:master",
+		"# Keyspace\r\n", "db0:keys=1,expires=1,avg_ttl=", "db2:keys=1,expires=0,avg_ttl=0",
+	} {
+		if !strings.Contains(info, field) {
+			t.Errorf("INFO: %q not in %q", field, info)
+		}
+	}
+	if strings.Contains(info, "# Commandstats") || strings.Contains(info, "db1:") {
+		t.Errorf("INFO: got %q", info)
+	}
+
+	info = execute(re, "INFO", "commandstats", "keyspace")
+	for _, field := range []string{
+		"cmdstat_get:calls=2,", "rejected_calls=1,failed_calls=0\r\n",
+		"cmdstat_lpush:calls=1,", "rejected_calls=0,failed_calls=1\r\n",
+		"# Keyspace\r\n",
+	} {
+		if !strings.Contains(info, field) {
+			t.Errorf("INFO commandstats: %q not in %q", field, info)
+		}
+	}
+	if strings.Contains(info, "# Server") {
+		t.Errorf("INFO commandstats: got %q", info)
+	}
+	if info := execute(re, "INFO", "everything"); !strings.Contains(info, "# Commandstats") {
+		t.Errorf("INFO everything: got %q", info)
+	}
+}
+
+func TestSlowlog(t *testing.T) {
+	re := newTestExecutor()
+	re.config.SlowlogLogSlowerThan = 0
+	long := strings.Repeat("x", slowlogMaxArgLen+10)
+	args := []string{"SADD", "set"}
+	for i := 0; i < 40; i++ {
+		args = append(args, long)
+	}
+	execute(re, args...)
+	execute(re, "AUTH", "secret")
+	execute(re, "GET", "k")
+
+	reply := re.Execute(CreateCommandFromTokens(bulkTokens([]string{"SLOWLOG", "GET", "-1"})))
+	// the most recent entry first, AUTH is not logged
+	if len(reply.Items) != 2 {
+		t.Fatalf("got %d entries", len(reply.Items))
+	}
+	get, sadd := reply.Items[0].Items, reply.Items[1].Items
+	if get[0].Value != "1" || sadd[0].Value != "0" || len(get[3].Items) != 2 {
+		t.Errorf("got %s", reply.Serialize())
+	}
+	logged := sadd[3].Items
+	if len(logged) != slowlogMaxArgs || logged[31].Value != "... (11 more arguments)" ||
+		logged[2].Value != long[:slowlogMaxArgLen]+"... (10 more bytes)" {
+		t.Errorf("got %s", reply.Serialize())
+	}
+
+	runSteps(t, re, []testStep{
+		// SLOWLOG GET was logged as well
+		{cmd("SLOWLOG", "LEN"), ":3\r\n"},
+		{cmd("SLOWLOG", "GET", "x"), "-" + ErrNotInteger.Error() + "\r\n"},
+		{cmd("SLOWLOG", "FOO"), "-ERR unknown subcommand 'FOO'. Try SLOWLOG HELP.\r\n"},
+		{cmd("CONFIG", "SET", "slowlog-max-len", "1"), "+OK\r\n"},
+		{cmd("SLOWLOG", "LEN"), ":1\r\n"},
+		{cmd("CONFIG", "SET", "slowlog-log-slower-than", "-1"), "+OK\r\n"},
+		{cmd("SLOWLOG", "RESET"), "+OK\r\n"},
+		{cmd("GET", "k"), "$-1\r\n"},
+		{cmd("SLOWLOG", "GET"), "*0\r\n"},
+	})
+}
+
+func TestLatencyLatest(t *testing.T) {
+	re := newTestExecutor()
+	runSteps(t, re, []testStep{{cmd("LATENCY", "LATEST"), "*0\r\n"}})
+	// the latency monitor is disabled by default
+	re.latencyAddSample("command", time.Second)
+	re.config.LatencyMonitorThreshold = 100
+	re.latencyAddSample("command", 50*time.Millisecond)
+	re.latencyAddSample("command", 300*time.Millisecond)
+	re.latencyAddSample("command", 200*time.Millisecond)
+	re.latencyAddSample("aof-write", 100*time.Millisecond)
+
+	now := time.Now().Unix()
+	reply := re.Execute(CreateCommandFromTokens(bulkTokens([]string{"LATENCY", "LATEST"})))
+	if len(reply.Items) != 2 {
+		t.Fatalf("got %s", reply.Serialize())
+	}
+	for i, want := range [][]string{{"aof-write", "100", "100"}, {"command", "200", "300"}} {
+		event := reply.Items[i].Items
+		if event[0].Value != want[0] || event[2].Value != want[1] || event[3].Value != want[2] {
+			t.Errorf("got %s, want %v", reply.Serialize(), want)
+		}
+		if ts, _ := parseInt(event[1].Value); ts < now-1 || ts > now {
+			t.Errorf("time: got %d", ts)
+		}
+	}
+	runSteps(t, re, []testStep{
+		{cmd("LATENCY", "RESET", "command", "missing"), ":1\r\n"},
+		{cmd("LATENCY", "RESET"), ":1\r\n"},
+		{cmd("LATENCY", "LATEST"), "*0\r\n"},
+	})
+}
+
+func TestMetrics(t *testing.T) {
+	re := newTestExecutor()
+	re.RegisterClient(NewClient(&bufferConn{}))
+	execute(re, "SET", "k", "v")
+	execute(re, "GET", "k")
+	execute(re, "GET", "k", "x")
+
+	server := httptest.NewServer(metricsHandler(re))
+	defer server.Close()
+	resp, err := server.Client().Get(server.URL + metricsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	metrics := string(body)
+	for _, line := range []string{
+		"# TYPE redis_command_duration_seconds histogram\n",
+		"redis_connected_clients 1\n",
+		`redis_db_keys{db="db0"} 1` + "\n",
+		`redis_commands_total{cmd="get"} 1` + "\n",
+		`redis_commands_rejected_total{cmd="get"} 1` + "\n",
+		`redis_command_duration_seconds_bucket{cmd="set",le="1"} 1` + "\n",
+		`redis_command_duration_seconds_bucket{cmd="set",le="+Inf"} 1` + "\n",
+		`redis_command_duration_seconds_count{cmd="set"} 1` + "\n",
+	} {
+		if !strings.Contains(metrics, line) {
+			t.Errorf("%q not in %q", line, metrics)
+		}
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type: got %q", ct)
+	}
+}
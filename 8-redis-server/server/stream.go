@@ -0,0 +1,440 @@
+package server
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// streamNodeMaxEntries is the number of entries of a node of a stream, like
+// stream-node-max-entries. The approximate trimming (~) only removes whole nodes.
+const streamNodeMaxEntries = 100
+
+/* ---------------- IDs ---------------- */
+
+// streamID is the ID of a stream entry: the unix time in milliseconds it was added at,
+// and a sequence number among the entries added in the same millisecond
+type streamID struct {
+	ms, seq uint64
+}
+
+var maxStreamID = streamID{math.MaxUint64, math.MaxUint64}
+
+func (id streamID) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+func (id streamID) compare(other streamID) int {
+	switch {
+	case id.ms < other.ms || (id.ms == other.ms && id.seq < other.seq):
+		return -1
+	case id == other:
+		return 0
+	}
+	return 1
+}
+
+func (id streamID) less(other streamID) bool {
+	return id.compare(other) < 0
+}
+
+// next returns the smallest ID greater than the ID, false if it is the greatest one
+func (id streamID) next() (streamID, bool) {
+	switch {
+	case id.seq < math.MaxUint64:
+		return streamID{id.ms, id.seq + 1}, true
+	case id.ms < math.MaxUint64:
+		return streamID{id.ms + 1, 0}, true
+	}
+	return id, false
+}
+
+// prev returns the greatest ID smaller than the ID, false if it is 0-0
+func (id streamID) prev() (streamID, bool) {
+	switch {
+	case id.seq > 0:
+		return streamID{id.ms, id.seq - 1}, true
+	case id.ms > 0:
+		return streamID{id.ms - 1, math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// parseStreamID parses an ID given as <ms>-<seq>, or <ms> alone whose sequence number
+// is @missingSeq
+func parseStreamID(s string, missingSeq uint64) (streamID, bool) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return streamID{}, false
+	}
+	if !hasSeq {
+		return streamID{ms, missingSeq}, true
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	return streamID{ms, seq}, err == nil
+}
+
+/* ---------------- entries ---------------- */
+
+// streamEntry is an entry of a stream, its fields and values alternate in @fields
+type streamEntry struct {
+	id     streamID
+	fields []string
+}
+
+// streamNode holds up to streamNodeMaxEntries consecutive entries of a stream
+type streamNode struct {
+	entries []streamEntry
+}
+
+func (n *streamNode) last() streamID {
+	return n.entries[len(n.entries)-1].id
+}
+
+/* ---------------- consumer groups ---------------- */
+
+// streamNACK is an entry delivered to a consumer of a group and not acknowledged yet
+type streamNACK struct {
+	id            streamID
+	consumer      *streamConsumer
+	deliveryTime  int64 // unix time in milliseconds of the last delivery
+	deliveryCount int64
+}
+
+// streamConsumer is a consumer of a group, with the entries delivered to it and not
+// acknowledged yet
+type streamConsumer struct {
+	name       string
+	seenTime   int64 // unix time in milliseconds of its last interaction
+	activeTime int64 // unix time in milliseconds of its last read or claim, 0 if never
+	pending    map[streamID]*streamNACK
+}
+
+// streamCG is a consumer group: the ID of the last entry delivered to its consumers,
+// and its pending entries list (PEL) ordered by ID
+type streamCG struct {
+	name      string
+	lastID    streamID
+	pel       []*streamNACK
+	consumers map[string]*streamConsumer
+}
+
+func newStreamCG(name string, lastID streamID) *streamCG {
+	return &streamCG{name: name, lastID: lastID, consumers: make(map[string]*streamConsumer)}
+}
+
+// pendingIndex returns the position of the first pending entry whose ID is not less than @id
+func (cg *streamCG) pendingIndex(id streamID) int {
+	return sort.Search(len(cg.pel), func(i int) bool { return !cg.pel[i].id.less(id) })
+}
+
+// pending returns the pending entry with the ID
+func (cg *streamCG) pending(id streamID) (*streamNACK, bool) {
+	if i := cg.pendingIndex(id); i < len(cg.pel) && cg.pel[i].id == id {
+		return cg.pel[i], true
+	}
+	return nil, false
+}
+
+// addPending adds an entry delivered to the consumer
+func (cg *streamCG) addPending(nack *streamNACK) {
+	i := cg.pendingIndex(nack.id)
+	cg.pel = append(cg.pel, nil)
+	copy(cg.pel[i+1:], cg.pel[i:])
+	cg.pel[i] = nack
+	nack.consumer.pending[nack.id] = nack
+}
+
+// removePending acknowledges the pending entry with the ID
+func (cg *streamCG) removePending(id streamID) bool {
+	i := cg.pendingIndex(id)
+	if i == len(cg.pel) || cg.pel[i].id != id {
+		return false
+	}
+	delete(cg.pel[i].consumer.pending, id)
+	cg.pel = append(cg.pel[:i], cg.pel[i+1:]...)
+	return true
+}
+
+// consumer returns the consumer of the group, creating it if @create is set. It
+// reports whether the consumer was created.
+func (cg *streamCG) consumer(name string, create bool, now int64) (*streamConsumer, bool) {
+	if consumer, found := cg.consumers[name]; found || !create {
+		return consumer, false
+	}
+	consumer := &streamConsumer{name: name, seenTime: now, pending: make(map[streamID]*streamNACK)}
+	cg.consumers[name] = consumer
+	return consumer, true
+}
+
+// deleteConsumer deletes the consumer and its pending entries, which it returns the number of
+func (cg *streamCG) deleteConsumer(consumer *streamConsumer) int {
+	n := len(consumer.pending)
+	for id := range consumer.pending {
+		cg.removePending(id)
+	}
+	delete(cg.consumers, consumer.name)
+	return n
+}
+
+// sortedConsumers returns the consumers of the group sorted by name
+func (cg *streamCG) sortedConsumers() []*streamConsumer {
+	consumers := make([]*streamConsumer, 0, len(cg.consumers))
+	for _, consumer := range cg.consumers {
+		consumers = append(consumers, consumer)
+	}
+	sort.Slice(consumers, func(i, j int) bool { return consumers[i].name < consumers[j].name })
+	return consumers
+}
+
+/* ---------------- Stream ---------------- */
+
+// Stream is the value of stream keys: an append-only log of entries ordered by ID.
+// The entries are held in nodes of up to streamNodeMaxEntries entries, like the
+// listpacks of the radix tree of Redis: an entry is found by a binary search of its
+// node then of its position in the node, appending is O(1) and trimming removes
+// whole nodes.
+type Stream struct {
+	nodes        []*streamNode
+	length       int
+	lastID       streamID // ID of the last entry added, even if it was deleted since
+	maxDeletedID streamID // greatest ID of the entries deleted by XDEL or trimmed
+	entriesAdded int64    // entries added over the lifetime of the stream
+	groups       map[string]*streamCG
+}
+
+func NewStream() *Stream {
+	return &Stream{groups: make(map[string]*streamCG)}
+}
+
+func (s *Stream) Len() int {
+	return s.length
+}
+
+// Clone returns a copy of the stream and of its consumer groups
+func (s *Stream) Clone() *Stream {
+	clone := &Stream{
+		nodes:        make([]*streamNode, len(s.nodes)),
+		length:       s.length,
+		lastID:       s.lastID,
+		maxDeletedID: s.maxDeletedID,
+		entriesAdded: s.entriesAdded,
+		groups:       make(map[string]*streamCG, len(s.groups)),
+	}
+	for i, node := range s.nodes {
+		// the fields of the entries are never modified, they are shared
+		clone.nodes[i] = &streamNode{entries: append([]streamEntry(nil), node.entries...)}
+	}
+	for name, cg := range s.groups {
+		cgClone := newStreamCG(name, cg.lastID)
+		for _, consumer := range cg.consumers {
+			cgClone.consumers[consumer.name] = &streamConsumer{
+				name:       consumer.name,
+				seenTime:   consumer.seenTime,
+				activeTime: consumer.activeTime,
+				pending:    make(map[streamID]*streamNACK, len(consumer.pending)),
+			}
+		}
+		cgClone.pel = make([]*streamNACK, len(cg.pel))
+		for i, nack := range cg.pel {
+			nackClone := *nack
+			nackClone.consumer = cgClone.consumers[nack.consumer.name]
+			nackClone.consumer.pending[nack.id] = &nackClone
+			cgClone.pel[i] = &nackClone
+		}
+		clone.groups[name] = cgClone
+	}
+	return clone
+}
+
+// Append adds an entry, its ID must be greater than lastID
+func (s *Stream) Append(id streamID, fields []string) {
+	var node *streamNode
+	if n := len(s.nodes); n > 0 && len(s.nodes[n-1].entries) < streamNodeMaxEntries {
+		node = s.nodes[n-1]
+	} else {
+		node = &streamNode{entries: make([]streamEntry, 0, 1)}
+		s.nodes = append(s.nodes, node)
+	}
+	node.entries = append(node.entries, streamEntry{id: id, fields: fields})
+	s.length++
+	s.lastID = id
+	s.entriesAdded++
+}
+
+// seek returns the position of the first entry whose ID is not less than @id: the
+// index of its node and its index in the node
+func (s *Stream) seek(id streamID) (int, int) {
+	n := sort.Search(len(s.nodes), func(i int) bool { return !s.nodes[i].last().less(id) })
+	if n == len(s.nodes) {
+		return n, 0
+	}
+	entries := s.nodes[n].entries
+	return n, sort.Search(len(entries), func(i int) bool { return !entries[i].id.less(id) })
+}
+
+// Get returns the entry with the ID
+func (s *Stream) Get(id streamID) (streamEntry, bool) {
+	n, i := s.seek(id)
+	if n < len(s.nodes) && s.nodes[n].entries[i].id == id {
+		return s.nodes[n].entries[i], true
+	}
+	return streamEntry{}, false
+}
+
+// Range returns the entries with an ID between @start and @end (inclusive), from the
+// last one if @reverse is set, up to @count entries if it is positive
+func (s *Stream) Range(start, end streamID, reverse bool, count int) []streamEntry {
+	var entries []streamEntry
+	if end.less(start) {
+		return entries
+	}
+	full := func() bool { return count > 0 && len(entries) == count }
+	if !reverse {
+		for n, i := s.seek(start); n < len(s.nodes) && !full(); n, i = n+1, 0 {
+			for ; i < len(s.nodes[n].entries) && !full(); i++ {
+				entry := s.nodes[n].entries[i]
+				if end.less(entry.id) {
+					return entries
+				}
+				entries = append(entries, entry)
+			}
+		}
+		return entries
+	}
+	n, i := s.seek(end)
+	// seek returns the first entry after @end unless it is equal to @end
+	if n == len(s.nodes) {
+		i = -1
+	} else if s.nodes[n].entries[i].id == end {
+		i++
+	}
+	for ; n >= 0 && !full(); n, i = n-1, -1 {
+		if n == len(s.nodes) {
+			continue
+		}
+		if i < 0 {
+			i = len(s.nodes[n].entries)
+		}
+		for i--; i >= 0 && !full(); i-- {
+			entry := s.nodes[n].entries[i]
+			if entry.id.less(start) {
+				return entries
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// First returns the first entry of the stream
+func (s *Stream) First() (streamEntry, bool) {
+	if s.length == 0 {
+		return streamEntry{}, false
+	}
+	return s.nodes[0].entries[0], true
+}
+
+// Last returns the last entry of the stream
+func (s *Stream) Last() (streamEntry, bool) {
+	if s.length == 0 {
+		return streamEntry{}, false
+	}
+	node := s.nodes[len(s.nodes)-1]
+	return node.entries[len(node.entries)-1], true
+}
+
+// Delete removes the entry with the ID. Its node is removed once empty.
+func (s *Stream) Delete(id streamID) bool {
+	n, i := s.seek(id)
+	if n == len(s.nodes) || s.nodes[n].entries[i].id != id {
+		return false
+	}
+	node := s.nodes[n]
+	node.entries = append(node.entries[:i], node.entries[i+1:]...)
+	if len(node.entries) == 0 {
+		s.nodes = append(s.nodes[:n], s.nodes[n+1:]...)
+	}
+	s.length--
+	if s.maxDeletedID.less(id) {
+		s.maxDeletedID = id
+	}
+	return true
+}
+
+// streamTrim is the trimming of XADD and XTRIM: the entries beyond the @maxLen last
+// ones, or those whose ID is less than @minID, are removed
+type streamTrim struct {
+	strategy string // "maxlen" or "minid", none if empty
+	approx   bool   // only whole nodes are removed (~)
+	maxLen   int64
+	minID    streamID
+	limit    int64 // maximum number of entries removed by an approximate trimming, 0 for no limit
+}
+
+// Trim removes the first entries according to @trim and returns their number
+func (s *Stream) Trim(trim *streamTrim) int64 {
+	var removed int64
+	for len(s.nodes) > 0 {
+		node := s.nodes[0]
+		// a whole node is removed if its last entry is to be removed
+		var wholeNode bool
+		if trim.strategy == "maxlen" {
+			wholeNode = int64(s.length-len(node.entries)) >= trim.maxLen
+		} else {
+			wholeNode = node.last().less(trim.minID)
+		}
+		if wholeNode {
+			if trim.approx && trim.limit > 0 && removed+int64(len(node.entries)) > trim.limit {
+				break
+			}
+			s.removeEntries(len(node.entries))
+			removed += int64(len(node.entries))
+			continue
+		}
+		if trim.approx {
+			break
+		}
+		// exact trimming removes the first entries of the node
+		i := 0
+		if trim.strategy == "maxlen" {
+			i = int(int64(s.length) - trim.maxLen)
+		} else {
+			for i < len(node.entries) && node.entries[i].id.less(trim.minID) {
+				i++
+			}
+		}
+		if i > 0 {
+			s.removeEntries(i)
+			removed += int64(i)
+		}
+		break
+	}
+	return removed
+}
+
+// removeEntries removes the @n first entries of the first node, at most all of them
+func (s *Stream) removeEntries(n int) {
+	node := s.nodes[0]
+	if last := node.entries[n-1].id; s.maxDeletedID.less(last) {
+		s.maxDeletedID = last
+	}
+	s.length -= n
+	if n == len(node.entries) {
+		s.nodes = s.nodes[1:]
+		return
+	}
+	node.entries = append(node.entries[:0:0], node.entries[n:]...)
+}
+
+// sortedGroups returns the consumer groups sorted by name
+func (s *Stream) sortedGroups() []*streamCG {
+	groups := make([]*streamCG, 0, len(s.groups))
+	for _, cg := range s.groups {
+		groups = append(groups, cg)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].name < groups[j].name })
+	return groups
+}
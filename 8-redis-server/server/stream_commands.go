@@ -0,0 +1,1228 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrStreamID          = errors.New("ERR Invalid stream ID specified as stream command argument")
+	ErrStreamIDSmaller   = errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	ErrStreamIDZero      = errors.New("ERR The ID specified in XADD must be greater than 0-0")
+	ErrStreamExhausted   = errors.New("ERR The stream has exhausted the last possible ID, unable to add more items")
+	ErrStreamStartID     = errors.New("ERR invalid start ID for the interval")
+	ErrStreamEndID       = errors.New("ERR invalid end ID for the interval")
+	ErrMaxLenNegative    = errors.New("ERR The MAXLEN argument must be >= 0.")
+	ErrLimitNegative     = errors.New("ERR The LIMIT argument must be >= 0.")
+	ErrLimitNotApprox    = errors.New("ERR syntax error, LIMIT cannot be used without the special ~ option")
+	ErrBlockTimeout      = errors.New("ERR timeout is not an integer or out of range")
+	ErrMissingGroup      = errors.New("ERR Missing GROUP option for XREADGROUP")
+	ErrMinIdleTime       = errors.New("ERR Invalid min-idle-time argument for XCLAIM")
+	ErrAutoClaimCount    = errors.New("ERR COUNT must be > 0")
+	ErrXGroupNoKey       = errors.New("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+	ErrBusyGroup         = errors.New("BUSYGROUP Consumer Group name already exists")
+	ErrXSetIDSmaller     = errors.New("ERR The ID specified in XSETID is smaller than the target stream top item")
+	ErrXSetIDMaxDeleted  = errors.New("ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id")
+	ErrXSetIDEntriesRead = errors.New("ERR The entries_added specified in XSETID is smaller than the target stream length")
+)
+
+// NoGroupError is the error of a command given a missing stream or consumer group
+func NoGroupError(key, group string) error {
+	return fmt.Errorf("NOGROUP No such key '%s' or consumer group '%s'", key, group)
+}
+
+// NoSuchGroupError is the error of XGROUP and XINFO given a missing consumer group
+func NoSuchGroupError(key, group string) error {
+	return fmt.Errorf("NOGROUP No such consumer group '%s' for key name '%s'", group, key)
+}
+
+func init() {
+	registerCommands(
+		&commandSpec{name: "xadd", arity: -5, handler: xaddCommand, flags: flagWrite | flagDenyOOM, keys: firstKey, categories: catStream},
+		&commandSpec{name: "xrange", arity: -4, handler: xrangeCommand, keys: firstKey, categories: catStream},
+		&commandSpec{name: "xrevrange", arity: -4, handler: xrangeCommand, keys: firstKey, categories: catStream},
+		&commandSpec{name: "xlen", arity: 2, handler: xlenCommand, keys: firstKey, categories: catStream},
+		&commandSpec{name: "xdel", arity: -3, handler: xdelCommand, flags: flagWrite, keys: firstKey, categories: catStream},
+		&commandSpec{name: "xtrim", arity: -4, handler: xtrimCommand, flags: flagWrite, keys: firstKey, categories: catStream},
+		&commandSpec{name: "xread", arity: -4, handler: xreadCommand, getKeys: xreadKeys, categories: catStream | catBlocking},
+		&commandSpec{name: "xreadgroup", arity: -7, handler: xreadgroupCommand, flags: flagWrite, getKeys: xreadKeys, categories: catStream | catBlocking},
+		&commandSpec{name: "xgroup", arity: -2, handler: xgroupCommand, flags: flagWrite | flagDenyOOM, keys: keySpec{1, 1, 1}, categories: catStream},
+		&commandSpec{name: "xack", arity: -4, handler: xackCommand, flags: flagWrite, keys: firstKey, categories: catStream},
+		&commandSpec{name: "xpending", arity: -3, handler: xpendingCommand, keys: firstKey, categories: catStream},
+		&commandSpec{name: "xclaim", arity: -6, handler: xclaimCommand, flags: flagWrite, keys: firstKey, categories: catStream},
+		&commandSpec{name: "xautoclaim", arity: -6, handler: xautoclaimCommand, flags: flagWrite, keys: firstKey, categories: catStream},
+		&commandSpec{name: "xinfo", arity: -2, handler: xinfoCommand, keys: keySpec{1, 1, 1}, categories: catStream},
+		&commandSpec{name: "xsetid", arity: -3, handler: xsetidCommand, flags: flagWrite | flagDenyOOM, keys: firstKey, categories: catStream},
+	)
+}
+
+/* ---------------- helpers ---------------- */
+
+// lookupStream returns the stream stored at key
+func lookupStream(re *RedisExecutorImpl, key string) (*Stream, bool, error) {
+	item, found, err := lookupTyped(re, key, StreamType)
+	if !found || err != nil {
+		return nil, found, err
+	}
+	return item.StreamValue(), true, nil
+}
+
+// lookupGroup returns the consumer group of the stream stored at key, failing with
+// NoGroupError if either of them is missing
+func lookupGroup(re *RedisExecutorImpl, key, group string) (*Stream, *streamCG, error) {
+	stream, found, err := lookupStream(re, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !found || stream.groups[group] == nil {
+		return nil, nil, NoGroupError(key, group)
+	}
+	return stream, stream.groups[group], nil
+}
+
+// parseRangeID parses the bound of a range of IDs: - and + for the smallest and the
+// greatest IDs, an ID prefixed by ( excludes it. The sequence number of an end
+// given as <ms> alone is the greatest one.
+func parseRangeID(s string, end bool) (streamID, error) {
+	switch s {
+	case "-":
+		return streamID{}, nil
+	case "+":
+		return maxStreamID, nil
+	}
+	var missingSeq uint64
+	if end {
+		missingSeq = math.MaxUint64
+	}
+	id, ok := parseStreamID(strings.TrimPrefix(s, "("), missingSeq)
+	if !ok {
+		return id, ErrStreamID
+	}
+	if !strings.HasPrefix(s, "(") {
+		return id, nil
+	}
+	if end {
+		if id, ok = id.prev(); !ok {
+			return id, ErrStreamEndID
+		}
+	} else if id, ok = id.next(); !ok {
+		return id, ErrStreamStartID
+	}
+	return id, nil
+}
+
+// parseStreamTrim parses the trimming of XADD and XTRIM, starting at its strategy
+// MAXLEN or MINID, and returns the number of arguments it spans
+func parseStreamTrim(args []string, trim *streamTrim) (int, error) {
+	trim.strategy = strings.ToLower(args[0])
+	n := 1
+	if n < len(args) && (args[n] == "=" || args[n] == "~") {
+		trim.approx = args[n] == "~"
+		n++
+	}
+	if n == len(args) {
+		return 0, ErrSyntax
+	}
+	if trim.strategy == "maxlen" {
+		maxLen, ok := parseInt(args[n])
+		if !ok {
+			return 0, ErrNotInteger
+		}
+		if maxLen < 0 {
+			return 0, ErrMaxLenNegative
+		}
+		trim.maxLen = maxLen
+	} else {
+		var ok bool
+		if trim.minID, ok = parseStreamID(args[n], 0); !ok {
+			return 0, ErrStreamID
+		}
+	}
+	n++
+	// like Redis, an approximate trimming removes at most 100 nodes by default
+	trim.limit = 100 * streamNodeMaxEntries
+	if n+1 < len(args) && strings.EqualFold(args[n], "limit") {
+		limit, ok := parseInt(args[n+1])
+		if !ok {
+			return 0, ErrNotInteger
+		}
+		if limit < 0 {
+			return 0, ErrLimitNegative
+		}
+		if !trim.approx {
+			return 0, ErrLimitNotApprox
+		}
+		trim.limit = limit
+		n += 2
+	}
+	return n, nil
+}
+
+// exactTrimArgs returns the arguments of an exact trimming leaving the stream as it is,
+// which is propagated in place of the trimming run, e.g. an approximate one
+func exactTrimArgs(stream *Stream) []string {
+	if first, found := stream.First(); found {
+		return []string{"MINID", "=", first.id.String()}
+	}
+	return []string{"MAXLEN", "=", "0"}
+}
+
+// entryResponse is the reply of an entry: its ID and its fields and values
+func entryResponse(entry streamEntry) *RedisResponse {
+	return ArrayResponse(BulkResponse(entry.id.String()), BulkArrayResponse(entry.fields))
+}
+
+func entriesResponse(entries []streamEntry) *RedisResponse {
+	items := make([]*RedisResponse, len(entries))
+	for i, entry := range entries {
+		items[i] = entryResponse(entry)
+	}
+	return ArrayResponse(items...)
+}
+
+// entriesAfter returns up to @count entries (all of them if 0) whose ID is greater than @after
+func entriesAfter(stream *Stream, after streamID, count int) []streamEntry {
+	start, ok := after.next()
+	if !ok {
+		return nil
+	}
+	return stream.Range(start, maxStreamID, false, count)
+}
+
+// propagateClaim propagates the delivery of a pending entry as an XCLAIM setting its
+// consumer, delivery time and count, so that the replicas and the append only file
+// record the same pending entries
+func (re *RedisExecutorImpl) propagateClaim(key string, cg *streamCG, nack *streamNACK) {
+	re.rewriteCommand("xclaim", key, cg.name, nack.consumer.name, "0", nack.id.String(),
+		"TIME", strconv.FormatInt(nack.deliveryTime, 10), "RETRYCOUNT", strconv.FormatInt(nack.deliveryCount, 10),
+		"FORCE", "JUSTID", "LASTID", cg.lastID.String())
+}
+
+// groupConsumer returns the consumer of the group, creating it if needed. Its creation
+// is propagated.
+func (re *RedisExecutorImpl) groupConsumer(key string, cg *streamCG, name string, now int64) *streamConsumer {
+	consumer, created := cg.consumer(name, true, now)
+	if created {
+		re.rewriteCommand("xgroup", "createconsumer", key, cg.name, name)
+		re.signalModifiedKey(key)
+		re.notifyKeyspaceEvent(notifyStream, "xgroup-createconsumer", key)
+	}
+	consumer.seenTime = now
+	return consumer
+}
+
+/* ---------------- commands ---------------- */
+
+// XADD key [NOMKSTREAM] [MAXLEN | MINID [= | ~] threshold [LIMIT count]] * | id field value [field value ...]
+func xaddCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	key := args[0]
+	var noMkStream bool
+	var trim streamTrim
+	i := 1
+options:
+	for i < len(args) {
+		switch strings.ToLower(args[i]) {
+		case "nomkstream":
+			noMkStream = true
+			i++
+		case "maxlen", "minid":
+			n, err := parseStreamTrim(args[i:], &trim)
+			if err != nil {
+				return ErrorResponse(err)
+			}
+			i += n
+		default:
+			break options
+		}
+	}
+	if n := len(args) - i - 1; n <= 0 || n%2 != 0 {
+		return ErrorResponse(WrongArgsError(cmd.Name()))
+	}
+	idArg, fields := args[i], args[i+1:]
+
+	// the ID is generated from the current time (*), or only its sequence number (<ms>-*)
+	var id streamID
+	autoID, autoSeq := idArg == "*", strings.HasSuffix(idArg, "-*")
+	var ok bool
+	switch {
+	case autoID:
+	case autoSeq:
+		if id.ms, ok = parseUint(strings.TrimSuffix(idArg, "-*")); !ok {
+			return ErrorResponse(ErrStreamID)
+		}
+	default:
+		if id, ok = parseStreamID(idArg, 0); !ok {
+			return ErrorResponse(ErrStreamID)
+		}
+		if id == (streamID{}) {
+			return ErrorResponse(ErrStreamIDZero)
+		}
+	}
+
+	stream, found, err := lookupStream(re, key)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		if noMkStream {
+			return NilResponse()
+		}
+		stream = NewStream()
+	}
+	last := stream.lastID
+	switch {
+	case autoID:
+		if now := uint64(nowMs()); now > last.ms {
+			id = streamID{now, 0}
+		} else if id, ok = last.next(); !ok {
+			return ErrorResponse(ErrStreamExhausted)
+		}
+	case autoSeq:
+		switch {
+		case id.ms > last.ms:
+			// 0-0 is not a valid ID
+			if id.ms == 0 {
+				id.seq = 1
+			}
+		case id.ms == last.ms && last.seq < math.MaxUint64:
+			id.seq = last.seq + 1
+		default:
+			return ErrorResponse(ErrStreamIDSmaller)
+		}
+	default:
+		if !last.less(id) {
+			return ErrorResponse(ErrStreamIDSmaller)
+		}
+	}
+
+	stream.Append(id, append([]string(nil), fields...))
+	if !found {
+		_ = re.Set(key, &CacheItem{Key: key, Value: stream, Type: StreamType})
+	}
+	re.notifyKeyspaceEvent(notifyStream, "xadd", key)
+	argv := []string{"xadd", key}
+	if trim.strategy != "" {
+		if stream.Trim(&trim) > 0 {
+			re.notifyKeyspaceEvent(notifyStream, "xtrim", key)
+		}
+		argv = append(argv, exactTrimArgs(stream)...)
+	}
+	re.signalModifiedKey(key)
+	re.signalKeyAsReady(key)
+	// the ID generated is propagated
+	re.rewriteCommand(append(append(argv, id.String()), fields...)...)
+	return BulkResponse(id.String())
+}
+
+// parseUint parses a base 10 unsigned integer
+func parseUint(s string) (uint64, bool) {
+	n, err := strconv.ParseUint(s, 10, 64)
+	return n, err == nil
+}
+
+// XRANGE key start end [COUNT count], also XREVRANGE key end start [COUNT count]
+func xrangeCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	reverse := cmd.Name() == "xrevrange"
+	startArg, endArg := args[1], args[2]
+	if reverse {
+		startArg, endArg = endArg, startArg
+	}
+	start, err := parseRangeID(startArg, false)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	end, err := parseRangeID(endArg, true)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	count := int64(-1)
+	switch {
+	case len(args) == 5 && strings.EqualFold(args[3], "count"):
+		var ok bool
+		if count, ok = parseInt(args[4]); !ok {
+			return ErrorResponse(ErrNotInteger)
+		}
+		if count <= 0 {
+			return ArrayResponse()
+		}
+	case len(args) != 3:
+		return ErrorResponse(ErrSyntax)
+	}
+
+	stream, found, err := lookupStream(re, args[0])
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return ArrayResponse()
+	}
+	return entriesResponse(stream.Range(start, end, reverse, int(min(count, math.MaxInt32))))
+}
+
+// XLEN key
+func xlenCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	stream, found, err := lookupStream(re, cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return IntegerResponse(0)
+	}
+	return IntegerResponse(int64(stream.Len()))
+}
+
+// XDEL key id [id ...], the stream is kept once empty
+func xdelCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	ids := make([]streamID, 0, len(cmd.Args())-1)
+	for _, arg := range cmd.Args()[1:] {
+		id, ok := parseStreamID(arg, 0)
+		if !ok {
+			return ErrorResponse(ErrStreamID)
+		}
+		ids = append(ids, id)
+	}
+	key := cmd.Arg(0)
+	stream, found, err := lookupStream(re, key)
+	if err != nil || !found {
+		return integerOrError(0, err)
+	}
+	var deleted int64
+	for _, id := range ids {
+		if stream.Delete(id) {
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		re.signalModifiedKey(key)
+		re.notifyKeyspaceEvent(notifyStream, "xdel", key)
+	}
+	return IntegerResponse(deleted)
+}
+
+// integerOrError replies the error if any, the integer otherwise
+func integerOrError(n int64, err error) *RedisResponse {
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	return IntegerResponse(n)
+}
+
+// XTRIM key MAXLEN | MINID [= | ~] threshold [LIMIT count]
+func xtrimCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	var trim streamTrim
+	if strategy := strings.ToLower(args[1]); strategy != "maxlen" && strategy != "minid" {
+		return ErrorResponse(ErrSyntax)
+	}
+	n, err := parseStreamTrim(args[1:], &trim)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if 1+n != len(args) {
+		return ErrorResponse(ErrSyntax)
+	}
+	key := args[0]
+	stream, found, err := lookupStream(re, key)
+	if err != nil || !found {
+		return integerOrError(0, err)
+	}
+	removed := stream.Trim(&trim)
+	if removed > 0 {
+		re.signalModifiedKey(key)
+		re.notifyKeyspaceEvent(notifyStream, "xtrim", key)
+		re.rewriteCommand(append([]string{"xtrim", key}, exactTrimArgs(stream)...)...)
+	}
+	return IntegerResponse(removed)
+}
+
+// xreadArgs are the arguments of XREAD and XREADGROUP
+type xreadArgs struct {
+	group, consumer string
+	count           int // 0 for no limit
+	blocking        bool
+	timeout         time.Duration // 0 blocks forever
+	noAck           bool
+	keys, ids       []string
+}
+
+// parseXreadArgs parses [GROUP group consumer] [COUNT count] [BLOCK milliseconds] [NOACK]
+// STREAMS key [key ...] id [id ...], GROUP and NOACK being options of XREADGROUP
+func parseXreadArgs(cmd *Cmd) (*xreadArgs, error) {
+	args := cmd.Args()
+	group := cmd.Name() == "xreadgroup"
+	opts := &xreadArgs{}
+	for i := 0; i < len(args); i++ {
+		switch option := strings.ToLower(args[i]); {
+		case option == "count" && i+1 < len(args):
+			count, ok := parseInt(args[i+1])
+			if !ok {
+				return nil, ErrNotInteger
+			}
+			opts.count = int(max(0, min(count, math.MaxInt32)))
+			i++
+		case option == "block" && i+1 < len(args):
+			ms, ok := parseInt(args[i+1])
+			if !ok || ms > math.MaxInt64/int64(time.Millisecond) {
+				return nil, ErrBlockTimeout
+			}
+			if ms < 0 {
+				return nil, ErrTimeoutNegative
+			}
+			opts.blocking, opts.timeout = true, time.Duration(ms)*time.Millisecond
+			i++
+		case group && option == "group" && i+2 < len(args):
+			opts.group, opts.consumer = args[i+1], args[i+2]
+			i += 2
+		case group && option == "noack":
+			opts.noAck = true
+		case option == "streams":
+			streams := args[i+1:]
+			if len(streams) == 0 || len(streams)%2 != 0 {
+				last := "$"
+				if group {
+					last = ">"
+				}
+				return nil, fmt.Errorf("ERR Unbalanced '%s' list of streams: for each stream key an ID or '%s' must be specified.", cmd.Name(), last)
+			}
+			opts.keys, opts.ids = streams[:len(streams)/2], streams[len(streams)/2:]
+			if group && opts.group == "" {
+				return nil, ErrMissingGroup
+			}
+			return opts, nil
+		default:
+			return nil, ErrSyntax
+		}
+	}
+	return nil, ErrSyntax
+}
+
+// xreadKeys returns the keys of XREAD and XREADGROUP, following STREAMS
+func xreadKeys(args []string) []string {
+	for i := 0; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "count", "block":
+			i++
+		case "group":
+			i += 2
+		case "streams":
+			streams := args[i+1:]
+			return streams[:len(streams)/2]
+		}
+	}
+	return nil
+}
+
+// streamReply is the reply of XREAD and XREADGROUP for a stream: its key and entries
+func streamReply(key string, entries *RedisResponse) *RedisResponse {
+	return ArrayResponse(BulkResponse(key), entries)
+}
+
+// XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]. The entries
+// whose ID is greater than the one given for their stream are replied, $ standing for
+// the last ID of the stream. With BLOCK the client waits for an entry to be added.
+func xreadCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	opts, err := parseXreadArgs(cmd)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	after := make([]streamID, len(opts.keys))
+	for i, key := range opts.keys {
+		stream, found, err := lookupStream(re, key)
+		if err != nil {
+			return ErrorResponse(err)
+		}
+		if opts.ids[i] == "$" {
+			if found {
+				after[i] = stream.lastID
+			}
+			continue
+		}
+		var ok bool
+		if after[i], ok = parseStreamID(opts.ids[i], 0); !ok {
+			return ErrorResponse(ErrStreamID)
+		}
+	}
+
+	read := func(i int) []streamEntry {
+		stream, found, err := lookupStream(re, opts.keys[i])
+		if !found || err != nil {
+			return nil
+		}
+		return entriesAfter(stream, after[i], opts.count)
+	}
+	var items []*RedisResponse
+	for i, key := range opts.keys {
+		if entries := read(i); len(entries) > 0 {
+			items = append(items, streamReply(key, entriesResponse(entries)))
+		}
+	}
+	if len(items) > 0 {
+		return ArrayResponse(items...)
+	}
+	if !opts.blocking {
+		return NullArrayResponse()
+	}
+
+	serve := func(key string) (*RedisResponse, bool) {
+		for i := range opts.keys {
+			if opts.keys[i] != key {
+				continue
+			}
+			if entries := read(i); len(entries) > 0 {
+				return ArrayResponse(streamReply(key, entriesResponse(entries))), true
+			}
+		}
+		return nil, false
+	}
+	bc := &blockedClient{keys: opts.keys, timeout: opts.timeout, serve: serve}
+	re.block(bc)
+	return BlockedResponse(bc)
+}
+
+// readGroup delivers entries of the stream to the consumer of the group: the entries
+// never delivered to the group if @history is false, otherwise the entries pending for
+// the consumer whose ID is greater than @after. The deliveries are propagated.
+func (re *RedisExecutorImpl) readGroup(key string, stream *Stream, cg *streamCG, opts *xreadArgs, history bool, after streamID) []*RedisResponse {
+	now := nowMs()
+	consumer := re.groupConsumer(key, cg, opts.consumer, now)
+	items := []*RedisResponse{}
+	if history {
+		start, ok := after.next()
+		for i := cg.pendingIndex(start); ok && i < len(cg.pel) && (opts.count == 0 || len(items) < opts.count); i++ {
+			nack := cg.pel[i]
+			if nack.consumer != consumer {
+				continue
+			}
+			if entry, found := stream.Get(nack.id); found {
+				items = append(items, entryResponse(entry))
+			} else {
+				items = append(items, ArrayResponse(BulkResponse(nack.id.String()), NullArrayResponse()))
+			}
+			nack.deliveryTime = now
+			nack.deliveryCount++
+			re.propagateClaim(key, cg, nack)
+		}
+	} else {
+		for _, entry := range entriesAfter(stream, cg.lastID, opts.count) {
+			cg.lastID = entry.id
+			items = append(items, entryResponse(entry))
+			if opts.noAck {
+				continue
+			}
+			// the entry may be pending already if the group was set to an older ID
+			cg.removePending(entry.id)
+			nack := &streamNACK{id: entry.id, consumer: consumer, deliveryTime: now, deliveryCount: 1}
+			cg.addPending(nack)
+			re.propagateClaim(key, cg, nack)
+		}
+		if opts.noAck && len(items) > 0 {
+			re.rewriteCommand("xgroup", "setid", key, cg.name, cg.lastID.String())
+		}
+	}
+	if len(items) > 0 {
+		consumer.activeTime = now
+		re.signalModifiedKey(key)
+	}
+	return items
+}
+
+// XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key
+// [key ...] id [id ...]. The ID > reads the entries never delivered to the group, which
+// are added to the pending entries of the consumer unless NOACK is given. Another ID
+// reads the pending entries of the consumer after it. Only > blocks.
+func xreadgroupCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	opts, err := parseXreadArgs(cmd)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	after := make([]streamID, len(opts.keys))
+	newOnly := true
+	for i, key := range opts.keys {
+		if _, _, err := lookupGroup(re, key, opts.group); err != nil {
+			if errors.Is(err, ErrWrongType) {
+				return ErrorResponse(err)
+			}
+			return ErrorResponse(fmt.Errorf("%w in XREADGROUP with GROUP option", err))
+		}
+		if opts.ids[i] == ">" {
+			continue
+		}
+		newOnly = false
+		var ok bool
+		if after[i], ok = parseStreamID(opts.ids[i], 0); !ok {
+			return ErrorResponse(ErrStreamID)
+		}
+	}
+
+	var items []*RedisResponse
+	for i, key := range opts.keys {
+		stream, cg, _ := lookupGroup(re, key, opts.group)
+		history := opts.ids[i] != ">"
+		if entries := re.readGroup(key, stream, cg, opts, history, after[i]); len(entries) > 0 || history {
+			items = append(items, streamReply(key, ArrayResponse(entries...)))
+		}
+	}
+	if len(items) > 0 {
+		return ArrayResponse(items...)
+	}
+	if !opts.blocking || !newOnly {
+		return NullArrayResponse()
+	}
+
+	serve := func(key string) (*RedisResponse, bool) {
+		stream, cg, err := lookupGroup(re, key, opts.group)
+		if err != nil {
+			if errors.Is(err, ErrWrongType) {
+				return nil, false
+			}
+			return ErrorResponse(fmt.Errorf("%w in XREADGROUP with GROUP option", err)), true
+		}
+		if last, found := stream.Last(); !found || !cg.lastID.less(last.id) {
+			return nil, false
+		}
+		entries := re.readGroup(key, stream, cg, opts, false, streamID{})
+		return ArrayResponse(streamReply(key, ArrayResponse(entries...))), true
+	}
+	bc := &blockedClient{keys: opts.keys, timeout: opts.timeout, serve: serve}
+	re.block(bc)
+	return BlockedResponse(bc)
+}
+
+// XGROUP CREATE key group id | $ [MKSTREAM] | SETID key group id | $ | DESTROY key group |
+// CREATECONSUMER key group consumer | DELCONSUMER key group consumer
+func xgroupCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	subcommand := strings.ToLower(args[0])
+	switch subcommand {
+	case "create", "setid", "destroy", "createconsumer", "delconsumer":
+	default:
+		return ErrorResponse(UnknownSubcommandError(cmd))
+	}
+	wants := map[string]int{"create": 4, "setid": 4, "destroy": 3, "createconsumer": 4, "delconsumer": 4}[subcommand]
+	mkStream := subcommand == "create" && len(args) == 5 && strings.EqualFold(args[4], "mkstream")
+	switch {
+	case len(args) == wants || mkStream:
+	case subcommand == "create" && len(args) > wants:
+		return ErrorResponse(ErrSyntax)
+	default:
+		return ErrorResponse(WrongArgsError("xgroup|" + subcommand))
+	}
+	key, name := args[1], args[2]
+	stream, found, err := lookupStream(re, key)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found && !mkStream {
+		return ErrorResponse(ErrXGroupNoKey)
+	}
+	// the ID of CREATE and SETID, $ being the last ID of the stream
+	var id streamID
+	if subcommand == "create" || subcommand == "setid" {
+		if args[3] == "$" {
+			if found {
+				id = stream.lastID
+			}
+		} else {
+			var ok bool
+			if id, ok = parseStreamID(args[3], 0); !ok {
+				return ErrorResponse(ErrStreamID)
+			}
+		}
+	}
+	if stream == nil {
+		stream = NewStream()
+		_ = re.Set(key, &CacheItem{Key: key, Value: stream, Type: StreamType})
+	}
+
+	cg := stream.groups[name]
+	if cg == nil && subcommand != "create" && subcommand != "destroy" {
+		return ErrorResponse(NoSuchGroupError(key, name))
+	}
+	switch subcommand {
+	case "create":
+		if cg != nil {
+			return ErrorResponse(ErrBusyGroup)
+		}
+		stream.groups[name] = newStreamCG(name, id)
+		re.signalModifiedKey(key)
+		re.notifyKeyspaceEvent(notifyStream, "xgroup-create", key)
+		re.rewriteCommand("xgroup", "create", key, name, id.String(), "MKSTREAM")
+		return OKResponse()
+	case "setid":
+		cg.lastID = id
+		re.signalModifiedKey(key)
+		re.notifyKeyspaceEvent(notifyStream, "xgroup-setid", key)
+		re.rewriteCommand("xgroup", "setid", key, name, id.String())
+		return OKResponse()
+	case "destroy":
+		if cg == nil {
+			return IntegerResponse(0)
+		}
+		delete(stream.groups, name)
+		re.signalModifiedKey(key)
+		re.notifyKeyspaceEvent(notifyStream, "xgroup-destroy", key)
+		// the clients blocked on the group are unblocked with an error
+		re.signalKeyAsReady(key)
+		return IntegerResponse(1)
+	case "createconsumer":
+		if _, created := cg.consumer(args[3], true, nowMs()); !created {
+			return IntegerResponse(0)
+		}
+		re.signalModifiedKey(key)
+		re.notifyKeyspaceEvent(notifyStream, "xgroup-createconsumer", key)
+		return IntegerResponse(1)
+	}
+	consumer, _ := cg.consumer(args[3], false, 0)
+	if consumer == nil {
+		return IntegerResponse(0)
+	}
+	pending := cg.deleteConsumer(consumer)
+	re.signalModifiedKey(key)
+	re.notifyKeyspaceEvent(notifyStream, "xgroup-delconsumer", key)
+	return IntegerResponse(int64(pending))
+}
+
+// XACK key group id [id ...]
+func xackCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	ids := make([]streamID, 0, len(cmd.Args())-2)
+	for _, arg := range cmd.Args()[2:] {
+		id, ok := parseStreamID(arg, 0)
+		if !ok {
+			return ErrorResponse(ErrStreamID)
+		}
+		ids = append(ids, id)
+	}
+	key := cmd.Arg(0)
+	stream, found, err := lookupStream(re, key)
+	if err != nil || !found || stream.groups[cmd.Arg(1)] == nil {
+		return integerOrError(0, err)
+	}
+	cg := stream.groups[cmd.Arg(1)]
+	var acked int64
+	for _, id := range ids {
+		if cg.removePending(id) {
+			acked++
+		}
+	}
+	if acked > 0 {
+		re.signalModifiedKey(key)
+	}
+	return IntegerResponse(acked)
+}
+
+// XPENDING key group [[IDLE min-idle-time] start end count [consumer]]. Without a range
+// the summary of the pending entries is replied: their number, smallest and greatest
+// IDs, and their number for each consumer.
+func xpendingCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	var minIdle int64
+	var start, end streamID
+	var count int64
+	var consumerName string
+	extended := len(args) > 2
+	if extended {
+		i := 2
+		if strings.EqualFold(args[i], "idle") && len(args) > 3 {
+			var ok bool
+			if minIdle, ok = parseInt(args[3]); !ok {
+				return ErrorResponse(ErrNotInteger)
+			}
+			i = 4
+		}
+		if n := len(args) - i; n != 3 && n != 4 {
+			return ErrorResponse(ErrSyntax)
+		}
+		var err error
+		if start, err = parseRangeID(args[i], false); err != nil {
+			return ErrorResponse(err)
+		}
+		if end, err = parseRangeID(args[i+1], true); err != nil {
+			return ErrorResponse(err)
+		}
+		var ok bool
+		if count, ok = parseInt(args[i+2]); !ok {
+			return ErrorResponse(ErrNotInteger)
+		}
+		if i+3 < len(args) {
+			consumerName = args[i+3]
+		}
+	}
+
+	_, cg, err := lookupGroup(re, args[0], args[1])
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !extended {
+		if len(cg.pel) == 0 {
+			return ArrayResponse(IntegerResponse(0), NilResponse(), NilResponse(), NullArrayResponse())
+		}
+		var consumers []*RedisResponse
+		for _, consumer := range cg.sortedConsumers() {
+			if len(consumer.pending) > 0 {
+				consumers = append(consumers, BulkArrayResponse([]string{consumer.name, strconv.Itoa(len(consumer.pending))}))
+			}
+		}
+		return ArrayResponse(IntegerResponse(int64(len(cg.pel))), BulkResponse(cg.pel[0].id.String()),
+			BulkResponse(cg.pel[len(cg.pel)-1].id.String()), ArrayResponse(consumers...))
+	}
+
+	now := nowMs()
+	items := []*RedisResponse{}
+	for i := cg.pendingIndex(start); i < len(cg.pel) && int64(len(items)) < count; i++ {
+		nack := cg.pel[i]
+		if end.less(nack.id) {
+			break
+		}
+		if (consumerName != "" && nack.consumer.name != consumerName) || now-nack.deliveryTime < minIdle {
+			continue
+		}
+		items = append(items, ArrayResponse(BulkResponse(nack.id.String()), BulkResponse(nack.consumer.name),
+			IntegerResponse(now-nack.deliveryTime), IntegerResponse(nack.deliveryCount)))
+	}
+	return ArrayResponse(items...)
+}
+
+// claim gives the pending entry to the consumer, delivered at @deliveryTime. Its
+// delivery count is set to @retryCount if not negative, or incremented unless @justID
+// is set. The claim is propagated.
+func (re *RedisExecutorImpl) claim(key string, cg *streamCG, nack *streamNACK, consumer *streamConsumer, deliveryTime, retryCount int64, justID bool) {
+	if nack.consumer != consumer {
+		delete(nack.consumer.pending, nack.id)
+		nack.consumer = consumer
+		consumer.pending[nack.id] = nack
+	}
+	nack.deliveryTime = deliveryTime
+	if retryCount >= 0 {
+		nack.deliveryCount = retryCount
+	} else if !justID {
+		nack.deliveryCount++
+	}
+	consumer.activeTime = nowMs()
+	re.propagateClaim(key, cg, nack)
+}
+
+// claimedResponse is the reply of a claimed entry: its ID alone with JUSTID
+func claimedResponse(entry streamEntry, justID bool) *RedisResponse {
+	if justID {
+		return BulkResponse(entry.id.String())
+	}
+	return entryResponse(entry)
+}
+
+// parseMinIdleTime parses the min-idle-time of XCLAIM and XAUTOCLAIM, negative being 0
+func parseMinIdleTime(arg string) (int64, error) {
+	minIdle, ok := parseInt(arg)
+	if !ok {
+		return 0, ErrMinIdleTime
+	}
+	return max(minIdle, 0), nil
+}
+
+// XCLAIM key group consumer min-idle-time id [id ...] [IDLE ms] [TIME unix-time-ms]
+// [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID id]. The pending entries idle for at least
+// min-idle-time are given to the consumer. FORCE claims the entries not pending yet.
+func xclaimCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	minIdle, err := parseMinIdleTime(args[3])
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	var ids []streamID
+	i := 4
+	for ; i < len(args); i++ {
+		id, ok := parseStreamID(args[i], 0)
+		if !ok {
+			break
+		}
+		ids = append(ids, id)
+	}
+	now := nowMs()
+	deliveryTime, retryCount := now, int64(-1)
+	var force, justID, hasLastID bool
+	var lastID streamID
+	for ; i < len(args); i++ {
+		option := strings.ToLower(args[i])
+		switch {
+		case option == "force":
+			force = true
+			continue
+		case option == "justid":
+			justID = true
+			continue
+		case i+1 == len(args):
+			return ErrorResponse(fmt.Errorf("ERR Unrecognized XCLAIM option '%s'", args[i]))
+		}
+		i++
+		value, ok := parseInt(args[i])
+		switch option {
+		case "idle":
+			deliveryTime = now - value
+		case "time":
+			deliveryTime = value
+		case "retrycount":
+			retryCount = value
+		case "lastid":
+			if lastID, ok = parseStreamID(args[i], 0); !ok {
+				return ErrorResponse(ErrStreamID)
+			}
+			hasLastID = true
+		default:
+			return ErrorResponse(fmt.Errorf("ERR Unrecognized XCLAIM option '%s'", args[i-1]))
+		}
+		if !ok {
+			return ErrorResponse(ErrNotInteger)
+		}
+	}
+	// like Redis, a delivery time in the future is now
+	if deliveryTime < 0 || deliveryTime > now {
+		deliveryTime = now
+	}
+
+	key := args[0]
+	stream, cg, err := lookupGroup(re, key, args[1])
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if hasLastID && cg.lastID.less(lastID) {
+		cg.lastID = lastID
+		re.signalModifiedKey(key)
+		re.rewriteCommand("xgroup", "setid", key, cg.name, lastID.String())
+	}
+	consumer := re.groupConsumer(key, cg, args[2], now)
+	items := []*RedisResponse{}
+	for _, id := range ids {
+		nack, pending := cg.pending(id)
+		entry, exists := stream.Get(id)
+		if !exists {
+			// the entries deleted from the stream are not pending anymore
+			if pending {
+				cg.removePending(id)
+				re.signalModifiedKey(key)
+				re.rewriteCommand("xack", key, cg.name, id.String())
+			}
+			continue
+		}
+		switch {
+		case !pending && !force:
+			continue
+		case !pending:
+			nack = &streamNACK{id: id, consumer: consumer, deliveryCount: 1}
+			cg.addPending(nack)
+		case minIdle > 0 && now-nack.deliveryTime < minIdle:
+			continue
+		}
+		re.claim(key, cg, nack, consumer, deliveryTime, retryCount, justID)
+		re.signalModifiedKey(key)
+		items = append(items, claimedResponse(entry, justID))
+	}
+	return ArrayResponse(items...)
+}
+
+// XAUTOCLAIM key group consumer min-idle-time start [COUNT count] [JUSTID]. Like XCLAIM
+// for the pending entries from start, up to count of them. The reply is the ID to
+// continue from (0-0 once done), the claimed entries and the IDs of the pending
+// entries deleted from the stream, which are removed from the pending entries.
+func xautoclaimCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	minIdle, err := parseMinIdleTime(args[3])
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	start, err := parseRangeID(args[4], false)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	count := int64(100)
+	var justID bool
+	for i := 5; i < len(args); i++ {
+		switch option := strings.ToLower(args[i]); {
+		case option == "count" && i+1 < len(args):
+			var ok bool
+			if count, ok = parseInt(args[i+1]); !ok || count < 1 || count > math.MaxInt32 {
+				return ErrorResponse(ErrAutoClaimCount)
+			}
+			i++
+		case option == "justid":
+			justID = true
+		default:
+			return ErrorResponse(ErrSyntax)
+		}
+	}
+
+	key := args[0]
+	stream, cg, err := lookupGroup(re, key, args[1])
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	now := nowMs()
+	consumer := re.groupConsumer(key, cg, args[2], now)
+	claimed := []*RedisResponse{}
+	deleted := []string{}
+	// like Redis, up to 10 pending entries are scanned for each entry claimed
+	attempts := count * 10
+	i := cg.pendingIndex(start)
+	for ; i < len(cg.pel) && attempts > 0 && int64(len(claimed)) < count; attempts-- {
+		nack := cg.pel[i]
+		entry, exists := stream.Get(nack.id)
+		if !exists {
+			cg.removePending(nack.id)
+			deleted = append(deleted, nack.id.String())
+			re.signalModifiedKey(key)
+			re.rewriteCommand("xack", key, cg.name, nack.id.String())
+			continue
+		}
+		i++
+		if now-nack.deliveryTime < minIdle {
+			continue
+		}
+		re.claim(key, cg, nack, consumer, now, -1, justID)
+		re.signalModifiedKey(key)
+		claimed = append(claimed, claimedResponse(entry, justID))
+	}
+	next := streamID{}
+	if i < len(cg.pel) {
+		next = cg.pel[i].id
+	}
+	return ArrayResponse(BulkResponse(next.String()), ArrayResponse(claimed...), BulkArrayResponse(deleted))
+}
+
+// XINFO STREAM key | GROUPS key | CONSUMERS key group
+func xinfoCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	subcommand := strings.ToLower(args[0])
+	switch {
+	case subcommand != "stream" && subcommand != "groups" && subcommand != "consumers":
+		return ErrorResponse(UnknownSubcommandError(cmd))
+	case subcommand == "consumers" && len(args) != 3, subcommand != "consumers" && len(args) != 2:
+		return ErrorResponse(WrongArgsError("xinfo|" + subcommand))
+	}
+	key := args[1]
+	stream, found, err := lookupStream(re, key)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return ErrorResponse(ErrNoSuchKey)
+	}
+
+	now := nowMs()
+	switch subcommand {
+	case "stream":
+		entryOrNil := func(entry streamEntry, found bool) *RedisResponse {
+			if !found {
+				return NilResponse()
+			}
+			return entryResponse(entry)
+		}
+		first, hasFirst := stream.First()
+		last, hasLast := stream.Last()
+		return ArrayResponse(
+			BulkResponse("length"), IntegerResponse(int64(stream.Len())),
+			BulkResponse("radix-tree-keys"), IntegerResponse(int64(len(stream.nodes))),
+			BulkResponse("last-generated-id"), BulkResponse(stream.lastID.String()),
+			BulkResponse("max-deleted-entry-id"), BulkResponse(stream.maxDeletedID.String()),
+			BulkResponse("entries-added"), IntegerResponse(stream.entriesAdded),
+			BulkResponse("recorded-first-entry-id"), BulkResponse(first.id.String()),
+			BulkResponse("groups"), IntegerResponse(int64(len(stream.groups))),
+			BulkResponse("first-entry"), entryOrNil(first, hasFirst),
+			BulkResponse("last-entry"), entryOrNil(last, hasLast),
+		)
+	case "groups":
+		groups := []*RedisResponse{}
+		for _, cg := range stream.sortedGroups() {
+			groups = append(groups, ArrayResponse(
+				BulkResponse("name"), BulkResponse(cg.name),
+				BulkResponse("consumers"), IntegerResponse(int64(len(cg.consumers))),
+				BulkResponse("pending"), IntegerResponse(int64(len(cg.pel))),
+				BulkResponse("last-delivered-id"), BulkResponse(cg.lastID.String()),
+			))
+		}
+		return ArrayResponse(groups...)
+	}
+	cg := stream.groups[args[2]]
+	if cg == nil {
+		return ErrorResponse(NoSuchGroupError(key, args[2]))
+	}
+	consumers := []*RedisResponse{}
+	for _, consumer := range cg.sortedConsumers() {
+		inactive := int64(-1)
+		if consumer.activeTime > 0 {
+			inactive = now - consumer.activeTime
+		}
+		consumers = append(consumers, ArrayResponse(
+			BulkResponse("name"), BulkResponse(consumer.name),
+			BulkResponse("pending"), IntegerResponse(int64(len(consumer.pending))),
+			BulkResponse("idle"), IntegerResponse(now-consumer.seenTime),
+			BulkResponse("inactive"), IntegerResponse(inactive),
+		))
+	}
+	return ArrayResponse(consumers...)
+}
+
+// XSETID key last-id [ENTRIESADDED entries-added] [MAXDELETEDID max-deleted-id], used to
+// rebuild the streams in the append only file
+func xsetidCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	id, ok := parseStreamID(args[1], 0)
+	if !ok {
+		return ErrorResponse(ErrStreamID)
+	}
+	entriesAdded := int64(-1)
+	var maxDeletedID streamID
+	var hasMaxDeletedID bool
+	for i := 2; i < len(args); i += 2 {
+		option := strings.ToLower(args[i])
+		switch {
+		case i+1 == len(args):
+			return ErrorResponse(ErrSyntax)
+		case option == "entriesadded":
+			if entriesAdded, ok = parseInt(args[i+1]); !ok {
+				return ErrorResponse(ErrNotInteger)
+			}
+			if entriesAdded < 0 {
+				return ErrorResponse(errors.New("ERR entries_added must be positive"))
+			}
+		case option == "maxdeletedid":
+			if maxDeletedID, ok = parseStreamID(args[i+1], 0); !ok {
+				return ErrorResponse(ErrStreamID)
+			}
+			if id.less(maxDeletedID) {
+				return ErrorResponse(ErrXSetIDMaxDeleted)
+			}
+			hasMaxDeletedID = true
+		default:
+			return ErrorResponse(ErrSyntax)
+		}
+	}
+
+	key := args[0]
+	stream, found, err := lookupStream(re, key)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return ErrorResponse(ErrNoSuchKey)
+	}
+	if last, found := stream.Last(); found && id.less(last.id) {
+		return ErrorResponse(ErrXSetIDSmaller)
+	}
+	if entriesAdded >= 0 && entriesAdded < int64(stream.Len()) {
+		return ErrorResponse(ErrXSetIDEntriesRead)
+	}
+	stream.lastID = id
+	if entriesAdded >= 0 {
+		stream.entriesAdded = entriesAdded
+	}
+	if hasMaxDeletedID {
+		stream.maxDeletedID = maxDeletedID
+	}
+	re.signalModifiedKey(key)
+	re.notifyKeyspaceEvent(notifyStream, "xsetid", key)
+	return OKResponse()
+}
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// entryReply is the reply of a stream entry, its id and its field-value pairs
+func entryReply(id string, fields ...string) string {
+	reply := "*2\r\n$" + strconv.Itoa(len(id)) + "\r\n" + id + "\r\n*" + strconv.Itoa(len(fields)) + "\r\n"
+	for _, field := range fields {
+		reply += "$" + strconv.Itoa(len(field)) + "\r\n" + field + "\r\n"
+	}
+	return reply
+}
+
+func TestStreamCommands(t *testing.T) {
+	runSteps(t, newTestExecutor(), []testStep{
+		{cmd("XADD", "s", "1-1", "a", "1"), "$3\r\n1-1\r\n"},
+		{cmd("XADD", "s", "1-*", "b", "2"), "$3\r\n1-2\r\n"},
+		{cmd("XADD", "s", "1-2", "c", "3"), "-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n"},
+		{cmd("XADD", "s", "0-0", "c", "3"), "-ERR The ID specified in XADD must be greater than 0-0\r\n"},
+		{cmd("XADD", "s", "x", "c", "3"), "-ERR Invalid stream ID specified as stream command argument\r\n"},
+		{cmd("XADD", "s", "5", "c", "3", "d"), "-ERR wrong number of arguments for 'xadd' command\r\n"},
+		{cmd("XADD", "s", "5", "c", "3"), "$3\r\n5-0\r\n"},
+		{cmd("XADD", "s", "7-3", "d", "4"), "$3\r\n7-3\r\n"},
+		{cmd("XADD", "missing", "NOMKSTREAM", "*", "a", "1"), "$-1\r\n"},
+		{cmd("EXISTS", "missing"), ":0\r\n"},
+		{cmd("XLEN", "s"), ":4\r\n"},
+		{cmd("XLEN", "missing"), ":0\r\n"},
+		{cmd("TYPE", "s"), "+stream\r\n"},
+		{cmd("XRANGE", "s", "-", "+"), "*4\r\n" + entryReply("1-1", "a", "1") + entryReply("1-2", "b", "2") +
+			entryReply("5-0", "c", "3") + entryReply("7-3", "d", "4")},
+		{cmd("XRANGE", "s", "1", "5", "COUNT", "2"), "*2\r\n" + entryReply("1-1", "a", "1") + entryReply("1-2", "b", "2")},
+		{cmd("XRANGE", "s", "(1-2", "+"), "*2\r\n" + entryReply("5-0", "c", "3") + entryReply("7-3", "d", "4")},
+		{cmd("XRANGE", "s", "(18446744073709551615-18446744073709551615", "+"), "-ERR invalid start ID for the interval\r\n"},
+		{cmd("XRANGE", "s", "8", "1"), "*0\r\n"},
+		{cmd("XREVRANGE", "s", "+", "-", "COUNT", "3"), "*3\r\n" + entryReply("7-3", "d", "4") + entryReply("5-0", "c", "3") + entryReply("1-2", "b", "2")},
+		{cmd("XREVRANGE", "s", "5", "(1-1"), "*2\r\n" + entryReply("5-0", "c", "3") + entryReply("1-2", "b", "2")},
+		{cmd("XDEL", "s", "1-2", "9-9"), ":1\r\n"},
+		{cmd("XLEN", "s"), ":3\r\n"},
+		{cmd("XTRIM", "s", "MINID", "5"), ":1\r\n"},
+		{cmd("XTRIM", "s", "MAXLEN", "-1"), "-ERR The MAXLEN argument must be >= 0.\r\n"},
+		{cmd("XTRIM", "s", "MAXLEN", "1", "LIMIT", "10"), "-ERR syntax error, LIMIT cannot be used without the special ~ option\r\n"},
+		{cmd("XADD", "s", "MAXLEN", "2", "8-0", "e", "5"), "$3\r\n8-0\r\n"},
+		{cmd("XLEN", "s"), ":2\r\n"},
+		{cmd("XRANGE", "s", "-", "7-3"), "*1\r\n" + entryReply("7-3", "d", "4")},
+		{cmd("SET", "str", "v"), "+OK\r\n"},
+		{cmd("XADD", "str", "*", "a", "1"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	})
+}
+
+func TestStreamNodes(t *testing.T) {
+	re := newTestExecutor()
+	const n = 3*streamNodeMaxEntries + 10
+	for i := 1; i <= n; i++ {
+		execute(re, "XADD", "s", strconv.Itoa(i), "i", strconv.Itoa(i))
+	}
+	runSteps(t, re, []testStep{
+		{cmd("XRANGE", "s", "99", "101"), "*3\r\n" + entryReply("99-0", "i", "99") + entryReply("100-0", "i", "100") + entryReply("101-0", "i", "101")},
+		{cmd("XREVRANGE", "s", "201", "199"), "*3\r\n" + entryReply("201-0", "i", "201") + entryReply("200-0", "i", "200") + entryReply("199-0", "i", "199")},
+		// an approximated trim only removes whole nodes
+		{cmd("XTRIM", "s", "MAXLEN", "~", "250"), ":0\r\n"},
+		{cmd("XTRIM", "s", "MAXLEN", "~", "150"), ":100\r\n"},
+		{cmd("XTRIM", "s", "MAXLEN", "~", "0", "LIMIT", "100"), ":100\r\n"},
+		{cmd("XLEN", "s"), ":110\r\n"},
+		{cmd("XTRIM", "s", "MAXLEN", "=", "5"), ":105\r\n"},
+		{cmd("XRANGE", "s", "-", "+", "COUNT", "1"), "*1\r\n" + entryReply("306-0", "i", "306")},
+	})
+}
+
+func TestXRead(t *testing.T) {
+	re := newTestExecutor()
+	execute(re, "XADD", "a", "1", "f", "1")
+	execute(re, "XADD", "a", "2", "f", "2")
+	execute(re, "XADD", "b", "1", "g", "1")
+	runSteps(t, re, []testStep{
+		{cmd("XREAD", "STREAMS", "a", "b", "1", "0"), "*2\r\n*2\r\n$1\r\na\r\n*1\r\n" + entryReply("2-0", "f", "2") +
+			"*2\r\n$1\r\nb\r\n*1\r\n" + entryReply("1-0", "g", "1")},
+		{cmd("XREAD", "COUNT", "1", "STREAMS", "a", "0"), "*1\r\n*2\r\n$1\r\na\r\n*1\r\n" + entryReply("1-0", "f", "1")},
+		{cmd("XREAD", "STREAMS", "a", "b", "$", "$"), "*-1\r\n"},
+		{cmd("XREAD", "STREAMS", "a", "b", "0"), "-ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.\r\n"},
+		{cmd("XREAD", "BLOCK", "-1", "STREAMS", "a", "$"), "-ERR timeout is negative\r\n"},
+		{cmd("XREAD", "BLOCK", "10", "STREAMS", "a", "$"), "*-1\r\n"},
+	})
+
+	// a blocked reader is served by the next entry added to any of its streams
+	c, _ := newTestClient(t, re, "10.0.0.1:1000")
+	read := make(chan string)
+	go func() {
+		read <- re.Execute(CreateCommandFromTokens(bulkTokens(cmd("XREAD", "BLOCK", "0", "STREAMS", "a", "b", "$", "$"))).SetClient(c)).Serialize()
+	}()
+	waitBlocked(re, c)
+	runSteps(t, re, []testStep{{cmd("XADD", "b", "5", "g", "5"), "$3\r\n5-0\r\n"}})
+	if got, want := <-read, "*1\r\n*2\r\n$1\r\nb\r\n*1\r\n"+entryReply("5-0", "g", "5"); got != want {
+		t.Errorf("XREAD: got %q, want %q", got, want)
+	}
+}
+
+// waitBlocked waits for the client to block
+func waitBlocked(re *RedisExecutorImpl, c *Client) {
+	for {
+		var blocked bool
+		re.exclusive(func() { blocked = c.blocked != nil })
+		if blocked {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// idleTimes matches the idle time and the delivery count of a pending entry
+var idleTimes = regexp.MustCompile(`:\d+\r\n(:\d+\r\n)`)
+
+// pendingEntries returns the extended form of XPENDING, the idle times depending on the
+// clock being replaced by 0
+func pendingEntries(re *RedisExecutorImpl, args ...string) string {
+	return idleTimes.ReplaceAllString(execute(re, append(cmd("XPENDING"), args...)...), ":0\r\n$1")
+}
+
+func TestConsumerGroups(t *testing.T) {
+	re := newTestExecutor()
+	execute(re, "XADD", "s", "1", "f", "1")
+	execute(re, "XADD", "s", "2", "f", "2")
+	execute(re, "XADD", "s", "3", "f", "3")
+	runSteps(t, re, []testStep{
+		{cmd("XGROUP", "CREATE", "missing", "g", "$"), "-ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.\r\n"},
+		{cmd("XGROUP", "CREATE", "empty", "g", "$", "MKSTREAM"), "+OK\r\n"},
+		{cmd("XLEN", "empty"), ":0\r\n"},
+		{cmd("XGROUP", "CREATE", "s", "g", "0"), "+OK\r\n"},
+		{cmd("XGROUP", "CREATE", "s", "g", "0"), "-BUSYGROUP Consumer Group name already exists\r\n"},
+		{cmd("XREADGROUP", "GROUP", "nope", "alice", "STREAMS", "s", ">"), "-NOGROUP No such key 's' or consumer group 'nope' in XREADGROUP with GROUP option\r\n"},
+		{cmd("XREADGROUP", "GROUP", "g", "alice", "COUNT", "2", "STREAMS", "s", ">"), "*1\r\n*2\r\n$1\r\ns\r\n*2\r\n" +
+			entryReply("1-0", "f", "1") + entryReply("2-0", "f", "2")},
+		{cmd("XREADGROUP", "GROUP", "g", "bob", "STREAMS", "s", ">"), "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n" + entryReply("3-0", "f", "3")},
+		{cmd("XREADGROUP", "GROUP", "g", "bob", "STREAMS", "s", ">"), "*-1\r\n"},
+		// the history of a consumer is its pending entries
+		{cmd("XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", "0"), "*1\r\n*2\r\n$1\r\ns\r\n*2\r\n" +
+			entryReply("1-0", "f", "1") + entryReply("2-0", "f", "2")},
+		{cmd("XPENDING", "s", "g"), "*4\r\n:3\r\n$3\r\n1-0\r\n$3\r\n3-0\r\n*2\r\n*2\r\n$5\r\nalice\r\n$1\r\n2\r\n*2\r\n$3\r\nbob\r\n$1\r\n1\r\n"},
+		{cmd("XACK", "s", "g", "1", "1", "9"), ":1\r\n"},
+		{cmd("XACK", "s", "nope", "1"), ":0\r\n"},
+	})
+	if got, want := pendingEntries(re, "s", "g", "-", "+", "10", "bob"), "*1\r\n*4\r\n$3\r\n3-0\r\n$3\r\nbob\r\n:0\r\n:1\r\n"; got != want {
+		t.Errorf("XPENDING: got %q, want %q", got, want)
+	}
+	runSteps(t, re, []testStep{
+		{cmd("XPENDING", "s", "g", "IDLE", "100000", "-", "+", "10"), "*0\r\n"},
+		// claiming an entry delivers it again to the new owner
+		{cmd("XCLAIM", "s", "g", "bob", "0", "2"), "*1\r\n" + entryReply("2-0", "f", "2")},
+		{cmd("XCLAIM", "s", "g", "bob", "x", "2"), "-ERR Invalid min-idle-time argument for XCLAIM\r\n"},
+	})
+	if got, want := pendingEntries(re, "s", "g", "-", "+", "1"), "*1\r\n*4\r\n$3\r\n2-0\r\n$3\r\nbob\r\n:0\r\n:3\r\n"; got != want {
+		t.Errorf("XPENDING: got %q, want %q", got, want)
+	}
+	runSteps(t, re, []testStep{
+		{cmd("XAUTOCLAIM", "s", "g", "carol", "0", "0", "COUNT", "1", "JUSTID"), "*3\r\n$3\r\n3-0\r\n*1\r\n$3\r\n2-0\r\n*0\r\n"},
+		{cmd("XAUTOCLAIM", "s", "g", "carol", "0", "3-0"), "*3\r\n$3\r\n0-0\r\n*1\r\n" + entryReply("3-0", "f", "3") + "*0\r\n"},
+		{cmd("XAUTOCLAIM", "s", "g", "carol", "0", "0", "COUNT", "0"), "-ERR COUNT must be > 0\r\n"},
+		// the entries deleted meanwhile are acknowledged by XAUTOCLAIM
+		{cmd("XDEL", "s", "2"), ":1\r\n"},
+		{cmd("XAUTOCLAIM", "s", "g", "dave", "0", "0"), "*3\r\n$3\r\n0-0\r\n*1\r\n" + entryReply("3-0", "f", "3") + "*1\r\n$3\r\n2-0\r\n"},
+		{cmd("XPENDING", "s", "g"), "*4\r\n:1\r\n$3\r\n3-0\r\n$3\r\n3-0\r\n*1\r\n*2\r\n$4\r\ndave\r\n$1\r\n1\r\n"},
+		{cmd("XGROUP", "CREATECONSUMER", "s", "g", "erin"), ":1\r\n"},
+		{cmd("XGROUP", "CREATECONSUMER", "s", "g", "erin"), ":0\r\n"},
+		{cmd("XGROUP", "DELCONSUMER", "s", "g", "dave"), ":1\r\n"},
+		{cmd("XPENDING", "s", "g"), "*4\r\n:0\r\n$-1\r\n$-1\r\n*-1\r\n"},
+		{cmd("XGROUP", "SETID", "s", "g", "$"), "+OK\r\n"},
+		{cmd("XREADGROUP", "GROUP", "g", "alice", "NOACK", "STREAMS", "s", ">"), "*-1\r\n"},
+		{cmd("XADD", "s", "4", "f", "4"), "$3\r\n4-0\r\n"},
+		{cmd("XREADGROUP", "GROUP", "g", "alice", "NOACK", "STREAMS", "s", ">"), "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n" + entryReply("4-0", "f", "4")},
+		{cmd("XPENDING", "s", "g", "-", "+", "10"), "*0\r\n"},
+		{cmd("XGROUP", "DESTROY", "s", "g"), ":1\r\n"},
+		{cmd("XGROUP", "DESTROY", "s", "g"), ":0\r\n"},
+		{cmd("XINFO", "GROUPS", "s"), "*0\r\n"},
+	})
+}
+
+func TestXReadGroupBlocking(t *testing.T) {
+	re := newTestExecutor()
+	execute(re, "XGROUP", "CREATE", "s", "g", "$", "MKSTREAM")
+	c, _ := newTestClient(t, re, "10.0.0.1:1000")
+	read := make(chan string)
+	go func() {
+		read <- re.Execute(CreateCommandFromTokens(bulkTokens(cmd("XREADGROUP", "GROUP", "g", "alice", "BLOCK", "0", "STREAMS", "s", ">"))).SetClient(c)).Serialize()
+	}()
+	waitBlocked(re, c)
+	runSteps(t, re, []testStep{{cmd("XADD", "s", "1", "f", "1"), "$3\r\n1-0\r\n"}})
+	if got, want := <-read, "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n"+entryReply("1-0", "f", "1"); got != want {
+		t.Errorf("XREADGROUP: got %q, want %q", got, want)
+	}
+	runSteps(t, re, []testStep{{cmd("XPENDING", "s", "g"), "*4\r\n:1\r\n$3\r\n1-0\r\n$3\r\n1-0\r\n*1\r\n*2\r\n$5\r\nalice\r\n$1\r\n1\r\n"}})
+
+	// the blocked clients of a destroyed group are unblocked with an error
+	go func() {
+		read <- re.Execute(CreateCommandFromTokens(bulkTokens(cmd("XREADGROUP", "GROUP", "g", "alice", "BLOCK", "0", "STREAMS", "s", ">"))).SetClient(c)).Serialize()
+	}()
+	waitBlocked(re, c)
+	runSteps(t, re, []testStep{{cmd("XGROUP", "DESTROY", "s", "g"), ":1\r\n"}})
+	if got, want := <-read, "-NOGROUP No such key 's' or consumer group 'g' in XREADGROUP with GROUP option\r\n"; got != want {
+		t.Errorf("XREADGROUP: got %q, want %q", got, want)
+	}
+}
+
+func TestXInfo(t *testing.T) {
+	re := newTestExecutor()
+	execute(re, "XADD", "s", "1", "f", "1")
+	execute(re, "XADD", "s", "2", "f", "2")
+	execute(re, "XGROUP", "CREATE", "s", "g", "0")
+	execute(re, "XREADGROUP", "GROUP", "g", "alice", "COUNT", "1", "STREAMS", "s", ">")
+	info := execute(re, "XINFO", "STREAM", "s")
+	for _, field := range []string{
+		"$6\r\nlength\r\n:2\r\n",
+		"$17\r\nlast-generated-id\r\n$3\r\n2-0\r\n",
+		"$13\r\nentries-added\r\n:2\r\n",
+		"$6\r\ngroups\r\n:1\r\n",
+		"$11\r\nfirst-entry\r\n" + entryReply("1-0", "f", "1"),
+	} {
+		if !strings.Contains(info, field) {
+			t.Errorf("XINFO STREAM: %q not in %q", field, info)
+		}
+	}
+	groups := execute(re, "XINFO", "GROUPS", "s")
+	for _, field := range []string{"$4\r\nname\r\n$1\r\ng\r\n", "$9\r\nconsumers\r\n:1\r\n", "$7\r\npending\r\n:1\r\n", "$17\r\nlast-delivered-id\r\n$3\r\n1-0\r\n"} {
+		if !strings.Contains(groups, field) {
+			t.Errorf("XINFO GROUPS: %q not in %q", field, groups)
+		}
+	}
+	if consumers := execute(re, "XINFO", "CONSUMERS", "s", "g"); !strings.Contains(consumers, "$4\r\nname\r\n$5\r\nalice\r\n$7\r\npending\r\n:1\r\n") {
+		t.Errorf("XINFO CONSUMERS: got %q", consumers)
+	}
+	runSteps(t, re, []testStep{
+		{cmd("XINFO", "CONSUMERS", "s", "nope"), "-NOGROUP No such consumer group 'nope' for key name 's'\r\n"},
+		{cmd("XINFO", "STREAM", "missing"), "-ERR no such key\r\n"},
+	})
+}
+
+func TestStreamPropagation(t *testing.T) {
+	re := newAppendOnlyExecutor(t, t.TempDir())
+	execute(re, "XADD", "s", "1-*", "f", "1")
+	execute(re, "XADD", "s", "MAXLEN", "1", "2", "f", "2")
+	execute(re, "XGROUP", "CREATE", "s", "g", "$")
+	execute(re, "XADD", "s", "3", "f", "3")
+	execute(re, "XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", ">")
+
+	// the generated ids are propagated, and the reads of the groups as claims
+	got := readAppendOnly(t, re.config.AppendOnlyPath())
+	want := []string{
+		"select 0",
+		"xadd s 1-0 f 1",
+		"xadd s MINID = 2-0 2-0 f 2",
+		"xgroup create s g 2-0 MKSTREAM",
+		"xadd s 3-0 f 3",
+		"xgroup createconsumer s g alice",
+	}
+	if len(got) != len(want)+1 || strings.Join(got[:len(want)], "\n") != strings.Join(want, "\n") ||
+		!strings.HasPrefix(got[len(want)], "xclaim s g alice 0 3-0 TIME ") || !strings.HasSuffix(got[len(want)], " RETRYCOUNT 1 FORCE JUSTID LASTID 3-0") {
+		t.Errorf("got %q, want %q and an xclaim", got, want)
+	}
+}
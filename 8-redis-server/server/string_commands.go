@@ -0,0 +1,460 @@
+package server
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// maxStringLength is the maximum size of a string value (proto-max-bulk-len)
+const maxStringLength = 512 * 1024 * 1024
+
+func init() {
+	registerCommands(
+		&commandSpec{name: "get", arity: 2, handler: getCommand, keys: firstKey, categories: catString},
+		&commandSpec{name: "set", arity: -3, handler: setCommand, flags: flagWrite | flagDenyOOM, keys: firstKey, categories: catString},
+		&commandSpec{name: "setnx", arity: 3, handler: setnxCommand, flags: flagWrite | flagDenyOOM, keys: firstKey, categories: catString},
+		&commandSpec{name: "getdel", arity: 2, handler: getdelCommand, flags: flagWrite, keys: firstKey, categories: catString},
+		&commandSpec{name: "getex", arity: -2, handler: getexCommand, flags: flagWrite, keys: firstKey, categories: catString},
+		&commandSpec{name: "mget", arity: -2, handler: mgetCommand, keys: allKeys, categories: catString},
+		&commandSpec{name: "mset", arity: -3, handler: msetCommand, flags: flagWrite | flagDenyOOM, keys: keySpec{0, -1, 2}, categories: catString},
+		&commandSpec{name: "msetnx", arity: -3, handler: msetCommand, flags: flagWrite | flagDenyOOM, keys: keySpec{0, -1, 2}, categories: catString},
+		&commandSpec{name: "incr", arity: 2, handler: incrCommand, flags: flagWrite | flagDenyOOM, keys: firstKey, categories: catString},
+		&commandSpec{name: "decr", arity: 2, handler: incrCommand, flags: flagWrite | flagDenyOOM, keys: firstKey, categories: catString},
+		&commandSpec{name: "incrby", arity: 3, handler: incrCommand, flags: flagWrite | flagDenyOOM, keys: firstKey, categories: catString},
+		&commandSpec{name: "decrby", arity: 3, handler: incrCommand, flags: flagWrite | flagDenyOOM, keys: firstKey, categories: catString},
+		&commandSpec{name: "incrbyfloat", arity: 3, handler: incrbyfloatCommand, flags: flagWrite | flagDenyOOM, keys: firstKey, categories: catString},
+		&commandSpec{name: "append", arity: 3, handler: appendCommand, flags: flagWrite | flagDenyOOM, keys: firstKey, categories: catString},
+		&commandSpec{name: "strlen", arity: 2, handler: strlenCommand, keys: firstKey, categories: catString},
+		&commandSpec{name: "getrange", arity: 4, handler: getrangeCommand, keys: firstKey, categories: catString},
+		&commandSpec{name: "setrange", arity: 4, handler: setrangeCommand, flags: flagWrite | flagDenyOOM, keys: firstKey, categories: catString},
+	)
+}
+
+/* ---------------- helpers ---------------- */
+
+// lookupString returns the string item stored at key
+func lookupString(re *RedisExecutorImpl, key string) (*CacheItem, bool, error) {
+	return lookupTyped(re, key, StringType)
+}
+
+// setString stores a string at key, discarding any previous value and time to live
+func setString(re *RedisExecutorImpl, key string, value []byte, expireAt int64) {
+	_ = re.Set(key, &CacheItem{
+		Key:      key,
+		Value:    value,
+		Type:     StringType,
+		ExpireAt: expireAt,
+	})
+	re.signalModifiedKey(key)
+}
+
+// growString zero-pads the string up to @size bytes, it is modified in place if its
+// capacity allows
+func growString(value []byte, size int64) []byte {
+	if n := int(size) - len(value); n > 0 {
+		value = append(value, make([]byte, n)...)
+	}
+	return value
+}
+
+// parseExpireOption parses the argument of the EX, PX, EXAT and PXAT options
+func parseExpireOption(name, option, arg string) (int64, error) {
+	when, ok := parseInt(arg)
+	if !ok {
+		return 0, ErrNotInteger
+	}
+	expireAt, ok := toUnixMs(option, when)
+	if when <= 0 || !ok {
+		return 0, InvalidExpireError(name)
+	}
+	return expireAt, nil
+}
+
+func isExpireOption(option string) bool {
+	return option == "ex" || option == "px" || option == "exat" || option == "pxat"
+}
+
+/* ---------------- commands ---------------- */
+
+// GET key
+func getCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	item, found, err := lookupString(re, cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return NilResponse()
+	}
+	return BulkResponse(string(item.StringValue()))
+}
+
+// SET key value [NX | XX] [GET] [EX seconds | PX milliseconds | EXAT unix-time-seconds |
+// PXAT unix-time-milliseconds | KEEPTTL]
+func setCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	var nx, xx, get, keepTTL bool
+	var expireAt int64
+	var err error
+
+	key, value := cmd.Arg(0), cmd.Arg(1)
+	args := cmd.Args()[2:]
+	for i := 0; i < len(args); i++ {
+		option := strings.ToLower(args[i])
+		switch {
+		case option == "nx" && !xx:
+			nx = true
+		case option == "xx" && !nx:
+			xx = true
+		case option == "get":
+			get = true
+		case option == "keepttl" && expireAt == 0:
+			keepTTL = true
+		case isExpireOption(option) && !keepTTL && expireAt == 0 && i+1 < len(args):
+			if expireAt, err = parseExpireOption(cmd.Name(), option, args[i+1]); err != nil {
+				return ErrorResponse(err)
+			}
+			i++
+		default:
+			return ErrorResponse(ErrSyntax)
+		}
+	}
+
+	old, found := re.Get(key)
+	if get && found {
+		if _, _, err = lookupString(re, key); err != nil {
+			return ErrorResponse(err)
+		}
+	}
+	reply := OKResponse()
+	if get {
+		reply = NilResponse()
+		if found {
+			reply = BulkResponse(string(old.StringValue()))
+		}
+	}
+	if (nx && found) || (xx && !found) {
+		if get {
+			return reply
+		}
+		return NilResponse()
+	}
+
+	if keepTTL && found {
+		expireAt = old.ExpireAt
+	}
+	setString(re, key, []byte(value), expireAt)
+	re.notifyKeyspaceEvent(notifyString, "set", key)
+	if expireAt > 0 && !keepTTL {
+		re.notifyKeyspaceEvent(notifyGeneric, "expire", key)
+	}
+	// a relative expiry is propagated as an absolute one
+	if expireAt > 0 {
+		re.rewriteCommand("set", key, value, "pxat", strconv.FormatInt(expireAt, 10))
+	} else {
+		re.rewriteCommand("set", key, value)
+	}
+	return reply
+}
+
+// SETNX key value
+func setnxCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	if found, _ := re.Contains(cmd.Arg(0)); found {
+		return IntegerResponse(0)
+	}
+	setString(re, cmd.Arg(0), []byte(cmd.Arg(1)), 0)
+	re.notifyKeyspaceEvent(notifyString, "set", cmd.Arg(0))
+	return IntegerResponse(1)
+}
+
+// GETDEL key
+func getdelCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	item, found, err := lookupString(re, cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return NilResponse()
+	}
+	_ = re.Remove(cmd.Arg(0))
+	re.signalModifiedKey(cmd.Arg(0))
+	re.notifyKeyspaceEvent(notifyGeneric, "del", cmd.Arg(0))
+	return BulkResponse(string(item.StringValue()))
+}
+
+// GETEX key [EX seconds | PX milliseconds | EXAT unix-time-seconds |
+// PXAT unix-time-milliseconds | PERSIST]
+func getexCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	var persist bool
+	var expireAt int64
+	var err error
+
+	args := cmd.Args()[1:]
+	for i := 0; i < len(args); i++ {
+		option := strings.ToLower(args[i])
+		switch {
+		case option == "persist" && expireAt == 0:
+			persist = true
+		case isExpireOption(option) && !persist && expireAt == 0 && i+1 < len(args):
+			if expireAt, err = parseExpireOption(cmd.Name(), option, args[i+1]); err != nil {
+				return ErrorResponse(err)
+			}
+			i++
+		default:
+			return ErrorResponse(ErrSyntax)
+		}
+	}
+
+	key := cmd.Arg(0)
+	item, found, err := lookupString(re, key)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return NilResponse()
+	}
+	var event string
+	switch {
+	case expireAt > 0 && expireAt <= nowMs():
+		_ = re.Remove(key)
+		re.rewriteCommand("del", key)
+		event = "del"
+	case expireAt > 0:
+		re.SetExpire(key, expireAt)
+		re.rewriteCommand("pexpireat", key, strconv.FormatInt(expireAt, 10))
+		event = "expire"
+	case persist:
+		re.SetExpire(key, 0)
+		re.rewriteCommand("persist", key)
+		event = "persist"
+	default:
+		return BulkResponse(string(item.StringValue()))
+	}
+	re.signalModifiedKey(key)
+	re.notifyKeyspaceEvent(notifyGeneric, event, key)
+	return BulkResponse(string(item.StringValue()))
+}
+
+// MGET key [key ...], keys holding a non string value are reported as nil
+func mgetCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	items := make([]*RedisResponse, 0, len(cmd.Args()))
+	for _, key := range cmd.Args() {
+		item, found, err := lookupString(re, key)
+		if !found || err != nil {
+			items = append(items, NilResponse())
+			continue
+		}
+		items = append(items, BulkResponse(string(item.StringValue())))
+	}
+	return ArrayResponse(items...)
+}
+
+// MSET key value [key value ...], also MSETNX which sets nothing if any key exists
+func msetCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	if len(args)%2 != 0 {
+		return ErrorResponse(WrongArgsError(cmd.Name()))
+	}
+	if cmd.Name() == "msetnx" {
+		for i := 0; i < len(args); i += 2 {
+			if found, _ := re.Contains(args[i]); found {
+				return IntegerResponse(0)
+			}
+		}
+	}
+	for i := 0; i < len(args); i += 2 {
+		setString(re, args[i], []byte(args[i+1]), 0)
+		re.notifyKeyspaceEvent(notifyString, "set", args[i])
+	}
+	if cmd.Name() == "msetnx" {
+		return IntegerResponse(1)
+	}
+	return OKResponse()
+}
+
+// INCR key, DECR key, INCRBY key increment and DECRBY key decrement
+func incrCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	by := int64(1)
+	if len(cmd.Args()) > 1 {
+		var ok bool
+		if by, ok = parseInt(cmd.Arg(1)); !ok {
+			return ErrorResponse(ErrNotInteger)
+		}
+	}
+	if strings.HasPrefix(cmd.Name(), "decr") {
+		if by == math.MinInt64 {
+			return ErrorResponse(ErrDecrOverflow)
+		}
+		by = -by
+	}
+
+	key := cmd.Arg(0)
+	item, found, err := lookupString(re, key)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	var value int64
+	if found {
+		var ok bool
+		if value, ok = parseInt(string(item.StringValue())); !ok {
+			return ErrorResponse(ErrNotInteger)
+		}
+	}
+	if (by < 0 && value < 0 && by < math.MinInt64-value) ||
+		(by > 0 && value > 0 && by > math.MaxInt64-value) {
+		return ErrorResponse(ErrOverflow)
+	}
+	value += by
+
+	// the time to live of an existing key is retained
+	if found {
+		item.Value = strconv.AppendInt(item.StringValue()[:0], value, 10)
+		re.signalModifiedKey(key)
+	} else {
+		setString(re, key, strconv.AppendInt(nil, value, 10), 0)
+	}
+	re.notifyKeyspaceEvent(notifyString, "incrby", key)
+	return IntegerResponse(value)
+}
+
+// INCRBYFLOAT key increment
+func incrbyfloatCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	by, ok := parseFloat(cmd.Arg(1))
+	if !ok {
+		return ErrorResponse(ErrNotFloat)
+	}
+
+	key := cmd.Arg(0)
+	item, found, err := lookupString(re, key)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	var value float64
+	if found {
+		if value, ok = parseFloat(string(item.StringValue())); !ok {
+			return ErrorResponse(ErrNotFloat)
+		}
+	}
+	value += by
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return ErrorResponse(ErrIncrNaN)
+	}
+
+	result := formatFloat(value)
+	if found {
+		item.Value = []byte(result)
+		re.signalModifiedKey(key)
+	} else {
+		setString(re, key, []byte(result), 0)
+	}
+	re.notifyKeyspaceEvent(notifyString, "incrbyfloat", key)
+	// propagate the result, the float arithmetic might differ when replayed
+	re.rewriteCommand("set", key, result, "keepttl")
+	return BulkResponse(result)
+}
+
+// APPEND key value
+func appendCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	key, value := cmd.Arg(0), cmd.Arg(1)
+	item, found, err := lookupString(re, key)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		setString(re, key, []byte(value), 0)
+		re.notifyKeyspaceEvent(notifyString, "append", key)
+		return IntegerResponse(int64(len(value)))
+	}
+	if len(item.StringValue())+len(value) > maxStringLength {
+		return ErrorResponse(ErrStringTooLong)
+	}
+	item.Value = append(item.StringValue(), value...)
+	re.signalModifiedKey(key)
+	re.notifyKeyspaceEvent(notifyString, "append", key)
+	return IntegerResponse(int64(len(item.StringValue())))
+}
+
+// STRLEN key
+func strlenCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	item, found, err := lookupString(re, cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return IntegerResponse(0)
+	}
+	return IntegerResponse(int64(len(item.StringValue())))
+}
+
+// GETRANGE key start end, negative offsets are relative to the end of the string
+func getrangeCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	start, ok1 := parseInt(cmd.Arg(1))
+	end, ok2 := parseInt(cmd.Arg(2))
+	if !ok1 || !ok2 {
+		return ErrorResponse(ErrNotInteger)
+	}
+	item, found, err := lookupString(re, cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return BulkResponse("")
+	}
+
+	value := item.StringValue()
+	length := int64(len(value))
+	if start < 0 && end < 0 && start > end {
+		return BulkResponse("")
+	}
+	if start < 0 {
+		start += length
+	}
+	if end < 0 {
+		end += length
+	}
+	start, end = max(start, 0), max(end, 0)
+	if end >= length {
+		end = length - 1
+	}
+	if length == 0 || start > end {
+		return BulkResponse("")
+	}
+	return BulkResponse(string(value[start : end+1]))
+}
+
+// SETRANGE key offset value, the string is zero-padded up to offset if needed
+func setrangeCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	offset, ok := parseInt(cmd.Arg(1))
+	if !ok {
+		return ErrorResponse(ErrNotInteger)
+	}
+	if offset < 0 {
+		return ErrorResponse(ErrOffsetRange)
+	}
+
+	key, value := cmd.Arg(0), cmd.Arg(2)
+	item, found, err := lookupString(re, key)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	var buf []byte
+	if found {
+		buf = item.StringValue()
+	}
+	if len(value) == 0 {
+		return IntegerResponse(int64(len(buf)))
+	}
+	if offset > maxStringLength-int64(len(value)) {
+		return ErrorResponse(ErrStringTooLong)
+	}
+
+	buf = growString(buf, offset+int64(len(value)))
+	copy(buf[offset:], value)
+	if found {
+		item.Value = buf
+		re.signalModifiedKey(key)
+	} else {
+		setString(re, key, buf, 0)
+	}
+	re.notifyKeyspaceEvent(notifyString, "setrange", key)
+	return IntegerResponse(int64(len(buf)))
+}
@@ -0,0 +1,76 @@
+package server
+
+import "testing"
+
+func TestIncrDecr(t *testing.T) {
+	runSteps(t, newTestExecutor(), []testStep{
+		{cmd("INCR", "n"), ":1\r\n"},
+		{cmd("INCRBY", "n", "9"), ":10\r\n"},
+		{cmd("DECRBY", "n", "20"), ":-10\r\n"},
+		{cmd("DECR", "n"), ":-11\r\n"},
+		{cmd("INCRBY", "n", "1.5"), "-ERR value is not an integer or out of range\r\n"},
+		{cmd("SET", "max", "9223372036854775807"), "+OK\r\n"},
+		{cmd("INCR", "max"), "-ERR increment or decrement would overflow\r\n"},
+		{cmd("DECRBY", "n", "-9223372036854775808"), "-ERR decrement would overflow\r\n"},
+		{cmd("SET", "s", "abc"), "+OK\r\n"},
+		{cmd("INCR", "s"), "-ERR value is not an integer or out of range\r\n"},
+		{cmd("SET", "s", " 1"), "+OK\r\n"},
+		{cmd("INCR", "s"), "-ERR value is not an integer or out of range\r\n"},
+		{cmd("INCRBYFLOAT", "f", "10.5"), "$4\r\n10.5\r\n"},
+		{cmd("INCRBYFLOAT", "f", "0.1"), "$4\r\n10.6\r\n"},
+		{cmd("INCRBYFLOAT", "f", "inf"), "-ERR increment would produce NaN or Infinity\r\n"},
+		{cmd("INCRBYFLOAT", "s", "1"), "-ERR value is not a valid float\r\n"},
+	})
+}
+
+func TestStringRanges(t *testing.T) {
+	runSteps(t, newTestExecutor(), []testStep{
+		{cmd("APPEND", "s", "abc"), ":3\r\n"},
+		{cmd("APPEND", "s", "def"), ":6\r\n"},
+		{cmd("STRLEN", "s"), ":6\r\n"},
+		{cmd("STRLEN", "missing"), ":0\r\n"},
+		{cmd("GETRANGE", "s", "0", "-1"), "$6\r\nabcdef\r\n"},
+		{cmd("GETRANGE", "s", "-3", "-2"), "$2\r\nde\r\n"},
+		{cmd("GETRANGE", "s", "4", "2"), "$0\r\n\r\n"},
+		{cmd("SETRANGE", "s", "1", "XY"), ":6\r\n"},
+		{cmd("GET", "s"), "$6\r\naXYdef\r\n"},
+		{cmd("SETRANGE", "p", "3", "x"), ":4\r\n"},
+		{cmd("GET", "p"), "$4\r\n\x00\x00\x00x\r\n"},
+		{cmd("SETRANGE", "p", "-1", "x"), "-ERR offset is out of range\r\n"},
+		{cmd("SETRANGE", "p", "536870912", "x"), "-ERR string exceeds maximum allowed size (proto-max-bulk-len)\r\n"},
+		{cmd("SETRANGE", "p", "9223372036854775807", "x"), "-ERR string exceeds maximum allowed size (proto-max-bulk-len)\r\n"},
+	})
+}
+
+func TestMultiKeyStrings(t *testing.T) {
+	runSteps(t, newTestExecutor(), []testStep{
+		{cmd("MSET", "a", "1", "b", "2"), "+OK\r\n"},
+		{cmd("MSET", "a", "1", "b"), "-ERR wrong number of arguments for 'mset' command\r\n"},
+		{cmd("MGET", "a", "missing", "b"), "*3\r\n$1\r\n1\r\n$-1\r\n$1\r\n2\r\n"},
+		{cmd("MSETNX", "a", "1", "c", "3"), ":0\r\n"},
+		{cmd("MSETNX", "c", "3", "d", "4"), ":1\r\n"},
+		{cmd("GETDEL", "d"), "$1\r\n4\r\n"},
+		{cmd("EXISTS", "d", "c", "c"), ":2\r\n"},
+		{cmd("SETNX", "c", "x"), ":0\r\n"},
+		{cmd("DEL", "c", "a", "missing"), ":2\r\n"},
+		{cmd("TYPE", "b"), "+string\r\n"},
+		{cmd("TYPE", "missing"), "+none\r\n"},
+	})
+}
+
+func TestStringExpiry(t *testing.T) {
+	runSteps(t, newTestExecutor(), []testStep{
+		{cmd("SET", "k", "v", "EX", "100"), "+OK\r\n"},
+		{cmd("TTL", "k"), ":100\r\n"},
+		{cmd("SET", "k", "w", "KEEPTTL", "GET"), "$1\r\nv\r\n"},
+		{cmd("TTL", "k"), ":100\r\n"},
+		{cmd("GETEX", "k", "PERSIST"), "$1\r\nw\r\n"},
+		{cmd("TTL", "k"), ":-1\r\n"},
+		{cmd("SET", "k", "v", "EX", "0"), "-ERR invalid expire time in 'set' command\r\n"},
+		{cmd("SET", "k", "v", "NX", "XX"), "-ERR syntax error\r\n"},
+		{cmd("SET", "k", "v", "NX"), "$-1\r\n"},
+		{cmd("PEXPIRE", "k", "-1"), ":1\r\n"},
+		{cmd("GET", "k"), "$-1\r\n"},
+		{cmd("TTL", "k"), ":-2\r\n"},
+	})
+}
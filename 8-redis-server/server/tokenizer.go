@@ -2,10 +2,12 @@ package server
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"go.uber.org/zap"
 	"io"
 	"strconv"
+	"strings"
 )
 
 type RedisTokenizer interface {
@@ -14,6 +16,24 @@ type RedisTokenizer interface {
 	GetTokens(reader *bufio.Reader) (tokens []string, err error)
 }
 
+// The limits of a request, like in Redis
+const (
+	// inlineMaxSize is the maximum length of an inline command or of the header of an
+	// array or of a bulk string
+	inlineMaxSize = 64 << 10
+	// multibulkMaxLen is the maximum number of arguments of a command
+	multibulkMaxLen = 1024 * 1024
+	// bulkMaxLen is the maximum length of an argument, like proto-max-bulk-len
+	bulkMaxLen = 512 << 20
+)
+
+// ProtocolError is a malformed request, the client is replied the error and disconnected
+type ProtocolError string
+
+func (e ProtocolError) Error() string {
+	return "ERR Protocol error: " + string(e)
+}
+
 // Tokenizer is used to create tokens from the reader
 type Tokenizer struct {
 	KTerminal string // terminate the sequence
@@ -26,10 +46,9 @@ func DefaultTokenizer() *Tokenizer {
 }
 
 func NewTokenizer(terminal string) *Tokenizer {
-	logger, _ := zap.NewProduction()
 	return &Tokenizer{
 		KTerminal: terminal,
-		Logger:    logger,
+		Logger:    newLogger(),
 	}
 }
 
@@ -63,7 +82,6 @@ func (tok *Tokenizer) NextToken(reader *bufio.Reader) (data []byte, err error) {
 	// @ignored stores @kTerminal
 	// 		data = "some_random<value", ignored = "<>"
 
-	fmt.Println("-tok-", start, end)
 	// read the tokens until @kTerminal is found.
 	// It doesn't skip the @kTerminal. In case of strings with
 	// @kTerminal as substring, NextToken() will return the substring,
@@ -73,7 +91,7 @@ func (tok *Tokenizer) NextToken(reader *bufio.Reader) (data []byte, err error) {
 		// end of reader
 		if err != nil {
 			if err == io.EOF {
-				tok.Info("EOF")
+				tok.Debug("EOF")
 				return data, err
 			}
 			tok.Error("error while reading byte", zap.Error(err))
@@ -103,63 +121,141 @@ func (tok *Tokenizer) NextToken(reader *bufio.Reader) (data []byte, err error) {
 			}
 		}
 	}
-	fmt.Println("--<>--", string(data))
 	return data, err
 }
 
+// GetTokens reads the next request of a client: a RESP array of bulk strings, or an
+// inline command as typed in telnet or netcat, e.g. SET foo "hello world". The tokens
+// of an inline command are those of the equivalent RESP array.
 func (tok *Tokenizer) GetTokens(reader *bufio.Reader) (tokens []string, err error) {
-	// Read the <metadata, data>
-	var data, metadata []byte
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] != '*' {
+		return tok.getInlineTokens(reader)
+	}
+	return tok.getTokens(reader)
+}
 
-	// read the <metadata>
-	if metadata, err = tok.NextToken(reader); err != nil {
-		tok.Error("error while tokenizing metadata", zap.Error(err))
-		return tokens, err
+// getInlineTokens reads an inline command, a line of arguments separated by spaces
+// which may be quoted like in redis-cli (see splitArgs). An empty line has no tokens.
+func (tok *Tokenizer) getInlineTokens(reader *bufio.Reader) (tokens []string, err error) {
+	line, err := readLine(reader, "too big inline request")
+	if err != nil {
+		return nil, err
+	}
+	args, err := splitArgs(string(line))
+	if err != nil {
+		return nil, ProtocolError("unbalanced quotes in request")
+	}
+	if len(args) == 0 {
+		return nil, nil
+	}
+	tokens = append(tokens, "*"+strconv.Itoa(len(args)))
+	for _, arg := range args {
+		tokens = append(tokens, "$"+strconv.Itoa(len(arg)), arg)
 	}
-	tokens = append(tokens, string(metadata))
+	return tokens, nil
+}
 
+// getTokens reads a RESP array of bulk strings. Each bulk string is read as the
+// number of bytes of its header, so that it may contain any byte, CRLF included.
+func (tok *Tokenizer) getTokens(reader *bufio.Reader) (tokens []string, err error) {
+	// the tokens of every command are only logged at the debug level
 	defer func() {
-		tok.Info(
-			"token",
-			zap.String("metadata", string(metadata)),
-			zap.String("data", string(data)),
-			zap.Strings("tokens", tokens),
-		)
+		if ce := tok.Check(zap.DebugLevel, "token"); ce != nil {
+			ce.Write(zap.Strings("tokens", tokens), zap.Error(err))
+		}
 	}()
-	// read the data i.e the content
-	if metadata[0] == '*' {
-		// An array is a collection of tokens (<metadata>\r\n<data>\r\n)*
-		var temp []string
-		var arraySize int
-
-		if arraySize, err = ToInt(metadata[1:]); err != nil {
-			tok.Error("error while converting metadata to int", zap.Error(err))
+
+	line, err := readLine(reader, "too big mbulk count string")
+	if err != nil {
+		return nil, err
+	}
+	count, err := ToInt(line[1:])
+	if err != nil || count > multibulkMaxLen {
+		return nil, ProtocolError("invalid multibulk length")
+	}
+	if count <= 0 {
+		return nil, nil
+	}
+	tokens = make([]string, 0, 1+2*min(count, 1024))
+	tokens = append(tokens, string(line))
+	for ; count > 0; count-- {
+		if line, err = readLine(reader, "too big bulk count string"); err != nil {
 			return nil, err
 		}
-		// read the next @arraySize tokens (<metadata, data>) pairs
-		for arraySize > 0 {
-			if temp, err = tok.GetTokens(reader); err != nil {
-				tok.Error("error while tokenizing data", zap.Error(err))
-				return nil, err
-			}
-			tokens = append(tokens, temp...)
-			arraySize--
+		if len(line) == 0 {
+			return nil, ProtocolError("expected '$', got '\\r'")
 		}
-		return tokens, err
+		if line[0] != '$' {
+			return nil, ProtocolError(fmt.Sprintf("expected '$', got '%c'", line[0]))
+		}
+		size, err := ToInt(line[1:])
+		if err != nil || size < 0 || size > bulkMaxLen {
+			return nil, ProtocolError("invalid bulk length")
+		}
+		data, err := readBulk(reader, size)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, string(line), data)
 	}
+	return tokens, nil
+}
 
-	// read the <data> (content)
-	data, err = tok.NextToken(reader)
-	if err != nil {
-		if err == io.EOF {
-			tok.Info("EOF received")
-			return tokens, nil
+// readLine reads a line of the request without its CRLF, failing with the protocol
+// error @tooBig once it is longer than inlineMaxSize. The line is read by chunks of
+// the buffer of the reader, a client can't make it grow without bound.
+func readLine(reader *bufio.Reader, tooBig string) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			if len(line) >= inlineMaxSize {
+				return nil, ProtocolError(tooBig)
+			}
+			continue
+		}
+		if len(line) > inlineMaxSize {
+			return nil, ProtocolError(tooBig)
 		}
-		tok.Error("error while tokenizing data", zap.Error(err))
-		return tokens, err
+		if err == io.EOF && len(line) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		return bytes.TrimSuffix(line[:len(line)-1], []byte("\r")), nil
+	}
+}
+
+// readBulk reads the @size bytes of a bulk string followed by its CRLF. The string
+// grows as its bytes arrive rather than being allocated from its announced size.
+func readBulk(reader *bufio.Reader, size int) (string, error) {
+	var data strings.Builder
+	data.Grow(min(size, readBufferSize))
+	if _, err := io.CopyN(&data, reader, int64(size)); err != nil {
+		return "", noEOF(err)
+	}
+	var crlf [2]byte
+	if _, err := io.ReadFull(reader, crlf[:]); err != nil {
+		return "", noEOF(err)
+	}
+	if crlf != [2]byte{'\r', '\n'} {
+		return "", ProtocolError("expected CRLF after the bulk string")
+	}
+	return data.String(), nil
+}
+
+// noEOF reports a request cut in the middle as io.ErrUnexpectedEOF
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
 	}
-	tokens = append(tokens, string(data))
-	return tokens, err
+	return err
 }
 
 var ToInt = func(s []byte) (int, error) {
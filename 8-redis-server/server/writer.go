@@ -0,0 +1,186 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// pushOutputLimit is the size of the pending output beyond which a client is
+// disconnected when a message is pushed to it, like the hard limit of the
+// pubsub class of client-output-buffer-limit
+const pushOutputLimit = 32 << 20
+
+// pipelineMaxReplies is the number of replies of a client queued before they are
+// written, while the client pipelines commands
+const pipelineMaxReplies = 1024
+
+// pipelineMaxOutput is the size of the pending replies beyond which the connection
+// handler stops reading the commands of a client until they are written, so that
+// a client pipelining commands without reading its replies doesn't grow its output
+const pipelineMaxOutput = 1 << 20
+
+var ErrOutputLimit = errors.New("client output buffer limit reached")
+
+// replyWriter serializes the writes to a connection. The replies of the client and
+// the messages pushed to it by other clients (Pub/Sub) are appended to a pending
+// buffer, which a single goroutine writes to the connection in order. Pushing never
+// blocks on the network, so a slow subscriber doesn't hold up the publishers.
+//
+// The replies are only written once flushed, so that the replies to pipelined
+// commands are written at once rather than one write per reply.
+type replyWriter struct {
+	conn io.WriteCloser
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	drained *sync.Cond // broadcast once the pending output is being written or discarded
+	pending []byte     // output not written yet
+	spare   []byte     // buffer being written, reused for the next batch
+	queued  int        // replies pending since the last flush
+	flush   bool       // the pending output is to be written
+	closed  bool
+	err     error // first write error, the output is discarded afterwards
+	done    chan struct{}
+}
+
+func newReplyWriter(conn io.WriteCloser) *replyWriter {
+	rw := &replyWriter{conn: conn, done: make(chan struct{})}
+	rw.cond = sync.NewCond(&rw.mu)
+	rw.drained = sync.NewCond(&rw.mu)
+	go rw.loop()
+	return rw
+}
+
+// Write queues the reply to a command of the client, written once flushed or once
+// pipelineMaxReplies replies are queued
+func (rw *replyWriter) Write(response *RedisResponse) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.closed {
+		return
+	}
+	rw.pending = response.AppendTo(rw.pending)
+	if rw.queued++; rw.queued >= pipelineMaxReplies {
+		rw.signalFlush()
+	}
+}
+
+// Flush writes the queued replies
+func (rw *replyWriter) Flush() {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if len(rw.pending) > 0 {
+		rw.signalFlush()
+	}
+}
+
+// WaitDrained flushes the queued replies and waits until at most @limit bytes of
+// output are pending, or the writer is closed
+func (rw *replyWriter) WaitDrained(limit int) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	for len(rw.pending) > limit && !rw.closed {
+		rw.signalFlush()
+		rw.drained.Wait()
+	}
+}
+
+func (rw *replyWriter) signalFlush() {
+	rw.queued, rw.flush = 0, true
+	rw.cond.Signal()
+}
+
+// Push queues a message sent to the client by another client. The connection is
+// closed if the client doesn't keep up with its messages.
+func (rw *replyWriter) Push(response *RedisResponse) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.reachedLimit(pushOutputLimit) {
+		return
+	}
+	rw.pending = response.AppendTo(rw.pending)
+	rw.signalFlush()
+}
+
+// WriteRaw queues output already encoded, e.g. the replication stream. The connection
+// is closed if the pending output exceeds @limit.
+func (rw *replyWriter) WriteRaw(p []byte, limit int) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.reachedLimit(limit) {
+		return
+	}
+	rw.pending = append(rw.pending, p...)
+	rw.signalFlush()
+}
+
+// reachedLimit reports whether nothing can be queued: the writer is closed, or
+// it is closed now as the pending output exceeds @limit
+func (rw *replyWriter) reachedLimit(limit int) bool {
+	if rw.closed {
+		return true
+	}
+	if len(rw.pending) > limit {
+		rw.err, rw.closed, rw.pending = ErrOutputLimit, true, nil
+		rw.cond.Signal()
+		rw.drained.Broadcast()
+		_ = rw.conn.Close()
+		return true
+	}
+	return false
+}
+
+// Disconnect closes the connection, the pending output is discarded
+func (rw *replyWriter) Disconnect() {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	rw.closed, rw.pending = true, nil
+	rw.cond.Signal()
+	rw.drained.Broadcast()
+	_ = rw.conn.Close()
+}
+
+// Close writes the pending output and stops the writer. It doesn't close the connection.
+func (rw *replyWriter) Close() error {
+	rw.mu.Lock()
+	rw.closed = true
+	rw.cond.Signal()
+	rw.drained.Broadcast()
+	rw.mu.Unlock()
+	<-rw.done
+	return rw.err
+}
+
+func (rw *replyWriter) loop() {
+	defer close(rw.done)
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	for {
+		for !rw.flush && !rw.closed {
+			rw.cond.Wait()
+		}
+		if len(rw.pending) == 0 {
+			if rw.closed {
+				return
+			}
+			rw.flush = false
+			continue
+		}
+		buf := rw.pending
+		rw.pending, rw.flush = rw.spare[:0], false
+		rw.drained.Broadcast()
+		rw.mu.Unlock()
+		_, err := rw.conn.Write(buf)
+		rw.mu.Lock()
+		rw.spare = buf
+		if err != nil {
+			if rw.err == nil {
+				rw.err = err
+			}
+			rw.closed, rw.pending = true, nil
+			rw.drained.Broadcast()
+			return
+		}
+	}
+}
@@ -0,0 +1,639 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+const (
+	zskiplistMaxLevel = 32
+	zskiplistP        = 0.25 // probability of a node to have one more level
+)
+
+var (
+	ErrScoreNaN       = errors.New("ERR resulting score is not a number (NaN)")
+	ErrMinMaxNotFloat = errors.New("ERR min or max is not a float")
+	ErrLexRange       = errors.New("ERR min or max not valid string range item")
+)
+
+/* ---------------- skiplist ---------------- */
+
+// zskiplist is the skiplist of a sorted set, ordered by score then member, as in Redis.
+// Each level of a node stores the number of nodes it skips (span), which gives the
+// rank of a node in O(log n) and the node at a rank in O(log n).
+type zskiplist struct {
+	header *zskiplistNode
+	tail   *zskiplistNode
+	length int
+	level  int
+}
+
+type zskiplistNode struct {
+	member   string
+	score    float64
+	backward *zskiplistNode
+	level    []zskiplistLevel
+}
+
+type zskiplistLevel struct {
+	forward *zskiplistNode
+	span    int
+}
+
+func newZskiplist() *zskiplist {
+	return &zskiplist{
+		header: &zskiplistNode{level: make([]zskiplistLevel, zskiplistMaxLevel)},
+		level:  1,
+	}
+}
+
+func zslRandomLevel() int {
+	level := 1
+	for level < zskiplistMaxLevel && rand.Float64() < zskiplistP {
+		level++
+	}
+	return level
+}
+
+// before reports whether the node sorts before (score, member)
+func (n *zskiplistNode) before(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+// next returns the following node, or the previous one when iterating in reverse
+func (n *zskiplistNode) next(reverse bool) *zskiplistNode {
+	if reverse {
+		return n.backward
+	}
+	return n.level[0].forward
+}
+
+// insert adds a new node, the member must not be in the skiplist already
+func (zsl *zskiplist) insert(score float64, member string) *zskiplistNode {
+	var update [zskiplistMaxLevel]*zskiplistNode
+	var rank [zskiplistMaxLevel]int
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		if i < zsl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := zslRandomLevel()
+	if level > zsl.level {
+		for i := zsl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = zsl.header
+			update[i].level[i].span = zsl.length
+		}
+		zsl.level = level
+	}
+
+	x = &zskiplistNode{member: member, score: score, level: make([]zskiplistLevel, level)}
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		// update the spans covered by the new node
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	// the untouched levels now span one more node
+	for i := level; i < zsl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != zsl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		zsl.tail = x
+	}
+	zsl.length++
+	return x
+}
+
+// delete removes the node matching the score and member
+func (zsl *zskiplist) delete(score float64, member string) bool {
+	var update [zskiplistMaxLevel]*zskiplistNode
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	x = x.level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+
+	for i := 0; i < zsl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+	for zsl.level > 1 && zsl.header.level[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+	zsl.length--
+	return true
+}
+
+// rank returns the 1-based rank of the node, 0 if it doesn't exist
+func (zsl *zskiplist) rank(score float64, member string) int {
+	rank := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && (x.level[i].forward.before(score, member) ||
+			(x.level[i].forward.score == score && x.level[i].forward.member == member)) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x != zsl.header && x.member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// byRank returns the node at the 1-based rank
+func (zsl *zskiplist) byRank(rank int) *zskiplistNode {
+	traversed := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
+
+// zrangeSpec is a score range, each bound being inclusive unless marked exclusive
+type zrangeSpec struct {
+	min, max     float64
+	minex, maxex bool
+}
+
+func (r *zrangeSpec) gteMin(score float64) bool {
+	if r.minex {
+		return score > r.min
+	}
+	return score >= r.min
+}
+
+func (r *zrangeSpec) lteMax(score float64) bool {
+	if r.maxex {
+		return score < r.max
+	}
+	return score <= r.max
+}
+
+// isInRange reports whether a part of the skiplist is within the range
+func (zsl *zskiplist) isInRange(r *zrangeSpec) bool {
+	if r.min > r.max || (r.min == r.max && (r.minex || r.maxex)) {
+		return false
+	}
+	if zsl.tail == nil || !r.gteMin(zsl.tail.score) {
+		return false
+	}
+	first := zsl.header.level[0].forward
+	return first != nil && r.lteMax(first.score)
+}
+
+// firstInRange returns the first node within the range
+func (zsl *zskiplist) firstInRange(r *zrangeSpec) *zskiplistNode {
+	if !zsl.isInRange(r) {
+		return nil
+	}
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !r.gteMin(x.level[i].forward.score) {
+			x = x.level[i].forward
+		}
+	}
+	x = x.level[0].forward
+	if !r.lteMax(x.score) {
+		return nil
+	}
+	return x
+}
+
+// lastInRange returns the last node within the range
+func (zsl *zskiplist) lastInRange(r *zrangeSpec) *zskiplistNode {
+	if !zsl.isInRange(r) {
+		return nil
+	}
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && r.lteMax(x.level[i].forward.score) {
+			x = x.level[i].forward
+		}
+	}
+	if !r.gteMin(x.score) {
+		return nil
+	}
+	return x
+}
+
+// lexBound is a bound of a lexicographical range: a member, or -inf ("-") / +inf ("+")
+type lexBound struct {
+	value     string
+	inf       int // -1 for "-", 1 for "+", 0 for a member
+	exclusive bool
+}
+
+// compareLex compares a member to a bound
+func compareLex(member string, bound *lexBound) int {
+	if bound.inf != 0 {
+		return -bound.inf
+	}
+	return strings.Compare(member, bound.value)
+}
+
+// zlexRangeSpec is a lexicographical range, meaningful when all the members have the same score
+type zlexRangeSpec struct {
+	min, max lexBound
+}
+
+func (r *zlexRangeSpec) gteMin(member string) bool {
+	if c := compareLex(member, &r.min); r.min.exclusive {
+		return c > 0
+	} else {
+		return c >= 0
+	}
+}
+
+func (r *zlexRangeSpec) lteMax(member string) bool {
+	if c := compareLex(member, &r.max); r.max.exclusive {
+		return c < 0
+	} else {
+		return c <= 0
+	}
+}
+
+func (r *zlexRangeSpec) isEmpty() bool {
+	var c int
+	switch {
+	case r.min.inf == r.max.inf && r.min.inf != 0:
+		c = 0
+	case r.min.inf == -1 || r.max.inf == 1:
+		c = -1
+	case r.min.inf == 1 || r.max.inf == -1:
+		c = 1
+	default:
+		c = strings.Compare(r.min.value, r.max.value)
+	}
+	return c > 0 || (c == 0 && (r.min.exclusive || r.max.exclusive))
+}
+
+func (zsl *zskiplist) isInLexRange(r *zlexRangeSpec) bool {
+	if r.isEmpty() || zsl.tail == nil || !r.gteMin(zsl.tail.member) {
+		return false
+	}
+	first := zsl.header.level[0].forward
+	return first != nil && r.lteMax(first.member)
+}
+
+func (zsl *zskiplist) firstInLexRange(r *zlexRangeSpec) *zskiplistNode {
+	if !zsl.isInLexRange(r) {
+		return nil
+	}
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !r.gteMin(x.level[i].forward.member) {
+			x = x.level[i].forward
+		}
+	}
+	x = x.level[0].forward
+	if !r.lteMax(x.member) {
+		return nil
+	}
+	return x
+}
+
+func (zsl *zskiplist) lastInLexRange(r *zlexRangeSpec) *zskiplistNode {
+	if !zsl.isInLexRange(r) {
+		return nil
+	}
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && r.lteMax(x.level[i].forward.member) {
+			x = x.level[i].forward
+		}
+	}
+	if !r.gteMin(x.member) {
+		return nil
+	}
+	return x
+}
+
+/* ---------------- sorted set ---------------- */
+
+// ZSet is the value of sorted set keys. The dict maps the members to their score
+// and the skiplist keeps them ordered.
+type ZSet struct {
+	dict *Dict[float64]
+	zsl  *zskiplist
+}
+
+func NewZSet() *ZSet {
+	return &ZSet{dict: NewDict[float64](), zsl: newZskiplist()}
+}
+
+func (zs *ZSet) Len() int {
+	return zs.zsl.length
+}
+
+// Clone returns a copy of the sorted set
+func (zs *ZSet) Clone() *ZSet {
+	clone := NewZSet()
+	for node := zs.zsl.header.level[0].forward; node != nil; node = node.level[0].forward {
+		clone.dict.Set(node.member, node.score)
+		clone.zsl.insert(node.score, node.member)
+	}
+	return clone
+}
+
+func (zs *ZSet) Score(member string) (float64, bool) {
+	return zs.dict.Get(member)
+}
+
+// zaddFlags are the options of ZADD
+type zaddFlags struct {
+	nx, xx, gt, lt, incr bool
+}
+
+// zaddResult tells what Add did with a member
+type zaddResult int
+
+const (
+	zaddNop       zaddResult = iota // skipped because of the flags
+	zaddAdded                       // new member
+	zaddUpdated                     // score changed
+	zaddUnchanged                   // same score
+)
+
+// Add adds the member or updates its score according to the flags, it returns
+// the final score of the member
+func (zs *ZSet) Add(score float64, member string, flags zaddFlags) (zaddResult, float64, error) {
+	current, found := zs.dict.Get(member)
+	if !found {
+		if flags.xx {
+			return zaddNop, 0, nil
+		}
+		zs.dict.Set(member, score)
+		zs.zsl.insert(score, member)
+		return zaddAdded, score, nil
+	}
+
+	if flags.nx {
+		return zaddNop, current, nil
+	}
+	if flags.incr {
+		score += current
+		if math.IsNaN(score) {
+			return zaddNop, current, ErrScoreNaN
+		}
+	}
+	if (flags.lt && score >= current) || (flags.gt && score <= current) {
+		return zaddNop, current, nil
+	}
+	if score == current {
+		return zaddUnchanged, score, nil
+	}
+	zs.zsl.delete(current, member)
+	zs.zsl.insert(score, member)
+	zs.dict.Set(member, score)
+	return zaddUpdated, score, nil
+}
+
+func (zs *ZSet) Remove(member string) bool {
+	score, found := zs.dict.Delete(member)
+	if found {
+		zs.zsl.delete(score, member)
+	}
+	return found
+}
+
+// Rank returns the 0-based rank of the member, from the highest score if reverse is set
+func (zs *ZSet) Rank(member string, reverse bool) (int, bool) {
+	score, found := zs.dict.Get(member)
+	if !found {
+		return 0, false
+	}
+	rank := zs.zsl.rank(score, member)
+	if reverse {
+		return zs.zsl.length - rank, true
+	}
+	return rank - 1, true
+}
+
+// RangeByRank returns the nodes from the 0-based rank start to end (inclusive, within
+// bounds), counted from the highest score if reverse is set
+func (zs *ZSet) RangeByRank(start, end int, reverse bool) []*zskiplistNode {
+	var node *zskiplistNode
+	if reverse {
+		node = zs.zsl.byRank(zs.zsl.length - start)
+	} else {
+		node = zs.zsl.byRank(start + 1)
+	}
+	nodes := make([]*zskiplistNode, 0, end-start+1)
+	for i := start; i <= end && node != nil; i++ {
+		nodes = append(nodes, node)
+		node = node.next(reverse)
+	}
+	return nodes
+}
+
+// RangeByScore returns the nodes within the range, skipping the first @offset ones and
+// returning at most @limit of them (all if negative)
+func (zs *ZSet) RangeByScore(r *zrangeSpec, reverse bool, offset, limit int) []*zskiplistNode {
+	var node *zskiplistNode
+	if reverse {
+		node = zs.zsl.lastInRange(r)
+	} else {
+		node = zs.zsl.firstInRange(r)
+	}
+	for ; node != nil && offset > 0; offset-- {
+		node = node.next(reverse)
+	}
+	var nodes []*zskiplistNode
+	for ; node != nil && limit != 0; limit-- {
+		if (reverse && !r.gteMin(node.score)) || (!reverse && !r.lteMax(node.score)) {
+			break
+		}
+		nodes = append(nodes, node)
+		node = node.next(reverse)
+	}
+	return nodes
+}
+
+// RangeByLex is the lexicographical equivalent of RangeByScore
+func (zs *ZSet) RangeByLex(r *zlexRangeSpec, reverse bool, offset, limit int) []*zskiplistNode {
+	var node *zskiplistNode
+	if reverse {
+		node = zs.zsl.lastInLexRange(r)
+	} else {
+		node = zs.zsl.firstInLexRange(r)
+	}
+	for ; node != nil && offset > 0; offset-- {
+		node = node.next(reverse)
+	}
+	var nodes []*zskiplistNode
+	for ; node != nil && limit != 0; limit-- {
+		if (reverse && !r.gteMin(node.member)) || (!reverse && !r.lteMax(node.member)) {
+			break
+		}
+		nodes = append(nodes, node)
+		node = node.next(reverse)
+	}
+	return nodes
+}
+
+// Count returns the number of members within the score range in O(log n)
+func (zs *ZSet) Count(r *zrangeSpec) int {
+	first := zs.zsl.firstInRange(r)
+	if first == nil {
+		return 0
+	}
+	last := zs.zsl.lastInRange(r)
+	return zs.zsl.rank(last.score, last.member) - zs.zsl.rank(first.score, first.member) + 1
+}
+
+// PopMin removes and returns the member with the lowest score, the highest if max is set
+func (zs *ZSet) PopMin(max bool) (*zskiplistNode, bool) {
+	node := zs.zsl.header.level[0].forward
+	if max {
+		node = zs.zsl.tail
+	}
+	if node == nil {
+		return nil, false
+	}
+	zs.Remove(node.member)
+	return node, true
+}
+
+/* ---------------- parsing & formatting ---------------- */
+
+// parseScoreRange parses the min and max of a score range, e.g. "(1.5" or "-inf"
+func parseScoreRange(min, max string) (*zrangeSpec, error) {
+	r := &zrangeSpec{}
+	var ok1, ok2 bool
+	r.min, r.minex, ok1 = parseScoreBound(min)
+	r.max, r.maxex, ok2 = parseScoreBound(max)
+	if !ok1 || !ok2 {
+		return nil, ErrMinMaxNotFloat
+	}
+	return r, nil
+}
+
+func parseScoreBound(s string) (float64, bool, bool) {
+	exclusive := strings.HasPrefix(s, "(")
+	if exclusive {
+		s = s[1:]
+	}
+	value, ok := parseFloat(s)
+	return value, exclusive, ok
+}
+
+// parseLexRange parses the min and max of a lexicographical range, e.g. "[a", "(b", "-" or "+"
+func parseLexRange(min, max string) (*zlexRangeSpec, error) {
+	r := &zlexRangeSpec{}
+	var ok1, ok2 bool
+	r.min, ok1 = parseLexBound(min)
+	r.max, ok2 = parseLexBound(max)
+	if !ok1 || !ok2 {
+		return nil, ErrLexRange
+	}
+	return r, nil
+}
+
+func parseLexBound(s string) (lexBound, bool) {
+	if len(s) == 0 {
+		return lexBound{}, false
+	}
+	switch s[0] {
+	case '-':
+		return lexBound{inf: -1, exclusive: true}, len(s) == 1
+	case '+':
+		return lexBound{inf: 1, exclusive: true}, len(s) == 1
+	case '[':
+		return lexBound{value: s[1:]}, true
+	case '(':
+		return lexBound{value: s[1:], exclusive: true}, true
+	}
+	return lexBound{}, false
+}
+
+// formatScore formats a score with the shortest representation that round-trips,
+// switching to the scientific notation for large and small exponents like Redis does
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+	// e.g. -1.2345e+06: the digits are 12345 and K = 6 - (5 - 1) = 2
+	formatted := strconv.FormatFloat(score, 'e', -1, 64)
+	neg := formatted[0] == '-'
+	formatted = strings.TrimPrefix(formatted, "-")
+	mantissa, exponent, _ := strings.Cut(formatted, "e")
+	digits := strings.Replace(mantissa, ".", "", 1)
+	exp10, _ := strconv.Atoi(exponent)
+	k := exp10 - (len(digits) - 1)
+	ndigits := len(digits)
+	exp := exp10
+	if exp < 0 {
+		exp = -exp
+	}
+
+	var out string
+	switch {
+	case k >= 0 && exp < ndigits+7:
+		out = digits + strings.Repeat("0", k)
+	case k < 0 && (k > -7 || exp < 4):
+		if offset := ndigits + k; offset <= 0 {
+			out = "0." + strings.Repeat("0", -offset) + digits
+		} else {
+			out = digits[:offset] + "." + digits[offset:]
+		}
+	default:
+		out = digits[:1]
+		if ndigits > 1 {
+			out += "." + digits[1:]
+		}
+		sign := "+"
+		if exp10 < 0 {
+			sign = "-"
+		}
+		out += "e" + sign + strconv.Itoa(exp)
+	}
+	if neg {
+		return "-" + out
+	}
+	return out
+}
@@ -0,0 +1,656 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrZaddXXNX       = errors.New("ERR XX and NX options at the same time are not compatible")
+	ErrZaddGTLTNX     = errors.New("ERR GT, LT, and/or NX options at the same time are not compatible")
+	ErrZaddIncrPairs  = errors.New("ERR INCR option supports a single increment-element pair")
+	ErrWeightNotFloat = errors.New("ERR weight value is not a float")
+	ErrLimitNoRange   = errors.New("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	ErrLexWithScores  = errors.New("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+)
+
+func init() {
+	registerCommands(
+		&commandSpec{name: "zadd", arity: -4, handler: zaddCommand, flags: flagWrite | flagDenyOOM, keys: firstKey, categories: catSortedSet},
+		&commandSpec{name: "zincrby", arity: 4, handler: zincrbyCommand, flags: flagWrite | flagDenyOOM, keys: firstKey, categories: catSortedSet},
+		&commandSpec{name: "zrem", arity: -3, handler: zremCommand, flags: flagWrite, keys: firstKey, categories: catSortedSet},
+		&commandSpec{name: "zscore", arity: 3, handler: zscoreCommand, keys: firstKey, categories: catSortedSet},
+		&commandSpec{name: "zcard", arity: 2, handler: zcardCommand, keys: firstKey, categories: catSortedSet},
+		&commandSpec{name: "zrank", arity: -3, handler: zrankCommand, keys: firstKey, categories: catSortedSet},
+		&commandSpec{name: "zrevrank", arity: -3, handler: zrankCommand, keys: firstKey, categories: catSortedSet},
+		&commandSpec{name: "zrange", arity: -4, handler: zrangeCommand, keys: firstKey, categories: catSortedSet},
+		&commandSpec{name: "zcount", arity: 4, handler: zcountCommand, keys: firstKey, categories: catSortedSet},
+		&commandSpec{name: "zpopmin", arity: -2, handler: zpopCommand, flags: flagWrite, keys: firstKey, categories: catSortedSet},
+		&commandSpec{name: "zpopmax", arity: -2, handler: zpopCommand, flags: flagWrite, keys: firstKey, categories: catSortedSet},
+		&commandSpec{name: "bzpopmin", arity: -3, handler: bzpopCommand, flags: flagWrite, keys: keySpec{0, -2, 1}, categories: catSortedSet | catBlocking},
+		&commandSpec{name: "bzpopmax", arity: -3, handler: bzpopCommand, flags: flagWrite, keys: keySpec{0, -2, 1}, categories: catSortedSet | catBlocking},
+		&commandSpec{name: "zunionstore", arity: -4, handler: zsetOpStoreCommand, flags: flagWrite | flagDenyOOM, getKeys: zsetOpStoreKeys, categories: catSortedSet},
+		&commandSpec{name: "zinterstore", arity: -4, handler: zsetOpStoreCommand, flags: flagWrite | flagDenyOOM, getKeys: zsetOpStoreKeys, categories: catSortedSet},
+		&commandSpec{name: "zscan", arity: -3, handler: zscanCommand, keys: firstKey, categories: catSortedSet},
+	)
+}
+
+/* ---------------- helpers ---------------- */
+
+// lookupZSet returns the sorted set stored at key
+func lookupZSet(re *RedisExecutorImpl, key string) (*ZSet, bool, error) {
+	item, found, err := lookupTyped(re, key, ZSetType)
+	if !found || err != nil {
+		return nil, found, err
+	}
+	return item.ZSetValue(), true, nil
+}
+
+// storeZSet stores the sorted set at key, replacing its value, and notifies the event.
+// An empty sorted set deletes the key. Clients blocked on the key are signalled.
+func storeZSet(re *RedisExecutorImpl, key string, zset *ZSet, event string) {
+	if zset.Len() == 0 {
+		if found, _ := re.Contains(key); found {
+			_ = re.Remove(key)
+			re.notifyKeyspaceEvent(notifyGeneric, "del", key)
+		}
+		re.signalModifiedKey(key)
+		return
+	}
+	_ = re.Set(key, &CacheItem{Key: key, Value: zset, Type: ZSetType})
+	re.signalModifiedKey(key)
+	re.signalKeyAsReady(key)
+	re.notifyKeyspaceEvent(notifyZSet, event, key)
+}
+
+// deleteZSetIfEmpty removes the key once its sorted set has no more members
+func deleteZSetIfEmpty(re *RedisExecutorImpl, key string, zset *ZSet) {
+	if zset.Len() == 0 {
+		_ = re.Remove(key)
+		re.notifyKeyspaceEvent(notifyGeneric, "del", key)
+	}
+}
+
+// nodesResponse builds the reply of the range commands: the members, followed by
+// their score if @withScores is set
+func nodesResponse(nodes []*zskiplistNode, withScores bool) *RedisResponse {
+	items := make([]*RedisResponse, 0, len(nodes))
+	for _, node := range nodes {
+		items = append(items, BulkResponse(node.member))
+		if withScores {
+			items = append(items, BulkResponse(formatScore(node.score)))
+		}
+	}
+	return ArrayResponse(items...)
+}
+
+/* ---------------- commands ---------------- */
+
+// ZADD key [NX | XX] [GT | LT] [CH] [INCR] score member [score member ...]
+func zaddCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	var flags zaddFlags
+	var ch bool
+	args := cmd.Args()[1:]
+	for len(args) > 0 {
+		switch strings.ToLower(args[0]) {
+		case "nx":
+			flags.nx = true
+		case "xx":
+			flags.xx = true
+		case "gt":
+			flags.gt = true
+		case "lt":
+			flags.lt = true
+		case "ch":
+			ch = true
+		case "incr":
+			flags.incr = true
+		default:
+			goto elements
+		}
+		args = args[1:]
+	}
+
+elements:
+	if len(args) == 0 || len(args)%2 != 0 {
+		return ErrorResponse(ErrSyntax)
+	}
+	if flags.nx && flags.xx {
+		return ErrorResponse(ErrZaddXXNX)
+	}
+	if (flags.gt && flags.nx) || (flags.lt && flags.nx) || (flags.gt && flags.lt) {
+		return ErrorResponse(ErrZaddGTLTNX)
+	}
+	if flags.incr && len(args) > 2 {
+		return ErrorResponse(ErrZaddIncrPairs)
+	}
+	scores := make([]float64, len(args)/2)
+	for i := range scores {
+		var ok bool
+		if scores[i], ok = parseFloat(args[2*i]); !ok {
+			return ErrorResponse(ErrNotFloat)
+		}
+	}
+
+	key := cmd.Arg(0)
+	zset, found, err := lookupZSet(re, key)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		if flags.xx {
+			if flags.incr {
+				return NilResponse()
+			}
+			return IntegerResponse(0)
+		}
+		zset = NewZSet()
+	}
+
+	event := "zadd"
+	if flags.incr {
+		event = "zincr"
+	}
+	var added, updated int64
+	var result zaddResult
+	var score float64
+	for i := range scores {
+		if result, score, err = zset.Add(scores[i], args[2*i+1], flags); err != nil {
+			return ErrorResponse(err)
+		}
+		switch result {
+		case zaddAdded:
+			added++
+		case zaddUpdated:
+			updated++
+		}
+	}
+	if !found {
+		storeZSet(re, key, zset, event)
+	} else if added+updated > 0 {
+		re.signalModifiedKey(key)
+		re.notifyKeyspaceEvent(notifyZSet, event, key)
+	}
+
+	if flags.incr {
+		if result == zaddNop {
+			return NilResponse()
+		}
+		return BulkResponse(formatScore(score))
+	}
+	if ch {
+		return IntegerResponse(added + updated)
+	}
+	return IntegerResponse(added)
+}
+
+// ZINCRBY key increment member
+func zincrbyCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	by, ok := parseFloat(cmd.Arg(1))
+	if !ok {
+		return ErrorResponse(ErrNotFloat)
+	}
+	key := cmd.Arg(0)
+	zset, found, err := lookupZSet(re, key)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		zset = NewZSet()
+	}
+	_, score, err := zset.Add(by, cmd.Arg(2), zaddFlags{incr: true})
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		storeZSet(re, key, zset, "zincr")
+	} else {
+		re.signalModifiedKey(key)
+		re.notifyKeyspaceEvent(notifyZSet, "zincr", key)
+	}
+	return BulkResponse(formatScore(score))
+}
+
+// ZREM key member [member ...]
+func zremCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	key := cmd.Arg(0)
+	zset, found, err := lookupZSet(re, key)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return IntegerResponse(0)
+	}
+	var removed int64
+	for _, member := range cmd.Args()[1:] {
+		if zset.Remove(member) {
+			removed++
+		}
+	}
+	if removed > 0 {
+		re.notifyKeyspaceEvent(notifyZSet, "zrem", key)
+		deleteZSetIfEmpty(re, key, zset)
+		re.signalModifiedKey(key)
+	}
+	return IntegerResponse(removed)
+}
+
+// ZSCORE key member
+func zscoreCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	zset, found, err := lookupZSet(re, cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return NilResponse()
+	}
+	if score, found := zset.Score(cmd.Arg(1)); found {
+		return BulkResponse(formatScore(score))
+	}
+	return NilResponse()
+}
+
+// ZCARD key
+func zcardCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	zset, found, err := lookupZSet(re, cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return IntegerResponse(0)
+	}
+	return IntegerResponse(int64(zset.Len()))
+}
+
+// ZRANK key member [WITHSCORE], also ZREVRANK
+func zrankCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	if len(args) > 3 || (len(args) == 3 && strings.ToLower(args[2]) != "withscore") {
+		return ErrorResponse(ErrSyntax)
+	}
+	withScore := len(args) == 3
+	missing := NilResponse()
+	if withScore {
+		missing = NullArrayResponse()
+	}
+
+	zset, found, err := lookupZSet(re, args[0])
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return missing
+	}
+	rank, found := zset.Rank(args[1], cmd.Name() == "zrevrank")
+	if !found {
+		return missing
+	}
+	if withScore {
+		score, _ := zset.Score(args[1])
+		return ArrayResponse(IntegerResponse(int64(rank)), BulkResponse(formatScore(score)))
+	}
+	return IntegerResponse(int64(rank))
+}
+
+// ZRANGE key start stop [BYSCORE | BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+// With REV and BYSCORE or BYLEX, start and stop are the max and the min of the range.
+func zrangeCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	var byScore, byLex, reverse, withScores, withLimit bool
+	offset, limit := int64(0), int64(-1)
+	args := cmd.Args()
+	for i := 3; i < len(args); i++ {
+		switch option := strings.ToLower(args[i]); {
+		case option == "byscore":
+			byScore = true
+		case option == "bylex":
+			byLex = true
+		case option == "rev":
+			reverse = true
+		case option == "withscores":
+			withScores = true
+		case option == "limit" && i+2 < len(args):
+			var ok1, ok2 bool
+			offset, ok1 = parseInt(args[i+1])
+			limit, ok2 = parseInt(args[i+2])
+			if !ok1 || !ok2 {
+				return ErrorResponse(ErrNotInteger)
+			}
+			withLimit = true
+			i += 2
+		default:
+			return ErrorResponse(ErrSyntax)
+		}
+	}
+	if byScore && byLex {
+		return ErrorResponse(ErrSyntax)
+	}
+	if withLimit && !byScore && !byLex {
+		return ErrorResponse(ErrLimitNoRange)
+	}
+	if withScores && byLex {
+		return ErrorResponse(ErrLexWithScores)
+	}
+
+	min, max := args[1], args[2]
+	if reverse && (byScore || byLex) {
+		min, max = max, min
+	}
+	var scoreRange *zrangeSpec
+	var lexRange *zlexRangeSpec
+	var start, end int64
+	var err error
+	switch {
+	case byScore:
+		scoreRange, err = parseScoreRange(min, max)
+	case byLex:
+		lexRange, err = parseLexRange(min, max)
+	default:
+		var ok1, ok2 bool
+		start, ok1 = parseInt(min)
+		end, ok2 = parseInt(max)
+		if !ok1 || !ok2 {
+			err = ErrNotInteger
+		}
+	}
+	if err != nil {
+		return ErrorResponse(err)
+	}
+
+	zset, found, err := lookupZSet(re, args[0])
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found || offset < 0 {
+		return ArrayResponse()
+	}
+	switch {
+	case byScore:
+		return nodesResponse(zset.RangeByScore(scoreRange, reverse, int(offset), int(limit)), withScores)
+	case byLex:
+		return nodesResponse(zset.RangeByLex(lexRange, reverse, int(offset), int(limit)), false)
+	}
+	from, to, ok := normalizeRange(start, end, zset.Len())
+	if !ok {
+		return ArrayResponse()
+	}
+	return nodesResponse(zset.RangeByRank(from, to, reverse), withScores)
+}
+
+// ZCOUNT key min max
+func zcountCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	r, err := parseScoreRange(cmd.Arg(1), cmd.Arg(2))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	zset, found, err := lookupZSet(re, cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return IntegerResponse(0)
+	}
+	return IntegerResponse(int64(zset.Count(r)))
+}
+
+// ZPOPMIN key [count], also ZPOPMAX
+func zpopCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	if len(args) > 2 {
+		return ErrorResponse(ErrSyntax)
+	}
+	count := int64(1)
+	if len(args) == 2 {
+		var ok bool
+		if count, ok = parseInt(args[1]); !ok || count < 0 {
+			return ErrorResponse(ErrNotPositive)
+		}
+	}
+	key := args[0]
+	zset, found, err := lookupZSet(re, key)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return ArrayResponse()
+	}
+	var nodes []*zskiplistNode
+	for ; count > 0; count-- {
+		node, ok := zset.PopMin(cmd.Name() == "zpopmax")
+		if !ok {
+			break
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) > 0 {
+		re.notifyKeyspaceEvent(notifyZSet, cmd.Name(), key)
+		deleteZSetIfEmpty(re, key, zset)
+		re.signalModifiedKey(key)
+	}
+	return nodesResponse(nodes, true)
+}
+
+// BZPOPMIN key [key ...] timeout, also BZPOPMAX
+func bzpopCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	keys := args[:len(args)-1]
+	timeout, err := parseTimeout(args[len(args)-1])
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	max := cmd.Name() == "bzpopmax"
+
+	serve := func(key string) (*RedisResponse, bool) {
+		zset, found, err := lookupZSet(re, key)
+		if !found || err != nil {
+			return nil, false
+		}
+		node, _ := zset.PopMin(max)
+		re.notifyKeyspaceEvent(notifyZSet, strings.TrimPrefix(cmd.Name(), "b"), key)
+		deleteZSetIfEmpty(re, key, zset)
+		re.signalModifiedKey(key)
+		re.rewriteCommand(strings.TrimPrefix(cmd.Name(), "b"), key)
+		return BulkArrayResponse([]string{key, node.member, formatScore(node.score)}), true
+	}
+
+	for _, key := range keys {
+		if _, _, err := lookupZSet(re, key); err != nil {
+			return ErrorResponse(err)
+		}
+		if response, served := serve(key); served {
+			return response
+		}
+	}
+	bc := &blockedClient{keys: keys, timeout: timeout, serve: serve}
+	re.block(bc)
+	return BlockedResponse(bc)
+}
+
+// zsetOpInput is an input of ZUNIONSTORE and ZINTERSTORE: a sorted set, or a set
+// whose members have a score of 1, and its weight
+type zsetOpInput struct {
+	zset   *ZSet
+	set    *Set
+	weight float64
+}
+
+func (in *zsetOpInput) len() int {
+	switch {
+	case in.zset != nil:
+		return in.zset.Len()
+	case in.set != nil:
+		return in.set.Len()
+	}
+	return 0
+}
+
+func (in *zsetOpInput) score(member string) (float64, bool) {
+	switch {
+	case in.zset != nil:
+		return in.zset.Score(member)
+	case in.set != nil && in.set.Contains(member):
+		return 1, true
+	}
+	return 0, false
+}
+
+func (in *zsetOpInput) forEach(fn func(member string, score float64)) {
+	switch {
+	case in.zset != nil:
+		in.zset.dict.ForEach(func(member string, score float64) bool {
+			fn(member, score)
+			return true
+		})
+	case in.set != nil:
+		in.set.ForEach(func(member string, _ struct{}) bool {
+			fn(member, 1)
+			return true
+		})
+	}
+}
+
+// zsetAggregate combines the scores of a member in ZUNIONSTORE and ZINTERSTORE
+func zsetAggregate(aggregate string, acc, score float64) float64 {
+	switch aggregate {
+	case "min":
+		return math.Min(acc, score)
+	case "max":
+		return math.Max(acc, score)
+	}
+	// +inf + -inf is NaN, which is replaced by 0
+	if sum := acc + score; !math.IsNaN(sum) {
+		return sum
+	}
+	return 0
+}
+
+// weighted multiplies a score by a weight, 0 * inf being 0
+func weighted(score, weight float64) float64 {
+	if value := score * weight; !math.IsNaN(value) {
+		return value
+	}
+	return 0
+}
+
+// zsetOpStoreKeys returns the destination and the input keys of ZUNIONSTORE and
+// ZINTERSTORE, without the inputs if numkeys is invalid
+func zsetOpStoreKeys(args []string) []string {
+	numKeys, ok := parseInt(args[1])
+	if !ok || numKeys < 1 || numKeys > int64(len(args)-2) {
+		return args[:1]
+	}
+	return append([]string{args[0]}, args[2:2+numKeys]...)
+}
+
+// ZUNIONSTORE destination numkeys key [key ...] [WEIGHTS weight [weight ...]]
+// [AGGREGATE SUM | MIN | MAX], also ZINTERSTORE
+func zsetOpStoreCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	args := cmd.Args()
+	numKeys, ok := parseInt(args[1])
+	if !ok {
+		return ErrorResponse(ErrNotInteger)
+	}
+	if numKeys < 1 {
+		return ErrorResponse(fmt.Errorf("ERR at least 1 input key is needed for '%s' command", cmd.Name()))
+	}
+	if numKeys > int64(len(args)-2) {
+		return ErrorResponse(ErrSyntax)
+	}
+
+	inputs := make([]*zsetOpInput, numKeys)
+	for i := range inputs {
+		inputs[i] = &zsetOpInput{weight: 1}
+	}
+	aggregate := "sum"
+	options := args[2+numKeys:]
+	for i := 0; i < len(options); i++ {
+		switch option := strings.ToLower(options[i]); {
+		case option == "weights" && len(options)-i-1 >= len(inputs):
+			for j := range inputs {
+				weight, ok := parseFloat(options[i+1+j])
+				if !ok {
+					return ErrorResponse(ErrWeightNotFloat)
+				}
+				inputs[j].weight = weight
+			}
+			i += len(inputs)
+		case option == "aggregate" && i+1 < len(options):
+			aggregate = strings.ToLower(options[i+1])
+			if aggregate != "sum" && aggregate != "min" && aggregate != "max" {
+				return ErrorResponse(ErrSyntax)
+			}
+			i++
+		default:
+			return ErrorResponse(ErrSyntax)
+		}
+	}
+
+	for i, key := range args[2 : 2+numKeys] {
+		item, found := lookupKey(re, key)
+		switch {
+		case !found:
+		case item.Type == ZSetType:
+			inputs[i].zset = item.ZSetValue()
+		case item.Type == SetType:
+			inputs[i].set = item.SetValue()
+		default:
+			return ErrorResponse(ErrWrongType)
+		}
+	}
+
+	result := NewZSet()
+	if cmd.Name() == "zunionstore" {
+		scores := make(map[string]float64)
+		for _, input := range inputs {
+			input.forEach(func(member string, score float64) {
+				score = weighted(score, input.weight)
+				if acc, found := scores[member]; found {
+					score = zsetAggregate(aggregate, acc, score)
+				}
+				scores[member] = score
+			})
+		}
+		for member, score := range scores {
+			result.Add(score, member, zaddFlags{})
+		}
+	} else {
+		// iterate the smallest input and look its members up in the others
+		sort.SliceStable(inputs, func(i, j int) bool { return inputs[i].len() < inputs[j].len() })
+		inputs[0].forEach(func(member string, score float64) {
+			score = weighted(score, inputs[0].weight)
+			for _, other := range inputs[1:] {
+				otherScore, found := other.score(member)
+				if !found {
+					return
+				}
+				score = zsetAggregate(aggregate, score, weighted(otherScore, other.weight))
+			}
+			result.Add(score, member, zaddFlags{})
+		})
+	}
+
+	storeZSet(re, args[0], result, cmd.Name())
+	return IntegerResponse(int64(result.Len()))
+}
+
+// ZSCAN key cursor [MATCH pattern] [COUNT count]
+func zscanCommand(re *RedisExecutorImpl, cmd *Cmd) *RedisResponse {
+	opts, err := parseScanArgs(cmd.Args()[1:], false, false)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	zset, found, err := lookupZSet(re, cmd.Arg(0))
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	if !found {
+		return scanResponse(0, nil)
+	}
+	var values []string
+	cursor := scanDict(zset.dict, opts, func(member string, score float64) {
+		values = append(values, member, formatScore(score))
+	})
+	return scanResponse(cursor, values)
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestZSetCommands(t *testing.T) {
+	runSteps(t, newTestExecutor(), []testStep{
+		{cmd("ZADD", "z", "1", "a", "2", "b", "3", "c"), ":3\r\n"},
+		{cmd("ZADD", "z", "CH", "5", "a", "2", "b", "4", "d"), ":2\r\n"},
+		{cmd("ZADD", "z", "NX", "XX", "1", "a"), "-ERR XX and NX options at the same time are not compatible\r\n"},
+		{cmd("ZADD", "z", "GT", "LT", "1", "a"), "-ERR GT, LT, and/or NX options at the same time are not compatible\r\n"},
+		{cmd("ZADD", "z", "INCR", "1", "a", "2", "b"), "-ERR INCR option supports a single increment-element pair\r\n"},
+		{cmd("ZADD", "z", "x", "a"), "-ERR value is not a valid float\r\n"},
+		{cmd("ZADD", "z", "GT", "1", "a"), ":0\r\n"},
+		{cmd("ZADD", "z", "XX", "INCR", "1", "missing"), "$-1\r\n"},
+		{cmd("ZADD", "z", "INCR", "0.5", "b"), "$3\r\n2.5\r\n"},
+		{cmd("ZINCRBY", "z", "-inf", "c"), "$4\r\n-inf\r\n"},
+		{cmd("ZINCRBY", "z", "+inf", "c"), "-ERR resulting score is not a number (NaN)\r\n"},
+		{cmd("ZSCORE", "z", "a"), "$1\r\n5\r\n"},
+		{cmd("ZCARD", "z"), ":4\r\n"},
+		{cmd("ZRANK", "z", "a"), ":3\r\n"},
+		{cmd("ZREVRANK", "z", "a", "WITHSCORE"), "*2\r\n:0\r\n$1\r\n5\r\n"},
+		{cmd("ZRANK", "z", "missing", "WITHSCORE"), "*-1\r\n"},
+		{cmd("ZRANGE", "z", "0", "-1", "WITHSCORES"), "*8\r\n$1\r\nc\r\n$4\r\n-inf\r\n$1\r\nb\r\n$3\r\n2.5\r\n$1\r\nd\r\n$1\r\n4\r\n$1\r\na\r\n$1\r\n5\r\n"},
+		{cmd("ZRANGE", "z", "(2.5", "+inf", "BYSCORE"), "*2\r\n$1\r\nd\r\n$1\r\na\r\n"},
+		{cmd("ZRANGE", "z", "+inf", "-inf", "BYSCORE", "REV", "LIMIT", "1", "2"), "*2\r\n$1\r\nd\r\n$1\r\nb\r\n"},
+		{cmd("ZRANGE", "z", "0", "1", "LIMIT", "0", "1"), "-ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX\r\n"},
+		{cmd("ZRANGE", "z", "[a", "(c", "BYLEX", "WITHSCORES"), "-ERR syntax error, WITHSCORES not supported in combination with BYLEX\r\n"},
+		{cmd("ZRANGE", "z", "x", "y", "BYSCORE"), "-ERR min or max is not a float\r\n"},
+		{cmd("ZCOUNT", "z", "-inf", "(5"), ":3\r\n"},
+		{cmd("ZREM", "z", "a", "missing"), ":1\r\n"},
+		{cmd("ZPOPMIN", "z", "2"), "*4\r\n$1\r\nc\r\n$4\r\n-inf\r\n$1\r\nb\r\n$3\r\n2.5\r\n"},
+		{cmd("ZPOPMAX", "z"), "*2\r\n$1\r\nd\r\n$1\r\n4\r\n"},
+		{cmd("EXISTS", "z"), ":0\r\n"},
+		{cmd("ZPOPMIN", "z", "-1"), "-ERR value is out of range, must be positive\r\n"},
+		{cmd("SET", "str", "v"), "+OK\r\n"},
+		{cmd("ZADD", "str", "1", "a"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	})
+}
+
+func TestZRangeByLex(t *testing.T) {
+	re := newTestExecutor()
+	execute(re, "ZADD", "z", "0", "a", "0", "b", "0", "c", "0", "d", "0", "e")
+	runSteps(t, re, []testStep{
+		{cmd("ZRANGE", "z", "[b", "(d", "BYLEX"), "*2\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{cmd("ZRANGE", "z", "+", "(c", "BYLEX", "REV"), "*2\r\n$1\r\ne\r\n$1\r\nd\r\n"},
+		{cmd("ZRANGE", "z", "-", "+", "BYLEX", "LIMIT", "3", "-1"), "*2\r\n$1\r\nd\r\n$1\r\ne\r\n"},
+		{cmd("ZRANGE", "z", "b", "d", "BYLEX"), "-ERR min or max not valid string range item\r\n"},
+	})
+}
+
+func TestZSetStore(t *testing.T) {
+	re := newTestExecutor()
+	execute(re, "ZADD", "z1", "1", "a", "2", "b")
+	execute(re, "ZADD", "z2", "10", "b", "20", "c")
+	execute(re, "SADD", "s", "a", "c")
+	runSteps(t, re, []testStep{
+		{cmd("ZUNIONSTORE", "dst", "2", "z1", "z2", "WEIGHTS", "2", "1"), ":3\r\n"},
+		{cmd("ZRANGE", "dst", "0", "-1", "WITHSCORES"), "*6\r\n$1\r\na\r\n$1\r\n2\r\n$1\r\nb\r\n$2\r\n14\r\n$1\r\nc\r\n$2\r\n20\r\n"},
+		{cmd("ZINTERSTORE", "dst", "2", "z1", "z2", "AGGREGATE", "MAX"), ":1\r\n"},
+		{cmd("ZRANGE", "dst", "0", "-1", "WITHSCORES"), "*2\r\n$1\r\nb\r\n$2\r\n10\r\n"},
+		{cmd("ZINTERSTORE", "dst", "2", "z1", "s"), ":1\r\n"},
+		{cmd("ZSCORE", "dst", "a"), "$1\r\n2\r\n"},
+		{cmd("ZINTERSTORE", "dst", "2", "z1", "missing"), ":0\r\n"},
+		{cmd("EXISTS", "dst"), ":0\r\n"},
+		{cmd("ZUNIONSTORE", "dst", "0", "z1"), "-ERR at least 1 input key is needed for 'zunionstore' command\r\n"},
+		{cmd("ZUNIONSTORE", "dst", "3", "z1", "z2"), "-ERR syntax error\r\n"},
+		{cmd("ZUNIONSTORE", "dst", "1", "z1", "WEIGHTS", "x"), "-ERR weight value is not a float\r\n"},
+	})
+}
+
+func TestSkiplistRanks(t *testing.T) {
+	zset := NewZSet()
+	for i := 0; i < 1000; i++ {
+		zset.Add(float64(i%100), strconv.Itoa(i), zaddFlags{})
+	}
+	for i := 0; i < 1000; i += 3 {
+		zset.Remove(strconv.Itoa(i))
+	}
+	nodes := zset.RangeByRank(0, zset.Len()-1, false)
+	if len(nodes) != zset.Len() {
+		t.Fatalf("got %d nodes, want %d", len(nodes), zset.Len())
+	}
+	for i, node := range nodes {
+		if i > 0 && !nodes[i-1].before(node.score, node.member) {
+			t.Fatalf("nodes %d and %d are out of order", i-1, i)
+		}
+		if rank, _ := zset.Rank(node.member, false); rank != i {
+			t.Fatalf("rank of %s: got %d, want %d", node.member, rank, i)
+		}
+		if got := zset.zsl.byRank(i + 1); got != node {
+			t.Fatalf("node at rank %d: got %s, want %s", i+1, got.member, node.member)
+		}
+	}
+}